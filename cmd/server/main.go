@@ -4,9 +4,11 @@ import (
 	"fmt"
 	"log"
 	"saboriman-music/config"
+	"saboriman-music/internal/artist"
 	"saboriman-music/internal/db"
-	"saboriman-music/internal/handler" // 1. 导入 handler 包
+	"saboriman-music/internal/library"
 	"saboriman-music/internal/router"
+	"saboriman-music/internal/scanner"
 	"strings"
 
 	"github.com/gofiber/fiber/v2"
@@ -47,12 +49,38 @@ func main() {
 		log.Printf("警告: 数据库自动迁移失败: %v", err)
 	}
 
+	// 为配置中的 MusicFolder 创建默认音乐库
+	if err := library.EnsureDefault(gormDB, cfg.MusicFolder); err != nil {
+		log.Printf("警告: 初始化音乐库失败: %v", err)
+	}
+	// 把升级前按名称生成的艺术家 ID 改为新的 ID
+	if err := artist.MigrateIDs(gormDB); err != nil {
+		log.Printf("警告: 迁移艺术家 ID 失败: %v", err)
+	}
+	// 为升级前入库的歌曲和专辑建立艺术家关联
+	if err := artist.Backfill(gormDB); err != nil {
+		log.Printf("警告: 建立艺术家关联失败: %v", err)
+	}
+
+	// 上次退出时没有结束的扫描记录标记为失败
+	if err := scanner.RecoverJobs(gormDB); err != nil {
+		log.Printf("警告: 更新扫描记录失败: %v", err)
+	}
+
 	// 2. 在程序启动时执行一次音乐库扫描
-	if cfg.MusicFolder != "" {
+	if libs := library.All(); len(libs) > 0 {
 		log.Println("🚀 服务启动，开始执行后台音乐库扫描...")
-		go handler.ScanLibraryInBackground(gormDB, cfg.MusicFolder)
+		if _, err := scanner.Default.Start(gormDB, libs, scanner.DefaultOptions(false), "startup"); err != nil {
+			log.Printf("警告: 启动扫描失败: %v", err)
+		}
 	} else {
-		log.Println("⚠️  配置中未指定 MusicFolder，跳过启动时扫描。")
+		log.Println("⚠️  尚未添加音乐库，跳过启动时扫描。")
+	}
+	// 监听目录变化并按计划定期扫描，之后添加的音乐库也会加入监听
+	if watchOpts := scanner.DefaultWatchOptions(); watchOpts.Watch || watchOpts.FullScanInterval > 0 {
+		if _, err := scanner.Default.Watch(gormDB, watchOpts); err != nil {
+			log.Printf("警告: 监听音乐库失败: %v", err)
+		}
 	}
 
 	app := fiber.New()
@@ -77,7 +105,7 @@ func main() {
 	log.Printf("静态文件服务已启动，监听于 %s", appBasePath+"/music")
 
 	// 5. 将 *gorm.DB 实例传递给路由设置函数
-	router.SetupRoutes(app, gormDB)
+	router.SetupRoutes(app, gormDB, scanner.Default)
 
 	// SPA 路由回退：把非 /api 开头的所有请求回退到 index.html
 	app.Use(func(c *fiber.Ctx) error {
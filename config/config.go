@@ -23,6 +23,45 @@ type Config struct {
 		Password string `mapstructure:"password"`
 		Name     string `mapstructure:"name"`
 	}
+	Transcoding TranscodingConfig `mapstructure:"transcoding"`
+	Scanner     ScannerConfig     `mapstructure:"scanner"`
+}
+
+// ScannerConfig 音乐库扫描设置
+type ScannerConfig struct {
+	Concurrency int `mapstructure:"concurrency"` // 并发读取元数据的协程数，默认 CPU 核数
+	BatchSize   int `mapstructure:"batchsize"`   // 每个事务写入的记录数，默认 200
+
+	Extensions []string `mapstructure:"extensions"` // 扫描的音频扩展名，不区分大小写，为空时使用默认列表
+
+	Watch            bool `mapstructure:"watch"`            // 监听音乐库目录，文件变化时自动扫描受影响的目录
+	WatchDelay       int  `mapstructure:"watchdelay"`       // 最后一次文件变化后等待的秒数，默认 5
+	FullScanInterval int  `mapstructure:"fullscaninterval"` // 定期扫描整个音乐库的间隔（小时），0 表示不定期扫描
+
+	ArtistSeparators []string `mapstructure:"artistseparators"` // 拆分多位艺术家的分隔符，不区分大小写，为空时使用默认分隔符
+	VariousArtists   string   `mapstructure:"variousartists"`   // 合辑没有专辑艺术家标签时使用的专辑艺术家，默认 "Various Artists"
+
+	AnalyzeLoudness bool `mapstructure:"analyzeloudness"` // 扫描后在后台用 ffmpeg 测量没有 ReplayGain 标签的歌曲的响度
+}
+
+// TranscodingConfig 转码设置
+type TranscodingConfig struct {
+	FFmpegPath    string             `mapstructure:"ffmpegpath"`    // ffmpeg 可执行文件路径，默认从 PATH 查找
+	DefaultFormat string             `mapstructure:"defaultformat"` // 需要降码率但客户端未指定 format 时使用，默认 mp3
+	Profiles      []TranscodeProfile `mapstructure:"profiles"`      // 为空时使用内置的 mp3/opus/aac
+	CacheDir      string             `mapstructure:"cachedir"`      // 转码缓存目录，默认 cache/transcode
+	CacheSizeMB   int                `mapstructure:"cachesizemb"`   // 转码缓存容量 (MB)，默认 1024，负数表示不缓存
+	HLSBitRates   []int              `mapstructure:"hlsbitrates"`   // HLS 主播放列表提供的码率档位 (kbps)，默认 64/128/192/320
+	HLSSegment    int                `mapstructure:"hlssegment"`    // HLS 分片时长（秒），默认 10
+	ReplayGain    string             `mapstructure:"replaygain"`    // 转码时应用的增益：track、album，为空时不调整音量
+}
+
+// TranscodeProfile 转码方案，对应客户端请求的 format
+type TranscodeProfile struct {
+	Format      string   `mapstructure:"format"`      // 如 mp3、opus、aac
+	ContentType string   `mapstructure:"contenttype"` // 输出的 MIME 类型
+	BitRate     int      `mapstructure:"bitrate"`     // 默认码率 (kbps)
+	Args        []string `mapstructure:"args"`        // ffmpeg 输出参数，{bitrate} 会被替换为码率
 }
 
 // AppConfig 是一个全局变量，用于在应用各处访问配置
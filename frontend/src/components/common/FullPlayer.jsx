@@ -7,7 +7,7 @@ import api from '../../services/api.js';
 import LyricsSearchModal from './LyricsSearchModal.jsx';
 import LyricsEditModal from './LyricsEditModal.jsx';
 import LiquidGlass from '../ui/LiquidGlass.jsx';
-import { getFullUrl } from '../../services/fileUtil.js';
+import { getAudioUrl, getFullUrl } from '../../services/fileUtil.js';
 
 const FullPlayer = ({ isOpen, onClose }) => {
     const {
@@ -41,10 +41,7 @@ const FullPlayer = ({ isOpen, onClose }) => {
     const [isFavorited, setIsFavorited] = useState(false);
 
     // 使用 useMemo 缓存音频 URL
-    const audioUrl = useMemo(() => {
-        if (!currentMusic?.path) return '';
-        return getFullUrl(currentMusic.path);
-    }, [currentMusic?.path]);
+    const audioUrl = useMemo(() => getAudioUrl(currentMusic), [currentMusic?.path, currentMusic?.streamUrl]);
 
     // 使用 useMemo 缓存封面 URL
     const coverUrl = useMemo(() => {
@@ -5,7 +5,7 @@ import LiquidGlass from '../ui/LiquidGlass.jsx';
 import { usePlayer } from '../../contexts/PlayerContext.jsx';
 import FullPlayer from './FullPlayer.jsx';
 import api from '../../services/api.js';
-import { getFullUrl } from '../../services/fileUtil.js';
+import { getAudioUrl, getFullUrl } from '../../services/fileUtil.js';
 
 const Player = () => {
     const location = useLocation();
@@ -49,11 +49,7 @@ const Player = () => {
     const playlistMenuRef = useRef(null);
 
     // 使用 useMemo 缓存音频 URL
-    const audioUrl = useMemo(() => {
-        if (!currentMusic?.path) return '';
-        const url = getFullUrl(currentMusic?.path);
-        return url;
-    }, [currentMusic?.path]);
+    const audioUrl = useMemo(() => getAudioUrl(currentMusic), [currentMusic?.path, currentMusic?.streamUrl]);
 
     // 使用 useMemo 缓存封面 URL
     const coverUrl = useMemo(() => {
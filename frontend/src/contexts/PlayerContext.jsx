@@ -1,5 +1,5 @@
 import React, { createContext, useContext, useState, useCallback, useRef, useEffect } from 'react';
-import { getFullUrl } from '../services/fileUtil.js';
+import { getAudioUrl } from '../services/fileUtil.js';
 
 const PlayerContext = createContext();
 
@@ -28,7 +28,7 @@ export const PlayerProvider = ({ children }) => {
     const [isMuted, setIsMuted] = useState(false);
 
     // 音频 URL
-    const audioUrl = currentMusic?.path ? getFullUrl(currentMusic.path) : '';
+    const audioUrl = getAudioUrl(currentMusic);
     
     // 音频事件处理
     const handleTimeUpdate = () => {
@@ -16,4 +16,15 @@ export const getFullUrl = (path) => {
     console.log(`返回路径: ${path}`);
     
     return path;
-};
\ No newline at end of file
+};
+
+// 歌曲的播放地址：整轨文件中的音轨经由截取音轨的 streamUrl 播放，
+// <audio> 无法设置请求头，以 token 查询参数认证
+export const getAudioUrl = (music) => {
+    if (!music) return '';
+    if (music.streamUrl) {
+        const token = localStorage.getItem('token') || '';
+        return getFullUrl(`${music.streamUrl}?token=${encodeURIComponent(token)}`);
+    }
+    return music.path ? getFullUrl(music.path) : '';
+};
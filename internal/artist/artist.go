@@ -0,0 +1,344 @@
+// Package artist 从标签文本中拆分艺术家，并维护艺术家与歌曲、专辑的关联。
+//
+// 艺术家 ID 由名称生成（见 entity.ArtistID），写入关联时只需确保艺术家记录存在；
+// 关联随扫描更新，不再被引用的艺术家由 Prune 清理。
+package artist
+
+import (
+	"strings"
+
+	"saboriman-music/config"
+	"saboriman-music/internal/entity"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DefaultSeparators 未配置时使用的艺术家分隔符
+var DefaultSeparators = []string{";", " / ", " feat. ", " feat ", " ft. ", " featuring "}
+
+// Separators 返回配置的艺术家分隔符
+func Separators() []string {
+	if config.AppConfig != nil && len(config.AppConfig.Scanner.ArtistSeparators) > 0 {
+		return config.AppConfig.Scanner.ArtistSeparators
+	}
+	return DefaultSeparators
+}
+
+// DefaultVariousArtists 未配置时合辑使用的专辑艺术家
+const DefaultVariousArtists = "Various Artists"
+
+// VariousArtists 返回合辑使用的专辑艺术家名称
+func VariousArtists() string {
+	if config.AppConfig != nil && config.AppConfig.Scanner.VariousArtists != "" {
+		return config.AppConfig.Scanner.VariousArtists
+	}
+	return DefaultVariousArtists
+}
+
+// Split 按分隔符（不区分大小写）拆分艺术家，去掉包裹的括号并按 ID 去重，
+// 如 "A (feat. B)" 拆分为 A、B
+func Split(value string, separators []string) []string {
+	var names []string
+	seen := make(map[string]bool)
+	add := func(part string) {
+		name := strings.Join(strings.Fields(strings.Trim(strings.TrimSpace(part), "()[]")), " ")
+		if id := entity.ArtistID(name); name != "" && !seen[id] {
+			seen[id] = true
+			names = append(names, name)
+		}
+	}
+
+	start := 0
+	for i := 0; i < len(value); {
+		matched := 0
+		for _, sep := range separators {
+			if sep != "" && len(value)-i >= len(sep) && strings.EqualFold(value[i:i+len(sep)], sep) {
+				matched = len(sep)
+				break
+			}
+		}
+		if matched == 0 {
+			i++
+			continue
+		}
+		add(value[start:i])
+		i += matched
+		start = i
+	}
+	add(value[start:])
+	return names
+}
+
+// Primary 返回拆分后的第一位艺术家，用作歌曲或专辑的主要艺术家
+func Primary(value string) string {
+	if names := Split(value, Separators()); len(names) > 0 {
+		return names[0]
+	}
+	return strings.TrimSpace(value)
+}
+
+// Credit 艺术家及其角色
+type Credit struct {
+	Name string
+	Role entity.ArtistRole
+}
+
+// MusicCredits 整理歌曲各角色的艺术家；tagged 为多值标签（如 ARTISTS）中列出的艺术家，
+// 某个角色有多值标签时优先使用，否则拆分歌曲对应的文本字段
+func MusicCredits(m *entity.Music, tagged map[entity.ArtistRole][]string) []Credit {
+	separators := Separators()
+	fields := []struct {
+		role  entity.ArtistRole
+		value string
+	}{
+		{entity.ArtistRoleArtist, m.Artist},
+		{entity.ArtistRoleAlbumArtist, m.AlbumArtist},
+		{entity.ArtistRoleComposer, m.Composer},
+		{entity.ArtistRolePerformer, m.Performer},
+	}
+
+	var credits []Credit
+	for _, f := range fields {
+		var names []string
+		for _, v := range tagged[f.role] {
+			names = append(names, Split(v, separators)...)
+		}
+		if len(names) == 0 {
+			names = Split(f.value, separators)
+		}
+		for _, name := range names {
+			credits = append(credits, Credit{Name: name, Role: f.role})
+		}
+	}
+	return credits
+}
+
+// AlbumCredits 拆分专辑艺术家
+func AlbumCredits(albumArtist string) []Credit {
+	var credits []Credit
+	for _, name := range Split(albumArtist, Separators()) {
+		credits = append(credits, Credit{Name: name, Role: entity.ArtistRoleAlbumArtist})
+	}
+	return credits
+}
+
+// Linker 写入艺术家关联并记住已确认存在的艺术家，减少重复写入；不能在多个协程中共用
+type Linker struct {
+	known map[string]bool
+}
+
+// NewLinker 创建 Linker
+func NewLinker() *Linker {
+	return &Linker{known: make(map[string]bool)}
+}
+
+// ensure 确保艺术家记录存在，返回艺术家 ID
+func (l *Linker) ensure(tx *gorm.DB, name string) (string, error) {
+	id := entity.ArtistID(name)
+	if l.known[id] {
+		return id, nil
+	}
+	// 已存在时保留最先入库的名称写法
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&entity.Artist{ID: id, Name: name}).Error; err != nil {
+		return "", err
+	}
+	l.known[id] = true
+	return id, nil
+}
+
+// LinkMusic 用 credits 替换歌曲的艺术家关联
+func (l *Linker) LinkMusic(tx *gorm.DB, musicID string, credits []Credit) error {
+	if err := tx.Where("music_id = ?", musicID).Delete(&entity.MusicArtist{}).Error; err != nil {
+		return err
+	}
+	rows, err := l.rows(tx, credits)
+	if err != nil || len(rows) == 0 {
+		return err
+	}
+	links := make([]entity.MusicArtist, 0, len(rows))
+	for _, r := range rows {
+		links = append(links, entity.MusicArtist{MusicID: musicID, ArtistID: r.id, Role: r.role, Position: r.position})
+	}
+	return tx.Create(&links).Error
+}
+
+// LinkAlbum 用 credits 替换专辑的艺术家关联
+func (l *Linker) LinkAlbum(tx *gorm.DB, albumID string, credits []Credit) error {
+	if err := tx.Where("album_id = ?", albumID).Delete(&entity.AlbumArtist{}).Error; err != nil {
+		return err
+	}
+	rows, err := l.rows(tx, credits)
+	if err != nil || len(rows) == 0 {
+		return err
+	}
+	links := make([]entity.AlbumArtist, 0, len(rows))
+	for _, r := range rows {
+		links = append(links, entity.AlbumArtist{AlbumID: albumID, ArtistID: r.id, Role: r.role, Position: r.position})
+	}
+	return tx.Create(&links).Error
+}
+
+// ReplaceRole 用 credits 替换多首歌曲中某个角色的艺术家关联，其他角色保持不变
+func (l *Linker) ReplaceRole(tx *gorm.DB, musicIDs []string, role entity.ArtistRole, credits []Credit) error {
+	if len(musicIDs) == 0 {
+		return nil
+	}
+	if err := tx.Where("music_id IN ? AND role = ?", musicIDs, role).Delete(&entity.MusicArtist{}).Error; err != nil {
+		return err
+	}
+	rows, err := l.rows(tx, credits)
+	if err != nil || len(rows) == 0 {
+		return err
+	}
+	links := make([]entity.MusicArtist, 0, len(rows)*len(musicIDs))
+	for _, id := range musicIDs {
+		for _, r := range rows {
+			links = append(links, entity.MusicArtist{MusicID: id, ArtistID: r.id, Role: role, Position: r.position})
+		}
+	}
+	return tx.Create(&links).Error
+}
+
+type linkRow struct {
+	id       string
+	role     entity.ArtistRole
+	position int
+}
+
+// rows 确保艺术家存在并计算每个角色中的顺序，同一角色中重复的艺术家只保留一次
+func (l *Linker) rows(tx *gorm.DB, credits []Credit) ([]linkRow, error) {
+	var rows []linkRow
+	positions := make(map[entity.ArtistRole]int)
+	seen := make(map[linkRow]bool)
+	for _, c := range credits {
+		id, err := l.ensure(tx, c.Name)
+		if err != nil {
+			return nil, err
+		}
+		key := linkRow{id: id, role: c.Role}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		rows = append(rows, linkRow{id: id, role: c.Role, position: positions[c.Role]})
+		positions[c.Role]++
+	}
+	return rows, nil
+}
+
+// Prune 删除已不存在的歌曲和专辑的关联，以及不再被任何歌曲或专辑引用的艺术家
+func Prune(db *gorm.DB) error {
+	if err := db.Where("music_id NOT IN (?)", db.Model(&entity.Music{}).Select("id")).
+		Delete(&entity.MusicArtist{}).Error; err != nil {
+		return err
+	}
+	if err := db.Where("album_id NOT IN (?)", db.Model(&entity.Album{}).Select("id")).
+		Delete(&entity.AlbumArtist{}).Error; err != nil {
+		return err
+	}
+	return db.Where("id NOT IN (?) AND id NOT IN (?)",
+		db.Model(&entity.MusicArtist{}).Select("artist_id"),
+		db.Model(&entity.AlbumArtist{}).Select("artist_id")).
+		Delete(&entity.Artist{}).Error
+}
+
+// Backfill 为升级前入库、还没有艺术家关联的歌曲和专辑建立关联
+func Backfill(db *gorm.DB) error {
+	linker := NewLinker()
+	var musics []entity.Music
+	err := db.Where("id NOT IN (?)", db.Model(&entity.MusicArtist{}).Select("music_id")).
+		FindInBatches(&musics, 500, func(*gorm.DB, int) error {
+			return db.Transaction(func(tx *gorm.DB) error {
+				for i := range musics {
+					if err := linker.LinkMusic(tx, musics[i].ID, MusicCredits(&musics[i], nil)); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+		}).Error
+	if err != nil {
+		return err
+	}
+
+	var albums []entity.Album
+	return db.Where("id NOT IN (?)", db.Model(&entity.AlbumArtist{}).Select("album_id")).
+		FindInBatches(&albums, 500, func(*gorm.DB, int) error {
+			return db.Transaction(func(tx *gorm.DB) error {
+				for _, a := range albums {
+					if err := linker.LinkAlbum(tx, a.ID, AlbumCredits(a.ArtistName)); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+		}).Error
+}
+
+// MigrateIDs 把 ID 与 entity.ArtistID 不一致的艺术家（升级前按旧规则生成）改为新 ID，
+// 同时更新歌曲、专辑的关联与用户的收藏和评分
+func MigrateIDs(db *gorm.DB) error {
+	var artists []entity.Artist
+	if err := db.Find(&artists).Error; err != nil {
+		return err
+	}
+	for _, a := range artists {
+		id := entity.ArtistID(a.Name)
+		if id == a.ID {
+			continue
+		}
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			return migrateID(tx, a, id)
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateID 把艺术家的记录复制到新 ID 后删除旧记录，新 ID 已存在时合并
+func migrateID(tx *gorm.DB, a entity.Artist, id string) error {
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&entity.Artist{ID: id, Name: a.Name, CreatedAt: a.CreatedAt}).Error; err != nil {
+		return err
+	}
+
+	var musics []entity.MusicArtist
+	if err := tx.Where("artist_id = ?", a.ID).Find(&musics).Error; err != nil {
+		return err
+	}
+	for i := range musics {
+		musics[i].ArtistID = id
+	}
+	var albums []entity.AlbumArtist
+	if err := tx.Where("artist_id = ?", a.ID).Find(&albums).Error; err != nil {
+		return err
+	}
+	for i := range albums {
+		albums[i].ArtistID = id
+	}
+	var annotations []entity.Annotation
+	if err := tx.Where("item_type = ? AND item_id = ?", entity.ItemTypeArtist, a.ID).Find(&annotations).Error; err != nil {
+		return err
+	}
+	for i := range annotations {
+		annotations[i].ItemID = id
+	}
+	for _, rows := range []interface{}{&musics, &albums, &annotations} {
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(rows, 500).Error; err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Where("artist_id = ?", a.ID).Delete(&entity.MusicArtist{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("artist_id = ?", a.ID).Delete(&entity.AlbumArtist{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("item_type = ? AND item_id = ?", entity.ItemTypeArtist, a.ID).Delete(&entity.Annotation{}).Error; err != nil {
+		return err
+	}
+	return tx.Delete(&entity.Artist{}, "id = ?", a.ID).Error
+}
@@ -0,0 +1,96 @@
+package artist
+
+import (
+	"net/url"
+	"testing"
+
+	database "saboriman-music/internal/db"
+	"saboriman-music/internal/entity"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestArtistID(t *testing.T) {
+	tests := []struct {
+		a, b string
+		same bool
+	}{
+		{"Artist A", "artist a", true},
+		{"Artist A", "  Artist   A ", true},
+		{"A B", "a-b", false},
+		{"AC/DC", "AC DC", false},
+		{"AC/DC", "ACDC", false},
+		{"Who?", "Who", false},
+		{"周杰伦", "周杰倫", false},
+	}
+	for _, tt := range tests {
+		a, b := entity.ArtistID(tt.a), entity.ArtistID(tt.b)
+		if (a == b) != tt.same {
+			t.Errorf("ArtistID(%q) = %s, ArtistID(%q) = %s, expected same=%v", tt.a, a, tt.b, b, tt.same)
+		}
+		for _, id := range []string{a, b} {
+			if url.PathEscape(id) != id || len(id) != 16 {
+				t.Errorf("expected a URL-safe 16 character id, got %q", id)
+			}
+		}
+	}
+	if entity.ArtistID("AC/DC") != entity.ArtistID("AC/DC") {
+		t.Fatalf("expected ids to be stable")
+	}
+}
+
+func TestMigrateIDs(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sqlDB, err := db.DB(); err == nil {
+		sqlDB.SetMaxOpenConns(1)
+	}
+	if err := db.AutoMigrate(database.GetAllEntities()...); err != nil {
+		t.Fatal(err)
+	}
+
+	// 升级前按旧规则生成的 ID
+	db.Create(&entity.Artist{ID: "ac/dc", Name: "AC/DC"})
+	db.Create(&entity.MusicArtist{MusicID: "M1", ArtistID: "ac/dc", Role: entity.ArtistRoleArtist})
+	db.Create(&entity.AlbumArtist{AlbumID: "A1", ArtistID: "ac/dc", Role: entity.ArtistRoleAlbumArtist})
+	db.Create(&entity.Annotation{UserID: "U1", ItemType: entity.ItemTypeArtist, ItemID: "ac/dc", Rating: 5})
+	// 新 ID 的艺术家已存在时合并
+	id := entity.ArtistID("Queen")
+	db.Create(&entity.Artist{ID: "queen", Name: "Queen"})
+	db.Create(&entity.Artist{ID: id, Name: "Queen"})
+	db.Create(&entity.MusicArtist{MusicID: "M2", ArtistID: "queen", Role: entity.ArtistRoleArtist})
+	db.Create(&entity.MusicArtist{MusicID: "M2", ArtistID: id, Role: entity.ArtistRoleArtist})
+
+	if err := MigrateIDs(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	if err := MigrateIDs(db); err != nil {
+		t.Fatalf("migrate again: %v", err)
+	}
+
+	var ids []string
+	db.Model(&entity.Artist{}).Order("name").Pluck("id", &ids)
+	if len(ids) != 2 || ids[0] != entity.ArtistID("AC/DC") || ids[1] != id {
+		t.Fatalf("unexpected artists %v", ids)
+	}
+	var count int64
+	db.Model(&entity.MusicArtist{}).Where("artist_id IN ?", ids).Count(&count)
+	if count != 2 {
+		t.Fatalf("expected links to move to the new ids, got %d", count)
+	}
+	db.Model(&entity.AlbumArtist{}).Where("artist_id = ?", ids[0]).Count(&count)
+	if count != 1 {
+		t.Fatalf("expected album link to move to the new id")
+	}
+	var annotation entity.Annotation
+	if err := db.First(&annotation, "item_id = ?", ids[0]).Error; err != nil || annotation.Rating != 5 {
+		t.Fatalf("expected rating to move to the new id: %+v (%v)", annotation, err)
+	}
+	db.Model(&entity.Annotation{}).Where("item_id = ?", "ac/dc").Count(&count)
+	if count != 0 {
+		t.Fatalf("expected old annotation to be removed")
+	}
+}
@@ -0,0 +1,136 @@
+package artist
+
+import (
+	"sort"
+
+	"saboriman-music/internal/entity"
+	"saboriman-music/internal/library"
+
+	"gorm.io/gorm"
+)
+
+// musicLinks 音乐库范围内的歌曲艺术家关联，roles 为空时包含所有角色
+func musicLinks(db *gorm.DB, libraries []uint, roles []entity.ArtistRole) *gorm.DB {
+	q := db.Model(&entity.MusicArtist{}).
+		Joins("JOIN music ON music.id = music_artists.music_id").
+		Scopes(library.Scope("music", libraries))
+	if len(roles) > 0 {
+		q = q.Where("music_artists.role IN ?", roles)
+	}
+	return q
+}
+
+// albumLinks 音乐库范围内的专辑艺术家关联
+func albumLinks(db *gorm.DB, libraries []uint, roles []entity.ArtistRole) *gorm.DB {
+	q := db.Model(&entity.AlbumArtist{}).
+		Joins("JOIN album ON album.id = album_artists.album_id AND album.deleted_at IS NULL").
+		Scopes(library.Scope("album", libraries))
+	if len(roles) > 0 {
+		q = q.Where("album_artists.role IN ?", roles)
+	}
+	return q
+}
+
+// Scope 只保留在 libraries 中有歌曲或专辑的艺术家，指定 roles 时只看这些角色；
+// libraries 为 nil 时不限音乐库
+func Scope(libraries []uint, roles ...entity.ArtistRole) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		session := db.Session(&gorm.Session{NewDB: true})
+		return db.Where("(artists.id IN (?) OR artists.id IN (?))",
+			musicLinks(session, libraries, roles).Select("music_artists.artist_id"),
+			albumLinks(session, libraries, roles).Select("album_artists.artist_id"))
+	}
+}
+
+// AlbumScope 只保留艺术家参与的专辑：专辑艺术家中有该艺术家，或专辑中有该艺术家的歌曲
+func AlbumScope(libraries []uint, artistID string, roles ...entity.ArtistRole) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		session := db.Session(&gorm.Session{NewDB: true})
+		return db.Scopes(library.Scope("album", libraries)).
+			Where("(album.id IN (?) OR album.id IN (?))",
+				albumLinks(session, libraries, roles).Select("album_artists.album_id").
+					Where("album_artists.artist_id = ?", artistID),
+				musicLinks(session, libraries, roles).Select("music.album_id").
+					Where("music_artists.artist_id = ?", artistID))
+	}
+}
+
+// Stats 艺术家在音乐库范围内的统计
+type Stats struct {
+	AlbumCount int                 `json:"albumCount"`
+	SongCount  int                 `json:"songCount"`
+	Roles      []entity.ArtistRole `json:"roles"`
+}
+
+// LoadStats 批量统计艺术家的专辑数、歌曲数与担任过的角色
+func LoadStats(db *gorm.DB, libraries []uint, ids []string) (map[string]*Stats, error) {
+	stats := make(map[string]*Stats, len(ids))
+	for _, id := range ids {
+		stats[id] = &Stats{Roles: []entity.ArtistRole{}}
+	}
+	if len(ids) == 0 {
+		return stats, nil
+	}
+
+	var songs []struct {
+		ArtistID string
+		Role     entity.ArtistRole
+		MusicID  string
+		AlbumID  string
+	}
+	if err := musicLinks(db, libraries, nil).
+		Select("music_artists.artist_id, music_artists.role, music_artists.music_id, music.album_id").
+		Where("music_artists.artist_id IN ?", ids).
+		Scan(&songs).Error; err != nil {
+		return nil, err
+	}
+	var albums []struct {
+		ArtistID string
+		Role     entity.ArtistRole
+		AlbumID  string
+	}
+	if err := albumLinks(db, libraries, nil).
+		Select("album_artists.artist_id, album_artists.role, album_artists.album_id").
+		Where("album_artists.artist_id IN ?", ids).
+		Scan(&albums).Error; err != nil {
+		return nil, err
+	}
+
+	type pair struct{ artist, item string }
+	seenSongs := make(map[pair]bool)
+	seenAlbums := make(map[pair]bool)
+	seenRoles := make(map[pair]bool)
+	count := func(artistID, albumID string, role entity.ArtistRole) {
+		s := stats[artistID]
+		if albumID != "" && !seenAlbums[pair{artistID, albumID}] {
+			seenAlbums[pair{artistID, albumID}] = true
+			s.AlbumCount++
+		}
+		if !seenRoles[pair{artistID, string(role)}] {
+			seenRoles[pair{artistID, string(role)}] = true
+			s.Roles = append(s.Roles, role)
+		}
+	}
+	for _, r := range songs {
+		if !seenSongs[pair{r.ArtistID, r.MusicID}] {
+			seenSongs[pair{r.ArtistID, r.MusicID}] = true
+			stats[r.ArtistID].SongCount++
+		}
+		count(r.ArtistID, r.AlbumID, r.Role)
+	}
+	for _, r := range albums {
+		count(r.ArtistID, r.AlbumID, r.Role)
+	}
+	for _, s := range stats {
+		sort.Slice(s.Roles, func(i, j int) bool { return roleOrder[s.Roles[i]] < roleOrder[s.Roles[j]] })
+	}
+	return stats, nil
+}
+
+// roleOrder 返回角色列表时的顺序
+var roleOrder = map[entity.ArtistRole]int{
+	entity.ArtistRoleArtist:      0,
+	entity.ArtistRoleAlbumArtist: 1,
+	entity.ArtistRoleComposer:    2,
+	entity.ArtistRolePerformer:   3,
+}
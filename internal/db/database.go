@@ -9,6 +9,7 @@ import (
 	"gorm.io/driver/mysql"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"gorm.io/gorm/logger"
 	"gorm.io/gorm/schema"
 )
@@ -68,6 +69,15 @@ func GetAllEntities() []interface{} {
 		&entity.Playlist{},
 		&entity.PlaylistMusic{},
 		&entity.Album{},
+		&entity.SubsonicCredential{},
+		&entity.Annotation{},
+		&entity.PlayEvent{},
+		&entity.ScanJob{},
+		&entity.Library{},
+		&entity.UserLibrary{},
+		&entity.Artist{},
+		&entity.MusicArtist{},
+		&entity.AlbumArtist{},
 	}
 }
 
@@ -75,6 +85,10 @@ func GetAllEntities() []interface{} {
 func (d *Database) AutoMigrate() error {
 	log.Println("开始自动迁移数据库表...")
 
+	if err := MigratePlaylistPositions(d.DB); err != nil {
+		return err
+	}
+
 	entities := GetAllEntities()
 	for _, entity := range entities {
 		entityType := reflect.TypeOf(entity).Elem()
@@ -109,6 +123,84 @@ func (d *Database) AutoMigrate() error {
 	} else {
 		log.Println("系统用户 SYSTEM 已存在。")
 	}
+
+	return MigrateFavoritePlaylists(d.DB)
+}
+
+// favoritePlaylistName 旧版本用于保存收藏歌曲的播放列表名
+const favoritePlaylistName = "我的喜爱"
+
+// MigrateFavoritePlaylists 将旧版"我的喜爱"播放列表转换为用户收藏，转换后删除该播放列表
+func MigrateFavoritePlaylists(db *gorm.DB) error {
+	var playlists []entity.Playlist
+	if err := db.Where("name = ?", favoritePlaylistName).Find(&playlists).Error; err != nil {
+		return fmt.Errorf("failed to query favorite playlists: %v", err)
+	}
+	for _, p := range playlists {
+		var musicIDs []string
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&entity.PlaylistMusic{}).
+				Where("playlist_id = ?", p.ID).
+				Pluck("music_id", &musicIDs).Error; err != nil {
+				return err
+			}
+			if err := entity.SetStarred(tx, p.UserID, entity.ItemTypeMusic, musicIDs, true); err != nil {
+				return err
+			}
+			if err := tx.Where("playlist_id = ?", p.ID).Delete(&entity.PlaylistMusic{}).Error; err != nil {
+				return err
+			}
+			return tx.Delete(&entity.Playlist{}, "id = ?", p.ID).Error
+		})
+		if err != nil {
+			return fmt.Errorf("failed to migrate favorite playlist %s: %v", p.ID, err)
+		}
+		log.Printf("播放列表 %s（用户 %s）已转换为 %d 首收藏歌曲", p.ID, p.UserID, len(musicIDs))
+	}
+	return nil
+}
+
+// MigratePlaylistPositions 旧版 playlist_musics 以 (playlist_id, music_id) 为主键、按 order 列排序，
+// 重建为以 (playlist_id, position) 为主键的表，保留原有顺序
+func MigratePlaylistPositions(db *gorm.DB) error {
+	m := db.Migrator()
+	if !m.HasTable(&entity.PlaylistMusic{}) || m.HasColumn(&entity.PlaylistMusic{}, "position") {
+		return nil
+	}
+	type legacyRow struct {
+		PlaylistID string
+		MusicID    string
+		Order      int
+	}
+	var legacy []legacyRow
+	q := db.Table(entity.PlaylistMusic{}.TableName()).Order("playlist_id")
+	if m.HasColumn(&entity.PlaylistMusic{}, "order") {
+		q = q.Order(clause.OrderByColumn{Column: clause.Column{Name: "order"}})
+	}
+	if err := q.Order("music_id").Find(&legacy).Error; err != nil {
+		return fmt.Errorf("failed to read playlist musics: %v", err)
+	}
+
+	rows := make([]entity.PlaylistMusic, 0, len(legacy))
+	for i, r := range legacy {
+		position := 0
+		if i > 0 && legacy[i-1].PlaylistID == r.PlaylistID {
+			position = rows[i-1].Position + 1
+		}
+		rows = append(rows, entity.PlaylistMusic{PlaylistID: r.PlaylistID, Position: position, MusicID: r.MusicID})
+	}
+	if err := m.DropTable(&entity.PlaylistMusic{}); err != nil {
+		return fmt.Errorf("failed to drop playlist musics: %v", err)
+	}
+	if err := m.CreateTable(&entity.PlaylistMusic{}); err != nil {
+		return fmt.Errorf("failed to create playlist musics: %v", err)
+	}
+	if len(rows) > 0 {
+		if err := db.CreateInBatches(&rows, 500).Error; err != nil {
+			return fmt.Errorf("failed to restore playlist musics: %v", err)
+		}
+	}
+	log.Printf("播放列表歌曲表已按位置重建，共 %d 条记录", len(rows))
 	return nil
 }
 
@@ -0,0 +1,15 @@
+package dto
+
+import "saboriman-music/internal/entity"
+
+// SetRatingRequest 设置评分请求，0 表示清除评分
+type SetRatingRequest struct {
+	Rating int `json:"rating"`
+}
+
+// StarredResponse 当前用户的收藏
+type StarredResponse struct {
+	Musics  []ListMusicResponse `json:"musics"`
+	Albums  []ListAlbumResponse `json:"albums"`
+	Artists []entity.Annotation `json:"artists"`
+}
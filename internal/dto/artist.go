@@ -0,0 +1,12 @@
+package dto
+
+import (
+	"saboriman-music/internal/artist"
+	"saboriman-music/internal/entity"
+)
+
+// ArtistResponse 艺术家及其在当前用户可访问的音乐库中的统计
+type ArtistResponse struct {
+	entity.Artist
+	artist.Stats
+}
@@ -1,5 +1,7 @@
 package dto
 
+import "saboriman-music/internal/entity"
+
 // LoginRequest 登录请求
 type LoginRequest struct {
 	Username string `json:"username" validate:"required"` // 可以是用户名或邮箱
@@ -20,6 +22,8 @@ type UserInfo struct {
 	Email    string `json:"email"`
 	Avatar   string `json:"avatar,omitempty"`
 	Role     string `json:"role"`
+	// 默认最大播放码率 (kbps)，0 表示不限制
+	MaxBitRate int `json:"maxBitRate"`
 }
 
 // RegisterRequest 注册请求
@@ -34,3 +38,16 @@ type ChangePasswordRequest struct {
 	OldPassword string `json:"oldPassword" validate:"required"`
 	NewPassword string `json:"newPassword" validate:"required,min=6"`
 }
+
+// CreateSubsonicCredentialRequest 创建 Subsonic 凭据请求
+type CreateSubsonicCredentialRequest struct {
+	Type   string `json:"type"`             // password: 应用密码, apikey: OpenSubsonic apiKey
+	Name   string `json:"name"`             // 备注，如客户端名称
+	Secret string `json:"secret,omitempty"` // 仅应用密码可自定义，留空则自动生成
+}
+
+// SubsonicCredentialResponse 创建凭据的响应（仅创建时返回明文密钥）
+type SubsonicCredentialResponse struct {
+	entity.SubsonicCredential
+	Secret string `json:"secret"`
+}
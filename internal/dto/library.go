@@ -0,0 +1,12 @@
+package dto
+
+// LibraryRequest 创建或更新音乐库请求
+type LibraryRequest struct {
+	Name string `json:"name" validate:"required"`
+	Path string `json:"path" validate:"required"` // 根目录，不能与其他音乐库重叠
+}
+
+// UserLibrariesRequest 设置用户可以访问的音乐库，为空表示不限制
+type UserLibrariesRequest struct {
+	LibraryIDs []uint `json:"libraryIds"`
+}
@@ -2,11 +2,34 @@ package dto
 
 import (
 	"saboriman-music/internal/entity"
+	"time"
 )
 
 type ListMusicResponse struct {
 	entity.Music
-	Favorited bool `json:"favorited"`
+	Favorited bool       `json:"favorited"`
+	StarredAt *time.Time `json:"starredAt,omitempty"`
+	Rating    int        `json:"rating"`
+	// 当前用户的播放次数与最后播放时间（PlayCount 为全局播放次数）
+	UserPlayCount int        `json:"userPlayCount"`
+	PlayedAt      *time.Time `json:"playedAt,omitempty"`
+}
+
+// ListAlbumResponse 专辑及当前用户的收藏与评分，字段与 ListMusicResponse 一致
+type ListAlbumResponse struct {
+	entity.Album
+	Favorited bool       `json:"favorited"`
+	StarredAt *time.Time `json:"starredAt,omitempty"`
+	Rating    int        `json:"rating"`
+}
+
+// NowPlayingResponse 正在播放的歌曲及播放者
+type NowPlayingResponse struct {
+	Username   string       `json:"username"`
+	Client     string       `json:"client"`
+	StartedAt  time.Time    `json:"startedAt"`
+	MinutesAgo int          `json:"minutesAgo"`
+	Music      entity.Music `json:"music"`
 }
 
 type LyricRequest struct {
@@ -17,6 +17,13 @@ type UpdateUserRequest struct {
 	Password string `json:"password,omitempty" validate:"omitempty,min=6,max=100"`
 	Avatar   string `json:"avatar,omitempty" validate:"omitempty,url,max=500"`
 	Status   *int   `json:"status,omitempty" validate:"omitempty,oneof=0 1"`
+	// 默认最大播放码率 (kbps)，0 表示不限制
+	MaxBitRate *int `json:"maxBitRate,omitempty" validate:"omitempty,min=0"`
+}
+
+// UpdateSettingsRequest 当前用户修改个人设置
+type UpdateSettingsRequest struct {
+	MaxBitRate *int `json:"maxBitRate,omitempty" validate:"omitempty,min=0"` // 默认最大播放码率 (kbps)，0 表示不限制
 }
 
 // UserResponse 用户响应
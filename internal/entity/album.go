@@ -16,10 +16,17 @@ type Album struct {
 	CoverURL    string         `gorm:"type:varchar(512)" json:"coverUrl"`
 	ReleaseDate *time.Time     `gorm:"type:date" json:"release_date"` // 1. 修改这里：使用指针类型允许 NULL
 	Genre       string         `json:"genre" gorm:"type:varchar(100);index"`
+	LibraryID   uint           `gorm:"index" json:"libraryId"` // 所属音乐库，不同音乐库的同名专辑互相独立
 	CreatedAt   time.Time      `gorm:"autoCreateTime" json:"createdAt"`
 	UpdatedAt   time.Time      `gorm:"autoUpdateTime" json:"updatedAt"`
 	DeletedAt   gorm.DeletedAt `gorm:"index" json:"-"`
 
+	// 合辑：带合辑标记，或同一目录下同名专辑的歌曲来自多位艺术家
+	IsCompilation bool   `gorm:"default:false;index" json:"isCompilation"`
+	Dir           string `gorm:"type:varchar(1024)" json:"-"` // 没有专辑艺术家标签时按目录归组，记录专辑所在目录
+
+	MbzAlbumID string `gorm:"type:varchar(36);index" json:"mbzAlbumId,omitempty"` // MusicBrainz 发行 ID，歌曲带此标签时按它归组
+
 	// 关系: 一个专辑有多首音乐
 	Musics []Music `gorm:"foreignKey:AlbumID" json:"musics,omitempty"`
 }
@@ -0,0 +1,118 @@
+package entity
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ItemType 标注对象类型
+type ItemType string
+
+const (
+	ItemTypeMusic  ItemType = "music"
+	ItemTypeAlbum  ItemType = "album"
+	ItemTypeArtist ItemType = "artist"
+)
+
+// IsValid 检查标注对象类型是否有效
+func (t ItemType) IsValid() bool {
+	return t == ItemTypeMusic || t == ItemTypeAlbum || t == ItemTypeArtist
+}
+
+// Annotation 用户对歌曲/专辑/艺术家的个人标注（收藏时间、1~5 星评分、播放次数）
+// 艺术家没有独立的表，ItemID 为根据艺术家名生成的 ID
+type Annotation struct {
+	UserID    string     `gorm:"type:varchar(36);primaryKey" json:"userId"`
+	ItemType  ItemType   `gorm:"type:varchar(20);primaryKey" json:"itemType"`
+	ItemID    string     `gorm:"type:varchar(255);primaryKey" json:"itemId"`
+	StarredAt *time.Time `gorm:"index" json:"starredAt"`  // 为空表示未收藏
+	Rating    int        `gorm:"default:0" json:"rating"` // 0 表示未评分
+	PlayCount int        `gorm:"default:0" json:"playCount"`
+	PlayedAt  *time.Time `gorm:"index" json:"playedAt"` // 最后播放时间
+	CreatedAt time.Time  `gorm:"autoCreateTime" json:"createdAt"`
+	UpdatedAt time.Time  `gorm:"autoUpdateTime" json:"updatedAt"`
+}
+
+// TableName 指定表名
+func (Annotation) TableName() string {
+	return "annotations"
+}
+
+// annotationKey 标注表的联合主键列
+var annotationKey = []clause.Column{{Name: "user_id"}, {Name: "item_type"}, {Name: "item_id"}}
+
+// SetStarred 收藏或取消收藏，已收藏的条目保留原收藏时间
+func SetStarred(db *gorm.DB, userID string, itemType ItemType, ids []string, starred bool) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if !starred {
+			return tx.Model(&Annotation{}).
+				Where("user_id = ? AND item_type = ? AND item_id IN ?", userID, itemType, ids).
+				Update("starred_at", nil).Error
+		}
+		now := time.Now()
+		rows := make([]Annotation, 0, len(ids))
+		for _, id := range ids {
+			rows = append(rows, Annotation{UserID: userID, ItemType: itemType, ItemID: id, StarredAt: &now})
+		}
+		if err := tx.Clauses(clause.OnConflict{Columns: annotationKey, DoNothing: true}).Create(&rows).Error; err != nil {
+			return err
+		}
+		// 已存在但未收藏的记录（如只评过分）
+		return tx.Model(&Annotation{}).
+			Where("user_id = ? AND item_type = ? AND item_id IN ? AND starred_at IS NULL", userID, itemType, ids).
+			Update("starred_at", now).Error
+	})
+	if err != nil || itemType != ItemTypeMusic {
+		return err
+	}
+	return RefreshLikeCount(db, ids)
+}
+
+// SetRating 设置评分，rating 为 0 表示清除评分
+func SetRating(db *gorm.DB, userID string, itemType ItemType, id string, rating int) error {
+	row := Annotation{UserID: userID, ItemType: itemType, ItemID: id, Rating: rating}
+	return db.Clauses(clause.OnConflict{
+		Columns:   annotationKey,
+		DoUpdates: clause.AssignmentColumns([]string{"rating", "updated_at"}),
+	}).Create(&row).Error
+}
+
+// LoadAnnotations 批量读取用户对指定条目的标注，按 ItemID 索引
+func LoadAnnotations(db *gorm.DB, userID string, itemType ItemType, ids []string) (map[string]Annotation, error) {
+	result := make(map[string]Annotation, len(ids))
+	if len(ids) == 0 {
+		return result, nil
+	}
+	var rows []Annotation
+	if err := db.Where("user_id = ? AND item_type = ? AND item_id IN ?", userID, itemType, ids).
+		Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, r := range rows {
+		result[r.ItemID] = r
+	}
+	return result, nil
+}
+
+// StarredIDs 返回用户已收藏条目 ID 的子查询，可直接用于 IN (?)
+func StarredIDs(db *gorm.DB, userID string, itemType ItemType) *gorm.DB {
+	return db.Model(&Annotation{}).
+		Select("item_id").
+		Where("user_id = ? AND item_type = ? AND starred_at IS NOT NULL", userID, itemType)
+}
+
+// RefreshLikeCount 以收藏该歌曲的用户数刷新 Music.LikeCount，所有歌曲在一条 UPDATE 中完成
+func RefreshLikeCount(db *gorm.DB, musicIDs []string) error {
+	if len(musicIDs) == 0 {
+		return nil
+	}
+	count := db.Model(&Annotation{}).
+		Select("COUNT(*)").
+		Where("annotations.item_type = ? AND annotations.item_id = music.id AND annotations.starred_at IS NOT NULL", ItemTypeMusic)
+	return db.Model(&Music{}).Where("id IN ?", musicIDs).UpdateColumn("like_count", count).Error
+}
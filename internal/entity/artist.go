@@ -0,0 +1,81 @@
+package entity
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ArtistRole 艺术家在歌曲或专辑中的角色
+type ArtistRole string
+
+const (
+	ArtistRoleArtist      ArtistRole = "artist"      // 歌曲艺术家
+	ArtistRoleAlbumArtist ArtistRole = "albumartist" // 专辑艺术家
+	ArtistRoleComposer    ArtistRole = "composer"    // 作曲
+	ArtistRolePerformer   ArtistRole = "performer"   // 表演者
+)
+
+// IsValid 检查角色是否有效
+func (r ArtistRole) IsValid() bool {
+	switch r {
+	case ArtistRoleArtist, ArtistRoleAlbumArtist, ArtistRoleComposer, ArtistRolePerformer:
+		return true
+	default:
+		return false
+	}
+}
+
+// Artist 艺术家实体，ID 由规范化后的名称生成，同名艺术家重新扫描后 ID 不变
+type Artist struct {
+	ID        string    `gorm:"type:varchar(255);primaryKey" json:"id"`
+	Name      string    `gorm:"type:varchar(255);index" json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// BeforeCreate GORM 钩子，未指定 ID 时由名称生成
+func (artist *Artist) BeforeCreate(tx *gorm.DB) (err error) {
+	if artist.ID == "" {
+		artist.ID = ArtistID(artist.Name)
+	}
+	return
+}
+
+func (Artist) TableName() string {
+	return "artists"
+}
+
+// ArtistID 根据艺术家名生成 ID：忽略大小写与多余空白后取哈希，
+// 不同的名称不会因字符替换得到相同的 ID，任意名称的 ID 都可以直接用在 URL 中
+func ArtistID(name string) string {
+	sum := sha1.Sum([]byte(strings.ToLower(strings.Join(strings.Fields(name), " "))))
+	return hex.EncodeToString(sum[:8])
+}
+
+// MusicArtist 歌曲与艺术家的关联，同一位艺术家可以在一首歌中担任多个角色
+type MusicArtist struct {
+	MusicID  string     `gorm:"type:varchar(36);primaryKey" json:"musicId"`
+	ArtistID string     `gorm:"type:varchar(255);primaryKey;index" json:"artistId"`
+	Role     ArtistRole `gorm:"type:varchar(20);primaryKey" json:"role"`
+	Position int        `json:"position"` // 在该角色中的顺序，0 为主要艺术家
+}
+
+func (MusicArtist) TableName() string {
+	return "music_artists"
+}
+
+// AlbumArtist 专辑与艺术家的关联
+type AlbumArtist struct {
+	AlbumID  string     `gorm:"type:varchar(8);primaryKey" json:"albumId"`
+	ArtistID string     `gorm:"type:varchar(255);primaryKey;index" json:"artistId"`
+	Role     ArtistRole `gorm:"type:varchar(20);primaryKey" json:"role"`
+	Position int        `json:"position"`
+}
+
+func (AlbumArtist) TableName() string {
+	return "album_artists"
+}
@@ -0,0 +1,33 @@
+package entity
+
+import "time"
+
+// Library 音乐库，每个音乐库对应一个根目录并独立扫描
+// ID 使用自增整数，直接作为 Subsonic 的 musicFolderId
+type Library struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Name      string    `gorm:"type:varchar(100);not null" json:"name"`
+	Path      string    `gorm:"type:varchar(768);uniqueIndex;not null" json:"path"` // 根目录
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"createdAt"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime" json:"updatedAt"`
+
+	// ContentChangedAt 最近一次增删或移动歌曲的时间，删除不会反映在歌曲的 updated_at 上
+	ContentChangedAt *time.Time `json:"contentChangedAt"`
+}
+
+// TableName 指定表名
+func (Library) TableName() string {
+	return "libraries"
+}
+
+// UserLibrary 用户可以访问的音乐库，仅对 User.Restricted 的用户生效；
+// 受限用户没有记录时不能访问任何音乐库，管理员始终可以访问全部音乐库
+type UserLibrary struct {
+	UserID    string `gorm:"type:varchar(36);primaryKey" json:"userId"`
+	LibraryID uint   `gorm:"primaryKey" json:"libraryId"`
+}
+
+// TableName 指定表名
+func (UserLibrary) TableName() string {
+	return "user_libraries"
+}
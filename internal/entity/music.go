@@ -1,6 +1,7 @@
 package entity
 
 import (
+	"fmt"
 	"strings"
 	"time"
 
@@ -9,39 +10,63 @@ import (
 )
 
 type Music struct {
-	ID          string    `gorm:"type:varchar(36);primaryKey" json:"id"`
-	Title       string    `gorm:"type:varchar(255);index" json:"title"`
-	Artist      string    `gorm:"type:varchar(255);index" json:"artist"`
-	AlbumArtist string    `gorm:"type:varchar(255);index" json:"albumArtist"`
-	AlbumID     string    `gorm:"type:varchar(36);index" json:"albumId"`     // 专辑ID（外键）
-	Album       *Album    `gorm:"foreignKey:AlbumID" json:"album,omitempty"` // 关联的专辑对象
-	Genre       string    `gorm:"type:varchar(100)" json:"genre"`
-	Composer    string    `gorm:"type:varchar(255)" json:"composer"`
-	Performer   string    `gorm:"type:varchar(255)" json:"performer"`
-	Year        int       `json:"year"`
-	ReleaseDate string    `gorm:"type:varchar(50)" json:"date"`
-	TrackNumber int       `json:"trackNumber"`
-	DiscNumber  int       `json:"discNumber"`
-	Duration    int       `json:"duration"`                                  // 秒
-	FileUrl     string    `gorm:"type:varchar(768);uniqueIndex" json:"path"` // 修改为 varchar(768)，符合 MySQL utf8mb4 索引限制
-	CoverUrl    string    `gorm:"type:varchar(768)" json:"coverUrl"`         // 新增：封面路径
-	Size        int64     `json:"size"`                                      // 文件大小（字节）
-	Suffix      string    `gorm:"type:varchar(10)" json:"suffix"`            // 文件扩展名
-	BitRate     int       `json:"bitRate"`                                   // kbps
-	SampleRate  int       `json:"sampleRate"`                                // Hz
-	BitDepth    int       `json:"bitDepth"`                                  // bits
-	Channels    int       `json:"channels"`                                  // 声道数
-	HasCoverArt bool      `json:"hasCoverArt"`                               // 是否有封面
-	Label       string    `gorm:"type:varchar(255)" json:"label"`            // 唱片公司
-	Copyright   string    `gorm:"type:text" json:"copyright"`                // 版权信息
-	ISRC        string    `gorm:"type:varchar(50)" json:"isrc"`              // 国际标准录音代码
-	UPC         string    `gorm:"type:varchar(50)" json:"upc"`               // 通用产品代码
-	PlayCount   int       `gorm:"default:0" json:"playCount"`
-	LikeCount   int       `gorm:"default:0" json:"likeCount"`
-	UserID      string    `gorm:"type:varchar(36);index" json:"userId"`
-	User        *User     `gorm:"foreignKey:UserID" json:"user,omitempty"`
-	CreatedAt   time.Time `json:"createdAt"`
-	UpdatedAt   time.Time `json:"updatedAt"`
+	ID          string     `gorm:"type:varchar(36);primaryKey" json:"id"`
+	Title       string     `gorm:"type:varchar(255);index" json:"title"`
+	Artist      string     `gorm:"type:varchar(255);index" json:"artist"`
+	AlbumArtist string     `gorm:"type:varchar(255);index" json:"albumArtist"`
+	AlbumID     string     `gorm:"type:varchar(36);index" json:"albumId"`     // 专辑ID（外键）
+	Album       *Album     `gorm:"foreignKey:AlbumID" json:"album,omitempty"` // 关联的专辑对象
+	LibraryID   uint       `gorm:"index" json:"libraryId"`                    // 所属音乐库
+	Genre       string     `gorm:"type:varchar(100)" json:"genre"`
+	Composer    string     `gorm:"type:varchar(255)" json:"composer"`
+	Performer   string     `gorm:"type:varchar(255)" json:"performer"`
+	Year        int        `json:"year"`
+	ReleaseDate string     `gorm:"type:varchar(50)" json:"date"`
+	TrackNumber int        `json:"trackNumber"`
+	DiscNumber  int        `json:"discNumber"`
+	Duration    int        `json:"duration"`                                  // 秒
+	FileUrl     string     `gorm:"type:varchar(768);uniqueIndex" json:"path"` // 修改为 varchar(768)，符合 MySQL utf8mb4 索引限制
+	CoverUrl    string     `gorm:"type:varchar(768)" json:"coverUrl"`         // 新增：封面路径
+	Size        int64      `json:"size"`                                      // 文件大小（字节）
+	ModTime     *time.Time `json:"modTime,omitempty"`                         // 文件修改时间，增量扫描据此判断文件是否变化
+	Fingerprint string     `gorm:"type:varchar(64);index" json:"-"`           // 内容指纹，扫描时据此识别移动/重命名的文件
+	CueFile     string     `gorm:"type:varchar(768)" json:"-"`                // CUE 文件路径，非空表示整轨文件中的一条音轨
+	CueStart    int        `gorm:"default:0" json:"cueStart,omitempty"`       // 音轨在文件中的起始位置（毫秒）
+	CueEnd      int        `gorm:"default:0" json:"cueEnd,omitempty"`         // 音轨的结束位置（毫秒），0 表示到文件末尾
+	StreamURL   string     `gorm:"-" json:"streamUrl,omitempty"`              // 整轨文件中的音轨的播放地址，按 CUE 起止位置截取
+	Suffix      string     `gorm:"type:varchar(10)" json:"suffix"`            // 文件扩展名
+	Codec       string     `gorm:"type:varchar(32)" json:"codec"`             // 音频编码（ffprobe 的 codec_name），如 aac、alac、flac
+	BitRate     int        `json:"bitRate"`                                   // kbps
+	SampleRate  int        `json:"sampleRate"`                                // Hz
+	BitDepth    int        `json:"bitDepth"`                                  // bits
+	Channels    int        `json:"channels"`                                  // 声道数
+	TrackGain   *float64   `json:"trackGain,omitempty"`                       // ReplayGain 音轨增益 (dB)，来自标签或响度分析
+	TrackPeak   *float64   `json:"trackPeak,omitempty"`                       // 音轨峰值（线性，1 为满幅）
+	AlbumGain   *float64   `json:"albumGain,omitempty"`                       // ReplayGain 专辑增益 (dB)
+	AlbumPeak   *float64   `json:"albumPeak,omitempty"`                       // 专辑峰值（线性）
+	Loudness    *float64   `json:"loudness,omitempty"`                        // ffmpeg 测得的 EBU R128 综合响度 (LUFS)，标签中有增益时为空
+	LoudnessErr bool       `gorm:"default:false" json:"-"`                    // 响度测量失败，重新读取文件后再测量
+	HasCoverArt bool       `json:"hasCoverArt"`                               // 是否有封面
+	Label       string     `gorm:"type:varchar(255)" json:"label"`            // 唱片公司
+	Copyright   string     `gorm:"type:text" json:"copyright"`                // 版权信息
+	ISRC        string     `gorm:"type:varchar(50)" json:"isrc"`              // 国际标准录音代码
+	UPC         string     `gorm:"type:varchar(50)" json:"upc"`               // 通用产品代码
+	PlayCount   int        `gorm:"default:0" json:"playCount"`
+	LikeCount   int        `gorm:"default:0" json:"likeCount"`
+	UserID      string     `gorm:"type:varchar(36);index" json:"userId"`
+	User        *User      `gorm:"foreignKey:UserID" json:"user,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	UpdatedAt   time.Time  `json:"updatedAt"`
+
+	// MusicBrainz 标识与其他标签
+	MbzTrackID    string `gorm:"type:varchar(36);index" json:"mbzTrackId,omitempty"` // MusicBrainz 录音 ID
+	MbzAlbumID    string `gorm:"type:varchar(36);index" json:"mbzAlbumId,omitempty"` // MusicBrainz 发行 ID，扫描时优先据此归组专辑
+	MbzArtistID   string `gorm:"type:varchar(36)" json:"mbzArtistId,omitempty"`      // MusicBrainz 艺术家 ID
+	ReleaseType   string `gorm:"type:varchar(50)" json:"releaseType,omitempty"`      // 发行类型，如 album、single、ep
+	OriginalDate  string `gorm:"type:varchar(50)" json:"originalDate,omitempty"`     // 原始发行日期
+	CatalogNumber string `gorm:"type:varchar(100)" json:"catalogNumber,omitempty"`   // 唱片目录编号
+	BPM           int    `json:"bpm,omitempty"`                                      // 每分钟节拍数
+	Mood          string `gorm:"type:varchar(100)" json:"mood,omitempty"`            // 情绪
 }
 
 // BeforeCreate GORM 钩子，在创建记录前自动生成 8 位 UUID
@@ -53,3 +78,26 @@ func (music *Music) BeforeCreate(tx *gorm.DB) (err error) {
 func (Music) TableName() string {
 	return "music"
 }
+
+// AfterFind GORM 钩子，整轨文件中的音轨不能直接播放 FileUrl，改为经由截取音轨的播放接口
+func (music *Music) AfterFind(tx *gorm.DB) (err error) {
+	if music.CueFile != "" && music.ID != "" {
+		music.StreamURL = "/api/musics/" + music.ID + "/stream"
+	}
+	return
+}
+
+// CueTrackPath 整轨文件中一条音轨的 FileUrl："音频文件#音轨号"
+func CueTrackPath(audioPath string, track int) string {
+	return fmt.Sprintf("%s#%02d", audioPath, track)
+}
+
+// FilePath 返回歌曲所在的音频文件，整轨文件中的音轨去掉 FileUrl 末尾的 "#音轨号"
+func (music *Music) FilePath() string {
+	if music.CueFile != "" {
+		if i := strings.LastIndex(music.FileUrl, "#"); i >= 0 {
+			return music.FileUrl[:i]
+		}
+	}
+	return music.FileUrl
+}
@@ -0,0 +1,80 @@
+package entity
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// PlayEvent 播放记录
+// Submission 为 true 表示播放完成（计入播放次数），false 表示仅上报"正在播放"
+type PlayEvent struct {
+	ID         string    `gorm:"type:varchar(8);primaryKey" json:"id"`
+	UserID     string    `gorm:"type:varchar(36);index:idx_play_events_user_played;not null" json:"userId"`
+	MusicID    string    `gorm:"type:varchar(36);index;not null" json:"musicId"`
+	PlayedAt   time.Time `gorm:"index:idx_play_events_user_played" json:"playedAt"`
+	Client     string    `gorm:"type:varchar(100)" json:"client"`
+	Submission bool      `gorm:"default:true" json:"submission"`
+	CreatedAt  time.Time `gorm:"autoCreateTime" json:"createdAt"`
+}
+
+// BeforeCreate GORM 钩子，在创建记录前自动生成 8 位 UUID
+func (e *PlayEvent) BeforeCreate(tx *gorm.DB) (err error) {
+	e.ID = strings.ToUpper(uuid.New().String()[:8])
+	return
+}
+
+// TableName 指定表名
+func (PlayEvent) TableName() string {
+	return "play_events"
+}
+
+// RecordPlay 写入播放记录；submission 时累加全局播放次数以及用户对歌曲和所属专辑的播放次数、最后播放时间
+func RecordPlay(db *gorm.DB, userID string, music *Music, playedAt time.Time, client string, submission bool) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		event := PlayEvent{
+			UserID:     userID,
+			MusicID:    music.ID,
+			PlayedAt:   playedAt,
+			Client:     client,
+			Submission: submission,
+		}
+		if err := tx.Create(&event).Error; err != nil {
+			return err
+		}
+		if !submission {
+			return nil
+		}
+
+		if err := tx.Model(&Music{}).Where("id = ?", music.ID).
+			UpdateColumn("play_count", gorm.Expr("play_count + 1")).Error; err != nil {
+			return err
+		}
+		if err := addPlay(tx, userID, ItemTypeMusic, music.ID, playedAt); err != nil {
+			return err
+		}
+		if music.AlbumID == "" {
+			return nil
+		}
+		return addPlay(tx, userID, ItemTypeAlbum, music.AlbumID, playedAt)
+	})
+}
+
+// addPlay 累加用户对条目的播放次数，最后播放时间只会前移（迟到的离线 scrobble 不覆盖更新的记录）
+func addPlay(tx *gorm.DB, userID string, itemType ItemType, id string, playedAt time.Time) error {
+	row := Annotation{UserID: userID, ItemType: itemType, ItemID: id}
+	if err := tx.Clauses(clause.OnConflict{Columns: annotationKey, DoNothing: true}).Create(&row).Error; err != nil {
+		return err
+	}
+	key := tx.Model(&Annotation{}).
+		Where("user_id = ? AND item_type = ? AND item_id = ?", userID, itemType, id).
+		Session(&gorm.Session{})
+	if err := key.UpdateColumn("play_count", gorm.Expr("play_count + 1")).Error; err != nil {
+		return err
+	}
+	return key.Where("played_at IS NULL OR played_at < ?", playedAt).
+		UpdateColumn("played_at", playedAt).Error
+}
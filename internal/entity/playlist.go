@@ -6,6 +6,7 @@ import (
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // Playlist 播放列表实体
@@ -18,7 +19,7 @@ type Playlist struct {
 	User        User           `gorm:"foreignKey:UserID" json:"user"`
 	IsPublic    bool           `gorm:"default:true" json:"is_public"`
 	PlayCount   int            `gorm:"type:int;default:0" json:"play_count"`
-	Musics      []*Music       `gorm:"many2many:playlist_musics;" json:"musics"`
+	Musics      []*Music       `gorm:"-" json:"musics"` // 按 PlaylistMusic.Position 顺序加载，见 LoadPlaylistMusics
 	CreatedAt   time.Time      `gorm:"autoCreateTime" json:"created_at"`
 	UpdatedAt   time.Time      `gorm:"autoUpdateTime" json:"updated_at"`
 	DeletedAt   gorm.DeletedAt `gorm:"index" json:"-"`
@@ -35,14 +36,25 @@ func (Playlist) TableName() string {
 	return "playlists"
 }
 
-// PlaylistMusic 播放列表和音乐的中间表
+// PlaylistMusic 播放列表和音乐的中间表，以 (playlist_id, position) 为主键，同一首歌可以出现多次
 type PlaylistMusic struct {
 	PlaylistID string `gorm:"type:varchar(8);primaryKey" json:"playlist_id"`
-	MusicID    string `gorm:"type:varchar(8);primaryKey" json:"music_id"`
-	Order      int    `gorm:"type:int;default:0;comment:排序" json:"order"`
+	Position   int    `gorm:"primaryKey;autoIncrement:false;comment:排序" json:"position"`
+	MusicID    string `gorm:"type:varchar(8);not null;index" json:"music_id"`
 }
 
 // TableName 指定表名
 func (PlaylistMusic) TableName() string {
 	return "playlist_musics"
 }
+
+// LoadPlaylistMusics 按位置顺序加载播放列表中的歌曲，重复的歌曲各占一项
+func LoadPlaylistMusics(db *gorm.DB, playlistID string, scopes ...func(*gorm.DB) *gorm.DB) ([]Music, error) {
+	var musics []Music
+	err := db.Scopes(scopes...).
+		Joins("JOIN playlist_musics ON playlist_musics.music_id = music.id").
+		Where("playlist_musics.playlist_id = ?", playlistID).
+		Order(clause.OrderByColumn{Column: clause.Column{Table: "playlist_musics", Name: "position"}}).
+		Find(&musics).Error
+	return musics, err
+}
@@ -0,0 +1,51 @@
+package entity
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ScanStatus 扫描任务状态
+type ScanStatus string
+
+const (
+	ScanRunning   ScanStatus = "running"
+	ScanCompleted ScanStatus = "completed"
+	ScanFailed    ScanStatus = "failed"
+	ScanCancelled ScanStatus = "cancelled"
+)
+
+// ScanJob 一次音乐库扫描的记录，运行中的计数会随进度更新
+type ScanJob struct {
+	ID           string     `gorm:"type:varchar(8);primaryKey" json:"id"`
+	Status       ScanStatus `gorm:"type:varchar(20);index" json:"status"`
+	Trigger      string     `gorm:"type:varchar(20)" json:"trigger"` // startup、api、subsonic、watcher、schedule
+	FullScan     bool       `json:"fullScan"`
+	LibraryIDs   []uint     `gorm:"type:text;serializer:json" json:"libraryIds"`      // 扫描的音乐库
+	Paths        []string   `gorm:"type:text;serializer:json" json:"paths,omitempty"` // 只扫描的目录，为空表示整个音乐库
+	StartedAt    time.Time  `gorm:"index" json:"startedAt"`
+	FinishedAt   *time.Time `json:"finishedAt,omitempty"`
+	ScannedFiles int        `json:"scannedFiles"`
+	Added        int        `json:"added"`
+	Updated      int        `json:"updated"`
+	Unchanged    int        `json:"unchanged"`
+	Moved        int        `json:"moved"`
+	Removed      int        `json:"removed"`
+	ErrorCount   int        `json:"errorCount"`
+	Errors       []string   `gorm:"type:text;serializer:json" json:"errors"`
+	Message      string     `gorm:"type:text" json:"message,omitempty"` // 扫描失败的原因
+}
+
+// BeforeCreate GORM 钩子，在创建记录前自动生成 8 位 UUID
+func (j *ScanJob) BeforeCreate(tx *gorm.DB) (err error) {
+	j.ID = strings.ToUpper(uuid.New().String()[:8])
+	return
+}
+
+// TableName 指定表名
+func (ScanJob) TableName() string {
+	return "scan_jobs"
+}
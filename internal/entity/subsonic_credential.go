@@ -0,0 +1,66 @@
+package entity
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// CredentialType Subsonic 凭据类型
+type CredentialType string
+
+const (
+	CredentialAppPassword CredentialType = "password" // 应用密码：支持 p= 与 t+s 认证
+	CredentialAPIKey      CredentialType = "apikey"   // OpenSubsonic apiKey
+)
+
+// IsValid 检查凭据类型是否有效
+func (t CredentialType) IsValid() bool {
+	return t == CredentialAppPassword || t == CredentialAPIKey
+}
+
+// SubsonicCredential Subsonic 客户端专用凭据
+// 用户登录密码只保存 bcrypt 哈希，无法校验 t=md5(password+salt)，
+// 因此为 Subsonic 客户端单独保存一份可校验的明文应用密码 / apiKey
+type SubsonicCredential struct {
+	ID         string         `gorm:"type:varchar(8);primaryKey" json:"id"`
+	UserID     string         `gorm:"type:varchar(36);index;not null" json:"userId"`
+	User       *User          `gorm:"foreignKey:UserID" json:"user,omitempty"`
+	Type       CredentialType `gorm:"type:varchar(20);not null" json:"type"`
+	Name       string         `gorm:"type:varchar(100)" json:"name"`             // 备注，如客户端名称
+	Secret     string         `gorm:"type:varchar(128);index;not null" json:"-"` // 应用密码或 apiKey
+	LastUsedAt *time.Time     `json:"lastUsedAt"`
+	CreatedAt  time.Time      `gorm:"autoCreateTime" json:"createdAt"`
+	UpdatedAt  time.Time      `gorm:"autoUpdateTime" json:"updatedAt"`
+}
+
+// BeforeCreate GORM 钩子，生成 ID，未指定密钥时自动生成随机密钥
+func (cred *SubsonicCredential) BeforeCreate(tx *gorm.DB) (err error) {
+	cred.ID = strings.ToUpper(uuid.New().String()[:8])
+	if cred.Secret == "" {
+		cred.Secret, err = GenerateSecret(cred.Type)
+	}
+	return
+}
+
+// GenerateSecret 生成随机密钥：apiKey 为 32 字节十六进制，应用密码为 8 字节十六进制便于手动输入
+func GenerateSecret(t CredentialType) (string, error) {
+	n := 8
+	if t == CredentialAPIKey {
+		n = 32
+	}
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// TableName 指定表名
+func (SubsonicCredential) TableName() string {
+	return "subsonic_credentials"
+}
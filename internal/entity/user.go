@@ -11,16 +11,18 @@ import (
 
 // User 用户实体
 type User struct {
-	ID        string         `gorm:"type:varchar(36);primaryKey" json:"id"`
-	Username  string         `gorm:"type:varchar(50);uniqueIndex;not null" json:"username"`
-	Email     string         `gorm:"type:varchar(100);uniqueIndex;not null" json:"email"`
-	Password  string         `gorm:"type:varchar(60);not null" json:"-"`
-	Avatar    string         `gorm:"type:varchar(255)" json:"avatar"`
-	Role      Role           `gorm:"type:varchar(20);default:'user'" json:"role"` // 使用 Role 枚举
-	Status    int            `gorm:"type:tinyint;default:1;comment:状态 1:正常 0:禁用" json:"status"`
-	CreatedAt time.Time      `gorm:"autoCreateTime" json:"created_at"`
-	UpdatedAt time.Time      `gorm:"autoUpdateTime" json:"updated_at"`
-	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+	ID         string         `gorm:"type:varchar(36);primaryKey" json:"id"`
+	Username   string         `gorm:"type:varchar(50);uniqueIndex;not null" json:"username"`
+	Email      string         `gorm:"type:varchar(100);uniqueIndex;not null" json:"email"`
+	Password   string         `gorm:"type:varchar(60);not null" json:"-"`
+	Avatar     string         `gorm:"type:varchar(255)" json:"avatar"`
+	Role       Role           `gorm:"type:varchar(20);default:'user'" json:"role"` // 使用 Role 枚举
+	Status     int            `gorm:"type:tinyint;default:1;comment:状态 1:正常 0:禁用" json:"status"`
+	MaxBitRate int            `gorm:"default:0;comment:默认最大播放码率(kbps) 0:不限制" json:"maxBitRate"`
+	Restricted bool           `gorm:"default:false;comment:只能访问分配的音乐库" json:"restricted"` // 见 UserLibrary
+	CreatedAt  time.Time      `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt  time.Time      `gorm:"autoUpdateTime" json:"updated_at"`
+	DeletedAt  gorm.DeletedAt `gorm:"index" json:"-"`
 }
 
 // BeforeCreate 是一个 GORM 钩子，在创建记录之前被调用
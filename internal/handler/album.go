@@ -3,6 +3,7 @@ package handler
 import (
 	"saboriman-music/internal/dto"
 	"saboriman-music/internal/entity"
+	"saboriman-music/internal/library"
 	"saboriman-music/internal/utils"
 
 	"github.com/gofiber/fiber/v2"
@@ -56,8 +57,17 @@ func (h *AlbumHandler) GetAlbum(c *fiber.Ctx) error {
 		}
 		return utils.SendError(c, "查询专辑失败")
 	}
+	if !canAccess(h.db, c, album.LibraryID) {
+		return utils.SendError(c, "专辑不存在")
+	}
 
-	return utils.SendSuccess(c, "获取专辑成功", album)
+	// 附加当前用户的收藏与评分
+	userID, _ := c.Locals("userID").(string)
+	responses, err := annotateAlbums(h.db, userID, []entity.Album{album})
+	if err != nil {
+		return utils.SendError(c, "获取收藏信息失败")
+	}
+	return utils.SendSuccess(c, "获取专辑成功", responses[0])
 }
 
 // UpdateAlbum 更新专辑
@@ -113,15 +123,24 @@ func (h *AlbumHandler) ListAlbums(c *fiber.Ctx) error {
 	pageSize := c.QueryInt("page_size", 10)
 	query := c.Query("q")
 
+	ids, err := libraryScope(h.db, c)
+	if err != nil {
+		return sendScopeError(c, err)
+	}
+
 	var albums []entity.Album
 	var total int64
 
-	dbQuery := h.db.Model(&entity.Album{})
+	dbQuery := h.db.Model(&entity.Album{}).Scopes(library.Scope("album", ids))
 
 	if query != "" {
 		searchQuery := "%" + query + "%"
 		dbQuery = dbQuery.Where("name LIKE ? OR artist_name LIKE ?", searchQuery, searchQuery)
 	}
+	// compilation=true 只看合辑，false 排除合辑
+	if v := c.Query("compilation"); v != "" {
+		dbQuery = dbQuery.Where("is_compilation = ?", c.QueryBool("compilation"))
+	}
 
 	if err := dbQuery.Count(&total).Error; err != nil {
 		return utils.SendError(c, "获取专辑总数失败")
@@ -132,10 +151,17 @@ func (h *AlbumHandler) ListAlbums(c *fiber.Ctx) error {
 		return utils.SendError(c, "获取专辑列表失败")
 	}
 
+	// 附加当前用户的收藏与评分
+	userID, _ := c.Locals("userID").(string)
+	albumResponses, err := annotateAlbums(h.db, userID, albums)
+	if err != nil {
+		return utils.SendError(c, "获取收藏信息失败")
+	}
+
 	totalPages := (total + int64(pageSize) - 1) / int64(pageSize)
 
 	result := map[string]interface{}{
-		"data":       albums,
+		"data":       albumResponses,
 		"total":      total,
 		"page":       page,
 		"totalPages": totalPages,
@@ -154,6 +180,15 @@ func (h *AlbumHandler) GetAlbumMusics(c *fiber.Ctx) error {
 		}
 		return utils.SendError(c, "查询专辑失败")
 	}
+	if !canAccess(h.db, c, album.LibraryID) {
+		return utils.SendError(c, "专辑不存在")
+	}
 
-	return utils.SendSuccess(c, "获取专辑音乐列表成功", album.Musics)
+	// 附加当前用户的收藏与评分
+	userID, _ := c.Locals("userID").(string)
+	musicResponses, err := annotateMusics(h.db, userID, album.Musics)
+	if err != nil {
+		return utils.SendError(c, "获取收藏信息失败")
+	}
+	return utils.SendSuccess(c, "获取专辑音乐列表成功", musicResponses)
 }
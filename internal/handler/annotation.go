@@ -0,0 +1,207 @@
+package handler
+
+import (
+	"saboriman-music/internal/artist"
+	"saboriman-music/internal/dto"
+	"saboriman-music/internal/entity"
+	"saboriman-music/internal/library"
+	"saboriman-music/internal/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// AnnotationHandler 收藏与评分处理器
+type AnnotationHandler struct {
+	db *gorm.DB
+}
+
+// NewAnnotationHandler 创建收藏与评分处理器
+func NewAnnotationHandler(db *gorm.DB) *AnnotationHandler {
+	return &AnnotationHandler{db: db}
+}
+
+// itemExists 检查被标注的条目是否存在，且在当前用户可以访问的音乐库中
+func itemExists(db *gorm.DB, c *fiber.Ctx, itemType entity.ItemType, id string) (bool, error) {
+	libraries, err := accessibleLibraries(db, c)
+	if err != nil {
+		return false, err
+	}
+	var count int64
+	switch itemType {
+	case entity.ItemTypeMusic:
+		err = db.Model(&entity.Music{}).Scopes(library.Scope("music", libraries)).Where("id = ?", id).Count(&count).Error
+	case entity.ItemTypeAlbum:
+		err = db.Model(&entity.Album{}).Scopes(library.Scope("album", libraries)).Where("id = ?", id).Count(&count).Error
+	case entity.ItemTypeArtist:
+		err = db.Model(&entity.Artist{}).Scopes(artist.Scope(libraries)).Where("artists.id = ?", id).Count(&count).Error
+	}
+	return count > 0, err
+}
+
+// Star 收藏条目，如 POST /api/musics/:id/star
+func (h *AnnotationHandler) Star(itemType entity.ItemType) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return h.setStarred(c, itemType, true)
+	}
+}
+
+// Unstar 取消收藏，如 DELETE /api/musics/:id/star
+func (h *AnnotationHandler) Unstar(itemType entity.ItemType) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return h.setStarred(c, itemType, false)
+	}
+}
+
+func (h *AnnotationHandler) setStarred(c *fiber.Ctx, itemType entity.ItemType, starred bool) error {
+	userID, ok := c.Locals("userID").(string)
+	if !ok || userID == "" {
+		return utils.SendError(c, "未认证的用户")
+	}
+	id := c.Params("id")
+	exists, err := itemExists(h.db, c, itemType, id)
+	if err != nil {
+		return utils.SendError(c, "查询失败")
+	}
+	if !exists {
+		return utils.SendError(c, "收藏对象不存在")
+	}
+
+	if err := entity.SetStarred(h.db, userID, itemType, []string{id}, starred); err != nil {
+		return utils.SendError(c, "更新收藏失败: "+err.Error())
+	}
+	if starred {
+		return utils.SendSuccess(c, "收藏成功", nil)
+	}
+	return utils.SendSuccess(c, "已取消收藏", nil)
+}
+
+// SetRating 设置评分（1~5，0 表示清除），如 PUT /api/musics/:id/rating
+func (h *AnnotationHandler) SetRating(itemType entity.ItemType) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, ok := c.Locals("userID").(string)
+		if !ok || userID == "" {
+			return utils.SendError(c, "未认证的用户")
+		}
+		var req dto.SetRatingRequest
+		if err := c.BodyParser(&req); err != nil {
+			return utils.SendError(c, "请求参数解析失败")
+		}
+		if req.Rating < 0 || req.Rating > 5 {
+			return utils.SendError(c, "评分必须在 0~5 之间")
+		}
+		id := c.Params("id")
+		exists, err := itemExists(h.db, c, itemType, id)
+		if err != nil {
+			return utils.SendError(c, "查询失败")
+		}
+		if !exists {
+			return utils.SendError(c, "评分对象不存在")
+		}
+
+		if err := entity.SetRating(h.db, userID, itemType, id, req.Rating); err != nil {
+			return utils.SendError(c, "设置评分失败: "+err.Error())
+		}
+		return utils.SendSuccess(c, "评分成功", nil)
+	}
+}
+
+// ListStarred 获取当前用户收藏的歌曲、专辑和艺术家
+func (h *AnnotationHandler) ListStarred(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(string)
+	if !ok || userID == "" {
+		return utils.SendError(c, "未认证的用户")
+	}
+
+	// 收藏后被移出访问范围的条目不再显示
+	libraries, err := accessibleLibraries(h.db, c)
+	if err != nil {
+		return utils.SendError(c, "获取收藏失败")
+	}
+
+	var musics []entity.Music
+	if err := h.db.Scopes(library.Scope("music", libraries)).
+		Where("id IN (?)", entity.StarredIDs(h.db, userID, entity.ItemTypeMusic)).
+		Order("title ASC").
+		Find(&musics).Error; err != nil {
+		return utils.SendError(c, "获取收藏歌曲失败")
+	}
+	musicResponses, err := annotateMusics(h.db, userID, musics)
+	if err != nil {
+		return utils.SendError(c, "获取收藏歌曲失败")
+	}
+
+	var albums []entity.Album
+	if err := h.db.Scopes(library.Scope("album", libraries)).
+		Where("id IN (?)", entity.StarredIDs(h.db, userID, entity.ItemTypeAlbum)).
+		Order("name ASC").
+		Find(&albums).Error; err != nil {
+		return utils.SendError(c, "获取收藏专辑失败")
+	}
+
+	albumResponses, err := annotateAlbums(h.db, userID, albums)
+	if err != nil {
+		return utils.SendError(c, "获取收藏专辑失败")
+	}
+
+	var artists []entity.Annotation
+	if err := h.db.Where("user_id = ? AND item_type = ? AND starred_at IS NOT NULL", userID, entity.ItemTypeArtist).
+		Order("starred_at DESC").
+		Find(&artists).Error; err != nil {
+		return utils.SendError(c, "获取收藏艺术家失败")
+	}
+
+	return utils.SendSuccess(c, "获取收藏成功", dto.StarredResponse{
+		Musics:  musicResponses,
+		Albums:  albumResponses,
+		Artists: artists,
+	})
+}
+
+// annotateMusics 为歌曲列表附加当前用户的收藏与评分
+func annotateMusics(db *gorm.DB, userID string, musics []entity.Music) ([]dto.ListMusicResponse, error) {
+	ids := make([]string, 0, len(musics))
+	for _, m := range musics {
+		ids = append(ids, m.ID)
+	}
+	annotations, err := entity.LoadAnnotations(db, userID, entity.ItemTypeMusic, ids)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]dto.ListMusicResponse, 0, len(musics))
+	for _, m := range musics {
+		a := annotations[m.ID]
+		result = append(result, dto.ListMusicResponse{
+			Music:         m,
+			Favorited:     a.StarredAt != nil,
+			StarredAt:     a.StarredAt,
+			Rating:        a.Rating,
+			UserPlayCount: a.PlayCount,
+			PlayedAt:      a.PlayedAt,
+		})
+	}
+	return result, nil
+}
+
+// annotateAlbums 为专辑列表附加当前用户的收藏与评分
+func annotateAlbums(db *gorm.DB, userID string, albums []entity.Album) ([]dto.ListAlbumResponse, error) {
+	ids := make([]string, 0, len(albums))
+	for _, a := range albums {
+		ids = append(ids, a.ID)
+	}
+	annotations, err := entity.LoadAnnotations(db, userID, entity.ItemTypeAlbum, ids)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]dto.ListAlbumResponse, 0, len(albums))
+	for _, album := range albums {
+		a := annotations[album.ID]
+		result = append(result, dto.ListAlbumResponse{
+			Album:     album,
+			Favorited: a.StarredAt != nil,
+			StarredAt: a.StarredAt,
+			Rating:    a.Rating,
+		})
+	}
+	return result, nil
+}
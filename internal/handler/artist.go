@@ -0,0 +1,149 @@
+package handler
+
+import (
+	"saboriman-music/internal/artist"
+	"saboriman-music/internal/dto"
+	"saboriman-music/internal/entity"
+	"saboriman-music/internal/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// ArtistHandler 艺术家处理器
+type ArtistHandler struct {
+	db *gorm.DB
+}
+
+// NewArtistHandler 创建艺术家处理器实例
+func NewArtistHandler(db *gorm.DB) *ArtistHandler {
+	return &ArtistHandler{db: db}
+}
+
+// artistRoles 解析 role 查询参数，为空表示不限角色
+func artistRoles(c *fiber.Ctx) ([]entity.ArtistRole, bool) {
+	role := entity.ArtistRole(c.Query("role"))
+	if role == "" {
+		return nil, true
+	}
+	return []entity.ArtistRole{role}, role.IsValid()
+}
+
+// withStats 为艺术家附加统计信息
+func (h *ArtistHandler) withStats(libraries []uint, artists []entity.Artist) ([]dto.ArtistResponse, error) {
+	ids := make([]string, 0, len(artists))
+	for _, a := range artists {
+		ids = append(ids, a.ID)
+	}
+	stats, err := artist.LoadStats(h.db, libraries, ids)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]dto.ArtistResponse, 0, len(artists))
+	for _, a := range artists {
+		result = append(result, dto.ArtistResponse{Artist: a, Stats: *stats[a.ID]})
+	}
+	return result, nil
+}
+
+// ListArtists 获取艺术家列表，可按名称（q）、角色（role）与音乐库（libraryId）筛选
+func (h *ArtistHandler) ListArtists(c *fiber.Ctx) error {
+	page := c.QueryInt("page", 1)
+	pageSize := c.QueryInt("page_size", 10)
+	query := c.Query("q")
+	roles, ok := artistRoles(c)
+	if !ok {
+		return utils.SendError(c, "无效的艺术家角色")
+	}
+
+	libraries, err := libraryScope(h.db, c)
+	if err != nil {
+		return sendScopeError(c, err)
+	}
+
+	dbQuery := h.db.Model(&entity.Artist{}).Scopes(artist.Scope(libraries, roles...))
+	if query != "" {
+		dbQuery = dbQuery.Where("artists.name LIKE ?", "%"+query+"%")
+	}
+
+	var total int64
+	if err := dbQuery.Count(&total).Error; err != nil {
+		return utils.SendError(c, "获取艺术家总数失败")
+	}
+
+	var artists []entity.Artist
+	offset := (page - 1) * pageSize
+	if err := dbQuery.Order("artists.name ASC").Offset(offset).Limit(pageSize).Find(&artists).Error; err != nil {
+		return utils.SendError(c, "获取艺术家列表失败")
+	}
+	list, err := h.withStats(libraries, artists)
+	if err != nil {
+		return utils.SendError(c, "获取艺术家列表失败")
+	}
+
+	totalPages := (total + int64(pageSize) - 1) / int64(pageSize)
+
+	result := map[string]interface{}{
+		"data":       list,
+		"total":      total,
+		"page":       page,
+		"totalPages": totalPages,
+	}
+	return utils.SendSuccess(c, "获取艺术家列表成功", result)
+}
+
+// findArtist 查询当前用户可以访问的艺术家
+func (h *ArtistHandler) findArtist(c *fiber.Ctx, libraries []uint) (*entity.Artist, error) {
+	var a entity.Artist
+	err := h.db.Scopes(artist.Scope(libraries)).First(&a, "artists.id = ?", c.Params("id")).Error
+	return &a, err
+}
+
+// GetArtist 获取艺术家信息
+func (h *ArtistHandler) GetArtist(c *fiber.Ctx) error {
+	libraries, err := accessibleLibraries(h.db, c)
+	if err != nil {
+		return sendScopeError(c, err)
+	}
+	a, err := h.findArtist(c, libraries)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return utils.SendError(c, "艺术家不存在")
+		}
+		return utils.SendError(c, "查询艺术家失败")
+	}
+
+	list, err := h.withStats(libraries, []entity.Artist{*a})
+	if err != nil {
+		return utils.SendError(c, "查询艺术家失败")
+	}
+	return utils.SendSuccess(c, "获取艺术家成功", list[0])
+}
+
+// ListArtistAlbums 获取艺术家参与的专辑，role 指定时只返回以该角色参与的专辑
+func (h *ArtistHandler) ListArtistAlbums(c *fiber.Ctx) error {
+	roles, ok := artistRoles(c)
+	if !ok {
+		return utils.SendError(c, "无效的艺术家角色")
+	}
+	libraries, err := libraryScope(h.db, c)
+	if err != nil {
+		return sendScopeError(c, err)
+	}
+	a, err := h.findArtist(c, libraries)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return utils.SendError(c, "艺术家不存在")
+		}
+		return utils.SendError(c, "查询艺术家失败")
+	}
+
+	var albums []entity.Album
+	if err := h.db.Scopes(artist.AlbumScope(libraries, a.ID, roles...)).
+		Order("release_date ASC").
+		Order("name ASC").
+		Find(&albums).Error; err != nil {
+		return utils.SendError(c, "获取艺术家专辑失败")
+	}
+	return utils.SendSuccess(c, "获取艺术家专辑成功", albums)
+}
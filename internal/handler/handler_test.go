@@ -0,0 +1,587 @@
+package handler_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"saboriman-music/config"
+	"saboriman-music/internal/artist"
+	database "saboriman-music/internal/db"
+	"saboriman-music/internal/entity"
+	"saboriman-music/internal/library"
+	"saboriman-music/internal/router"
+	"saboriman-music/internal/scanner"
+	"saboriman-music/internal/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// apiEnv 测试用的 JSON API：默认音乐库 /music 中有一首歌，另一个音乐库 /other 中有一首歌
+type apiEnv struct {
+	app   *fiber.App
+	db    *gorm.DB
+	user  entity.User
+	token string
+	admin string // 管理员的认证令牌
+	other entity.Library
+	song  entity.Music // 默认音乐库中的歌曲
+	hid   entity.Music // /other 中的歌曲
+}
+
+func setup(t *testing.T) *apiEnv {
+	t.Helper()
+	prev := config.AppConfig
+	t.Cleanup(func() { config.AppConfig = prev })
+	config.AppConfig = &config.Config{
+		MusicFolder: "/music",
+		Transcoding: config.TranscodingConfig{CacheDir: filepath.Join(os.TempDir(), "saboriman-test-transcode")},
+	}
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	// 内存库每个连接独立，限制为单连接保证迁移与查询在同一个库
+	if sqlDB, err := db.DB(); err == nil {
+		sqlDB.SetMaxOpenConns(1)
+	}
+	if err := db.AutoMigrate(database.GetAllEntities()...); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	env := &apiEnv{db: db}
+	env.user = entity.User{Username: "test", Email: "test@localhost", Password: "test", Role: entity.RoleUser, Status: 1}
+	if err := db.Create(&env.user).Error; err != nil {
+		t.Fatalf("seed user: %v", err)
+	}
+	if err := library.EnsureDefault(db, "/music"); err != nil {
+		t.Fatalf("seed library: %v", err)
+	}
+	env.other = entity.Library{Name: "other", Path: "/other"}
+	db.Create(&env.other)
+	library.Load(db)
+
+	env.song = entity.Music{Title: "Song", Artist: "Artist A", FileUrl: "/music/a.mp3", Suffix: "mp3", LibraryID: 1}
+	env.hid = entity.Music{Title: "Hidden", Artist: "Artist B", FileUrl: "/other/b.mp3", Suffix: "mp3", LibraryID: env.other.ID}
+	if err := db.Create(&env.song).Error; err != nil {
+		t.Fatalf("seed music: %v", err)
+	}
+	db.Create(&env.hid)
+
+	env.token, err = utils.GenerateToken(env.user.ID, env.user.Username, env.user.Email, string(env.user.Role))
+	if err != nil {
+		t.Fatalf("token: %v", err)
+	}
+	admin := entity.User{Username: "admin", Email: "admin@localhost", Password: "admin", Role: entity.RoleAdmin, Status: 1}
+	if err := db.Create(&admin).Error; err != nil {
+		t.Fatalf("seed admin: %v", err)
+	}
+	env.admin, err = utils.GenerateToken(admin.ID, admin.Username, admin.Email, string(admin.Role))
+	if err != nil {
+		t.Fatalf("admin token: %v", err)
+	}
+	env.app = fiber.New()
+	scans := scanner.NewManager()
+	t.Cleanup(func() { scans.Cancel() })
+	router.SetupRoutes(env.app, db, scans)
+	return env
+}
+
+// do 以测试用户的身份发送请求，返回状态码与解析后的响应
+func (e *apiEnv) do(t *testing.T, method, path, body string) (int, map[string]interface{}) {
+	t.Helper()
+	return e.doAs(t, e.token, method, path, body)
+}
+
+// doAs 以指定令牌发送请求
+func (e *apiEnv) doAs(t *testing.T, token, method, path, body string) (int, map[string]interface{}) {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	res, err := e.app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	data, _ := io.ReadAll(res.Body)
+	result := map[string]interface{}{}
+	if err := json.Unmarshal(data, &result); err != nil {
+		t.Fatalf("%s %s: invalid json %q", method, path, data)
+	}
+	return res.StatusCode, result
+}
+
+func TestDeleteLastGrantedLibrary(t *testing.T) {
+	env := setup(t)
+	if err := library.SetUserLibraries(env.db, env.user.ID, []uint{env.other.ID}); err != nil {
+		t.Fatalf("grant: %v", err)
+	}
+	if code, _ := env.do(t, "GET", "/api/musics/"+env.song.ID, ""); code == 200 {
+		t.Fatalf("expected song outside the granted library to be hidden")
+	}
+
+	if code, body := env.doAs(t, env.admin, "DELETE", fmt.Sprintf("/api/libraries/%d", env.other.ID), ""); code != 200 {
+		t.Fatalf("delete library: %d %v", code, body)
+	}
+
+	ids, err := library.Accessible(env.db, env.user.ID, env.user.Role)
+	if err != nil || ids == nil || len(ids) != 0 {
+		t.Fatalf("expected no accessible libraries, got %v (%v)", ids, err)
+	}
+	if code, _ := env.do(t, "GET", "/api/musics/"+env.song.ID, ""); code == 200 {
+		t.Fatalf("expected access not to widen after deleting the last granted library")
+	}
+	_, body := env.do(t, "GET", "/api/libraries", "")
+	if libs, _ := body["data"].([]interface{}); len(libs) != 0 {
+		t.Fatalf("expected no libraries, got %v", body["data"])
+	}
+
+	// 显式取消限制后恢复访问全部音乐库
+	if err := library.SetUserLibraries(env.db, env.user.ID, nil); err != nil {
+		t.Fatalf("unrestrict: %v", err)
+	}
+	if code, body := env.do(t, "GET", "/api/musics/"+env.song.ID, ""); code != 200 {
+		t.Fatalf("expected access after removing the restriction: %d %v", code, body)
+	}
+}
+
+func TestHiddenLibraryItems(t *testing.T) {
+	env := setup(t)
+	if err := library.SetUserLibraries(env.db, env.user.ID, []uint{1}); err != nil {
+		t.Fatalf("grant: %v", err)
+	}
+	playlist := entity.Playlist{Name: "mine", UserID: env.user.ID}
+	env.db.Create(&playlist)
+
+	tests := []struct {
+		name, method, path, body string
+	}{
+		{"lyrics", "GET", "/api/musics/%s/lyrics", ""},
+		{"star", "POST", "/api/musics/%s/star", ""},
+		{"like", "POST", "/api/musics/%s/like", ""},
+		{"rating", "PUT", "/api/musics/%s/rating", `{"rating":5}`},
+		{"playlist", "POST", "/api/playlists/" + playlist.ID + "/musics", `{"musicId":"%s"}`},
+		{"favorite", "POST", "/api/playlists/favorite", `{"musicId":"%s"}`},
+		{"update", "PUT", "/api/musics/%s", `{"title":"Renamed"}`},
+		{"delete", "DELETE", "/api/musics/%s", ""},
+	}
+	for _, tt := range tests {
+		path, body := tt.path, tt.body
+		if strings.Contains(path, "%s") {
+			path = fmt.Sprintf(path, env.hid.ID)
+		} else {
+			body = fmt.Sprintf(body, env.hid.ID)
+		}
+		if code, resp := env.do(t, tt.method, path, body); code == 200 {
+			t.Errorf("%s: expected song in an inaccessible library to be rejected, got %v", tt.name, resp)
+		}
+	}
+
+	var hid entity.Music
+	if err := env.db.First(&hid, "id = ?", env.hid.ID).Error; err != nil || hid.Title != "Hidden" {
+		t.Fatalf("expected hidden song to be left untouched: %+v (%v)", hid, err)
+	}
+	var count int64
+	env.db.Model(&entity.Annotation{}).Where("item_id = ?", env.hid.ID).Count(&count)
+	if count != 0 {
+		t.Fatalf("expected no annotations on the hidden song, got %d", count)
+	}
+	env.db.Model(&entity.PlaylistMusic{}).Where("music_id = ?", env.hid.ID).Count(&count)
+	if count != 0 {
+		t.Fatalf("expected hidden song not to be added to the playlist")
+	}
+
+	// 可以访问的歌曲不受影响
+	if code, resp := env.do(t, "POST", "/api/musics/"+env.song.ID+"/star", ""); code != 200 {
+		t.Fatalf("star accessible song: %d %v", code, resp)
+	}
+	if code, resp := env.do(t, "POST", "/api/playlists/"+playlist.ID+"/musics", `{"musicId":"`+env.song.ID+`"}`); code != 200 {
+		t.Fatalf("add accessible song: %d %v", code, resp)
+	}
+}
+
+func TestGetLyricsPrefersEmbedded(t *testing.T) {
+	env := setup(t)
+	// 只有一个 USLT 帧的 ID3v2.3 标签，之后为任意音频数据
+	body := append([]byte{0, 'e', 'n', 'g', 0}, "embedded line"...)
+	frame := append([]byte("USLT"), 0, 0, 0, byte(len(body)), 0, 0)
+	frame = append(frame, body...)
+	data := append([]byte{'I', 'D', '3', 3, 0, 0, 0, 0, byte(len(frame) >> 7), byte(len(frame) & 0x7f)}, frame...)
+	data = append(data, make([]byte, 1024)...)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "song.mp3")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	env.db.Model(&env.song).Update("file_url", path)
+
+	// 使用不存在的网络来源，若没有优先使用内嵌歌词则返回 none
+	code, resp := env.do(t, "GET", "/api/musics/"+env.song.ID+"/lyrics?engine=unreachable", "")
+	if code != 200 || resp["source"] != "embedded" || resp["lyrics"] != "embedded line" {
+		t.Fatalf("expected embedded lyrics, got %d %v", code, resp)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "lyrics")); !os.IsNotExist(err) {
+		t.Fatalf("expected embedded lyrics not to be saved as a network result")
+	}
+}
+
+func TestCueTrackLyricsFiles(t *testing.T) {
+	env := setup(t)
+	dir := t.TempDir()
+	rip := filepath.Join(dir, "disc.flac")
+	if err := os.WriteFile(rip, []byte("fLaC-data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var tracks []entity.Music
+	for n := 3; n <= 4; n++ {
+		track := entity.Music{Title: fmt.Sprintf("Track %d", n), FileUrl: entity.CueTrackPath(rip, n), CueFile: filepath.Join(dir, "disc.cue"),
+			CueStart: n * 60000, Suffix: "flac", LibraryID: 1}
+		env.db.Create(&track)
+		tracks = append(tracks, track)
+	}
+
+	if code, resp := env.do(t, "POST", "/api/lyrics/"+tracks[0].ID+"/lyrics", `{"lyrics":"[00:01.00]three"}`); code != 200 {
+		t.Fatalf("save lyrics: %d %v", code, resp)
+	}
+	env.do(t, "POST", "/api/lyrics/"+tracks[0].ID+"/tlyrics", `{"lyrics":"[00:01.00]三"}`)
+	for _, name := range []string{"disc.03.lrc", "disc.03.zh.lrc"} {
+		if _, err := os.Stat(filepath.Join(dir, "lyrics", name)); err != nil {
+			t.Fatalf("expected per-track lyrics file %s: %v", name, err)
+		}
+	}
+
+	_, resp := env.do(t, "GET", "/api/musics/"+tracks[0].ID+"/lyrics?engine=unreachable", "")
+	if resp["source"] != "local" || resp["lyrics"] != "[00:01.00]three" || resp["tlyrics"] != "[00:01.00]三" {
+		t.Fatalf("expected local lyrics for track 3, got %v", resp)
+	}
+	// 同一文件中的其他音轨不会读到第 3 轨的歌词
+	_, resp = env.do(t, "GET", "/api/musics/"+tracks[1].ID+"/lyrics?engine=unreachable", "")
+	if resp["source"] == "local" {
+		t.Fatalf("expected track 4 not to share track 3's lyrics, got %v", resp)
+	}
+}
+
+func TestArtistIDsInRoutes(t *testing.T) {
+	env := setup(t)
+	linker := artist.NewLinker()
+	names := []string{"AC/DC", "Who?", "A B", "a-b"}
+	for _, name := range names {
+		m := entity.Music{Title: name, Artist: name, FileUrl: "/music/" + url.PathEscape(name) + ".mp3", LibraryID: 1}
+		env.db.Create(&m)
+		if err := linker.LinkMusic(env.db, m.ID, artist.MusicCredits(&m, nil)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range names {
+		code, resp := env.do(t, "GET", "/api/artists/"+entity.ArtistID(name), "")
+		data, _ := resp["data"].(map[string]interface{})
+		if code != 200 || data["name"] != name {
+			t.Errorf("%s: unexpected artist %d %v", name, code, resp)
+		}
+	}
+}
+
+func TestHLSSegmentMaxBitRate(t *testing.T) {
+	env := setup(t)
+	dir := t.TempDir()
+	// 用输出参数的脚本代替 ffmpeg
+	ffmpeg := filepath.Join(dir, "ffmpeg")
+	if err := os.WriteFile(ffmpeg, []byte("#!/bin/sh\necho \"$@\"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	config.AppConfig.Transcoding.FFmpegPath = ffmpeg
+	path := filepath.Join(dir, "a.flac")
+	if err := os.WriteFile(path, []byte("fLaC-data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	song := entity.Music{Title: "Long", FileUrl: path, Suffix: "flac", BitRate: 900, Duration: 60, LibraryID: 1}
+	env.db.Create(&song)
+	env.db.Model(&env.user).Update("max_bit_rate", 160)
+
+	segment := func(bitRate int) string {
+		req := httptest.NewRequest("GET", fmt.Sprintf("/api/musics/%s/hls/%d/0.ts", song.ID, bitRate), nil)
+		req.Header.Set("Authorization", "Bearer "+env.token)
+		res, err := env.app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(res.Body)
+		return string(body)
+	}
+	// 超过上限的码率降为主播放列表中可用的最高档位
+	if out := segment(320); !strings.Contains(out, "-b:a 128k") {
+		t.Fatalf("expected segment to be capped at 128k, got %q", out)
+	}
+	if out := segment(64); !strings.Contains(out, "-b:a 64k") {
+		t.Fatalf("expected lower bitrate to be kept, got %q", out)
+	}
+}
+
+func TestHLSTokenQuery(t *testing.T) {
+	env := setup(t)
+	song := entity.Music{Title: "Long", FileUrl: "/music/long.flac", Suffix: "flac", BitRate: 900, Duration: 60, LibraryID: 1}
+	env.db.Create(&song)
+	fetch := func(path string, header bool) (int, string) {
+		req := httptest.NewRequest("GET", path, nil)
+		if header {
+			req.Header.Set("Authorization", "Bearer "+env.token)
+		}
+		res, err := env.app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(res.Body)
+		return res.StatusCode, string(body)
+	}
+
+	// 以请求头认证时，子播放列表地址附带令牌
+	base := "/api/musics/" + song.ID + "/hls/"
+	code, master := fetch(base+"master.m3u8", true)
+	if code != 200 || !strings.Contains(master, "128/index.m3u8?token="+env.token) {
+		t.Fatalf("expected token in variant urls: %d %s", code, master)
+	}
+	code, variant := fetch(base+"128/index.m3u8?token="+env.token, false)
+	if code != 200 || !strings.Contains(variant, "0.ts?token="+env.token) {
+		t.Fatalf("expected token in segment urls: %d %s", code, variant)
+	}
+	if code, _ := fetch(base+"128/index.m3u8", false); code != fiber.StatusUnauthorized {
+		t.Fatalf("expected 401 without a token, got %d", code)
+	}
+	if code, _ := fetch(base+"128/index.m3u8?token=bogus", false); code != fiber.StatusUnauthorized {
+		t.Fatalf("expected 401 with an invalid token, got %d", code)
+	}
+	// 其他接口仍只接受请求头
+	if code, _ := fetch("/api/musics/"+song.ID+"?token="+env.token, false); code != fiber.StatusUnauthorized {
+		t.Fatalf("expected token query to be rejected outside playback routes, got %d", code)
+	}
+}
+
+func TestStreamCueTrack(t *testing.T) {
+	env := setup(t)
+	dir := t.TempDir()
+	// 用输出参数的脚本代替 ffmpeg
+	ffmpeg := filepath.Join(dir, "ffmpeg")
+	if err := os.WriteFile(ffmpeg, []byte("#!/bin/sh\necho \"$@\"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	config.AppConfig.Transcoding.FFmpegPath = ffmpeg
+	rip := filepath.Join(dir, "disc.flac")
+	if err := os.WriteFile(rip, []byte("fLaC-data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	track := entity.Music{Title: "Track 3", FileUrl: entity.CueTrackPath(rip, 3), CueFile: filepath.Join(dir, "disc.cue"),
+		CueStart: 90000, CueEnd: 150000, Suffix: "flac", Duration: 60, LibraryID: 1}
+	env.db.Create(&track)
+	plain := entity.Music{Title: "Plain", FileUrl: rip, Suffix: "flac", LibraryID: 1}
+	env.db.Create(&plain)
+
+	_, body := env.do(t, "GET", "/api/musics/"+track.ID, "")
+	data, _ := body["data"].(map[string]interface{})
+	streamURL, _ := data["streamUrl"].(string)
+	if streamURL != "/api/musics/"+track.ID+"/stream" {
+		t.Fatalf("expected stream url for cue track, got %v", data)
+	}
+	_, body = env.do(t, "GET", "/api/musics/"+plain.ID, "")
+	if data, _ := body["data"].(map[string]interface{}); data["streamUrl"] != nil {
+		t.Fatalf("expected no stream url for a plain file, got %v", data["streamUrl"])
+	}
+
+	stream := func(id string) (int, string) {
+		req := httptest.NewRequest("GET", "/api/musics/"+id+"/stream?token="+env.token, nil)
+		res, err := env.app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, _ := io.ReadAll(res.Body)
+		return res.StatusCode, string(out)
+	}
+	// 音轨按 CUE 起止位置截取，而不是播放整个文件
+	if code, out := stream(track.ID); code != 200 || !strings.Contains(out, "-ss 90") || !strings.Contains(out, "-t 60") {
+		t.Fatalf("expected clipped stream: %d %q", code, out)
+	}
+	if code, out := stream(plain.ID); code != 200 || out != "fLaC-data" {
+		t.Fatalf("expected raw file: %d %q", code, out)
+	}
+	if code, _ := stream(env.hid.ID); code != fiber.StatusNotFound {
+		t.Fatalf("expected missing file to be not found, got %d", code)
+	}
+}
+
+func TestPlaylistMusicsInOrder(t *testing.T) {
+	env := setup(t)
+	second := entity.Music{Title: "Second", FileUrl: "/music/second.mp3", LibraryID: 1}
+	env.db.Create(&second)
+	playlist := entity.Playlist{Name: "Mix", UserID: env.user.ID}
+	env.db.Create(&playlist)
+
+	if err := library.SetUserLibraries(env.db, env.user.ID, []uint{1}); err != nil {
+		t.Fatalf("grant: %v", err)
+	}
+	for _, id := range []string{second.ID, env.song.ID} {
+		if code, body := env.do(t, "POST", "/api/playlists/"+playlist.ID+"/musics", `{"musicId":"`+id+`"}`); code != 200 || body["error"] != false {
+			t.Fatalf("add %s: %d %v", id, code, body)
+		}
+	}
+	if _, body := env.do(t, "POST", "/api/playlists/"+playlist.ID+"/musics", `{"musicId":"`+env.hid.ID+`"}`); body["error"] != true {
+		t.Fatalf("expected song outside the granted libraries not to be added")
+	}
+	// 其他音乐库中的歌曲不会出现在列表中
+	env.db.Create(&entity.PlaylistMusic{PlaylistID: playlist.ID, Position: 1, MusicID: env.hid.ID})
+	_, body := env.do(t, "GET", "/api/playlists/"+playlist.ID, "")
+	data, _ := body["data"].(map[string]interface{})
+	musics, _ := data["musics"].([]interface{})
+	if len(musics) != 2 || musics[0].(map[string]interface{})["id"] != second.ID || musics[1].(map[string]interface{})["id"] != env.song.ID {
+		t.Fatalf("unexpected playlist musics %v", data["musics"])
+	}
+
+	// 再次添加同一首歌会将其移除
+	env.do(t, "POST", "/api/playlists/"+playlist.ID+"/musics", `{"musicId":"`+second.ID+`"}`)
+	env.do(t, "POST", "/api/playlists/"+playlist.ID+"/musics", `{"musicId":"`+second.ID+`"}`)
+	_, body = env.do(t, "GET", "/api/playlists/"+playlist.ID, "")
+	data, _ = body["data"].(map[string]interface{})
+	musics, _ = data["musics"].([]interface{})
+	if len(musics) != 2 || musics[0].(map[string]interface{})["id"] != env.song.ID || musics[1].(map[string]interface{})["id"] != second.ID {
+		t.Fatalf("expected re-added song at the end, got %v", data["musics"])
+	}
+}
+
+func TestAlbumAnnotations(t *testing.T) {
+	env := setup(t)
+	album := entity.Album{Name: "Album"}
+	env.db.Create(&album)
+	env.db.Model(&env.song).UpdateColumn("album_id", album.ID)
+
+	env.do(t, "POST", "/api/albums/"+album.ID+"/star", "")
+	env.do(t, "PUT", "/api/albums/"+album.ID+"/rating", `{"rating":4}`)
+	env.do(t, "POST", "/api/musics/"+env.song.ID+"/star", "")
+
+	_, body := env.do(t, "GET", "/api/albums/"+album.ID, "")
+	data, _ := body["data"].(map[string]interface{})
+	if data["favorited"] != true || data["rating"] != float64(4) {
+		t.Fatalf("expected starred and rated album, got %v", body)
+	}
+	_, body = env.do(t, "GET", "/api/albums", "")
+	page, _ := body["data"].(map[string]interface{})
+	albums, _ := page["data"].([]interface{})
+	if len(albums) != 1 || albums[0].(map[string]interface{})["favorited"] != true {
+		t.Fatalf("expected starred album in list, got %v", body)
+	}
+	_, body = env.do(t, "GET", "/api/albums/"+album.ID+"/musics", "")
+	musics, _ := body["data"].([]interface{})
+	if len(musics) != 1 || musics[0].(map[string]interface{})["favorited"] != true {
+		t.Fatalf("expected starred album song, got %v", body)
+	}
+
+	var song entity.Music
+	env.db.First(&song, "id = ?", env.song.ID)
+	if song.LikeCount != 1 {
+		t.Fatalf("expected like count 1, got %d", song.LikeCount)
+	}
+	env.do(t, "DELETE", "/api/musics/"+env.song.ID+"/star", "")
+	env.db.First(&song, "id = ?", env.song.ID)
+	if song.LikeCount != 0 {
+		t.Fatalf("expected like count 0 after unstar, got %d", song.LikeCount)
+	}
+}
+
+func TestPlayMusicNowPlaying(t *testing.T) {
+	env := setup(t)
+	nowPlaying := func() []interface{} {
+		_, body := env.do(t, "GET", "/api/now-playing", "")
+		list, _ := body["data"].([]interface{})
+		return list
+	}
+
+	// 提交播放记录不会设置正在播放
+	env.do(t, "POST", "/api/musics/"+env.song.ID+"/play", "")
+	if list := nowPlaying(); len(list) != 0 {
+		t.Fatalf("expected submission not to set now playing, got %v", list)
+	}
+	env.do(t, "POST", "/api/musics/"+env.song.ID+"/play?submission=false", "")
+	if list := nowPlaying(); len(list) != 1 {
+		t.Fatalf("expected one now playing entry, got %v", list)
+	}
+	env.do(t, "POST", "/api/musics/"+env.song.ID+"/play?submission=true", "")
+	if list := nowPlaying(); len(list) != 0 {
+		t.Fatalf("expected submission to clear now playing, got %v", list)
+	}
+}
+
+func TestAdminRoutes(t *testing.T) {
+	env := setup(t)
+	tests := []struct {
+		method, path, body string
+	}{
+		{"GET", "/api/users", ""},
+		{"PUT", "/api/users/" + env.user.ID + "/libraries", `{"libraryIds":[]}`},
+		{"POST", "/api/libraries", `{"name":"etc","path":"/etc"}`},
+		{"PUT", "/api/libraries/1", `{"name":"renamed"}`},
+		{"DELETE", fmt.Sprintf("/api/libraries/%d", env.other.ID), ""},
+		{"POST", "/api/scan/cancel", ""},
+		{"GET", "/api/transcode/cache", ""},
+		{"POST", "/api/musics/scan", ""},
+	}
+	for _, tt := range tests {
+		if code, body := env.do(t, tt.method, tt.path, tt.body); code != fiber.StatusForbidden {
+			t.Errorf("%s %s: expected 403 for a regular user, got %d %v", tt.method, tt.path, code, body)
+		}
+	}
+	var count int64
+	env.db.Model(&entity.Library{}).Count(&count)
+	if count != 2 {
+		t.Fatalf("expected libraries to be unchanged, got %d", count)
+	}
+
+	// 普通路由不受管理员中间件影响
+	if code, body := env.do(t, "GET", "/api/musics/"+env.song.ID, ""); code != 200 {
+		t.Fatalf("expected regular routes to stay open: %d %v", code, body)
+	}
+	if code, body := env.doAs(t, env.admin, "GET", "/api/users", ""); code != 200 {
+		t.Fatalf("expected admin to list users: %d %v", code, body)
+	}
+}
+
+func TestPrivatePlaylists(t *testing.T) {
+	env := setup(t)
+	other := entity.User{Username: "other", Email: "other@localhost", Password: "other", Role: entity.RoleUser, Status: 1}
+	env.db.Create(&other)
+	private := entity.Playlist{Name: "Private", UserID: other.ID}
+	public := entity.Playlist{Name: "Public", UserID: other.ID}
+	env.db.Create(&private)
+	env.db.Create(&public)
+	env.db.Model(&private).Update("is_public", false)
+	mine := entity.Playlist{Name: "Mine", UserID: env.user.ID}
+	env.db.Create(&mine)
+	env.db.Model(&mine).Update("is_public", false)
+
+	_, body := env.do(t, "GET", "/api/playlists", "")
+	page, _ := body["data"].(map[string]interface{})
+	list, _ := page["data"].([]interface{})
+	names := map[string]bool{}
+	for _, p := range list {
+		names[p.(map[string]interface{})["name"].(string)] = true
+	}
+	if len(names) != 2 || !names["Public"] || !names["Mine"] || page["total"] != float64(2) {
+		t.Fatalf("expected own and public playlists only, got %v", body)
+	}
+	if _, body := env.do(t, "GET", "/api/playlists/"+private.ID, ""); body["error"] != true {
+		t.Fatalf("expected another user's private playlist to be hidden, got %v", body)
+	}
+	for _, id := range []string{public.ID, mine.ID} {
+		if code, body := env.do(t, "GET", "/api/playlists/"+id, ""); code != 200 {
+			t.Fatalf("expected playlist %s to be readable: %d %v", id, code, body)
+		}
+	}
+}
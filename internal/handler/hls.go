@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"strconv"
+
+	"saboriman-music/internal/entity"
+	"saboriman-music/internal/transcode"
+	"saboriman-music/internal/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// hlsMusic 查询 HLS 播放的歌曲，时长未知时无法分片
+func (h *MusicHandler) hlsMusic(c *fiber.Ctx) (*entity.Music, error) {
+	var music entity.Music
+	if err := h.db.First(&music, "id = ?", c.Params("id")).Error; err != nil || !canAccess(h.db, c, music.LibraryID) {
+		return nil, errors.New("音乐不存在")
+	}
+	if music.Duration <= 0 {
+		return nil, errors.New("音乐时长未知，无法分片")
+	}
+	return &music, nil
+}
+
+// hlsSuffix 子地址沿用当前请求的查询参数，并带上认证令牌，
+// 使播放器请求子播放列表与分片时无需设置 Authorization 请求头
+func hlsSuffix(c *fiber.Ctx) string {
+	query, _ := url.ParseQuery(string(c.Request().URI().QueryString()))
+	if token, _ := c.Locals("token").(string); token != "" && query.Get("token") == "" {
+		query.Set("token", token)
+	}
+	if len(query) == 0 {
+		return ""
+	}
+	return "?" + query.Encode()
+}
+
+// maxBitRate 当前用户的默认最大码率，0 表示不限制
+func (h *MusicHandler) maxBitRate(c *fiber.Ctx) int {
+	var user entity.User
+	h.db.Select("max_bit_rate").First(&user, "id = ?", c.Locals("userID"))
+	return user.MaxBitRate
+}
+
+// HLSMaster 获取 HLS 主播放列表，按用户默认最大码率省略更高的档位
+func (h *MusicHandler) HLSMaster(c *fiber.Ctx) error {
+	music, err := h.hlsMusic(c)
+	if err != nil {
+		return utils.SendError(c, err.Error())
+	}
+
+	suffix := hlsSuffix(c)
+	c.Set(fiber.HeaderContentType, transcode.PlaylistContentType)
+	return c.SendString(transcode.MasterPlaylist(transcode.HLSBitRates(music.BitRate, h.maxBitRate(c)), func(bitRate int) string {
+		return strconv.Itoa(bitRate) + "/index.m3u8" + suffix
+	}))
+}
+
+// HLSPlaylist 获取某个码率的 HLS 分片列表
+func (h *MusicHandler) HLSPlaylist(c *fiber.Ctx) error {
+	music, err := h.hlsMusic(c)
+	if err != nil {
+		return utils.SendError(c, err.Error())
+	}
+
+	suffix := hlsSuffix(c)
+	c.Set(fiber.HeaderContentType, transcode.PlaylistContentType)
+	return c.SendString(transcode.MediaPlaylist(music.Duration, func(i int) string {
+		return strconv.Itoa(i) + ".ts" + suffix
+	}))
+}
+
+// HLSSegment 获取 HLS 分片，按需转码并写入转码缓存；码率不超过主播放列表中用户可用的最高档位
+func (h *MusicHandler) HLSSegment(c *fiber.Ctx) error {
+	music, err := h.hlsMusic(c)
+	if err != nil {
+		return utils.SendError(c, err.Error())
+	}
+	bitRate, err := strconv.Atoi(c.Params("bitrate"))
+	if err != nil || bitRate <= 0 {
+		return utils.SendError(c, "无效的码率")
+	}
+	if limit := h.maxBitRate(c); limit > 0 {
+		if rates := transcode.HLSBitRates(0, limit); len(rates) > 0 && bitRate > rates[len(rates)-1] {
+			bitRate = rates[len(rates)-1]
+		}
+	}
+	index, err := strconv.Atoi(c.Params("segment"))
+	if err != nil {
+		return utils.SendError(c, "无效的分片序号")
+	}
+	fullPath := utils.MusicFilePath(music.FilePath())
+	if _, err := os.Stat(fullPath); err != nil {
+		return utils.SendError(c, "音乐文件不存在")
+	}
+
+	stream, err := transcode.OpenSegment(music.ID, fullPath, transcode.NewClip(music.CueStart, music.CueEnd), music.Duration, transcode.HLSBitRate(bitRate), index,
+		transcode.Gain(music.TrackGain, music.TrackPeak, music.AlbumGain, music.AlbumPeak))
+	if errors.Is(err, transcode.ErrSegmentOutOfRange) {
+		return utils.SendError(c, "分片不存在")
+	}
+	if err != nil {
+		return utils.SendError(c, "转码失败: "+err.Error())
+	}
+	c.Set(fiber.HeaderContentType, transcode.SegmentContentType)
+	c.Context().SetBodyStream(stream, -1)
+	return nil
+}
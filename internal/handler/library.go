@@ -0,0 +1,231 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"saboriman-music/internal/artist"
+	"saboriman-music/internal/dto"
+	"saboriman-music/internal/entity"
+	"saboriman-music/internal/library"
+	"saboriman-music/internal/scanner"
+	"saboriman-music/internal/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// LibraryHandler 音乐库处理器
+type LibraryHandler struct {
+	db    *gorm.DB
+	scans *scanner.Manager
+}
+
+// NewLibraryHandler 创建音乐库处理器实例，新增的音乐库由 scans 监听和扫描
+func NewLibraryHandler(db *gorm.DB, scans *scanner.Manager) *LibraryHandler {
+	return &LibraryHandler{db: db, scans: scans}
+}
+
+// accessibleLibraries 当前用户可以访问的音乐库，nil 表示全部
+func accessibleLibraries(db *gorm.DB, c *fiber.Ctx) ([]uint, error) {
+	userID, _ := c.Locals("userID").(string)
+	role, _ := c.Locals("role").(entity.Role)
+	return library.Accessible(db, userID, role)
+}
+
+// libraryScope 列表查询的音乐库范围：请求带 libraryId 时只返回该音乐库，
+// 无权访问时返回 library.ErrForbidden
+func libraryScope(db *gorm.DB, c *fiber.Ctx) ([]uint, error) {
+	ids, err := accessibleLibraries(db, c)
+	if err != nil {
+		return nil, err
+	}
+	return library.Resolve(ids, uint(c.QueryInt("libraryId", 0)))
+}
+
+// canAccess 判断当前用户能否访问某个音乐库中的条目
+func canAccess(db *gorm.DB, c *fiber.Ctx, libraryID uint) bool {
+	ids, err := accessibleLibraries(db, c)
+	return err == nil && library.Allowed(ids, libraryID)
+}
+
+// sendScopeError 返回音乐库范围的错误
+func sendScopeError(c *fiber.Ctx, err error) error {
+	if errors.Is(err, library.ErrForbidden) {
+		return utils.SendError(c, "无权访问该音乐库")
+	}
+	return utils.SendError(c, "获取音乐库权限失败")
+}
+
+// ListLibraries 获取当前用户可以访问的音乐库
+func (h *LibraryHandler) ListLibraries(c *fiber.Ctx) error {
+	ids, err := libraryScope(h.db, c)
+	if err != nil {
+		return sendScopeError(c, err)
+	}
+	q := h.db.Order("id ASC")
+	if ids != nil {
+		q = q.Where("id IN ?", ids)
+	}
+	var libs []entity.Library
+	if err := q.Find(&libs).Error; err != nil {
+		return utils.SendError(c, "获取音乐库列表失败")
+	}
+	return utils.SendSuccess(c, "获取音乐库列表成功", libs)
+}
+
+// CreateLibrary 添加音乐库（管理员），添加后立即扫描
+func (h *LibraryHandler) CreateLibrary(c *fiber.Ctx) error {
+	var req dto.LibraryRequest
+	if err := c.BodyParser(&req); err != nil {
+		return utils.SendError(c, "请求参数解析失败")
+	}
+	if req.Name == "" || req.Path == "" {
+		return utils.SendError(c, "音乐库名称和目录不能为空")
+	}
+
+	lib := entity.Library{Name: req.Name, Path: req.Path}
+	if err := library.Validate(h.db, &lib); err != nil {
+		return sendLibraryError(c, err)
+	}
+	if err := h.db.Create(&lib).Error; err != nil {
+		return utils.SendError(c, "创建音乐库失败: "+err.Error())
+	}
+	h.libraryChanged(lib, true)
+
+	return utils.SendSuccess(c, "音乐库创建成功", lib)
+}
+
+// UpdateLibrary 修改音乐库名称或目录（管理员），目录变化后重新扫描
+func (h *LibraryHandler) UpdateLibrary(c *fiber.Ctx) error {
+	var lib entity.Library
+	if err := h.db.First(&lib, "id = ?", c.Params("id")).Error; err != nil {
+		return utils.SendError(c, "音乐库不存在")
+	}
+
+	var req dto.LibraryRequest
+	if err := c.BodyParser(&req); err != nil {
+		return utils.SendError(c, "请求参数解析失败")
+	}
+	if req.Name != "" {
+		lib.Name = req.Name
+	}
+	oldPath := lib.Path
+	if req.Path != "" {
+		lib.Path = req.Path
+	}
+	if err := library.Validate(h.db, &lib); err != nil {
+		return sendLibraryError(c, err)
+	}
+	if err := h.db.Save(&lib).Error; err != nil {
+		return utils.SendError(c, "更新音乐库失败")
+	}
+	h.libraryChanged(lib, lib.Path != oldPath)
+
+	return utils.SendSuccess(c, "音乐库更新成功", lib)
+}
+
+// DeleteLibrary 删除音乐库（管理员），同时删除其中的歌曲与专辑记录，不删除文件
+func (h *LibraryHandler) DeleteLibrary(c *fiber.Ctx) error {
+	var lib entity.Library
+	if err := h.db.First(&lib, "id = ?", c.Params("id")).Error; err != nil {
+		return utils.SendError(c, "音乐库不存在")
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("library_id = ?", lib.ID).Delete(&entity.Music{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("library_id = ?", lib.ID).Delete(&entity.Album{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("library_id = ?", lib.ID).Delete(&entity.UserLibrary{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&lib).Error
+	})
+	if err != nil {
+		return utils.SendError(c, "删除音乐库失败")
+	}
+	if err := artist.Prune(h.db); err != nil {
+		log.Printf("清理艺术家失败: %v", err)
+	}
+	if _, err := library.Load(h.db); err != nil {
+		log.Printf("刷新音乐库列表失败: %v", err)
+	}
+
+	return utils.SendSuccess(c, "音乐库删除成功", nil)
+}
+
+// GetUserLibraries 获取为用户分配的音乐库（管理员），null 表示不限制
+func (h *LibraryHandler) GetUserLibraries(c *fiber.Ctx) error {
+	ids, err := library.UserLibraries(h.db, c.Params("id"))
+	if err != nil {
+		return utils.SendError(c, "获取用户音乐库失败")
+	}
+	return utils.SendSuccess(c, "获取用户音乐库成功", ids)
+}
+
+// SetUserLibraries 设置用户可以访问的音乐库（管理员）
+func (h *LibraryHandler) SetUserLibraries(c *fiber.Ctx) error {
+	userID := c.Params("id")
+	var user entity.User
+	if err := h.db.First(&user, "id = ?", userID).Error; err != nil {
+		return utils.SendError(c, "用户不存在")
+	}
+
+	var req dto.UserLibrariesRequest
+	if err := c.BodyParser(&req); err != nil {
+		return utils.SendError(c, "请求参数解析失败")
+	}
+	if len(req.LibraryIDs) > 0 {
+		var count int64
+		if err := h.db.Model(&entity.Library{}).Where("id IN ?", req.LibraryIDs).Count(&count).Error; err != nil {
+			return utils.SendError(c, "设置用户音乐库失败")
+		}
+		if count != int64(len(uniqueIDs(req.LibraryIDs))) {
+			return utils.SendError(c, "音乐库不存在")
+		}
+	}
+
+	if err := library.SetUserLibraries(h.db, userID, req.LibraryIDs); err != nil {
+		return utils.SendError(c, "设置用户音乐库失败")
+	}
+	return utils.SendSuccess(c, "设置用户音乐库成功", nil)
+}
+
+// libraryChanged 刷新音乐库缓存，必要时加入目录监听并开始扫描
+func (h *LibraryHandler) libraryChanged(lib entity.Library, rescan bool) {
+	if _, err := library.Load(h.db); err != nil {
+		log.Printf("刷新音乐库列表失败: %v", err)
+	}
+	if !rescan {
+		return
+	}
+	if err := h.scans.WatchLibrary(lib); err != nil {
+		log.Printf("监听音乐库 %s 失败: %v", lib.Name, err)
+	}
+	if _, err := h.scans.Start(h.db, []entity.Library{lib}, scanner.DefaultOptions(false), "api"); err != nil {
+		log.Printf("扫描音乐库 %s 失败: %v", lib.Name, err)
+	}
+}
+
+// sendLibraryError 返回音乐库目录校验的错误
+func sendLibraryError(c *fiber.Ctx, err error) error {
+	if errors.Is(err, library.ErrOverlap) {
+		return utils.SendError(c, "音乐库目录不能与其他音乐库重叠")
+	}
+	return utils.SendError(c, "音乐库目录无效: "+err.Error())
+}
+
+// uniqueIDs 去除重复的 ID
+func uniqueIDs(ids []uint) []uint {
+	seen := make(map[uint]bool, len(ids))
+	var result []uint
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			result = append(result, id)
+		}
+	}
+	return result
+}
@@ -195,6 +195,20 @@ func (h *LyricsHandler) GetLyricsByIdProxy(c *fiber.Ctx) error {
 	return c.Send(body)
 }
 
+// lyricsFiles 歌词文件路径：音乐文件夹/lyrics/歌曲名.lrc，翻译歌词为 歌曲名.zh.lrc；
+// 整轨文件中的音轨按音轨号区分（如 disc.03.lrc），避免同一文件中的音轨共用一份歌词
+func lyricsFiles(music *entity.Music) (dir, lyricsPath, translationPath string) {
+	musicPath := music.FilePath()
+	dir = filepath.Join(filepath.Dir(musicPath), "lyrics")
+	name := strings.TrimSuffix(filepath.Base(musicPath), filepath.Ext(musicPath))
+	if music.CueFile != "" {
+		if i := strings.LastIndex(music.FileUrl, "#"); i >= 0 {
+			name += "." + music.FileUrl[i+1:]
+		}
+	}
+	return dir, filepath.Join(dir, name+".lrc"), filepath.Join(dir, name+".zh.lrc")
+}
+
 // SaveLyrics 保存歌词
 func (h *LyricsHandler) SaveLyrics(c *fiber.Ctx) error {
 	id := c.Params("id")
@@ -220,15 +234,8 @@ func (h *LyricsHandler) SaveLyrics(c *fiber.Ctx) error {
 		return utils.SendError(c, "查询音乐失败")
 	}
 
-	// 获取音乐文件所在目录
-	musicPath := music.FileUrl
-	musicDir := filepath.Dir(musicPath)
-	musicFileName := filepath.Base(musicPath)
-
 	// 构建歌词文件路径：音乐文件夹/lyrics/歌曲名.lrc
-	lyricsDir := filepath.Join(musicDir, "lyrics")
-	lyricsFileName := strings.TrimSuffix(musicFileName, filepath.Ext(musicFileName)) + ".lrc"
-	lyricsPath := filepath.Join(lyricsDir, lyricsFileName)
+	lyricsDir, lyricsPath, _ := lyricsFiles(&music)
 
 	// 确保 lyrics 目录存在
 	if err := os.MkdirAll(lyricsDir, 0755); err != nil {
@@ -272,15 +279,8 @@ func (h *LyricsHandler) SaveTranslatiionLyrics(c *fiber.Ctx) error {
 		return utils.SendError(c, "查询音乐失败")
 	}
 
-	// 获取音乐文件所在目录
-	musicPath := music.FileUrl
-	musicDir := filepath.Dir(musicPath)
-	musicFileName := filepath.Base(musicPath)
-
 	// 构建翻译歌词文件路径：音乐文件夹/lyrics/歌曲名.zh.lrc
-	lyricsDir := filepath.Join(musicDir, "lyrics")
-	translationFileName := strings.TrimSuffix(musicFileName, filepath.Ext(musicFileName)) + ".zh.lrc"
-	translationPath := filepath.Join(lyricsDir, translationFileName)
+	lyricsDir, _, translationPath := lyricsFiles(&music)
 
 	// 确保 lyrics 目录存在
 	if err := os.MkdirAll(lyricsDir, 0755); err != nil {
@@ -1,40 +1,37 @@
 package handler
 
 import (
-	"context"
-	"crypto/md5"
-	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"io/fs"
 	"net/http"
 	"net/url"
 	"os"
-	"path/filepath"
-	"saboriman-music/config"
 	"saboriman-music/internal/dto"
 	"saboriman-music/internal/entity"
+	"saboriman-music/internal/library"
+	"saboriman-music/internal/nowplaying"
+	"saboriman-music/internal/scanner"
+	"saboriman-music/internal/transcode"
 	"saboriman-music/internal/utils"
 	"strings"
 	"time"
 
-	"github.com/dhowden/tag"
 	"github.com/gofiber/fiber/v2"
 	"github.com/jinzhu/copier"
-	"gopkg.in/vansante/go-ffprobe.v2"
 	"gorm.io/gorm"
 )
 
 // MusicHandler 音乐处理器
 type MusicHandler struct {
-	db *gorm.DB
+	db    *gorm.DB
+	scans *scanner.Manager
 }
 
-// NewMusicHandler 创建音乐处理器
-func NewMusicHandler(db *gorm.DB) *MusicHandler {
-	return &MusicHandler{db: db}
+// NewMusicHandler 创建音乐处理器，扫描由 scans 管理
+func NewMusicHandler(db *gorm.DB, scans *scanner.Manager) *MusicHandler {
+	return &MusicHandler{db: db, scans: scans}
 }
 
 // CreateMusic 创建音乐
@@ -54,7 +51,12 @@ func (h *MusicHandler) CreateMusic(c *fiber.Ctx) error {
 	var music entity.Music
 	copier.Copy(&music, &req)
 
-	if err := h.db.Create(&music).Error; err != nil {
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&music).Error; err != nil {
+			return err
+		}
+		return library.Touch(tx, music.LibraryID)
+	}); err != nil {
 		return utils.SendError(c, "创建音乐失败: "+err.Error())
 	}
 
@@ -72,6 +74,9 @@ func (h *MusicHandler) GetMusic(c *fiber.Ctx) error {
 		}
 		return utils.SendError(c, "查询音乐失败")
 	}
+	if !canAccess(h.db, c, music.LibraryID) {
+		return utils.SendError(c, "音乐不存在")
+	}
 
 	return utils.SendSuccess(c, "获取音乐成功", music)
 }
@@ -84,6 +89,9 @@ func (h *MusicHandler) UpdateMusic(c *fiber.Ctx) error {
 	if err := h.db.First(&music, "id = ?", id).Error; err != nil {
 		return utils.SendError(c, "音乐不存在")
 	}
+	if !canAccess(h.db, c, music.LibraryID) {
+		return utils.SendErrorWithStatus(c, fiber.StatusNotFound, "音乐不存在")
+	}
 
 	var req dto.UpdateMusicRequest
 	if err := c.BodyParser(&req); err != nil {
@@ -101,7 +109,19 @@ func (h *MusicHandler) UpdateMusic(c *fiber.Ctx) error {
 func (h *MusicHandler) DeleteMusic(c *fiber.Ctx) error {
 	id := c.Params("id") // ID 现在是字符串
 
-	if err := h.db.Delete(&entity.Music{}, "id = ?", id).Error; err != nil {
+	var music entity.Music
+	if err := h.db.Select("id", "library_id").First(&music, "id = ?", id).Error; err != nil {
+		return utils.SendError(c, "音乐不存在")
+	}
+	if !canAccess(h.db, c, music.LibraryID) {
+		return utils.SendErrorWithStatus(c, fiber.StatusNotFound, "音乐不存在")
+	}
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&entity.Music{}, "id = ?", music.ID).Error; err != nil {
+			return err
+		}
+		return library.Touch(tx, music.LibraryID)
+	}); err != nil {
 		return utils.SendError(c, "删除音乐失败")
 	}
 
@@ -122,11 +142,16 @@ func (h *MusicHandler) ListMusics(c *fiber.Ctx) error {
 		return utils.SendError(c, "未认证的用户")
 	}
 
+	ids, err := libraryScope(h.db, c)
+	if err != nil {
+		return sendScopeError(c, err)
+	}
+
 	var musics []entity.Music
 	var total int64
 
-	// 基础查询
-	dbQuery := h.db.Model(&entity.Music{})
+	// 基础查询，只包含用户可以访问的音乐库
+	dbQuery := h.db.Model(&entity.Music{}).Scopes(library.Scope("music", ids))
 
 	// 专辑筛选
 	if albumId != "" {
@@ -139,6 +164,11 @@ func (h *MusicHandler) ListMusics(c *fiber.Ctx) error {
 		dbQuery = dbQuery.Where("(title LIKE ? OR artist LIKE ? OR album_artist LIKE ?)", searchQuery, searchQuery, searchQuery)
 	}
 
+	// 只看收藏（在分页前过滤，保证总数和页数正确）
+	if isFavorited {
+		dbQuery = dbQuery.Where("id IN (?)", entity.StarredIDs(h.db, userID, entity.ItemTypeMusic))
+	}
+
 	// 先统计总数
 	if err := dbQuery.Count(&total).Error; err != nil {
 		return utils.SendError(c, "获取音乐总数失败")
@@ -157,34 +187,10 @@ func (h *MusicHandler) ListMusics(c *fiber.Ctx) error {
 		return utils.SendError(c, "获取音乐列表失败")
 	}
 
-	// 查找当前用户的"我的喜爱"播放列表
-	var favoritePlaylist entity.Playlist
-	err := h.db.Where("name = ? AND user_id = ?", "我的喜爱", userID).First(&favoritePlaylist).Error
-
-	// 获取"我的喜欢"中的所有音乐ID（使用桥接表，避免错误 JOIN）
-	favoriteMusicIDs := make(map[string]bool)
-	if err == nil {
-		var musicIDs []string
-		if err := h.db.
-			Table("playlist_musics").
-			Where("playlist_id = ?", favoritePlaylist.ID).
-			Pluck("music_id", &musicIDs).Error; err == nil {
-			for _, id := range musicIDs {
-				favoriteMusicIDs[id] = true
-			}
-		}
-	}
-
-	// 过滤喜爱（仅在需要时过滤）
-	musicResponses := make([]dto.ListMusicResponse, 0, len(musics))
-	for _, music := range musics {
-		if isFavorited && !favoriteMusicIDs[music.ID] {
-			continue
-		}
-		musicResponses = append(musicResponses, dto.ListMusicResponse{
-			Music:     music,
-			Favorited: favoriteMusicIDs[music.ID],
-		})
+	// 附加当前用户的收藏与评分
+	musicResponses, err := annotateMusics(h.db, userID, musics)
+	if err != nil {
+		return utils.SendError(c, "获取收藏信息失败")
 	}
 
 	totalPages := (total + int64(pageSize) - 1) / int64(pageSize)
@@ -197,630 +203,140 @@ func (h *MusicHandler) ListMusics(c *fiber.Ctx) error {
 	return utils.SendSuccess(c, "获取音乐列表成功", result)
 }
 
-// PlayMusic 播放音乐（增加播放次数）
+// PlayMusic 记录一次播放（submission=false 时只上报正在播放，不计入播放次数）
 func (h *MusicHandler) PlayMusic(c *fiber.Ctx) error {
 	id := c.Params("id") // ID 现在是字符串
 
-	result := h.db.Model(&entity.Music{}).Where("id = ?", id).Update("play_count", gorm.Expr("play_count + 1"))
-	if result.Error != nil {
-		return utils.SendError(c, "增加播放次数失败")
-	}
-	if result.RowsAffected == 0 {
-		return utils.SendError(c, "音乐不存在")
+	userID, ok := c.Locals("userID").(string)
+	if !ok || userID == "" {
+		return utils.SendError(c, "未认证的用户")
 	}
 
-	return utils.SendSuccess(c, "播放次数增加成功", nil)
-}
-
-// LikeMusic 点赞音乐（增加点赞次数）
-func (h *MusicHandler) LikeMusic(c *fiber.Ctx) error {
-	id := c.Params("id") // ID 现在是字符串
-
-	result := h.db.Model(&entity.Music{}).Where("id = ?", id).Update("like_count", gorm.Expr("like_count + 1"))
-	if result.Error != nil {
-		return utils.SendError(c, "点赞失败")
+	var music entity.Music
+	if err := h.db.First(&music, "id = ?", id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return utils.SendError(c, "音乐不存在")
+		}
+		return utils.SendError(c, "查询音乐失败")
 	}
-	if result.RowsAffected == 0 {
+	if !canAccess(h.db, c, music.LibraryID) {
 		return utils.SendError(c, "音乐不存在")
 	}
 
-	return utils.SendSuccess(c, "点赞成功", nil)
-}
-
-// ScanResult 定义了扫描结果的结构
-type ScanResult struct {
-	ScannedFiles int      `json:"scanned_files"`
-	Added        int      `json:"added"`
-	Removed      int      `json:"removed"`
-	Errors       []string `json:"errors"`
-}
-
-// 在 music.go 顶部添加规范化函数
-func normalizeString(s string) string {
-	// 1. 去前后空格
-	s = strings.TrimSpace(s)
-	// 2. 压缩多个空格为单空格
-	s = strings.Join(strings.Fields(s), " ")
-	// 3. 统一大小写（可选，保留原大小写更友好）
-	// 如果要严格匹配，可加: s = strings.ToLower(s)
-	return s
-}
-
-// ScanLibrary 扫描音乐库并更新数据库
-func (h *MusicHandler) ScanLibrary(c *fiber.Ctx) error {
-	musicFolder := config.AppConfig.MusicFolder
-	if musicFolder == "" {
-		return utils.SendError(c, "配置中未设置 MusicFolder")
+	submission := c.QueryBool("submission", true)
+	if err := entity.RecordPlay(h.db, userID, &music, time.Now(), "web", submission); err != nil {
+		return utils.SendError(c, "记录播放失败")
+	}
+	// 只有 submission=false 表示开始播放，提交播放记录时歌曲已播完
+	if submission {
+		nowplaying.Default.Clear(userID, "web", music.ID)
+	} else {
+		username, _ := c.Locals("username").(string)
+		nowplaying.Default.Set(userID, username, "web", music.ID, music.Duration)
 	}
 
-	// 使用 goroutine 在后台执行扫描，避免 HTTP 请求超时
-	go ScanLibraryInBackground(h.db, musicFolder)
-
-	return utils.SendSuccess(c, "音乐库扫描已在后台开始", nil)
+	return utils.SendSuccess(c, "播放次数增加成功", nil)
 }
 
-// ScanLibraryInBackground 是实际执行扫描的函数
-func ScanLibraryInBackground(db *gorm.DB, musicFolder string) {
-	fmt.Println("开始扫描音乐库:", musicFolder)
-	result := &ScanResult{}
-
-	// 将整个扫描过程包裹在一个事务中
-	err := db.Transaction(func(tx *gorm.DB) error {
-		// 0. 确保 'SYSTEM' 用户存在
-		var systemUser entity.User
-		err := tx.First(&systemUser, "id = ?", "SYSTEM").Error
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			fmt.Println("SYSTEM 用户不存在，正在创建...")
-			newUser := entity.User{
-				ID:       "SYSTEM",
-				Username: "System",
-				Email:    "system@localhost",
-				Password: "123456",
-				Role:     entity.RoleAdmin,
-				Status:   1,
-			}
-			if createErr := tx.Create(&newUser).Error; createErr != nil {
-				fmt.Printf("创建 SYSTEM 用户失败: %v\n", createErr)
-				return fmt.Errorf("致命错误: 无法创建 SYSTEM 用户: %v", createErr)
-			}
-			fmt.Println("SYSTEM 用户创建成功。")
-		} else if err != nil {
-			fmt.Printf("查询 SYSTEM 用户时出错: %v\n", err)
-			return fmt.Errorf("查询 SYSTEM 用户失败: %v", err)
-		} else {
-			fmt.Println("SYSTEM 用户已存在。")
-		}
-
-		// 1. 获取数据库中所有音乐的文件路径
-		var existingPaths []string
-		tx.Model(&entity.Music{}).Pluck("file_url", &existingPaths)
-		pathMap := make(map[string]bool)
-		for _, p := range existingPaths {
-			pathMap[p] = true
-		}
-
-		// 新增：为本次扫描创建一个专辑缓存，避免重复查询/创建
-		albumCache := make(map[string]*entity.Album)
-		// 专辑目录到封面路径的缓存
-		albumCoverCache := make(map[string]string)
-		foundPaths := make(map[string]bool)
-
-		// 2. 遍历文件系统
-		walkErr := filepath.WalkDir(musicFolder, func(path string, d fs.DirEntry, err error) error {
-			if err != nil {
-				result.Errors = append(result.Errors, fmt.Sprintf("访问路径失败 %s: %v", path, err))
-				return nil
-			}
-			if d.IsDir() {
-				return nil
-			}
-
-			foundPaths[path] = true
-			result.ScannedFiles++
-
-			if !isSupportedFileType(path) {
-				return nil
-			}
-
-			if _, exists := pathMap[path]; exists {
-				return nil
-			}
-
-			// 3. 解析元数据和时长
-			file, err := os.Open(path)
-			if err != nil {
-				result.Errors = append(result.Errors, fmt.Sprintf("打开文件失败 %s: %v", path, err))
-				return nil
-			}
-			defer file.Close()
-
-			meta, err := tag.ReadFrom(file)
-			if err != nil {
-				result.Errors = append(result.Errors, fmt.Sprintf("解析元数据失败 %s: %v", path, err))
-			}
-
-			// 使用 ffprobe 获取详细的音频信息
-			var durationSeconds int
-			var bitRate int
-			var sampleRate int
-			var bitDepth int
-			var channels int
-			var fileSize int64
-
-			probeCtx, cancelFn := context.WithTimeout(context.Background(), 10*time.Second)
-			defer cancelFn()
-			data, err := ffprobe.ProbeURL(probeCtx, path)
-			if err != nil {
-				result.Errors = append(result.Errors, fmt.Sprintf("探测时长失败 %s: %v", path, err))
-				return nil
-			}
-
-			// 提取音频流信息
-			durationSeconds = int(data.Format.Duration().Seconds())
-			if data.Format.BitRate != "" {
-				fmt.Sscanf(data.Format.BitRate, "%d", &bitRate)
-				bitRate = bitRate / 1000 // 转换为 kbps
-			}
-
-			// 获取文件大小
-			fileInfo, err := os.Stat(path)
-			if err == nil {
-				fileSize = fileInfo.Size()
-			}
-
-			// 从第一个音频流获取详细信息
-			if len(data.Streams) > 0 {
-				for _, stream := range data.Streams {
-					if stream.CodecType == "audio" {
-						if stream.SampleRate != "" {
-							fmt.Sscanf(stream.SampleRate, "%d", &sampleRate)
-						}
-						if stream.BitsPerRawSample != "" {
-							fmt.Sscanf(stream.BitsPerRawSample, "%d", &bitDepth)
-						}
-						channels = stream.Channels
-						break
-					}
-				}
-			}
-
-			// 4. 提取所有元数据标签
-			var albumName string
-			var trackNumber int
-			var discNumber int
-			var year int
-			var releaseDate string
-			var performer string
-			var label string
-			var copyright string
-			var isrc string
-			var upc string
-			var hasCoverArt bool
-
-			if meta != nil {
-				albumName = meta.Album()
-
-				track, _ := meta.Track()
-				trackNumber = track
-
-				disc, _ := meta.Disc()
-				discNumber = disc
-
-				year = meta.Year()
-
-				// 检查是否有封面
-				if meta.Picture() != nil {
-					hasCoverArt = true
-				}
-
-				// 提取原始标签
-				if raw := meta.Raw(); raw != nil {
-					// 发行日期
-					if dateTag, ok := raw["date"]; ok {
-						if dates, ok := dateTag.([]string); ok && len(dates) > 0 {
-							releaseDate = dates[0]
-						} else if dateStr, ok := dateTag.(string); ok {
-							releaseDate = dateStr
-						}
-					}
-
-					// 表演者
-					if performerTag, ok := raw["performer"]; ok {
-						if performers, ok := performerTag.([]string); ok && len(performers) > 0 {
-							performer = performers[0]
-						} else if performerStr, ok := performerTag.(string); ok {
-							performer = performerStr
-						}
-					}
-
-					// 唱片公司
-					if labelTag, ok := raw["label"]; ok {
-						if labels, ok := labelTag.([]string); ok && len(labels) > 0 {
-							label = labels[0]
-						} else if labelStr, ok := labelTag.(string); ok {
-							label = labelStr
-						}
-					}
-
-					// 版权信息
-					if copyrightTag, ok := raw["copyright"]; ok {
-						if copyrights, ok := copyrightTag.([]string); ok && len(copyrights) > 0 {
-							copyright = copyrights[0]
-						} else if copyrightStr, ok := copyrightTag.(string); ok {
-							copyright = copyrightStr
-						}
-					}
-
-					// ISRC 代码
-					if isrcTag, ok := raw["isrc"]; ok {
-						if isrcs, ok := isrcTag.([]string); ok && len(isrcs) > 0 {
-							isrc = isrcs[0]
-						} else if isrcStr, ok := isrcTag.(string); ok {
-							isrc = isrcStr
-						}
-					}
-
-					// UPC 代码
-					if upcTag, ok := raw["upc"]; ok {
-						if upcs, ok := upcTag.([]string); ok && len(upcs) > 0 {
-							upc = upcs[0]
-						} else if upcStr, ok := upcTag.(string); ok {
-							upc = upcStr
-						}
-					}
-				}
-			}
-
-			// 5. 查找或创建专辑
-			var albumID string
-
-			if albumName != "" {
-				// 规范化专辑名
-				albumName = normalizeString(albumName)
-
-				artistName := meta.AlbumArtist()
-				if artistName == "" {
-					artistName = meta.Artist()
-				}
-				if artistName == "" {
-					artistName = "未知艺术家"
-				}
-				// 规范化艺术家名
-				artistName = normalizeString(artistName)
-
-				// 使用规范化后的 "专辑名::艺术家名" 作为唯一键
-				albumKey := fmt.Sprintf("%s::%s", albumName, artistName)
-
-				// 首先检查内存缓存
-				album, found := albumCache[albumKey]
-				if !found {
-					// 缓存未命中,查询数据库(使用事务 tx)
-					var existingAlbum entity.Album
-					err := tx.Where("name = ? AND artist_name = ?", albumName, artistName).First(&existingAlbum).Error
-					if errors.Is(err, gorm.ErrRecordNotFound) {
-						// 数据库中也不存在,创建新专辑
-						// 先获取流派
-						albumGenre := meta.Genre()
-
-						newAlbum := entity.Album{
-							Name:       albumName,
-							ArtistName: artistName,
-							Genre:      albumGenre, // 新增：保存流派
-						}
-						// 安全地设置发行日期
-						if year > 0 {
-							t := time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC)
-							newAlbum.ReleaseDate = &t
-						}
-
-						// 提取并保存专辑封面
-						coverURL := extractAlbumCover(path, meta, albumCoverCache)
-						if coverURL != "" {
-							newAlbum.CoverURL = coverURL
-						}
-
-						if createErr := tx.Create(&newAlbum).Error; createErr != nil {
-							result.Errors = append(result.Errors, fmt.Sprintf("创建专辑失败 %s: %v", albumName, createErr))
-						} else {
-							albumCache[albumKey] = &newAlbum
-							albumID = newAlbum.ID
-						}
-					} else if err == nil {
-						albumCache[albumKey] = &existingAlbum
-						albumID = existingAlbum.ID
-
-						// 如果专辑已存在但没有封面，尝试补充封面
-						if existingAlbum.CoverURL == "" {
-							coverURL := extractAlbumCover(path, meta, albumCoverCache)
-							if coverURL != "" {
-								tx.Model(&existingAlbum).Update("cover_url", coverURL)
-								existingAlbum.CoverURL = coverURL
-							}
-						}
-					}
-				} else {
-					albumID = album.ID
-				}
-			}
-
-			// 6. 创建音乐实体
-			// 提取封面路径
-			coverURL := extractAlbumCover(path, meta, albumCoverCache)
-
-			music := entity.Music{
-				FileUrl:     path,
-				CoverUrl:    coverURL, // 新增：保存封面路径
-				Duration:    durationSeconds,
-				UserID:      "SYSTEM",
-				AlbumID:     albumID,
-				Album:       nil,
-				TrackNumber: trackNumber,
-				DiscNumber:  discNumber,
-				Year:        year,
-				ReleaseDate: releaseDate,
-				Size:        fileSize,
-				Suffix:      strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), "."),
-				BitRate:     bitRate,
-				SampleRate:  sampleRate,
-				BitDepth:    bitDepth,
-				Channels:    channels,
-				HasCoverArt: hasCoverArt,
-				Performer:   performer,
-				Label:       label,
-				Copyright:   copyright,
-				ISRC:        isrc,
-				UPC:         upc,
-			}
-
-			if meta != nil {
-				music.Title = normalizeString(meta.Title())
-				music.Artist = normalizeString(meta.Artist())
-				music.AlbumArtist = normalizeString(meta.AlbumArtist())
-				music.Genre = meta.Genre()
-				music.Composer = meta.Composer()
-			}
-
-			if music.Title == "" {
-				music.Title = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
-			}
-			if music.Artist == "" {
-				music.Artist = "未知艺术家"
-			}
-			if music.AlbumArtist == "" {
-				music.AlbumArtist = music.Artist
-			}
-
-			// 在扫描时使用
-			music.Genre = getOrInferGenre(tx, meta.Genre(), albumID, albumName)
-
-			if err := tx.Create(&music).Error; err != nil {
-				result.Errors = append(result.Errors, fmt.Sprintf("创建记录失败 %s: %v", path, err))
-			} else {
-				result.Added++
-			}
-			return nil
-		})
-
-		if walkErr != nil {
-			return walkErr
-		}
-
-		// 6. 清理（Pruning）：删除数据库中存在但文件已不存在的记录
-		for _, path := range existingPaths {
-			if _, found := foundPaths[path]; !found {
-				if err := tx.Where("file_url = ?", path).Delete(&entity.Music{}).Error; err == nil {
-					result.Removed++
-				}
-			}
-		}
-
-		return nil
-	})
+// ListNowPlaying 获取所有用户正在播放的歌曲
+func (h *MusicHandler) ListNowPlaying(c *fiber.Ctx) error {
+	entries := nowplaying.Default.List()
+	ids := make([]string, 0, len(entries))
+	for _, e := range entries {
+		ids = append(ids, e.MusicID)
+	}
 
+	libraries, err := accessibleLibraries(h.db, c)
 	if err != nil {
-		fmt.Printf("扫描事务失败: %v\n", err)
+		return utils.SendError(c, "获取正在播放失败")
 	}
 
-	fmt.Printf("扫描完成: 新增 %d, 移除 %d, 扫描文件 %d, 错误 %d\n", result.Added, result.Removed, result.ScannedFiles, len(result.Errors))
-	if len(result.Errors) > 0 {
-		fmt.Println("扫描期间发生错误:")
-		for _, e := range result.Errors {
-			fmt.Println("- ", e)
+	// 只显示当前用户可以访问的歌曲
+	var musics []entity.Music
+	if len(ids) > 0 {
+		if err := h.db.Preload("Album").Scopes(library.Scope("music", libraries)).
+			Where("id IN ?", ids).Find(&musics).Error; err != nil {
+			return utils.SendError(c, "获取正在播放失败")
 		}
 	}
-}
-
-// extractAlbumCover 提取专辑封面，优先级：1. 音乐文件内嵌封面 2. 目录下的图片文件
-func extractAlbumCover(musicPath string, meta tag.Metadata, coverCache map[string]string) string {
-	musicDir := filepath.Dir(musicPath)
-
-	// 检查是否已经为该目录找到过封面
-	if cachedCover, exists := coverCache[musicDir]; exists {
-		return cachedCover
+	byID := make(map[string]entity.Music, len(musics))
+	for _, m := range musics {
+		byID[m.ID] = m
 	}
 
-	var coverURL string
-
-	// 1. 优先尝试从音乐文件的元数据中提取封面
-	if meta != nil && meta.Picture() != nil {
-		picture := meta.Picture()
-		coverURL = saveCoverImage(musicDir, picture.Data, picture.Ext)
-		if coverURL != "" {
-			coverCache[musicDir] = coverURL
-			return coverURL
+	result := make([]dto.NowPlayingResponse, 0, len(entries))
+	for _, e := range entries {
+		m, ok := byID[e.MusicID]
+		if !ok {
+			continue
 		}
+		result = append(result, dto.NowPlayingResponse{
+			Username:   e.Username,
+			Client:     e.Client,
+			StartedAt:  e.StartedAt,
+			MinutesAgo: int(time.Since(e.StartedAt).Minutes()),
+			Music:      m,
+		})
 	}
-
-	// 2. 如果元数据中没有封面，扫描目录下的图片文件
-	coverURL = findCoverImageInDirectory(musicDir)
-	if coverURL != "" {
-		coverCache[musicDir] = coverURL
-		return coverURL
-	}
-
-	return ""
+	return utils.SendSuccess(c, "获取正在播放成功", result)
 }
 
-// saveCoverImage 将内嵌封面保存到磁盘
-func saveCoverImage(musicDir string, imageData []byte, ext string) string {
-	if len(imageData) == 0 {
-		return ""
-	}
-
-	// 创建 covers 目录
-	coversDir := filepath.Join(config.AppConfig.MusicFolder, ".covers")
-	if err := os.MkdirAll(coversDir, 0755); err != nil {
-		fmt.Printf("创建封面目录失败: %v\n", err)
-		return ""
+// TranscodeCacheStats 获取转码缓存的命中统计（管理员）
+func (h *MusicHandler) TranscodeCacheStats(c *fiber.Ctx) error {
+	cache := transcode.DefaultCache()
+	if cache == nil {
+		return utils.SendError(c, "转码缓存未启用")
 	}
-
-	// 使用 MD5 生成唯一的文件名
-	hash := md5.Sum(imageData)
-
-	// 确保扩展名包含点号
-	if ext != "" && !strings.HasPrefix(ext, ".") {
-		ext = "." + ext
-	}
-	// 如果没有扩展名，默认使用 .jpg
-	if ext == "" {
-		ext = ".jpg"
-	}
-
-	filename := hex.EncodeToString(hash[:]) + ext
-
-	coverPath := filepath.Join(coversDir, filename)
-
-	// 如果文件已存在，直接返回路径
-	if _, err := os.Stat(coverPath); err == nil {
-		return coverPath
-	}
-
-	// 保存图片文件
-	if err := os.WriteFile(coverPath, imageData, 0644); err != nil {
-		fmt.Printf("保存封面失败: %v\n", err)
-		return ""
-	}
-
-	fmt.Printf("保存专辑封面: %s\n", coverPath)
-	return coverPath
+	return utils.SendSuccess(c, "获取转码缓存统计成功", cache.Stats())
 }
 
-// findCoverImageInDirectory 在目录中查找封面图片
-func findCoverImageInDirectory(dir string) string {
-	// 常见的封面文件名
-	coverNames := []string{
-		"cover.jpg", "cover.jpeg", "cover.png",
-		"folder.jpg", "folder.jpeg", "folder.png",
-		"album.jpg", "album.jpeg", "album.png",
-		"front.jpg", "front.jpeg", "front.png",
-	}
+// LikeMusic 点赞音乐（即收藏，LikeCount 为收藏该歌曲的用户数）
+func (h *MusicHandler) LikeMusic(c *fiber.Ctx) error {
+	id := c.Params("id") // ID 现在是字符串
 
-	// 先尝试匹配常见的封面文件名
-	for _, name := range coverNames {
-		coverPath := filepath.Join(dir, name)
-		if _, err := os.Stat(coverPath); err == nil {
-			return coverPath
-		}
-		// 尝试大写
-		coverPath = filepath.Join(dir, strings.ToUpper(name))
-		if _, err := os.Stat(coverPath); err == nil {
-			return coverPath
-		}
+	userID, ok := c.Locals("userID").(string)
+	if !ok || userID == "" {
+		return utils.SendError(c, "未认证的用户")
 	}
-
-	// 如果没有找到，扫描目录中的第一张图片
-	entries, err := os.ReadDir(dir)
+	exists, err := itemExists(h.db, c, entity.ItemTypeMusic, id)
 	if err != nil {
-		return ""
+		return utils.SendError(c, "点赞失败")
 	}
-
-	for _, entry := range entries {
-		if entry.IsDir() {
-			continue
-		}
-		ext := strings.ToLower(filepath.Ext(entry.Name()))
-		if isImageFile(ext) {
-			return filepath.Join(dir, entry.Name())
-		}
+	if !exists {
+		return utils.SendError(c, "音乐不存在")
 	}
 
-	return ""
-}
-
-// isImageFile 检查文件扩展名是否为图片格式
-func isImageFile(ext string) bool {
-	imageExts := []string{".jpg", ".jpeg", ".png", ".gif", ".bmp", ".webp"}
-	for _, imgExt := range imageExts {
-		if ext == imgExt {
-			return true
-		}
+	if err := entity.SetStarred(h.db, userID, entity.ItemTypeMusic, []string{id}, true); err != nil {
+		return utils.SendError(c, "点赞失败")
 	}
-	return false
-}
 
-// isSupportedFileType 检查文件扩展名是否为支持的音频格式
-func isSupportedFileType(path string) bool {
-	ext := strings.ToLower(filepath.Ext(path))
-	supportedExts := []string{".mp3", ".m4a", ".flac", ".ogg", ".wav"}
-	for _, supported := range supportedExts {
-		if ext == supported {
-			return true
-		}
-	}
-	return false
+	return utils.SendSuccess(c, "点赞成功", nil)
 }
 
-// getOrInferGenre 获取或推断流派
-func getOrInferGenre(tx *gorm.DB, currentGenre, albumID, albumName string) string {
-	// 1. 如果当前音乐已有流派，直接返回
-	if currentGenre != "" {
-		return currentGenre
-	}
-
-	// 2. 尝试从专辑中其他音乐获取流派
-	if albumID != "" {
-		var genre string
-		err := tx.Model(&entity.Music{}).
-			Select("genre").
-			Where("album_id = ? AND genre != ''", albumID).
-			Group("genre").
-			Order("COUNT(*) DESC").
-			Limit(1).
-			Pluck("genre", &genre).Error
-
-		if err == nil && genre != "" {
-			return genre
+// ScanLibrary 在后台扫描音乐库并更新数据库，fullScan=true 时重新读取所有文件，
+// libraryId 指定只扫描一个音乐库（仅管理员）。同一时间只允许一个扫描，进度通过 /api/scan/status 查询
+func (h *MusicHandler) ScanLibrary(c *fiber.Ctx) error {
+	libs := library.All()
+	if id := uint(c.QueryInt("libraryId", 0)); id != 0 {
+		var lib entity.Library
+		if err := h.db.First(&lib, "id = ?", id).Error; err != nil {
+			return utils.SendError(c, "音乐库不存在")
 		}
+		libs = []entity.Library{lib}
 	}
-
-	// 3. 根据专辑名称或目录名称推断流派
-	albumLower := strings.ToLower(albumName)
-
-	genreKeywords := map[string][]string{
-		"Classical":  {"classical", "symphony", "concerto", "sonata", "古典"},
-		"Pop":        {"pop", "流行"},
-		"Rock":       {"rock", "摇滚"},
-		"Jazz":       {"jazz", "爵士"},
-		"Electronic": {"electronic", "edm", "techno", "house", "电子"},
-		"Hip Hop":    {"hip hop", "rap", "说唱"},
-		"Country":    {"country", "乡村"},
-		"R&B":        {"r&b", "soul"},
-		"Metal":      {"metal", "金属"},
-		"Folk":       {"folk", "民谣"},
-		"Soundtrack": {"soundtrack", "ost", "原声"},
-		"Anime":      {"anime", "动漫", "アニメ"},
-		"Game":       {"game", "游戏", "ゲーム"},
+	if len(libs) == 0 {
+		return utils.SendError(c, "尚未添加音乐库")
 	}
 
-	for genre, keywords := range genreKeywords {
-		for _, keyword := range keywords {
-			if strings.Contains(albumLower, keyword) {
-				return genre
-			}
-		}
+	job, err := h.scans.Start(h.db, libs, scanner.DefaultOptions(c.QueryBool("fullScan", false)), "api")
+	if errors.Is(err, scanner.ErrScanRunning) {
+		return utils.SendError(c, "已有扫描正在进行")
+	}
+	if err != nil {
+		return utils.SendError(c, "开始扫描失败")
 	}
 
-	return "Unknown"
+	return utils.SendSuccess(c, "音乐库扫描已在后台开始", job)
 }
 
 // GetLyrics 获取歌词
@@ -840,24 +356,13 @@ func (h *MusicHandler) GetLyrics(c *fiber.Ctx) error {
 			"error": "查询音乐失败",
 		})
 	}
-	// 获取音乐文件夹路径
-	// appBasePath := config.AppConfig.AppBasePath
-
-	// 获取音乐文件所在目录
-	musicPath := music.FileUrl
-	musicDir := filepath.Dir(musicPath)
-	musicFileName := filepath.Base(musicPath)
-
-	// 构建歌词文件路径：音乐文件夹/lyrics/歌曲名.lrc
-	lyricsDir := filepath.Join(musicDir, "lyrics")
-	lyricsFileName := strings.TrimSuffix(musicFileName, filepath.Ext(musicFileName)) + ".lrc"
-	// lyricsPath := filepath.Join(appBasePath, lyricsDir, lyricsFileName)
-	lyricsPath := filepath.Join(lyricsDir, lyricsFileName)
-
-	// 构建翻译歌词文件路径：音乐文件夹/lyrics/歌曲名.zh.lrc
-	translationFileName := strings.TrimSuffix(musicFileName, filepath.Ext(musicFileName)) + ".zh.lrc"
-	// translationPath := filepath.Join(appBasePath, lyricsDir, translationFileName)
-	translationPath := filepath.Join(lyricsDir, translationFileName)
+	if !canAccess(h.db, c, music.LibraryID) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "音乐不存在",
+		})
+	}
+	// 构建歌词文件路径：音乐文件夹/lyrics/歌曲名.lrc 与翻译歌词 歌曲名.zh.lrc
+	lyricsDir, lyricsPath, translationPath := lyricsFiles(&music)
 
 	// 1. 优先尝试从本地 lyrics 文件夹读取歌词（无论 engine 是什么）
 	lyricsContent, lyricsErr := os.ReadFile(lyricsPath)
@@ -886,12 +391,29 @@ func (h *MusicHandler) GetLyrics(c *fiber.Ctx) error {
 		})
 	}
 
-	// 3. 本地 lyrics 文件夹没有歌词文件，尝试从网络获取
+	// 3. 读取文件内嵌的歌词，整轨文件中的音轨只使用落在音轨范围内的同步歌词
+	embedded, err := scanner.EmbeddedLyrics(c.Context(), &music)
+	if err != nil {
+		fmt.Printf("读取内嵌歌词失败: %v\n", err)
+	} else if embedded != "" {
+		fmt.Printf("✓ 使用内嵌歌词: %s\n", music.FileUrl)
+		return c.JSON(fiber.Map{
+			"lyrics":  embedded,
+			"tlyrics": "",
+			"source":  "embedded",
+		})
+	}
+
+	// 4. 没有本地与内嵌歌词，尝试从网络获取
 	fmt.Printf("本地未找到歌词，尝试从 %s 获取...\n", engine)
 	fmt.Printf("请求歌词文件路径: %s\n", lyricsPath)
 	fmt.Printf("请求翻译歌词文件路径: %s\n", translationPath)
 
-	netLyrics, err := fetchLyricsFromNetwork(engine, music.Title, music.Artist, music.Album.Name)
+	albumName := ""
+	if music.Album != nil {
+		albumName = music.Album.Name
+	}
+	netLyrics, err := fetchLyricsFromNetwork(engine, music.Title, music.Artist, albumName)
 	if err != nil {
 		fmt.Printf("从网络获取歌词失败: %v\n", err)
 		return c.JSON(fiber.Map{
@@ -901,7 +423,7 @@ func (h *MusicHandler) GetLyrics(c *fiber.Ctx) error {
 		})
 	}
 
-	// 4. 将获取的歌词保存到本地 lyrics 文件夹
+	// 5. 将获取的歌词保存到本地 lyrics 文件夹
 	if netLyrics != "" {
 		// 确保 lyrics 目录存在
 		if err := os.MkdirAll(lyricsDir, 0755); err != nil {
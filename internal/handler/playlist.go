@@ -1,9 +1,9 @@
 package handler
 
 import (
-	"log"
 	"saboriman-music/internal/dto"
 	"saboriman-music/internal/entity"
+	"saboriman-music/internal/library"
 	"saboriman-music/internal/utils"
 
 	"github.com/gofiber/fiber/v2"
@@ -21,6 +21,14 @@ func NewPlaylistHandler(db *gorm.DB) *PlaylistHandler {
 	return &PlaylistHandler{db: db}
 }
 
+// readablePlaylists 只包含当前用户自己的与公开的播放列表
+func readablePlaylists(c *fiber.Ctx) func(*gorm.DB) *gorm.DB {
+	userID, _ := c.Locals("userID").(string)
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("(user_id = ? OR is_public = ?)", userID, true)
+	}
+}
+
 // CreatePlaylist 创建播放列表
 func (h *PlaylistHandler) CreatePlaylist(c *fiber.Ctx) error {
 	var req dto.CreatePlaylistRequest
@@ -34,6 +42,7 @@ func (h *PlaylistHandler) CreatePlaylist(c *fiber.Ctx) error {
 
 	var playlist entity.Playlist
 	copier.Copy(&playlist, &req)
+	playlist.UserID, _ = c.Locals("userID").(string)
 
 	if err := h.db.Create(&playlist).Error; err != nil {
 		return utils.SendError(c, "创建播放列表失败: "+err.Error())
@@ -47,13 +56,27 @@ func (h *PlaylistHandler) GetPlaylist(c *fiber.Ctx) error {
 	id := c.Params("id")
 
 	var playlist entity.Playlist
-	// 预加载关联的用户和音乐信息
-	if err := h.db.Preload("User").Preload("Musics").First(&playlist, "id = ?", id).Error; err != nil {
+	// 预加载关联的用户，按顺序加载歌曲
+	// 歌曲只包含当前用户可以访问的音乐库
+	libraries, err := accessibleLibraries(h.db, c)
+	if err != nil {
+		return utils.SendError(c, "查询播放列表失败")
+	}
+	// 他人的私有播放列表视为不存在
+	if err := h.db.Preload("User").Scopes(readablePlaylists(c)).First(&playlist, "id = ?", id).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return utils.SendError(c, "播放列表不存在")
 		}
 		return utils.SendError(c, "查询播放列表失败")
 	}
+	musics, err := entity.LoadPlaylistMusics(h.db, playlist.ID, library.Scope("music", libraries))
+	if err != nil {
+		return utils.SendError(c, "查询播放列表失败")
+	}
+	playlist.Musics = make([]*entity.Music, len(musics))
+	for i := range musics {
+		playlist.Musics[i] = &musics[i]
+	}
 
 	return utils.SendSuccess(c, "获取播放列表成功", playlist)
 }
@@ -90,7 +113,7 @@ func (h *PlaylistHandler) DeletePlaylist(c *fiber.Ctx) error {
 	return utils.SendSuccess(c, "播放列表删除成功", nil)
 }
 
-// ListPlaylists 获取播放列表列表
+// ListPlaylists 获取当前用户可见的播放列表列表
 func (h *PlaylistHandler) ListPlaylists(c *fiber.Ctx) error {
 	page := c.QueryInt("page", 1)
 	pageSize := c.QueryInt("page_size", 10)
@@ -99,7 +122,8 @@ func (h *PlaylistHandler) ListPlaylists(c *fiber.Ctx) error {
 	var playlists []entity.Playlist
 	var total int64
 
-	dbQuery := h.db.Model(&entity.Playlist{})
+	// 自己的播放列表 + 他人公开的播放列表
+	dbQuery := h.db.Model(&entity.Playlist{}).Scopes(readablePlaylists(c))
 
 	if query != "" {
 		searchQuery := "%" + query + "%"
@@ -135,12 +159,9 @@ func (h *PlaylistHandler) AddMusicToPlaylist(c *fiber.Ctx) error {
 		return utils.SendError(c, "请求参数解析失败")
 	}
 
-	// 检查音乐是否存在
-	var musicExists bool
-	if err := h.db.Model(&entity.Music{}).
-		Select("count(*) > 0").
-		Where("id = ?", req.MusicID).
-		Find(&musicExists).Error; err != nil {
+	// 检查音乐是否存在且当前用户可以访问
+	musicExists, err := itemExists(h.db, c, entity.ItemTypeMusic, req.MusicID)
+	if err != nil {
 		return utils.SendError(c, "查询音乐失败")
 	}
 
@@ -166,7 +187,7 @@ func (h *PlaylistHandler) AddMusicToPlaylist(c *fiber.Ctx) error {
 	}
 
 	if existingCount > 0 {
-		// 存在的话直接删除关联取消我的喜爱
+		// 已存在则移除（切换）
 		if err := h.db.Exec(
 			"DELETE FROM playlist_musics WHERE playlist_id = ? AND music_id = ?",
 			playlist.ID,
@@ -175,12 +196,6 @@ func (h *PlaylistHandler) AddMusicToPlaylist(c *fiber.Ctx) error {
 			return utils.SendError(c, "移除音乐失败: "+err.Error())
 		}
 
-		// 取消点赞计数
-		RES := h.db.Model(&entity.Music{}).Where("id = ?", req.MusicID).Update("like_count", gorm.Expr("like_count - 1"))
-		if RES.Error != nil {
-			return utils.SendError(c, "点赞失败")
-		}
-
 		result := map[string]interface{}{
 			"playlist": playlist,
 			"message":  "已移除",
@@ -189,18 +204,16 @@ func (h *PlaylistHandler) AddMusicToPlaylist(c *fiber.Ctx) error {
 		return utils.SendSuccess(c, "移除成功", result)
 	}
 
-	// 点赞计数
-	RES := h.db.Model(&entity.Music{}).Where("id = ?", req.MusicID).Update("like_count", gorm.Expr("like_count - 1"))
-	if RES.Error != nil {
-		return utils.SendError(c, "点赞失败")
+	// 直接插入关联表，追加到列表末尾
+	position, err := nextPlaylistPosition(h.db, playlist.ID)
+	if err != nil {
+		return utils.SendError(c, "添加音乐到播放列表失败: "+err.Error())
 	}
-
-	// 直接插入关联表
-	if err := h.db.Exec(
-		"INSERT INTO playlist_musics (playlist_id, music_id) VALUES (?, ?)",
-		playlist.ID,
-		req.MusicID,
-	).Error; err != nil {
+	if err := h.db.Create(&entity.PlaylistMusic{
+		PlaylistID: playlist.ID,
+		Position:   position,
+		MusicID:    req.MusicID,
+	}).Error; err != nil {
 		return utils.SendError(c, "添加音乐到播放列表失败: "+err.Error())
 	}
 
@@ -232,7 +245,7 @@ func (h *PlaylistHandler) RemoveMusicFromPlaylist(c *fiber.Ctx) error {
 		return utils.SendError(c, "音乐不存在")
 	}
 
-	if err := h.db.Model(&playlist).Association("Musics").Delete(&music); err != nil {
+	if err := h.db.Where("playlist_id = ? AND music_id = ?", playlist.ID, music.ID).Delete(&entity.PlaylistMusic{}).Error; err != nil {
 		return utils.SendError(c, "从播放列表删除音乐失败")
 	}
 
@@ -255,110 +268,71 @@ func (h *PlaylistHandler) PlayPlaylist(c *fiber.Ctx) error {
 	return utils.SendSuccess(c, "播放列表播放次数增加成功", nil)
 }
 
-// AddToFavoritePlaylist 添加到"我的喜爱"播放列表（支持切换）
+// AddToFavoritePlaylist 切换歌曲的收藏状态（兼容旧版"我的喜爱"接口）
 func (h *PlaylistHandler) AddToFavoritePlaylist(c *fiber.Ctx) error {
 	userID, ok := c.Locals("userID").(string)
 	if !ok || userID == "" {
 		return utils.SendError(c, "未认证的用户")
 	}
 
-	// 从请求体获取要添加的音乐ID
+	// 从请求体获取要收藏的音乐ID
 	var req dto.AddMusicToPlaylistRequest
 	if err := c.BodyParser(&req); err != nil {
 		return utils.SendError(c, "请求参数解析失败")
 	}
 
-	log.Printf("[DEBUG] 请求的音乐ID: '%s'", req.MusicID)
-
-	// 检查音乐是否存在
-	var musicExists bool
-	if err := h.db.Model(&entity.Music{}).
-		Select("count(*) > 0").
-		Where("id = ?", req.MusicID).
-		Find(&musicExists).Error; err != nil {
+	var music entity.Music
+	if err := h.db.First(&music, "id = ?", req.MusicID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return utils.SendError(c, "音乐不存在")
+		}
 		return utils.SendError(c, "查询音乐失败")
 	}
-
-	if !musicExists {
+	if !canAccess(h.db, c, music.LibraryID) {
 		return utils.SendError(c, "音乐不存在")
 	}
 
-	// 查找或创建"我的喜爱"播放列表
-	var playlist entity.Playlist
-	err := h.db.Where("name = ? AND user_id = ?", "我的喜爱", userID).First(&playlist).Error
-
+	annotations, err := entity.LoadAnnotations(h.db, userID, entity.ItemTypeMusic, []string{music.ID})
 	if err != nil {
-		if err == gorm.ErrRecordNotFound {
-			playlist = entity.Playlist{
-				Name:        "我的喜爱",
-				Description: "我喜欢的音乐",
-				UserID:      userID,
-			}
-
-			if err := h.db.Create(&playlist).Error; err != nil {
-				return utils.SendError(c, "创建播放列表失败: "+err.Error())
-			}
-			log.Printf("[DEBUG] 创建新的'我的喜爱'播放列表: %s", playlist.ID)
-		} else {
-			return utils.SendError(c, "查询播放列表失败")
-		}
+		return utils.SendError(c, "查询收藏失败")
 	}
 
-	// 检查音乐是否已在播放列表中（直接查询关联表）
-	var existingCount int64
-	if err := h.db.Table("playlist_musics").
-		Where("playlist_id = ? AND music_id = ?", playlist.ID, req.MusicID).
-		Count(&existingCount).Error; err != nil {
-		return utils.SendError(c, "检查关联失败")
-	}
-
-	if existingCount > 0 {
-		// 存在的话直接删除关联取消我的喜爱
-		if err := h.db.Exec(
-			"DELETE FROM playlist_musics WHERE playlist_id = ? AND music_id = ?",
-			playlist.ID,
-			req.MusicID,
-		).Error; err != nil {
-			log.Printf("[ERROR] 移除关联失败: %v", err)
+	if annotations[music.ID].StarredAt != nil {
+		if err := entity.SetStarred(h.db, userID, entity.ItemTypeMusic, []string{music.ID}, false); err != nil {
 			return utils.SendError(c, "取消喜爱失败: "+err.Error())
 		}
-
-		log.Printf("[DEBUG] 成功从播放列表 %s 移除音乐 %s", playlist.ID, req.MusicID)
-
 		result := map[string]interface{}{
-			"playlist":  playlist,
-			"music_id":  req.MusicID,
+			"music_id":  music.ID,
 			"favorited": false,
 			"action":    "removed",
 			"message":   "已取消喜爱",
 		}
-
 		return utils.SendSuccess(c, "取消喜爱成功", result)
 	}
 
-	// 直接插入关联表，避免操作 music 表
-	if err := h.db.Exec(
-		"INSERT INTO playlist_musics (playlist_id, music_id) VALUES (?, ?)",
-		playlist.ID,
-		req.MusicID,
-	).Error; err != nil {
-		log.Printf("[ERROR] 添加关联失败: %v", err)
-		return utils.SendError(c, "添加音乐到播放列表失败: "+err.Error())
+	if err := entity.SetStarred(h.db, userID, entity.ItemTypeMusic, []string{music.ID}, true); err != nil {
+		return utils.SendError(c, "添加喜爱失败: "+err.Error())
 	}
-
-	log.Printf("[DEBUG] 成功添加音乐 %s 到播放列表 %s", req.MusicID, playlist.ID)
-
-	// 获取完整的音乐信息用于返回
-	var music entity.Music
-	h.db.First(&music, "id = ?", req.MusicID)
-
 	result := map[string]interface{}{
-		"playlist":  playlist,
 		"music":     music,
 		"favorited": true,
 		"action":    "added",
 		"message":   "添加到我的喜爱成功",
 	}
-
 	return utils.SendSuccess(c, "添加成功", result)
 }
+
+// nextPlaylistPosition 返回播放列表末尾的位置，保证新加入的歌曲排在最后
+func nextPlaylistPosition(db *gorm.DB, playlistID string) (int, error) {
+	var last *int
+	if err := db.Model(&entity.PlaylistMusic{}).
+		Where("playlist_id = ?", playlistID).
+		Select("MAX(position)").
+		Scan(&last).Error; err != nil {
+		return 0, err
+	}
+	if last == nil {
+		return 0, nil
+	}
+	return *last + 1, nil
+}
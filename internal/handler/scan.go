@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"errors"
+	"saboriman-music/internal/entity"
+	"saboriman-music/internal/scanner"
+	"saboriman-music/internal/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// ScanStatusResponse 扫描状态
+type ScanStatusResponse struct {
+	Scanning bool            `json:"scanning"`
+	Job      *entity.ScanJob `json:"job"` // 当前或最近一次扫描，从未扫描过时为 null
+}
+
+// GetScanStatus 获取当前扫描的实时进度，或最近一次扫描的结果
+func (h *MusicHandler) GetScanStatus(c *fiber.Ctx) error {
+	job, running, ok := h.scans.Status()
+	if ok {
+		return utils.SendSuccess(c, "获取扫描状态成功", ScanStatusResponse{Scanning: running, Job: &job})
+	}
+
+	// 服务重启后内存中没有记录，返回数据库中最近一次扫描
+	last, err := scanner.LastJob(h.db)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return utils.SendError(c, "获取扫描状态失败")
+	}
+	return utils.SendSuccess(c, "获取扫描状态成功", ScanStatusResponse{Job: last})
+}
+
+// ListScanHistory 获取扫描历史，按开始时间倒序
+func (h *MusicHandler) ListScanHistory(c *fiber.Ctx) error {
+	limit := c.QueryInt("limit", 20)
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	jobs, err := scanner.History(h.db, limit)
+	if err != nil {
+		return utils.SendError(c, "获取扫描历史失败")
+	}
+	return utils.SendSuccess(c, "获取扫描历史成功", jobs)
+}
+
+// CancelScan 取消正在进行的扫描（管理员），已处理的文件会保留
+func (h *MusicHandler) CancelScan(c *fiber.Ctx) error {
+	if !h.scans.Cancel() {
+		return utils.SendError(c, "当前没有正在进行的扫描")
+	}
+	return utils.SendSuccess(c, "扫描已取消", nil)
+}
@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+
+	"saboriman-music/internal/entity"
+	"saboriman-music/internal/transcode"
+	"saboriman-music/internal/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// StreamMusic 播放音乐：普通文件直接发送（支持 Range），
+// 整轨文件中的音轨按 CUE 起止位置截取，截取结果写入转码缓存
+func (h *MusicHandler) StreamMusic(c *fiber.Ctx) error {
+	var music entity.Music
+	if err := h.db.First(&music, "id = ?", c.Params("id")).Error; err != nil || !canAccess(h.db, c, music.LibraryID) {
+		return utils.SendErrorWithStatus(c, fiber.StatusNotFound, "音乐不存在")
+	}
+	fullPath := utils.MusicFilePath(music.FilePath())
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return utils.SendErrorWithStatus(c, fiber.StatusNotFound, "音乐文件不存在")
+	}
+
+	clip := transcode.NewClip(music.CueStart, music.CueEnd)
+	decision := transcode.Decision{Raw: true}.ForClip(clip)
+	if decision.Raw {
+		return sendMusicFile(c, fullPath, utils.MusicContentType(filepath.Ext(fullPath), music.Codec))
+	}
+
+	start := func() (io.ReadCloser, error) {
+		return transcode.Start(fullPath, decision, clip, 0)
+	}
+	var stream io.ReadCloser
+	if cache := transcode.DefaultCache(); cache != nil {
+		key := transcode.Key(music.ID, info, decision)
+		if path, ok := cache.Lookup(key); ok {
+			return sendMusicFile(c, path, decision.Profile.ContentType)
+		}
+		stream, err = cache.Stream(key, decision.Profile.Format, start)
+	} else {
+		stream, err = start()
+	}
+	if err != nil {
+		return utils.SendError(c, "转码失败: "+err.Error())
+	}
+	c.Set(fiber.HeaderContentType, decision.Profile.ContentType)
+	c.Context().SetBodyStream(stream, -1)
+	return nil
+}
+
+// sendMusicFile 发送文件并覆盖按扩展名推断的 Content-Type
+func sendMusicFile(c *fiber.Ctx, path, contentType string) error {
+	if err := c.SendFile(path); err != nil {
+		return err
+	}
+	if status := c.Response().StatusCode(); status == fiber.StatusOK || status == fiber.StatusPartialContent {
+		c.Set(fiber.HeaderContentType, contentType)
+	}
+	return nil
+}
@@ -130,16 +130,42 @@ func (h *UserHandler) GetCurrentUser(c *fiber.Ctx) error {
 	}
 
 	userInfo := dto.UserInfo{
-		ID:       user.ID,
-		Username: user.Username,
-		Email:    user.Email,
-		Avatar:   user.Avatar,
-		Role:     string(user.Role),
+		ID:         user.ID,
+		Username:   user.Username,
+		Email:      user.Email,
+		Avatar:     user.Avatar,
+		Role:       string(user.Role),
+		MaxBitRate: user.MaxBitRate,
 	}
 
 	return utils.SendSuccess(c, "获取用户信息成功", userInfo)
 }
 
+// UpdateSettings 修改当前用户的个人设置
+func (h *UserHandler) UpdateSettings(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(string)
+
+	var req dto.UpdateSettingsRequest
+	if err := c.BodyParser(&req); err != nil {
+		return utils.SendError(c, "请求参数解析失败")
+	}
+
+	updates := map[string]interface{}{}
+	if req.MaxBitRate != nil {
+		if *req.MaxBitRate < 0 {
+			return utils.SendError(c, "最大码率不能为负数")
+		}
+		updates["max_bit_rate"] = *req.MaxBitRate
+	}
+	if len(updates) > 0 {
+		if err := h.db.Model(&entity.User{}).Where("id = ?", userID).Updates(updates).Error; err != nil {
+			return utils.SendError(c, "更新设置失败")
+		}
+	}
+
+	return utils.SendSuccess(c, "设置已更新", nil)
+}
+
 // ChangePassword 修改密码
 func (h *UserHandler) ChangePassword(c *fiber.Ctx) error {
 	userID := c.Locals("userID").(string)
@@ -284,3 +310,68 @@ func (h *UserHandler) ListUsers(c *fiber.Ctx) error {
 	}
 	return utils.SendSuccess(c, "获取用户列表成功", result)
 }
+
+// ListSubsonicCredentials 获取当前用户的 Subsonic 凭据列表（不含密钥）
+func (h *UserHandler) ListSubsonicCredentials(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(string)
+
+	var creds []entity.SubsonicCredential
+	if err := h.db.Where("user_id = ?", userID).Order("created_at DESC").Find(&creds).Error; err != nil {
+		return utils.SendError(c, "获取 Subsonic 凭据失败")
+	}
+
+	return utils.SendSuccess(c, "获取 Subsonic 凭据成功", creds)
+}
+
+// CreateSubsonicCredential 为当前用户创建 Subsonic 应用密码或 apiKey
+func (h *UserHandler) CreateSubsonicCredential(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(string)
+
+	var req dto.CreateSubsonicCredentialRequest
+	if err := c.BodyParser(&req); err != nil {
+		return utils.SendError(c, "请求参数解析失败")
+	}
+
+	credType := entity.CredentialType(req.Type)
+	if credType == "" {
+		credType = entity.CredentialAppPassword
+	}
+	if !credType.IsValid() {
+		return utils.SendError(c, "无效的凭据类型")
+	}
+
+	cred := entity.SubsonicCredential{
+		UserID: userID,
+		Type:   credType,
+		Name:   req.Name,
+	}
+	// apiKey 必须随机生成，应用密码允许自定义
+	if credType == entity.CredentialAppPassword {
+		cred.Secret = req.Secret
+	}
+
+	if err := h.db.Create(&cred).Error; err != nil {
+		return utils.SendError(c, "创建 Subsonic 凭据失败: "+err.Error())
+	}
+
+	return utils.SendSuccess(c, "Subsonic 凭据创建成功", dto.SubsonicCredentialResponse{
+		SubsonicCredential: cred,
+		Secret:             cred.Secret,
+	})
+}
+
+// DeleteSubsonicCredential 删除当前用户的 Subsonic 凭据
+func (h *UserHandler) DeleteSubsonicCredential(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(string)
+	id := c.Params("id")
+
+	result := h.db.Where("id = ? AND user_id = ?", id, userID).Delete(&entity.SubsonicCredential{})
+	if result.Error != nil {
+		return utils.SendError(c, "删除 Subsonic 凭据失败")
+	}
+	if result.RowsAffected == 0 {
+		return utils.SendError(c, "Subsonic 凭据不存在")
+	}
+
+	return utils.SendSuccess(c, "Subsonic 凭据删除成功", nil)
+}
@@ -0,0 +1,216 @@
+// Package library 管理多个音乐库及用户对音乐库的访问权限。
+//
+// 音乐库列表很少变化，读取后缓存在内存中，供路径换算等频繁调用的地方使用；
+// 增删改音乐库后需要调用 Load 刷新缓存。
+package library
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"saboriman-music/internal/entity"
+
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrForbidden 用户无权访问请求的音乐库
+	ErrForbidden = errors.New("library not accessible")
+	// ErrOverlap 音乐库根目录与已有音乐库重叠
+	ErrOverlap = errors.New("library path overlaps an existing library")
+)
+
+// cache 最近一次读取的音乐库列表
+var cache atomic.Pointer[[]entity.Library]
+
+// Load 从数据库重新读取音乐库列表并刷新缓存
+func Load(db *gorm.DB) ([]entity.Library, error) {
+	var libs []entity.Library
+	if err := db.Order("id ASC").Find(&libs).Error; err != nil {
+		return nil, err
+	}
+	cache.Store(&libs)
+	return libs, nil
+}
+
+// All 返回缓存的音乐库列表
+func All() []entity.Library {
+	if libs := cache.Load(); libs != nil {
+		return *libs
+	}
+	return nil
+}
+
+// Find 返回包含该路径的音乐库及相对其根目录的路径
+func Find(path string) (entity.Library, string, bool) {
+	for _, lib := range All() {
+		if Contains(lib.Path, path) {
+			rel, err := filepath.Rel(lib.Path, path)
+			if err == nil {
+				return lib, rel, true
+			}
+		}
+	}
+	return entity.Library{}, "", false
+}
+
+// Contains 判断路径是否为根目录本身或位于其中
+func Contains(root, path string) bool {
+	root, path = filepath.Clean(root), filepath.Clean(path)
+	return path == root || strings.HasPrefix(path, strings.TrimSuffix(root, string(filepath.Separator))+string(filepath.Separator))
+}
+
+// EnsureDefault 为配置中的 MusicFolder 创建默认音乐库，
+// 并把升级前入库、尚未归属任何音乐库的歌曲和专辑归入其中
+func EnsureDefault(db *gorm.DB, musicFolder string) error {
+	// 升级前以是否有授权记录区分受限用户
+	if err := db.Model(&entity.User{}).Where("restricted = ?", false).
+		Where("id IN (?)", db.Model(&entity.UserLibrary{}).Select("user_id")).
+		Update("restricted", true).Error; err != nil {
+		return err
+	}
+	if musicFolder != "" {
+		path := filepath.Clean(musicFolder)
+		lib := entity.Library{Name: filepath.Base(path), Path: path}
+		if err := db.Where("path = ?", path).FirstOrCreate(&lib).Error; err != nil {
+			return fmt.Errorf("创建默认音乐库失败: %w", err)
+		}
+		if err := db.Model(&entity.Music{}).Where("library_id = 0 OR library_id IS NULL").
+			Update("library_id", lib.ID).Error; err != nil {
+			return err
+		}
+		if err := db.Model(&entity.Album{}).Where("library_id = 0 OR library_id IS NULL").
+			Update("library_id", lib.ID).Error; err != nil {
+			return err
+		}
+	}
+	_, err := Load(db)
+	return err
+}
+
+// Touch 记录音乐库中的歌曲发生了增删或移动
+func Touch(db *gorm.DB, id uint) error {
+	return db.Model(&entity.Library{}).Where("id = ?", id).UpdateColumn("content_changed_at", time.Now()).Error
+}
+
+// Validate 检查音乐库的根目录：必须是已存在的目录，且不能与其他音乐库互相包含
+func Validate(db *gorm.DB, lib *entity.Library) error {
+	lib.Path = filepath.Clean(lib.Path)
+	info, err := os.Stat(lib.Path)
+	if err != nil {
+		return fmt.Errorf("访问目录失败: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s 不是目录", lib.Path)
+	}
+
+	var others []entity.Library
+	if err := db.Where("id <> ?", lib.ID).Find(&others).Error; err != nil {
+		return err
+	}
+	for _, other := range others {
+		if Contains(other.Path, lib.Path) || Contains(lib.Path, other.Path) {
+			return ErrOverlap
+		}
+	}
+	return nil
+}
+
+// Accessible 返回用户可以访问的音乐库 ID，nil 表示可以访问全部音乐库；
+// 受限用户的授权记录全部删除后返回空列表，不能访问任何音乐库
+func Accessible(db *gorm.DB, userID string, role entity.Role) ([]uint, error) {
+	if role.IsAdmin() {
+		return nil, nil
+	}
+	var user entity.User
+	if err := db.Select("id", "restricted").First(&user, "id = ?", userID).Error; err != nil {
+		return nil, err
+	}
+	if !user.Restricted {
+		return nil, nil
+	}
+	ids := []uint{}
+	if err := db.Model(&entity.UserLibrary{}).Where("user_id = ?", userID).
+		Pluck("library_id", &ids).Error; err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// Resolve 将访问范围缩小到请求的音乐库，requested 为 0 表示不指定
+func Resolve(accessible []uint, requested uint) ([]uint, error) {
+	if requested == 0 {
+		return accessible, nil
+	}
+	if !Allowed(accessible, requested) {
+		return nil, ErrForbidden
+	}
+	return []uint{requested}, nil
+}
+
+// Allowed 判断音乐库是否在访问范围内
+func Allowed(ids []uint, id uint) bool {
+	if ids == nil {
+		return true
+	}
+	for _, allowed := range ids {
+		if allowed == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Scope 按音乐库过滤查询，table 为带 library_id 列的表名，ids 为 nil 时不过滤
+func Scope(table string, ids []uint) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if ids == nil {
+			return db
+		}
+		return db.Where(table+".library_id IN ?", ids)
+	}
+}
+
+// UserLibraries 返回管理员为用户分配的音乐库 ID，用户不受限制时返回 nil
+func UserLibraries(db *gorm.DB, userID string) ([]uint, error) {
+	var user entity.User
+	if err := db.Select("id", "restricted").First(&user, "id = ?", userID).Error; err != nil {
+		return nil, err
+	}
+	if !user.Restricted {
+		return nil, nil
+	}
+	ids := []uint{}
+	err := db.Model(&entity.UserLibrary{}).Where("user_id = ?", userID).
+		Order("library_id ASC").Pluck("library_id", &ids).Error
+	return ids, err
+}
+
+// SetUserLibraries 替换用户可以访问的音乐库，ids 为空表示不限制
+func SetUserLibraries(db *gorm.DB, userID string, ids []uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&entity.UserLibrary{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&entity.User{}).Where("id = ?", userID).
+			Update("restricted", len(ids) > 0).Error; err != nil {
+			return err
+		}
+		seen := make(map[uint]bool, len(ids))
+		for _, id := range ids {
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			if err := tx.Create(&entity.UserLibrary{UserID: userID, LibraryID: id}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
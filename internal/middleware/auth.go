@@ -20,35 +20,60 @@ func AuthMiddleware() fiber.Handler {
 				"data":    nil,
 			})
 		}
+		return authenticate(c, authHeader)
+	}
+}
 
-		// 检查格式：Bearer <token>
-		parts := strings.SplitN(authHeader, " ", 2)
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-				"code":    401,
-				"message": "认证令牌格式错误",
-				"data":    nil,
-			})
-		}
-
-		// 解析 token
-		claims, err := utils.ParseToken(parts[1])
-		if err != nil {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-				"code":    401,
-				"message": "无效的认证令牌",
-				"data":    nil,
-			})
+// StreamAuthMiddleware 播放与 HLS 路由的认证中间件：浏览器的 <audio> 与 HLS 播放器无法设置请求头，
+// 未携带 Authorization 时从 token 查询参数读取令牌
+func StreamAuthMiddleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		authHeader := c.Get("Authorization")
+		if authHeader == "" {
+			token := c.Query("token")
+			if token == "" {
+				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+					"code":    401,
+					"message": "未提供认证令牌",
+					"data":    nil,
+				})
+			}
+			authHeader = "Bearer " + token
 		}
+		return authenticate(c, authHeader)
+	}
+}
 
-		// 将用户信息存储到 context 中
-		c.Locals("userID", claims.UserID)
-		c.Locals("username", claims.Username)
-		c.Locals("email", claims.Email)
-		c.Locals("role", entity.RoleFromString(claims.Role))
+// authenticate 解析 "Bearer <token>" 并将用户信息存储到 context 中
+func authenticate(c *fiber.Ctx, authHeader string) error {
+	// 检查格式：Bearer <token>
+	parts := strings.SplitN(authHeader, " ", 2)
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"code":    401,
+			"message": "认证令牌格式错误",
+			"data":    nil,
+		})
+	}
 
-		return c.Next()
+	// 解析 token
+	claims, err := utils.ParseToken(parts[1])
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"code":    401,
+			"message": "无效的认证令牌",
+			"data":    nil,
+		})
 	}
+
+	// 将用户信息存储到 context 中
+	c.Locals("userID", claims.UserID)
+	c.Locals("username", claims.Username)
+	c.Locals("email", claims.Email)
+	c.Locals("role", entity.RoleFromString(claims.Role))
+	c.Locals("token", parts[1])
+
+	return c.Next()
 }
 
 // AdminMiddleware 管理员权限中间件
@@ -64,11 +89,11 @@ func AdminMiddleware() fiber.Handler {
 		}
 
 		if !role.IsAdmin() {
-			// return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
-			// 	"code":    403,
-			// 	"message": "权限不足，需要管理员权限",
-			// 	"data":    nil,
-			// })
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"code":    403,
+				"message": "权限不足，需要管理员权限",
+				"data":    nil,
+			})
 		}
 
 		return c.Next()
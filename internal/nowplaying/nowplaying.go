@@ -0,0 +1,86 @@
+// Package nowplaying 记录各用户/客户端正在播放的歌曲（仅保存在内存中）
+package nowplaying
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// defaultTTL 歌曲时长未知时正在播放记录的保留时间
+const defaultTTL = 10 * time.Minute
+
+// Entry 一条正在播放记录
+type Entry struct {
+	UserID    string
+	Username  string
+	Client    string
+	MusicID   string
+	StartedAt time.Time
+	ExpiresAt time.Time
+}
+
+// Registry 正在播放记录表，同一用户的同一客户端只保留最新一条
+type Registry struct {
+	mu      sync.Mutex
+	entries map[string]Entry
+	now     func() time.Time
+}
+
+// New 创建正在播放记录表
+func New() *Registry {
+	return &Registry{entries: make(map[string]Entry), now: time.Now}
+}
+
+// Default 全局正在播放记录表，JSON API 与 Subsonic 接口共用
+var Default = New()
+
+// Set 记录用户开始播放歌曲，duration 为歌曲时长（秒），播放结束后自动过期
+func (r *Registry) Set(userID, username, client, musicID string, duration int) {
+	ttl := defaultTTL
+	if duration > 0 {
+		ttl = time.Duration(duration) * time.Second
+	}
+	now := r.now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries[userID+"\x00"+client] = Entry{
+		UserID:    userID,
+		Username:  username,
+		Client:    client,
+		MusicID:   musicID,
+		StartedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+}
+
+// Clear 歌曲播放完成（提交播放记录）后移除对应的正在播放记录，客户端已切到其他歌曲时保持不变
+func (r *Registry) Clear(userID, client, musicID string) {
+	key := userID + "\x00" + client
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if e, ok := r.entries[key]; ok && e.MusicID == musicID {
+		delete(r.entries, key)
+	}
+}
+
+// List 返回未过期的记录，最近开始播放的在前
+func (r *Registry) List() []Entry {
+	now := r.now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	list := make([]Entry, 0, len(r.entries))
+	for key, e := range r.entries {
+		if now.After(e.ExpiresAt) {
+			delete(r.entries, key)
+			continue
+		}
+		list = append(list, e)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].StartedAt.After(list[j].StartedAt)
+	})
+	return list
+}
@@ -1,20 +1,25 @@
 package router
 
 import (
+	"saboriman-music/internal/entity"
 	"saboriman-music/internal/handler"
 	"saboriman-music/internal/middleware"
+	"saboriman-music/internal/scanner"
 
 	"github.com/gofiber/fiber/v2"
 	"gorm.io/gorm"
 )
 
-func SetupRoutes(app *fiber.App, db *gorm.DB) {
+func SetupRoutes(app *fiber.App, db *gorm.DB, scans *scanner.Manager) {
 	// 创建处理器
 	userHandler := handler.NewUserHandler(db)
-	musicHandler := handler.NewMusicHandler(db)
+	musicHandler := handler.NewMusicHandler(db, scans)
 	lyricsHandler := handler.NewLyricsHandler(db)
 	albumHandler := handler.NewAlbumHandler(db)
 	playlistHandler := handler.NewPlaylistHandler(db)
+	annotationHandler := handler.NewAnnotationHandler(db)
+	libraryHandler := handler.NewLibraryHandler(db, scans)
+	artistHandler := handler.NewArtistHandler(db)
 
 	api := app.Group("/api")
 
@@ -30,6 +35,14 @@ func SetupRoutes(app *fiber.App, db *gorm.DB) {
 	lyrics.Post("/:id/lyrics", lyricsHandler.SaveLyrics)              // 新增：保存歌词
 	lyrics.Post("/:id/tlyrics", lyricsHandler.SaveTranslatiionLyrics) // 新增：保存歌词
 
+	// 播放与 HLS（需要认证）：播放器无法设置请求头，允许以 token 查询参数认证；
+	// 须在 protected 分组之前注册，否则会先经过只读取请求头的 AuthMiddleware
+	streamAuth := middleware.StreamAuthMiddleware()
+	api.Get("/musics/:id/stream", streamAuth, musicHandler.StreamMusic)
+	api.Get("/musics/:id/hls/master.m3u8", streamAuth, musicHandler.HLSMaster)
+	api.Get("/musics/:id/hls/:bitrate/index.m3u8", streamAuth, musicHandler.HLSPlaylist)
+	api.Get("/musics/:id/hls/:bitrate/:segment.ts", streamAuth, musicHandler.HLSSegment)
+
 	// 需要认证的路由
 	protected := api.Group("", middleware.AuthMiddleware())
 
@@ -37,16 +50,28 @@ func SetupRoutes(app *fiber.App, db *gorm.DB) {
 	users := protected.Group("/users")
 	users.Get("/me", userHandler.GetCurrentUser)
 	users.Put("/me/password", userHandler.ChangePassword)
+	users.Put("/me/settings", userHandler.UpdateSettings)
 	users.Post("/logout", userHandler.Logout)
+	users.Get("/me/subsonic-credentials", userHandler.ListSubsonicCredentials)
+	users.Post("/me/subsonic-credentials", userHandler.CreateSubsonicCredential)
+	users.Delete("/me/subsonic-credentials/:id", userHandler.DeleteSubsonicCredential)
 
-	// 管理员路由
-	admin := protected.Group("", middleware.AdminMiddleware())
-	adminUsers := admin.Group("/users")
-	adminUsers.Get("", userHandler.ListUsers)
-	adminUsers.Post("", userHandler.CreateUser)
-	adminUsers.Get("/:id", userHandler.GetUser)
-	adminUsers.Put("/:id", userHandler.UpdateUser)
-	adminUsers.Delete("/:id", userHandler.DeleteUser)
+	// 管理员路由：中间件挂在各个路由上，
+	// 以空前缀分组注册的中间件会作用于其后注册的所有 /api 路由
+	adminOnly := middleware.AdminMiddleware()
+	adminUsers := protected.Group("/users")
+	adminUsers.Get("", adminOnly, userHandler.ListUsers)
+	adminUsers.Post("", adminOnly, userHandler.CreateUser)
+	adminUsers.Get("/:id", adminOnly, userHandler.GetUser)
+	adminUsers.Put("/:id", adminOnly, userHandler.UpdateUser)
+	adminUsers.Delete("/:id", adminOnly, userHandler.DeleteUser)
+	adminUsers.Get("/:id/libraries", adminOnly, libraryHandler.GetUserLibraries)
+	adminUsers.Put("/:id/libraries", adminOnly, libraryHandler.SetUserLibraries)
+	protected.Get("/transcode/cache", adminOnly, musicHandler.TranscodeCacheStats)
+	protected.Post("/scan/cancel", adminOnly, musicHandler.CancelScan)
+	protected.Post("/libraries", adminOnly, libraryHandler.CreateLibrary)
+	protected.Put("/libraries/:id", adminOnly, libraryHandler.UpdateLibrary)
+	protected.Delete("/libraries/:id", adminOnly, libraryHandler.DeleteLibrary)
 
 	// 音乐相关（需要认证）
 	musics := protected.Group("/musics")
@@ -58,7 +83,17 @@ func SetupRoutes(app *fiber.App, db *gorm.DB) {
 	musics.Get("/:id/lyrics", musicHandler.GetLyrics) // 新增：获取歌词
 	musics.Post("/:id/play", musicHandler.PlayMusic)
 	musics.Post("/:id/like", musicHandler.LikeMusic)
-	musics.Post("/scan", musicHandler.ScanLibrary)
+	musics.Post("/:id/star", annotationHandler.Star(entity.ItemTypeMusic))
+	musics.Delete("/:id/star", annotationHandler.Unstar(entity.ItemTypeMusic))
+	musics.Put("/:id/rating", annotationHandler.SetRating(entity.ItemTypeMusic))
+	musics.Post("/scan", adminOnly, musicHandler.ScanLibrary)
+
+	// 音乐库
+	protected.Get("/libraries", libraryHandler.ListLibraries)
+
+	// 音乐库扫描状态与历史
+	protected.Get("/scan/status", musicHandler.GetScanStatus)
+	protected.Get("/scan/history", musicHandler.ListScanHistory)
 
 	// 专辑相关
 	albums := protected.Group("/albums")
@@ -68,6 +103,24 @@ func SetupRoutes(app *fiber.App, db *gorm.DB) {
 	albums.Put("/:id", albumHandler.UpdateAlbum)
 	albums.Delete("/:id", albumHandler.DeleteAlbum)
 	albums.Get("/:id/musics", albumHandler.GetAlbumMusics)
+	albums.Post("/:id/star", annotationHandler.Star(entity.ItemTypeAlbum))
+	albums.Delete("/:id/star", annotationHandler.Unstar(entity.ItemTypeAlbum))
+	albums.Put("/:id/rating", annotationHandler.SetRating(entity.ItemTypeAlbum))
+
+	// 艺术家相关（ID 与 Subsonic artistId 一致）
+	artists := protected.Group("/artists")
+	artists.Get("", artistHandler.ListArtists)
+	artists.Get("/:id", artistHandler.GetArtist)
+	artists.Get("/:id/albums", artistHandler.ListArtistAlbums)
+	artists.Post("/:id/star", annotationHandler.Star(entity.ItemTypeArtist))
+	artists.Delete("/:id/star", annotationHandler.Unstar(entity.ItemTypeArtist))
+	artists.Put("/:id/rating", annotationHandler.SetRating(entity.ItemTypeArtist))
+
+	// 当前用户的收藏
+	protected.Get("/starred", annotationHandler.ListStarred)
+
+	// 所有用户正在播放的歌曲
+	protected.Get("/now-playing", musicHandler.ListNowPlaying)
 
 	// 播放列表相关
 	playlists := protected.Group("/playlists")
@@ -82,5 +135,5 @@ func SetupRoutes(app *fiber.App, db *gorm.DB) {
 	playlists.Post("/favorite", playlistHandler.AddToFavoritePlaylist)
 
 	// Register subsonic endpoints
-	RegisterSubsonic(app, db)
+	RegisterSubsonic(app, db, scans)
 }
@@ -1,26 +1,71 @@
 package router
 
 import (
+	"saboriman-music/internal/scanner"
 	"saboriman-music/internal/subsonic"
 
 	"github.com/gofiber/fiber/v2"
 	"gorm.io/gorm"
 )
 
-func RegisterSubsonic(app *fiber.App, db *gorm.DB) {
-	subsonic := subsonic.NewMusicHandler(db)
+func RegisterSubsonic(app *fiber.App, db *gorm.DB, scans *scanner.Manager) {
+	// 所有 Subsonic 接口都需要认证，用户写入 Locals 供各处理器使用
+	rest := app.Group("/rest", subsonic.AuthMiddleware(db))
 
-	rest := app.Group("/rest")
+	subsonic := subsonic.NewMusicHandler(db, scans)
 
-	rest.Get("/ping.view", subsonic.HandlePing)
-	rest.Get("/getLicense.view", subsonic.HandleGetLicense)
+	// 客户端可以用 GET 查询参数或 POST 表单（OpenSubsonic formPost）调用所有接口
+	handle := func(path string, handler fiber.Handler) {
+		rest.Get(path, handler)
+		rest.Post(path, handler)
+	}
+
+	handle("/ping.view", subsonic.HandlePing)
+	handle("/getLicense.view", subsonic.HandleGetLicense)
 
 	// Browsing
-	rest.Get("/getArtists.view", subsonic.HandleGetArtists)
-	rest.Get("/getAlbum.view", subsonic.HandleGetAlbum)
-	rest.Get("/getRandomSongs.view", subsonic.HandleGetRandomSongs)
+	handle("/getMusicFolders.view", subsonic.HandleGetMusicFolders)
+	handle("/getIndexes.view", subsonic.HandleGetIndexes)
+	handle("/getMusicDirectory.view", subsonic.HandleGetMusicDirectory)
+	handle("/getArtists.view", subsonic.HandleGetArtists)
+	handle("/getArtist.view", subsonic.HandleGetArtist)
+	handle("/getAlbum.view", subsonic.HandleGetAlbum)
+	handle("/getSong.view", subsonic.HandleGetSong)
+	handle("/getGenres.view", subsonic.HandleGetGenres)
+
+	// Album/song lists
+	handle("/getAlbumList2.view", subsonic.HandleGetAlbumList2)
+	handle("/getRandomSongs.view", subsonic.HandleGetRandomSongs)
+	handle("/getSongsByGenre.view", subsonic.HandleGetSongsByGenre)
+	handle("/getStarred.view", subsonic.HandleGetStarred)
+	handle("/getStarred2.view", subsonic.HandleGetStarred2)
+	handle("/getNowPlaying.view", subsonic.HandleGetNowPlaying)
+
+	// Playlists
+	handle("/getPlaylists.view", subsonic.HandleGetPlaylists)
+	handle("/getPlaylist.view", subsonic.HandleGetPlaylist)
+	handle("/createPlaylist.view", subsonic.HandleCreatePlaylist)
+	handle("/updatePlaylist.view", subsonic.HandleUpdatePlaylist)
+	handle("/deletePlaylist.view", subsonic.HandleDeletePlaylist)
+
+	// Searching
+	handle("/search2.view", subsonic.HandleSearch2)
+	handle("/search3.view", subsonic.HandleSearch3)
+
+	// Media annotation
+	handle("/star.view", subsonic.HandleStar)
+	handle("/unstar.view", subsonic.HandleUnstar)
+	handle("/setRating.view", subsonic.HandleSetRating)
+	handle("/scrobble.view", subsonic.HandleScrobble)
 
 	// Media
-	rest.Get("/getCoverArt.view", subsonic.HandleGetCoverArt)
-	rest.Get("/stream.view", subsonic.HandleStream)
+	handle("/getCoverArt.view", subsonic.HandleGetCoverArt)
+	handle("/stream.view", subsonic.HandleStream)
+	handle("/download.view", subsonic.HandleDownload)
+	handle("/hls.m3u8", subsonic.HandleHLS)
+	handle("/hlsSegment.ts", subsonic.HandleHLSSegment)
+
+	// Media library scanning
+	handle("/getScanStatus.view", subsonic.HandleGetScanStatus)
+	handle("/startScan.view", subsonic.HandleStartScan)
 }
@@ -0,0 +1,432 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"saboriman-music/internal/artist"
+
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+// 整轨文件配合 CUE 的抓轨：CUE 中同一个 FILE 下有多条 TRACK 时，按音轨拆分为多首歌曲，
+// 每首歌记录在文件中的起止位置，播放时只输出这一段
+
+// cueSheet 解析后的 CUE 文件
+type cueSheet struct {
+	title      string // 专辑名
+	performer  string // 专辑艺术家
+	songwriter string
+	genre      string
+	date       string
+	albumGain  string // REM REPLAYGAIN_ALBUM_GAIN
+	albumPeak  string
+	files      []cueFile
+}
+
+// cueFile CUE 中的一个 FILE 及其音轨
+type cueFile struct {
+	name   string
+	tracks []cueTrack
+}
+
+func (f *cueFile) has(number int) bool {
+	for _, t := range f.tracks {
+		if t.number == number {
+			return true
+		}
+	}
+	return false
+}
+
+// cueTrack CUE 中的一条音轨
+type cueTrack struct {
+	number     int
+	title      string
+	performer  string
+	songwriter string
+	isrc       string
+	gain       string        // REM REPLAYGAIN_TRACK_GAIN
+	peak       string        // REM REPLAYGAIN_TRACK_PEAK
+	start      time.Duration // INDEX 01
+	pregap     time.Duration // INDEX 00，没有时等于 start
+}
+
+// cueAudio 一个音频文件对应的 CUE 音轨
+type cueAudio struct {
+	path   string      // CUE 文件路径
+	info   fs.FileInfo // CUE 文件信息，用于判断 CUE 是否修改
+	sheet  *cueSheet
+	tracks []cueTrack
+}
+
+// end 返回第 i 条音轨的结束位置：下一条音轨的 INDEX 00（没有时为 INDEX 01），最后一条为 0 表示到文件末尾
+func (a *cueAudio) end(i int) time.Duration {
+	if i+1 < len(a.tracks) {
+		return a.tracks[i+1].pregap
+	}
+	return 0
+}
+
+// isCueFile 检查是否为 CUE 文件
+func isCueFile(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".cue")
+}
+
+// loadCues 解析目录下的所有 CUE 文件，返回音频文件路径到音轨的映射；
+// 只处理同一个 FILE 下有多条音轨的整轨 CUE，一轨一文件的 CUE 直接扫描各个文件即可
+func loadCues(dir string) (map[string]*cueAudio, []string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, nil
+	}
+	var audios map[string]*cueAudio
+	var errs []string
+	for _, entry := range entries {
+		if entry.IsDir() || !isCueFile(entry.Name()) {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		info, err := entry.Info()
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("读取文件信息失败 %s: %v", path, err))
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("读取 CUE 失败 %s: %v", path, err))
+			continue
+		}
+		sheet := parseCue(data)
+		for _, f := range sheet.files {
+			if len(f.tracks) < 2 {
+				continue
+			}
+			audio := resolveCueFile(dir, f.name, entries)
+			if audio == "" {
+				errs = append(errs, fmt.Sprintf("CUE 引用的文件不存在 %s: %s", path, f.name))
+				continue
+			}
+			if audios == nil {
+				audios = make(map[string]*cueAudio)
+			}
+			audios[audio] = &cueAudio{path: path, info: info, sheet: sheet, tracks: f.tracks}
+		}
+	}
+	return audios, errs
+}
+
+// resolveCueFile 查找 CUE 中 FILE 引用的音频文件：依次尝试原文件名、忽略大小写的文件名，
+// 以及同名但扩展名不同的音频文件（如 CUE 写的是 .wav，文件已转换为 .flac）。
+// 相对路径可以指向 CUE 所在目录的子目录；绝对路径或指向目录外的路径多为抓轨时的路径，只按文件名在 CUE 所在目录查找
+func resolveCueFile(dir, name string, entries []fs.DirEntry) string {
+	name = filepath.FromSlash(strings.ReplaceAll(name, "\\", "/"))
+	if sub := filepath.Dir(name); sub != "." {
+		if !filepath.IsLocal(name) || len(name) > 1 && name[1] == ':' {
+			name = filepath.Base(name)
+		} else {
+			dir = filepath.Join(dir, sub)
+			name = filepath.Base(name)
+			var err error
+			if entries, err = os.ReadDir(dir); err != nil {
+				return ""
+			}
+		}
+	}
+	stem := strings.TrimSuffix(name, filepath.Ext(name))
+	var sameStem string
+	for _, entry := range entries {
+		if entry.IsDir() || !isSupportedFileType(entry.Name()) {
+			continue
+		}
+		if entry.Name() == name {
+			return filepath.Join(dir, entry.Name())
+		}
+		if strings.EqualFold(entry.Name(), name) {
+			sameStem = entry.Name()
+		} else if sameStem == "" && strings.EqualFold(strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name())), stem) {
+			sameStem = entry.Name()
+		}
+	}
+	if sameStem == "" {
+		return ""
+	}
+	return filepath.Join(dir, sameStem)
+}
+
+// parseCue 解析 CUE 文本，兼容 UTF-8（可带 BOM）与 GBK 编码
+func parseCue(data []byte) *cueSheet {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	if !utf8.Valid(data) {
+		if decoded, err := simplifiedchinese.GBK.NewDecoder().Bytes(data); err == nil {
+			data = decoded
+		}
+	}
+
+	sheet := &cueSheet{}
+	var current *cueFile
+	var track *cueTrack
+	hasStart, hasPregap := false, false
+	// closeTrack 结束当前音轨，没有 INDEX 01 或音轨号重复的音轨直接丢弃
+	closeTrack := func() {
+		if track != nil && current != nil && hasStart && !current.has(track.number) {
+			if !hasPregap {
+				track.pregap = track.start
+			}
+			current.tracks = append(current.tracks, *track)
+		}
+		track, hasStart, hasPregap = nil, false, false
+	}
+
+	lines := bufio.NewScanner(bytes.NewReader(data))
+	for lines.Scan() {
+		command, args := cueFields(lines.Text())
+		switch command {
+		case "FILE":
+			closeTrack()
+			if len(args) > 0 {
+				sheet.files = append(sheet.files, cueFile{name: args[0]})
+				current = &sheet.files[len(sheet.files)-1]
+			}
+		case "TRACK":
+			closeTrack()
+			if len(args) > 0 && current != nil {
+				n, err := strconv.Atoi(args[0])
+				if err != nil || n <= 0 {
+					n = len(current.tracks) + 1
+				}
+				track = &cueTrack{number: n}
+			}
+		case "TITLE", "PERFORMER", "SONGWRITER":
+			if len(args) == 0 {
+				continue
+			}
+			value := strings.Join(args, " ")
+			if track != nil {
+				setCueField(command, value, &track.title, &track.performer, &track.songwriter)
+			} else {
+				setCueField(command, value, &sheet.title, &sheet.performer, &sheet.songwriter)
+			}
+		case "ISRC":
+			if track != nil && len(args) > 0 {
+				track.isrc = args[0]
+			}
+		case "INDEX":
+			if track == nil || len(args) < 2 {
+				continue
+			}
+			at, ok := cueTime(args[1])
+			if !ok {
+				continue
+			}
+			switch args[0] {
+			case "00":
+				track.pregap, hasPregap = at, true
+			case "01":
+				track.start, hasStart = at, true
+			}
+		case "REM":
+			if len(args) < 2 {
+				continue
+			}
+			switch strings.ToUpper(args[0]) {
+			case "GENRE":
+				sheet.genre = strings.Join(args[1:], " ")
+			case "DATE":
+				sheet.date = args[1]
+			case "REPLAYGAIN_ALBUM_GAIN":
+				sheet.albumGain = args[1]
+			case "REPLAYGAIN_ALBUM_PEAK":
+				sheet.albumPeak = args[1]
+			case "REPLAYGAIN_TRACK_GAIN":
+				if track != nil {
+					track.gain = args[1]
+				}
+			case "REPLAYGAIN_TRACK_PEAK":
+				if track != nil {
+					track.peak = args[1]
+				}
+			}
+		}
+	}
+	closeTrack()
+	return sheet
+}
+
+func setCueField(command, value string, title, performer, songwriter *string) {
+	switch command {
+	case "TITLE":
+		*title = value
+	case "PERFORMER":
+		*performer = value
+	case "SONGWRITER":
+		*songwriter = value
+	}
+}
+
+// cueFields 拆分一行 CUE 命令，双引号中的内容作为一个参数
+func cueFields(line string) (string, []string) {
+	var fields []string
+	line = strings.TrimSpace(line)
+	for line != "" {
+		var field string
+		if line[0] == '"' {
+			end := strings.IndexByte(line[1:], '"')
+			if end < 0 {
+				field, line = line[1:], ""
+			} else {
+				field, line = line[1:end+1], line[end+2:]
+			}
+		} else if end := strings.IndexAny(line, " \t"); end < 0 {
+			field, line = line, ""
+		} else {
+			field, line = line[:end], line[end:]
+		}
+		fields = append(fields, normalizeString(field))
+		line = strings.TrimSpace(line)
+	}
+	if len(fields) == 0 {
+		return "", nil
+	}
+	return strings.ToUpper(fields[0]), fields[1:]
+}
+
+// cueTime 解析 mm:ss:ff 格式的时间，每秒 75 帧
+func cueTime(s string) (time.Duration, bool) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return 0, false
+	}
+	var n [3]int
+	for i, p := range parts {
+		v, err := strconv.Atoi(p)
+		if err != nil || v < 0 {
+			return 0, false
+		}
+		n[i] = v
+	}
+	return time.Duration(n[0]*60+n[1])*time.Second + time.Duration(n[2])*time.Second/75, true
+}
+
+// readCueTracks 读取整轨文件一次，再按 CUE 拆分为各条音轨；CUE 中没有的信息沿用文件标签
+func readCueTracks(ctx context.Context, f file, covers *coverCache) []*track {
+	whole := readTrack(ctx, f, covers)
+	if whole.music == nil {
+		return []*track{whole}
+	}
+	cue, sheet := f.cue, f.cue.sheet
+
+	albumName, albumArtist := whole.albumName, whole.albumArtist
+	albumTagged := whole.albumTagged
+	if sheet.title != "" {
+		albumName = sheet.title
+	}
+	if sheet.performer != "" {
+		albumArtist, albumTagged = sheet.performer, true
+	}
+	year := whole.year
+	if y, err := strconv.Atoi(strings.SplitN(sheet.date, "-", 2)[0]); err == nil && y > 0 {
+		year = y
+	}
+
+	tracks := make([]*track, 0, len(f.tracks))
+	for i, sub := range f.tracks {
+		ct := cue.tracks[i]
+		music := *whole.music
+		music.FileUrl = sub.path
+		music.CueFile = cue.path
+		music.CueStart = int(ct.start.Milliseconds())
+		music.CueEnd = int(cue.end(i).Milliseconds())
+		music.TrackNumber = ct.number
+		music.DiscNumber = 0
+		music.Title = firstNonEmpty(ct.title, fmt.Sprintf("音轨 %02d", ct.number))
+		music.Artist = firstNonEmpty(ct.performer, sheet.performer, whole.music.Artist)
+		// 与 readTrack 一致：没有专辑艺术家时以歌曲艺术家为准，合辑使用 "Various Artists"
+		trackAlbumArtist := albumArtist
+		if !albumTagged {
+			trackAlbumArtist = music.Artist
+			if whole.compilation {
+				trackAlbumArtist = artist.VariousArtists()
+			}
+		}
+		music.AlbumArtist = trackAlbumArtist
+		music.Composer = firstNonEmpty(ct.songwriter, sheet.songwriter, whole.music.Composer)
+		music.Genre = firstNonEmpty(sheet.genre, whole.music.Genre)
+		music.Year = year
+		music.ISRC = firstNonEmpty(ct.isrc, whole.music.ISRC)
+		if sheet.date != "" {
+			music.ReleaseDate = sheet.date
+		}
+		// 整个文件的音轨增益即专辑增益；各音轨的增益来自 CUE，没有时由响度测量补充
+		music.TrackGain, music.TrackPeak, music.Loudness = parseGain(ct.gain), parsePeak(ct.peak), nil
+		if gain := parseGain(sheet.albumGain); gain != nil {
+			music.AlbumGain, music.AlbumPeak = gain, parsePeak(sheet.albumPeak)
+		} else if music.AlbumGain == nil {
+			music.AlbumGain, music.AlbumPeak = whole.music.TrackGain, whole.music.TrackPeak
+		}
+		if whole.music.Fingerprint != "" {
+			music.Fingerprint = fmt.Sprintf("%s#%02d", whole.music.Fingerprint, ct.number)
+		}
+		// 时长按起止位置计算，最后一条音轨到文件末尾
+		end := cue.end(i)
+		if end == 0 {
+			end = time.Duration(whole.music.Duration) * time.Second
+		}
+		music.Duration = int((end - ct.start).Seconds())
+		if music.Duration < 0 {
+			music.Duration = 0
+		}
+
+		tracks = append(tracks, &track{
+			file:        sub,
+			music:       &music,
+			albumName:   albumName,
+			albumArtist: trackAlbumArtist,
+			albumTagged: albumTagged,
+			compilation: whole.compilation,
+			albumGenre:  music.Genre,
+			year:        year,
+			credits:     artist.MusicCredits(&music, nil),
+		})
+	}
+	// 读取整个文件时的错误只记录一次
+	if len(tracks) > 0 {
+		tracks[0].errs = whole.errs
+	}
+	return tracks
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v = normalizeString(v); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// cueFileInfo 以音频文件与 CUE 文件中较晚的修改时间作为修改时间，任一文件修改后都会重新读取
+type cueFileInfo struct {
+	fs.FileInfo
+	modTime time.Time
+}
+
+func (i cueFileInfo) ModTime() time.Time {
+	return i.modTime
+}
+
+func newCueFileInfo(audio, cue fs.FileInfo) fs.FileInfo {
+	if cue.ModTime().After(audio.ModTime()) {
+		return cueFileInfo{FileInfo: audio, modTime: cue.ModTime()}
+	}
+	return audio
+}
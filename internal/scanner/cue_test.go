@@ -0,0 +1,190 @@
+package scanner
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"saboriman-music/internal/entity"
+
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+const testCue = `REM GENRE Rock
+REM DATE 2001-05-01
+PERFORMER "Band"
+TITLE "Album"
+FILE "CD1\Album.wav" WAVE
+  TRACK 01 AUDIO
+    TITLE "One"
+    INDEX 01 00:00:00
+  TRACK 02 AUDIO
+    TITLE "Two"
+    PERFORMER "Guest"
+    INDEX 00 00:58:00
+    INDEX 01 01:00:00
+  TRACK 02 AUDIO
+    TITLE "Duplicate"
+    INDEX 01 01:10:00
+  TRACK 03 AUDIO
+    TITLE "No start"
+    INDEX 00 01:20:00
+  TRACK 04 AUDIO
+    TITLE "Four"
+    REM REPLAYGAIN_TRACK_GAIN -3.50 dB
+    INDEX 01 01:30:37
+`
+
+func TestParseCue(t *testing.T) {
+	gbk, err := simplifiedchinese.GBK.NewEncoder().Bytes([]byte("TITLE \"专辑\"\nFILE \"整轨.flac\" WAVE\n  TRACK 01 AUDIO\n    TITLE \"第一首\"\n    INDEX 01 00:00:00\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		data []byte
+		want func(t *testing.T, s *cueSheet)
+	}{
+		{"tracks", []byte(testCue), func(t *testing.T, s *cueSheet) {
+			if s.title != "Album" || s.performer != "Band" || s.genre != "Rock" || s.date != "2001-05-01" {
+				t.Fatalf("unexpected album fields %+v", s)
+			}
+			if len(s.files) != 1 || s.files[0].name != `CD1\Album.wav` {
+				t.Fatalf("unexpected files %+v", s.files)
+			}
+			// 重复的音轨号与没有 INDEX 01 的音轨被丢弃
+			tracks := s.files[0].tracks
+			if len(tracks) != 3 || tracks[0].title != "One" || tracks[1].title != "Two" || tracks[2].title != "Four" {
+				t.Fatalf("unexpected tracks %+v", tracks)
+			}
+			if tracks[1].performer != "Guest" || tracks[1].pregap != 58*time.Second || tracks[1].start != time.Minute {
+				t.Fatalf("unexpected second track %+v", tracks[1])
+			}
+			// 没有 INDEX 00 时 pregap 等于 start
+			if tracks[2].pregap != tracks[2].start || tracks[2].gain != "-3.50" {
+				t.Fatalf("unexpected last track %+v", tracks[2])
+			}
+		}},
+		{"utf8 bom", append([]byte("\xef\xbb\xbf"), `TITLE "Album"`...), func(t *testing.T, s *cueSheet) {
+			if s.title != "Album" {
+				t.Fatalf("unexpected title %q", s.title)
+			}
+		}},
+		{"gbk", gbk, func(t *testing.T, s *cueSheet) {
+			if s.title != "专辑" || len(s.files) != 1 || s.files[0].name != "整轨.flac" || s.files[0].tracks[0].title != "第一首" {
+				t.Fatalf("unexpected sheet %+v", s)
+			}
+		}},
+		{"track before file", []byte("TRACK 01 AUDIO\nINDEX 01 00:00:00\n"), func(t *testing.T, s *cueSheet) {
+			if len(s.files) != 0 {
+				t.Fatalf("expected no files, got %+v", s.files)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.want(t, parseCue(tt.data))
+		})
+	}
+}
+
+func TestCueTime(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Duration
+		ok   bool
+	}{
+		{"00:00:00", 0, true},
+		{"01:02:00", 62 * time.Second, true},
+		{"00:01:75", 2 * time.Second, true},
+		{"00:00:15", 200 * time.Millisecond, true},
+		{"90:00:00", 90 * time.Minute, true},
+		{"01:02", 0, false},
+		{"aa:00:00", 0, false},
+		{"00:-1:00", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := cueTime(tt.in)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("cueTime(%q) = %v, %v; want %v, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestResolveCueFile(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"Album.flac", "Other.MP3", "cover.jpg", "CD1/Disc.flac", "CD2/disc.wav.flac"} {
+		writeFile(t, filepath.Join(dir, name), []byte("x"))
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Album.flac", "Album.flac"},
+		{"album.FLAC", "Album.flac"},
+		{"Album.wav", "Album.flac"},
+		{"other.mp3", "Other.MP3"},
+		{"cover.wav", ""},
+		{"missing.flac", ""},
+		{"CD1/Disc.flac", "CD1/Disc.flac"},
+		{`CD1\Disc.wav`, "CD1/Disc.flac"},
+		{"CD3/Disc.flac", ""},
+		// 抓轨时的绝对路径与目录外的路径只按文件名查找
+		{`C:\Rips\Album.wav`, "Album.flac"},
+		{"/home/rips/Album.flac", "Album.flac"},
+		{"../Album.flac", "Album.flac"},
+	}
+	for _, tt := range tests {
+		want := tt.want
+		if want != "" {
+			want = filepath.Join(dir, filepath.FromSlash(want))
+		}
+		if got := resolveCueFile(dir, tt.name, entries); got != want {
+			t.Errorf("resolveCueFile(%q) = %q, want %q", tt.name, got, want)
+		}
+	}
+}
+
+func TestScan_CueTracks(t *testing.T) {
+	db := openDB(t)
+	lib := newLibrary(t, db)
+	// CUE 在专辑目录，整轨文件在子目录中；假的 ffprobe 报告文件时长为 120.5 秒
+	audio := filepath.Join(lib.Path, "Album", "CD1", "Album.mp3")
+	writeFile(t, audio, id3("whole", "TIT2", "Whole", "TPE1", "Band"))
+	writeFile(t, filepath.Join(lib.Path, "Album", "Album.cue"), []byte(testCue))
+
+	r := scan(t, db, lib, Options{})
+	if len(r.Errors) != 0 {
+		t.Fatalf("unexpected errors %v", r.Errors)
+	}
+	var musics []entity.Music
+	db.Order("track_number").Find(&musics)
+	if len(musics) != 3 {
+		t.Fatalf("expected 3 tracks, got %d", len(musics))
+	}
+	tests := []struct {
+		title               string
+		start, end, seconds int
+	}{
+		// 下一条音轨有 INDEX 00 时在 INDEX 00 结束
+		{"One", 0, 58000, 58},
+		{"Two", 60000, 90493, 30},
+		// 最后一条音轨到文件末尾
+		{"Four", 90493, 0, 29},
+	}
+	for i, tt := range tests {
+		m := musics[i]
+		if m.Title != tt.title || m.CueStart != tt.start || m.CueEnd != tt.end || m.Duration != tt.seconds {
+			t.Errorf("track %d: got %q %d-%d %ds, want %q %d-%d %ds",
+				i, m.Title, m.CueStart, m.CueEnd, m.Duration, tt.title, tt.start, tt.end, tt.seconds)
+		}
+		if m.FilePath() != audio || m.Genre != "Rock" || m.Year != 2001 {
+			t.Errorf("track %d: unexpected file or album fields %q %q %d", i, m.FilePath(), m.Genre, m.Year)
+		}
+	}
+}
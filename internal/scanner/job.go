@@ -0,0 +1,245 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"saboriman-music/internal/entity"
+
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrScanRunning 已有扫描正在进行
+	ErrScanRunning = errors.New("scan already running")
+	// ErrNoLibrary 没有需要扫描的音乐库
+	ErrNoLibrary = errors.New("no library to scan")
+)
+
+// Manager 扫描任务管理：同一时间只运行一个扫描，记录实时进度并保存历史
+type Manager struct {
+	mu     sync.Mutex
+	job    *entity.ScanJob // 当前或最近一次扫描
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	watcher *Watcher // 正在运行的目录监听，新增音乐库时加入监听
+
+	analyzing bool // 后台响度测量正在进行
+}
+
+// NewManager 创建扫描任务管理器
+func NewManager() *Manager {
+	return &Manager{}
+}
+
+// Default 全局扫描任务管理器
+var Default = NewManager()
+
+// progressSaveInterval 扫描期间保存进度到数据库的间隔，服务中途退出时保留已完成的计数
+var progressSaveInterval = 5 * time.Second
+
+// RecoverJobs 把上次服务退出时仍处于运行中的扫描记录标记为失败，在服务启动、开始扫描前调用
+func RecoverJobs(db *gorm.DB) error {
+	return db.Model(&entity.ScanJob{}).Where("status = ?", entity.ScanRunning).Updates(map[string]interface{}{
+		"status":      entity.ScanFailed,
+		"finished_at": time.Now(),
+		"message":     "服务在扫描过程中停止",
+	}).Error
+}
+
+// Start 在后台依次扫描各个音乐库，已有扫描进行时返回 ErrScanRunning。
+// opts.Paths 不为空时只扫描包含这些目录的音乐库
+func (m *Manager) Start(db *gorm.DB, libs []entity.Library, opts Options, trigger string) (entity.ScanJob, error) {
+	if len(opts.Paths) > 0 {
+		var targets []entity.Library
+		for _, lib := range libs {
+			if len(scanRoots(lib.Path, opts.Paths)) > 0 {
+				targets = append(targets, lib)
+			}
+		}
+		libs = targets
+	}
+	if len(libs) == 0 {
+		return entity.ScanJob{}, ErrNoLibrary
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.cancel != nil {
+		return *m.job, ErrScanRunning
+	}
+
+	job := &entity.ScanJob{
+		Status:    entity.ScanRunning,
+		Trigger:   trigger,
+		FullScan:  opts.FullScan,
+		Paths:     opts.Paths,
+		StartedAt: time.Now(),
+	}
+	for _, lib := range libs {
+		job.LibraryIDs = append(job.LibraryIDs, lib.ID)
+	}
+	if err := db.Create(job).Error; err != nil {
+		return entity.ScanJob{}, fmt.Errorf("创建扫描记录失败: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m.job, m.cancel, m.done = job, cancel, make(chan struct{})
+	go m.run(ctx, db, libs, opts, job.ID, m.done)
+	return *job, nil
+}
+
+// run 执行扫描并保存最终结果。各音乐库独立扫描，一个音乐库失败不影响其他音乐库
+func (m *Manager) run(ctx context.Context, db *gorm.DB, libs []entity.Library, opts Options, jobID string, done chan struct{}) {
+	defer close(done)
+	stopSaving := m.saveProgress(db, jobID)
+	total := &Result{}
+	var cancelled bool
+	var failures []string
+	for _, lib := range libs {
+		// 进度为已完成音乐库的结果加上当前音乐库的进度
+		finished := *total
+		libOpts := opts
+		libOpts.Progress = func(r Result) {
+			current := finished
+			current.add(&r)
+			m.mu.Lock()
+			applyResult(m.job, &current)
+			m.mu.Unlock()
+		}
+
+		log.Printf("开始扫描音乐库 %s: %s 完整扫描: %v", lib.Name, lib.Path, opts.FullScan)
+		result, err := Scan(ctx, db, lib, libOpts)
+		result.Log()
+		total.add(result)
+		if errors.Is(err, context.Canceled) {
+			cancelled = true
+			break
+		}
+		if err != nil {
+			log.Printf("扫描音乐库 %s 失败: %v", lib.Name, err)
+			failures = append(failures, fmt.Sprintf("%s: %v", lib.Name, err))
+		}
+	}
+
+	stopSaving()
+	m.mu.Lock()
+	job := m.job
+	applyResult(job, total)
+	finishedAt := time.Now()
+	job.FinishedAt = &finishedAt
+	switch {
+	case cancelled:
+		job.Status = entity.ScanCancelled
+	case len(failures) > 0:
+		job.Status = entity.ScanFailed
+		job.Message = strings.Join(failures, "; ")
+	default:
+		job.Status = entity.ScanCompleted
+	}
+	final := *job
+	m.mu.Unlock()
+
+	if err := db.Model(&entity.ScanJob{}).Where("id = ?", jobID).Select("*").Omit("id").Updates(&final).Error; err != nil {
+		log.Printf("保存扫描记录失败: %v", err)
+	}
+
+	// 记录保存后才允许开始下一次扫描
+	m.mu.Lock()
+	m.cancel()
+	m.cancel = nil
+	m.mu.Unlock()
+
+	if analyzeLoudness() {
+		m.AnalyzeLoudness(db)
+	}
+}
+
+// saveProgress 在后台定期把当前进度写入扫描记录，返回的函数停止保存并等待正在进行的写入结束
+func (m *Manager) saveProgress(db *gorm.DB, jobID string) func() {
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(progressSaveInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			m.mu.Lock()
+			progress := *m.job
+			m.mu.Unlock()
+			if err := db.Model(&entity.ScanJob{}).Where("id = ?", jobID).
+				Select("scanned_files", "added", "updated", "unchanged", "moved", "removed", "error_count").
+				Updates(&progress).Error; err != nil {
+				log.Printf("保存扫描进度失败: %v", err)
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-stopped
+	}
+}
+
+// applyResult 将扫描结果写入任务记录
+func applyResult(job *entity.ScanJob, r *Result) {
+	job.ScannedFiles = r.ScannedFiles
+	job.Added = r.Added
+	job.Updated = r.Updated
+	job.Unchanged = r.Unchanged
+	job.Moved = r.Moved
+	job.Removed = r.Removed
+	job.ErrorCount = len(r.Errors)
+	job.Errors = r.Errors
+}
+
+// Status 返回当前或最近一次扫描，从未扫描过时 ok 为 false
+func (m *Manager) Status() (job entity.ScanJob, running bool, ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.job == nil {
+		return entity.ScanJob{}, false, false
+	}
+	job = *m.job
+	// 返回副本，避免调用方读取时扫描协程继续追加
+	job.Errors = append([]string(nil), m.job.Errors...)
+	return job, m.cancel != nil, true
+}
+
+// Cancel 取消正在进行的扫描并等待其结束，没有扫描进行时返回 false
+func (m *Manager) Cancel() bool {
+	m.mu.Lock()
+	cancel, done := m.cancel, m.done
+	m.mu.Unlock()
+	if cancel == nil {
+		return false
+	}
+	cancel()
+	<-done
+	return true
+}
+
+// History 按开始时间倒序返回最近的扫描记录
+func History(db *gorm.DB, limit int) ([]entity.ScanJob, error) {
+	var jobs []entity.ScanJob
+	err := db.Order("started_at DESC").Limit(limit).Find(&jobs).Error
+	return jobs, err
+}
+
+// LastJob 返回数据库中最近一次扫描记录，用于服务重启后展示
+func LastJob(db *gorm.DB) (*entity.ScanJob, error) {
+	var job entity.ScanJob
+	if err := db.Order("started_at DESC").First(&job).Error; err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
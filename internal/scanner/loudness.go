@@ -0,0 +1,221 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"saboriman-music/config"
+	"saboriman-music/internal/entity"
+	"saboriman-music/internal/transcode"
+	"saboriman-music/internal/utils"
+
+	"gorm.io/gorm"
+)
+
+// 响度分析默认配置
+const (
+	loudnessBatch   = 50
+	loudnessTimeout = 5 * time.Minute
+)
+
+// replayGainTags 读取 ReplayGain 标签，Opus 的 R128 标签换算为 ReplayGain 增益
+func replayGainTags(raw map[string]interface{}, music *entity.Music) {
+	music.TrackGain = gainTag(raw, "replaygain_track_gain", "r128_track_gain")
+	music.TrackPeak = peakTag(raw, "replaygain_track_peak")
+	music.AlbumGain = gainTag(raw, "replaygain_album_gain", "r128_album_gain")
+	music.AlbumPeak = peakTag(raw, "replaygain_album_peak")
+}
+
+// gainTag 读取增益标签；没有时读取 R128 标签，
+// 其值为相对 -23 LUFS 的 Q7.8 定点数，换算到 ReplayGain 的 -18 LUFS 参考响度需加 5 dB
+func gainTag(raw map[string]interface{}, key, r128Key string) *float64 {
+	if values := rawValues(raw, key); len(values) > 0 {
+		return parseGain(values[0])
+	}
+	if values := rawValues(raw, r128Key); len(values) > 0 {
+		if q, err := strconv.Atoi(values[0]); err == nil {
+			gain := float64(q)/256 + 5
+			return &gain
+		}
+	}
+	return nil
+}
+
+// peakTag 读取峰值标签
+func peakTag(raw map[string]interface{}, key string) *float64 {
+	if values := rawValues(raw, key); len(values) > 0 {
+		return parsePeak(values[0])
+	}
+	return nil
+}
+
+// parseGain 解析 "-6.50 dB" 形式的增益，无法解析时返回 nil
+func parseGain(value string) *float64 {
+	value = strings.TrimSpace(strings.TrimSuffix(strings.ToLower(strings.TrimSpace(value)), "db"))
+	if gain, err := strconv.ParseFloat(value, 64); err == nil {
+		return &gain
+	}
+	return nil
+}
+
+// parsePeak 解析线性峰值，无法解析时返回 nil
+func parsePeak(value string) *float64 {
+	if peak, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil && peak >= 0 {
+		return &peak
+	}
+	return nil
+}
+
+// keepLoudness 音频内容未变化时沿用之前测得的响度与增益，避免重新测量
+func keepLoudness(music *entity.Music, prev entity.Music) {
+	if prev.Loudness == nil || music.TrackGain != nil || music.Fingerprint == "" || music.Fingerprint != prev.Fingerprint {
+		return
+	}
+	music.Loudness, music.TrackGain, music.TrackPeak = prev.Loudness, prev.TrackGain, prev.TrackPeak
+	if music.AlbumGain == nil && music.AlbumID == prev.AlbumID {
+		music.AlbumGain, music.AlbumPeak = prev.AlbumGain, prev.AlbumPeak
+	}
+}
+
+// analyzeLoudness 是否在扫描后测量响度
+func analyzeLoudness() bool {
+	return config.AppConfig != nil && config.AppConfig.Scanner.AnalyzeLoudness
+}
+
+// AnalyzeLoudness 在后台测量没有 ReplayGain 标签的歌曲的响度，已在测量时返回 false。
+// 扫描开始后测量在当前歌曲完成后停止，扫描结束时重新启动
+func (m *Manager) AnalyzeLoudness(db *gorm.DB) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.analyzing || m.cancel != nil {
+		return false
+	}
+	m.analyzing = true
+	go m.analyze(db)
+	return true
+}
+
+// scanning 是否有扫描正在进行
+func (m *Manager) scanning() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.cancel != nil
+}
+
+// analyze 按专辑顺序分批测量，每批结束后计算已全部测量的专辑的增益。
+// 测量失败的歌曲记录在 loudness_err 中，文件变化或完整扫描重新读取后才再次测量；
+// 找不到 ffmpeg 时停止测量且不做记录，安装后下次扫描结束时继续
+func (m *Manager) analyze(db *gorm.DB) {
+	defer func() {
+		m.mu.Lock()
+		m.analyzing = false
+		m.mu.Unlock()
+	}()
+
+	measured, failed := 0, 0
+	for {
+		var musics []entity.Music
+		q := db.Where("track_gain IS NULL AND loudness IS NULL AND loudness_err = ?", false)
+		if err := q.Order("album_id").Order("id").Limit(loudnessBatch).Find(&musics).Error; err != nil {
+			log.Printf("查询待测量响度的歌曲失败: %v", err)
+			return
+		}
+		if len(musics) == 0 {
+			break
+		}
+
+		albums := make(map[string]bool)
+		stopped := false
+		for i := range musics {
+			if m.scanning() {
+				stopped = true
+				break
+			}
+			music := &musics[i]
+			if err := measureLoudness(db, music); errors.Is(err, exec.ErrNotFound) || errors.Is(err, fs.ErrNotExist) {
+				// 无法启动 ffmpeg，不是文件的问题
+				log.Printf("无法测量响度: %v", err)
+				stopped = true
+				break
+			} else if err != nil {
+				log.Printf("测量响度失败 %s: %v", music.FileUrl, err)
+				failed++
+				if err := db.Model(&entity.Music{}).Where("id = ?", music.ID).Update("loudness_err", true).Error; err != nil {
+					log.Printf("记录响度测量失败 %s: %v", music.FileUrl, err)
+					stopped = true
+					break
+				}
+				continue
+			}
+			measured++
+			if music.AlbumID != "" {
+				albums[music.AlbumID] = true
+			}
+		}
+		for id := range albums {
+			if err := albumLoudness(db, id); err != nil {
+				log.Printf("计算专辑增益失败 %s: %v", id, err)
+			}
+		}
+		if stopped {
+			return
+		}
+	}
+	if measured > 0 || failed > 0 {
+		log.Printf("响度测量完成: 成功 %d, 失败 %d", measured, failed)
+	}
+}
+
+// measureLoudness 测量一首歌的响度并写入音轨增益与峰值
+func measureLoudness(db *gorm.DB, music *entity.Music) error {
+	ctx, cancel := context.WithTimeout(context.Background(), loudnessTimeout)
+	defer cancel()
+	l, err := transcode.MeasureLoudness(ctx, utils.MusicFilePath(music.FilePath()), transcode.NewClip(music.CueStart, music.CueEnd))
+	if err != nil {
+		return err
+	}
+	loudness, gain, peak := l.Integrated, l.Gain(), l.Peak()
+	music.Loudness, music.TrackGain, music.TrackPeak = &loudness, &gain, &peak
+	return db.Model(&entity.Music{}).Where("id = ?", music.ID).Updates(map[string]interface{}{
+		"loudness":   loudness,
+		"track_gain": gain,
+		"track_peak": peak,
+	}).Error
+}
+
+// albumLoudness 专辑中的歌曲都已测量时，按时长加权平均各歌曲的能量得到专辑响度，
+// 专辑峰值取各歌曲峰值的最大值；有歌曲带 ReplayGain 标签时不计算
+func albumLoudness(db *gorm.DB, albumID string) error {
+	var musics []entity.Music
+	if err := db.Select("id", "duration", "loudness", "track_peak").
+		Where("album_id = ?", albumID).Find(&musics).Error; err != nil {
+		return err
+	}
+	var energy, total, peak float64
+	for _, music := range musics {
+		if music.Loudness == nil {
+			return nil
+		}
+		weight := math.Max(float64(music.Duration), 1)
+		energy += weight * math.Pow(10, *music.Loudness/10)
+		total += weight
+		if music.TrackPeak != nil && *music.TrackPeak > peak {
+			peak = *music.TrackPeak
+		}
+	}
+	if total == 0 {
+		return nil
+	}
+	l := transcode.Loudness{Integrated: 10 * math.Log10(energy/total)}
+	return db.Model(&entity.Music{}).Where("album_id = ?", albumID).Updates(map[string]interface{}{
+		"album_gain": l.Gain(),
+		"album_peak": peak,
+	}).Error
+}
@@ -0,0 +1,219 @@
+package scanner
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"saboriman-music/internal/entity"
+	"saboriman-music/internal/utils"
+
+	"github.com/dhowden/tag"
+	"gopkg.in/vansante/go-ffprobe.v2"
+)
+
+// EmbeddedLyrics 读取歌曲文件内嵌的歌词：ID3v2 的 SYLT/USLT 帧、Vorbis 的 LYRICS/UNSYNCEDLYRICS 注释、
+// MP4 的 ©lyr 原子；dhowden/tag 无法解析的容器改用 ffprobe 读到的标签。
+// 同步歌词 (SYLT) 转换为 LRC 格式并优先使用，没有歌词时返回空字符串。
+// 整轨文件中的音轨只取时间落在音轨范围内的同步歌词并换算为音轨内的时间，不带时间的歌词无法对应到音轨
+func EmbeddedLyrics(ctx context.Context, music *entity.Music) (string, error) {
+	lyrics, err := fileLyrics(ctx, utils.MusicFilePath(music.FilePath()))
+	if err != nil || music.CueFile == "" {
+		return lyrics, err
+	}
+	return clipLyrics(lyrics, music.CueStart, music.CueEnd), nil
+}
+
+// fileLyrics 读取文件内嵌的歌词
+func fileLyrics(ctx context.Context, path string) (string, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer fh.Close()
+
+	if meta, err := tag.ReadFrom(fh); err == nil {
+		return tagLyrics(meta), nil
+	}
+
+	probeCtx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	data, err := ffprobe.ProbeURL(probeCtx, path)
+	if err != nil {
+		return "", err
+	}
+	if meta := newProbeMetadata(data); meta != nil {
+		return strings.TrimSpace(meta.Lyrics()), nil
+	}
+	return "", nil
+}
+
+// lrcTimestamp 匹配 LRC 行首的时间标签，如 [01:02.34]、[01:02]、[01:02.345]
+var lrcTimestamp = regexp.MustCompile(`^\[(\d+):(\d{1,2})(?:[.:](\d{1,3}))?\]`)
+
+// clipLyrics 取 LRC 歌词中时间在 [start, end) 毫秒之间的行，时间减去 start；
+// end 为 0 表示到文件末尾，没有时间标签的行（包括 [ar:] 等信息标签）丢弃
+func clipLyrics(lyrics string, start, end int) string {
+	var lines []string
+	for _, line := range strings.Split(lyrics, "\n") {
+		line = strings.TrimRight(line, "\r")
+		var times []int
+		for {
+			m := lrcTimestamp.FindStringSubmatch(line)
+			if m == nil {
+				break
+			}
+			minutes, _ := strconv.Atoi(m[1])
+			sec, _ := strconv.Atoi(m[2])
+			ms := (minutes*60 + sec) * 1000
+			if frac := m[3]; frac != "" {
+				f, _ := strconv.Atoi(frac)
+				for i := len(frac); i < 3; i++ {
+					f *= 10
+				}
+				ms += f
+			}
+			times = append(times, ms)
+			line = line[len(m[0]):]
+		}
+		for _, ms := range times {
+			if ms >= start && (end == 0 || ms < end) {
+				lines = append(lines, lrcTime(ms-start)+line)
+			}
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// lrcTime 毫秒换算为 LRC 时间标签
+func lrcTime(ms int) string {
+	return fmt.Sprintf("[%02d:%02d.%02d]", ms/60000, ms/1000%60, ms%1000/10)
+}
+
+// tagLyrics 从 dhowden/tag 读到的标签中取歌词
+func tagLyrics(meta tag.Metadata) string {
+	raw := meta.Raw()
+	keys := make([]string, 0, len(raw))
+	for k := range raw {
+		keys = append(keys, k)
+	}
+	// 同名帧依次命名为 USLT、USLT_0……，排序后取标签中的第一个
+	sort.Strings(keys)
+
+	var unsynced string
+	for _, k := range keys {
+		switch v := raw[k].(type) {
+		case []byte:
+			if strings.HasPrefix(k, "SYLT") || strings.HasPrefix(k, "SLT") {
+				if lrc := syltLyrics(v); lrc != "" {
+					return lrc
+				}
+			}
+		case *tag.Comm:
+			if unsynced == "" && (strings.HasPrefix(k, "USLT") || strings.HasPrefix(k, "ULT")) {
+				unsynced = strings.TrimSpace(v.Text)
+			}
+		case string:
+			// Vorbis 注释的键为小写，MP4 的 ©lyr 由 Lyrics() 读取
+			if unsynced == "" && (k == "lyrics" || k == "unsyncedlyrics") {
+				unsynced = strings.TrimSpace(v)
+			}
+		}
+	}
+	if unsynced == "" {
+		unsynced = strings.TrimSpace(meta.Lyrics())
+	}
+	return unsynced
+}
+
+// syltLyrics 将 ID3v2 的 SYLT 帧转换为 LRC 格式，仅支持以毫秒为单位的时间戳。帧结构：
+// 编码 (1) 语言 (3) 时间戳格式 (1) 内容类型 (1) 描述 (以 0 结尾) 之后重复 文本 (以 0 结尾) 时间戳 (4)。
+// 有文本以换行开头时，其余文本视为按音节同步，接到上一行
+func syltLyrics(b []byte) string {
+	if len(b) < 6 || b[4] != 2 {
+		return ""
+	}
+	enc := b[0]
+	_, rest, ok := syltText(enc, b[6:])
+	if !ok {
+		return ""
+	}
+
+	type entry struct {
+		text string
+		ms   uint32
+	}
+	var entries []entry
+	newlines := false
+	for len(rest) > 0 {
+		var text string
+		text, rest, ok = syltText(enc, rest)
+		if !ok || len(rest) < 4 {
+			break
+		}
+		entries = append(entries, entry{text, binary.BigEndian.Uint32(rest)})
+		rest = rest[4:]
+		if strings.HasPrefix(text, "\n") || strings.HasPrefix(text, "\r") {
+			newlines = true
+		}
+	}
+
+	var lines []string
+	for i, e := range entries {
+		if newlines && i > 0 && !strings.HasPrefix(e.text, "\n") && !strings.HasPrefix(e.text, "\r") {
+			lines[len(lines)-1] += e.text
+			continue
+		}
+		lines = append(lines, lrcTime(int(e.ms))+strings.TrimSpace(e.text))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// syltText 读取一个以 0 结尾的字符串，返回其余部分；UTF-16 编码以两个 0 字节结尾
+func syltText(enc byte, b []byte) (string, []byte, bool) {
+	switch enc {
+	case 0, 3: // ISO-8859-1、UTF-8
+		i := strings.IndexByte(string(b), 0)
+		if i < 0 {
+			return "", nil, false
+		}
+		if enc == 0 {
+			runes := make([]rune, i)
+			for j, c := range b[:i] {
+				runes[j] = rune(c)
+			}
+			return string(runes), b[i+1:], true
+		}
+		return string(b[:i]), b[i+1:], true
+	case 1, 2: // 带 BOM 的 UTF-16、UTF-16BE
+		for i := 0; i+1 < len(b); i += 2 {
+			if b[i] == 0 && b[i+1] == 0 {
+				return decodeUTF16(b[:i], enc == 2), b[i+2:], true
+			}
+		}
+	}
+	return "", nil, false
+}
+
+// decodeUTF16 解码 UTF-16 文本，有 BOM 时按 BOM 确定字节序
+func decodeUTF16(b []byte, bigEndian bool) string {
+	if len(b) >= 2 && (b[0] == 0xFF && b[1] == 0xFE || b[0] == 0xFE && b[1] == 0xFF) {
+		bigEndian = b[0] == 0xFE
+		b = b[2:]
+	}
+	units := make([]uint16, len(b)/2)
+	for i := range units {
+		if bigEndian {
+			units[i] = binary.BigEndian.Uint16(b[2*i:])
+		} else {
+			units[i] = binary.LittleEndian.Uint16(b[2*i:])
+		}
+	}
+	return string(utf16.Decode(units))
+}
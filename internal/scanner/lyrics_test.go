@@ -0,0 +1,140 @@
+package scanner
+
+import (
+	"context"
+	"encoding/binary"
+	"path/filepath"
+	"testing"
+	"unicode/utf16"
+
+	"saboriman-music/internal/entity"
+)
+
+// sylt 生成 SYLT 帧内容，entries 依次为文本与毫秒时间戳
+func sylt(enc, format byte, encode func(string) []byte, entries ...interface{}) []byte {
+	b := append([]byte{enc, 'e', 'n', 'g', format, 1}, encode("desc")...)
+	for i := 0; i+1 < len(entries); i += 2 {
+		b = append(b, encode(entries[i].(string))...)
+		b = binary.BigEndian.AppendUint32(b, uint32(entries[i+1].(int)))
+	}
+	return b
+}
+
+func latin1(s string) []byte {
+	b := make([]byte, 0, len(s)+1)
+	for _, r := range s {
+		b = append(b, byte(r))
+	}
+	return append(b, 0)
+}
+
+func utf8z(s string) []byte { return append([]byte(s), 0) }
+
+// utf16z 以指定字节序编码并以两个 0 字节结尾，bom 为 true 时带 BOM
+func utf16z(bigEndian, bom bool) func(string) []byte {
+	return func(s string) []byte {
+		units := utf16.Encode([]rune(s))
+		if bom {
+			units = append([]uint16{0xFEFF}, units...)
+		}
+		b := make([]byte, 2*len(units), 2*len(units)+2)
+		for i, u := range units {
+			if bigEndian {
+				binary.BigEndian.PutUint16(b[2*i:], u)
+			} else {
+				binary.LittleEndian.PutUint16(b[2*i:], u)
+			}
+		}
+		return append(b, 0, 0)
+	}
+}
+
+func TestSyltLyrics(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame []byte
+		want  string
+	}{
+		{"latin1", sylt(0, 2, latin1, "Café", 1500, "Über", 65230), "[00:01.50]Café\n[01:05.23]Über"},
+		{"utf8", sylt(3, 2, utf8z, "你好", 0, "世界", 12345), "[00:00.00]你好\n[00:12.34]世界"},
+		{"utf16 bom little endian", sylt(1, 2, utf16z(false, true), "歌词", 1000), "[00:01.00]歌词"},
+		{"utf16 bom big endian", sylt(1, 2, utf16z(true, true), "歌词", 1000), "[00:01.00]歌词"},
+		{"utf16be without bom", sylt(2, 2, utf16z(true, false), "歌词", 2000), "[00:02.00]歌词"},
+		{"syllables merged into lines", sylt(3, 2, utf8z, "\nHel", 1500, "lo", 2000, "\nWorld", 3000),
+			"[00:01.50]Hello\n[00:03.00]World"},
+		{"lines without newlines", sylt(3, 2, utf8z, "One", 1000, "Two", 2000), "[00:01.00]One\n[00:02.00]Two"},
+		{"truncated timestamp", sylt(3, 2, utf8z, "One", 1000, "Two", 2000)[:len(sylt(3, 2, utf8z, "One", 1000, "Two", 2000))-2],
+			"[00:01.00]One"},
+		{"unterminated text", append(sylt(3, 2, utf8z, "One", 1000), "Two"...), "[00:01.00]One"},
+		{"missing descriptor", []byte{3, 'e', 'n', 'g', 2, 1, 'd'}, ""},
+		{"header only", []byte{3, 'e', 'n', 'g'}, ""},
+		{"mpeg frame timestamps", sylt(3, 1, utf8z, "One", 1000), ""},
+		{"unknown encoding", sylt(9, 2, utf8z, "One", 1000), ""},
+	}
+	for _, tt := range tests {
+		if got := syltLyrics(tt.frame); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeUTF16(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      []byte
+		bigEndian bool
+		want      string
+	}{
+		{"little endian bom", []byte{0xFF, 0xFE, 'A', 0, 'B', 0}, false, "AB"},
+		{"big endian bom overrides", []byte{0xFE, 0xFF, 0, 'A', 0, 'B'}, false, "AB"},
+		{"big endian without bom", []byte{0, 'A', 0, 'B'}, true, "AB"},
+		{"surrogate pair", []byte{0x3D, 0xD8, 0xB5, 0xDC}, false, "\U0001F4B5"},
+		{"odd trailing byte", []byte{'A', 0, 'B'}, false, "A"},
+	}
+	for _, tt := range tests {
+		if got := decodeUTF16(tt.data, tt.bigEndian); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestClipLyrics(t *testing.T) {
+	lrc := "[ar:Artist]\n[00:01.00]Intro\n[01:00.50][02:10]Chorus\n[01:30.123]Verse\r\nplain text"
+	tests := []struct {
+		name       string
+		start, end int
+		want       string
+	}{
+		{"first track", 0, 60000, "[00:01.00]Intro"},
+		{"middle track", 60000, 120000, "[00:00.50]Chorus\n[00:30.12]Verse"},
+		{"last track to end of file", 120000, 0, "[00:10.00]Chorus"},
+		{"no lines in range", 200000, 0, ""},
+	}
+	for _, tt := range tests {
+		if got := clipLyrics(lrc, tt.start, tt.end); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEmbeddedLyrics(t *testing.T) {
+	dir := t.TempDir()
+	unsynced := filepath.Join(dir, "unsynced.mp3")
+	writeFile(t, unsynced, id3("a", "TIT2", "A", "USLT", "line one\nline two"))
+
+	got, err := EmbeddedLyrics(context.Background(), &entity.Music{FileUrl: unsynced})
+	if err != nil || got != "line one\nline two" {
+		t.Fatalf("unexpected lyrics %q (%v)", got, err)
+	}
+	// 整轨文件中的音轨不使用不带时间的歌词
+	cue := &entity.Music{FileUrl: entity.CueTrackPath(unsynced, 2), CueFile: filepath.Join(dir, "a.cue"), CueStart: 1000}
+	if got, err := EmbeddedLyrics(context.Background(), cue); err != nil || got != "" {
+		t.Fatalf("expected no lyrics for a cue track, got %q (%v)", got, err)
+	}
+
+	none := filepath.Join(dir, "none.mp3")
+	writeFile(t, none, id3("b", "TIT2", "B"))
+	if got, err := EmbeddedLyrics(context.Background(), &entity.Music{FileUrl: none}); err != nil || got != "" {
+		t.Fatalf("expected no lyrics, got %q (%v)", got, err)
+	}
+}
@@ -0,0 +1,487 @@
+package scanner
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"saboriman-music/config"
+	"saboriman-music/internal/artist"
+	"saboriman-music/internal/entity"
+
+	"github.com/dhowden/tag"
+	"gopkg.in/vansante/go-ffprobe.v2"
+)
+
+// probeTimeout 单个文件 ffprobe 的超时时间
+const probeTimeout = 10 * time.Second
+
+// track 工作协程解析出的一个音频文件，交给写入协程入库
+type track struct {
+	file
+	music       *entity.Music // 解析失败时为 nil；AlbumID 与推断的流派由写入协程补充
+	albumName   string        // 规范化后的专辑名，为空表示无专辑
+	albumArtist string        // 规范化后的专辑艺术家
+	albumTagged bool          // 专辑艺术家来自标签；否则按目录归组，见 writer.album
+	compilation bool          // 标签中标记为合辑
+	albumGenre  string
+	year        int
+	credits     []artist.Credit // 歌曲各角色的艺术家
+	errs        []string
+}
+
+// readTrack 读取文件的标签与 ffprobe 信息
+func readTrack(ctx context.Context, f file, covers *coverCache) *track {
+	t := &track{file: f}
+	path := f.path
+
+	// 1. 解析元数据和时长
+	fh, err := os.Open(path)
+	if err != nil {
+		t.errs = append(t.errs, fmt.Sprintf("打开文件失败 %s: %v", path, err))
+		return t
+	}
+	defer fh.Close()
+
+	meta, tagErr := tag.ReadFrom(fh)
+
+	// 使用 ffprobe 获取详细的音频信息
+	var durationSeconds int
+	var bitRate int
+	var sampleRate int
+	var bitDepth int
+	var channels int
+	var codec string
+	modTime := f.info.ModTime()
+
+	probeCtx, cancelFn := context.WithTimeout(ctx, probeTimeout)
+	defer cancelFn()
+	data, err := ffprobe.ProbeURL(probeCtx, path)
+	if err != nil {
+		if tagErr != nil {
+			t.errs = append(t.errs, fmt.Sprintf("解析元数据失败 %s: %v", path, tagErr))
+		}
+		t.errs = append(t.errs, fmt.Sprintf("探测时长失败 %s: %v", path, err))
+		return t
+	}
+	// dhowden/tag 不支持的容器改用 ffprobe 读到的标签
+	if meta == nil {
+		meta = newProbeMetadata(data)
+	}
+	if meta == nil && tagErr != nil {
+		t.errs = append(t.errs, fmt.Sprintf("解析元数据失败 %s: %v", path, tagErr))
+	}
+	fingerprint := fileFingerprint(fh, f.info.Size(), meta)
+
+	// 提取音频流信息
+	durationSeconds = int(data.Format.Duration().Seconds())
+	if data.Format.BitRate != "" {
+		fmt.Sscanf(data.Format.BitRate, "%d", &bitRate)
+		bitRate = bitRate / 1000 // 转换为 kbps
+	}
+
+	// 从第一个音频流获取详细信息
+	for _, stream := range data.Streams {
+		if stream.CodecType == "audio" {
+			if stream.SampleRate != "" {
+				fmt.Sscanf(stream.SampleRate, "%d", &sampleRate)
+			}
+			if stream.BitsPerRawSample != "" {
+				fmt.Sscanf(stream.BitsPerRawSample, "%d", &bitDepth)
+			}
+			channels = stream.Channels
+			codec = strings.ToLower(stream.CodecName) // 区分 .m4a 中的 AAC 与 ALAC
+			break
+		}
+	}
+
+	// 2. 提取所有元数据标签
+	music := &entity.Music{
+		FileUrl:     path,
+		CoverUrl:    covers.extract(path, meta),
+		Duration:    durationSeconds,
+		UserID:      "SYSTEM",
+		Size:        f.info.Size(),
+		ModTime:     &modTime,
+		Fingerprint: fingerprint,
+		Suffix:      strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), "."),
+		Codec:       codec,
+		BitRate:     bitRate,
+		SampleRate:  sampleRate,
+		BitDepth:    bitDepth,
+		Channels:    channels,
+	}
+
+	if meta != nil {
+		music.Title = normalizeString(meta.Title())
+		music.Artist = normalizeString(meta.Artist())
+		music.AlbumArtist = normalizeString(meta.AlbumArtist())
+		if raw := meta.Raw(); raw != nil {
+			t.compilation = rawFlag(raw, "compilation", "TCMP", "TCP", "cpil")
+		}
+		// 合辑没有专辑艺术家标签时使用 "Various Artists"
+		if music.AlbumArtist == "" && t.compilation {
+			music.AlbumArtist = artist.VariousArtists()
+		}
+		music.Genre = meta.Genre()
+		music.Composer = meta.Composer()
+		music.TrackNumber, _ = meta.Track()
+		music.DiscNumber, _ = meta.Disc()
+		music.Year = meta.Year()
+		music.HasCoverArt = meta.Picture() != nil
+
+		// 提取原始标签
+		if raw := meta.Raw(); raw != nil {
+			applyRawTags(raw, music)
+		}
+
+		// 专辑以 "专辑名::专辑艺术家" 区分
+		if albumName := normalizeString(meta.Album()); albumName != "" {
+			artistName := meta.AlbumArtist()
+			t.albumTagged = strings.TrimSpace(artistName) != ""
+			if !t.albumTagged && t.compilation {
+				artistName = artist.VariousArtists()
+			}
+			if artistName == "" {
+				artistName = meta.Artist()
+			}
+			if artistName == "" {
+				artistName = "未知艺术家"
+			}
+			t.albumName = albumName
+			t.albumArtist = normalizeString(artistName)
+			t.albumGenre = meta.Genre()
+			t.year = music.Year
+		}
+	}
+
+	if music.Title == "" {
+		music.Title = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	if music.Artist == "" {
+		music.Artist = "未知艺术家"
+	}
+	if music.AlbumArtist == "" {
+		music.AlbumArtist = music.Artist
+	}
+
+	var tagged map[entity.ArtistRole][]string
+	if meta != nil && meta.Raw() != nil {
+		tagged = map[entity.ArtistRole][]string{
+			entity.ArtistRoleArtist:      rawValues(meta.Raw(), "artists"),
+			entity.ArtistRoleAlbumArtist: rawValues(meta.Raw(), "albumartists"),
+		}
+	}
+	t.credits = artist.MusicCredits(music, tagged)
+
+	t.music = music
+	return t
+}
+
+// rawTag 依次尝试各个键名，返回第一个找到的原始标签值
+func rawTag(raw map[string]interface{}, keys ...string) string {
+	for _, key := range keys {
+		if values := rawValues(raw, key); len(values) > 0 {
+			return values[0]
+		}
+	}
+	return ""
+}
+
+// rawFlag 检查布尔型原始标签（如 iTunes 合辑标记）是否为真，任一 key 为真即返回 true
+func rawFlag(raw map[string]interface{}, keys ...string) bool {
+	for _, key := range keys {
+		switch v := raw[key].(type) {
+		case int:
+			if v != 0 {
+				return true
+			}
+		case string:
+			if s := strings.ToLower(strings.TrimSpace(v)); s == "1" || s == "true" || s == "yes" {
+				return true
+			}
+		case []string:
+			if len(v) > 0 && rawFlag(map[string]interface{}{key: v[0]}, key) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// rawValues 读取原始标签的所有值（key 不区分大小写），用于 ARTISTS 这类可以重复出现的多值标签；
+// 兼容 Vorbis 注释、MP4 自定义标签、ID3v2 的 TXXX 自定义帧以及按 owner 标识的 UFID 帧
+func rawValues(raw map[string]interface{}, key string) []string {
+	keys := make([]string, 0, len(raw))
+	for k := range raw {
+		keys = append(keys, k)
+	}
+	// ID3v2 的同名帧依次命名为 TXXX、TXXX_0、TXXX_1……，排序后保持标签中的顺序
+	sort.Strings(keys)
+
+	var values []string
+	for _, k := range keys {
+		switch v := raw[k].(type) {
+		case string:
+			if strings.EqualFold(k, key) {
+				values = append(values, v)
+			}
+		case []string:
+			if strings.EqualFold(k, key) {
+				values = append(values, v...)
+			}
+		case int:
+			if strings.EqualFold(k, key) {
+				values = append(values, strconv.Itoa(v))
+			}
+		case *tag.Comm:
+			if strings.HasPrefix(k, "TXX") && strings.EqualFold(v.Description, key) {
+				values = append(values, v.Text)
+			}
+		case *tag.UFID:
+			if strings.HasPrefix(k, "UFI") && strings.EqualFold(v.Provider, key) {
+				values = append(values, string(v.Identifier))
+			}
+		}
+	}
+
+	result := values[:0]
+	for _, v := range values {
+		if v = normalizeString(v); v != "" {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// normalizeString 去除首尾空格并压缩连续空格，保留原大小写
+func normalizeString(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// fileChanged 比较文件与数据库记录的大小与修改时间（精确到秒，兼容数据库的时间精度）
+func fileChanged(prev entity.Music, info os.FileInfo) bool {
+	return prev.ModTime == nil || prev.Size != info.Size() || prev.ModTime.Unix() != info.ModTime().Unix()
+}
+
+// fileFingerprint 计算文件的内容指纹：优先使用与标签无关的音频数据哈希，
+// 无法计算时退化为文件大小与标签的组合；两者都不可用时返回空串
+func fileFingerprint(file *os.File, size int64, meta tag.Metadata) string {
+	if _, err := file.Seek(0, io.SeekStart); err == nil {
+		if sum, err := tag.Sum(file); err == nil && sum != "" {
+			return "audio:" + sum
+		}
+	}
+	if meta == nil {
+		return ""
+	}
+	track, _ := meta.Track()
+	h := md5.Sum([]byte(fmt.Sprintf("%d|%s|%s|%s|%d", size, meta.Title(), meta.Artist(), meta.Album(), track)))
+	return "tags:" + hex.EncodeToString(h[:])
+}
+
+// DefaultExtensions 未配置时扫描的音频扩展名
+var DefaultExtensions = []string{
+	".mp3", ".m4a", ".m4b", ".aac", ".flac", ".ogg", ".oga", ".opus", ".wav",
+	".aiff", ".aif", ".ape", ".wv", ".dsf", ".dff", ".wma",
+}
+
+// extensions 返回配置的音频扩展名
+func extensions() []string {
+	if config.AppConfig != nil && len(config.AppConfig.Scanner.Extensions) > 0 {
+		return config.AppConfig.Scanner.Extensions
+	}
+	return DefaultExtensions
+}
+
+// isSupportedFileType 检查文件扩展名是否为支持的音频格式，配置中的扩展名可以不带 "."，不区分大小写
+func isSupportedFileType(path string) bool {
+	ext := strings.TrimPrefix(filepath.Ext(path), ".")
+	if ext == "" {
+		return false
+	}
+	for _, supported := range extensions() {
+		if strings.EqualFold(ext, strings.TrimPrefix(supported, ".")) {
+			return true
+		}
+	}
+	return false
+}
+
+// coverCache 专辑目录到封面路径的缓存，供多个工作协程共享
+type coverCache struct {
+	mu     sync.Mutex
+	dir    string // 内嵌封面的保存目录
+	covers map[string]*dirCover
+}
+
+// dirCover 一个目录的封面；同一目录的歌曲依次提取，不同目录的读写互不等待
+type dirCover struct {
+	mu  sync.Mutex
+	url string
+}
+
+func newCoverCache(dir string) *coverCache {
+	return &coverCache{dir: dir, covers: make(map[string]*dirCover)}
+}
+
+// extract 提取专辑封面，优先级：1. 音乐文件内嵌封面 2. 目录下的图片文件
+func (c *coverCache) extract(musicPath string, meta tag.Metadata) string {
+	musicDir := filepath.Dir(musicPath)
+
+	c.mu.Lock()
+	cover, ok := c.covers[musicDir]
+	if !ok {
+		cover = &dirCover{}
+		c.covers[musicDir] = cover
+	}
+	c.mu.Unlock()
+
+	cover.mu.Lock()
+	defer cover.mu.Unlock()
+
+	// 检查是否已经为该目录找到过封面
+	if cover.url != "" {
+		return cover.url
+	}
+
+	// 1. 优先尝试从音乐文件的元数据中提取封面
+	if meta != nil && meta.Picture() != nil {
+		picture := meta.Picture()
+		if coverURL := saveCoverImage(c.dir, picture.Data, picture.Ext); coverURL != "" {
+			cover.url = coverURL
+			return coverURL
+		}
+	}
+
+	// 2. 如果元数据中没有封面，扫描目录下的图片文件
+	cover.url = findCoverImageInDirectory(musicDir)
+	return cover.url
+}
+
+// saveCoverImage 将内嵌封面保存到 coversDir
+func saveCoverImage(coversDir string, imageData []byte, ext string) string {
+	if len(imageData) == 0 {
+		return ""
+	}
+
+	// 创建 covers 目录
+	if err := os.MkdirAll(coversDir, 0755); err != nil {
+		fmt.Printf("创建封面目录失败: %v\n", err)
+		return ""
+	}
+
+	// 使用 MD5 生成唯一的文件名
+	hash := md5.Sum(imageData)
+
+	// 确保扩展名包含点号
+	if ext != "" && !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	// 如果没有扩展名，默认使用 .jpg
+	if ext == "" {
+		ext = ".jpg"
+	}
+
+	filename := hex.EncodeToString(hash[:]) + ext
+
+	coverPath := filepath.Join(coversDir, filename)
+
+	// 如果文件已存在，直接返回路径
+	if _, err := os.Stat(coverPath); err == nil {
+		return coverPath
+	}
+
+	// 先写入临时文件再改名，不同目录中相同的封面可能被同时保存
+	if err := writeFileAtomic(coverPath, imageData); err != nil {
+		fmt.Printf("保存封面失败: %v\n", err)
+		return ""
+	}
+
+	fmt.Printf("保存专辑封面: %s\n", coverPath)
+	return coverPath
+}
+
+// writeFileAtomic 写入同目录下的临时文件后改名为 path
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".cover-*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// findCoverImageInDirectory 在目录中查找封面图片
+func findCoverImageInDirectory(dir string) string {
+	// 常见的封面文件名
+	coverNames := []string{
+		"cover.jpg", "cover.jpeg", "cover.png",
+		"folder.jpg", "folder.jpeg", "folder.png",
+		"album.jpg", "album.jpeg", "album.png",
+		"front.jpg", "front.jpeg", "front.png",
+	}
+
+	// 先尝试匹配常见的封面文件名
+	for _, name := range coverNames {
+		coverPath := filepath.Join(dir, name)
+		if _, err := os.Stat(coverPath); err == nil {
+			return coverPath
+		}
+		// 尝试大写
+		coverPath = filepath.Join(dir, strings.ToUpper(name))
+		if _, err := os.Stat(coverPath); err == nil {
+			return coverPath
+		}
+	}
+
+	// 如果没有找到，扫描目录中的第一张图片
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		ext := strings.ToLower(filepath.Ext(entry.Name()))
+		if isImageFile(ext) {
+			return filepath.Join(dir, entry.Name())
+		}
+	}
+
+	return ""
+}
+
+// isImageFile 检查文件扩展名是否为图片格式
+func isImageFile(ext string) bool {
+	imageExts := []string{".jpg", ".jpeg", ".png", ".gif", ".bmp", ".webp"}
+	for _, imgExt := range imageExts {
+		if ext == imgExt {
+			return true
+		}
+	}
+	return false
+}
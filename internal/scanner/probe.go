@@ -0,0 +1,99 @@
+package scanner
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/dhowden/tag"
+	"gopkg.in/vansante/go-ffprobe.v2"
+)
+
+// probeMetadata 以 ffprobe 读到的标签实现 tag.Metadata，
+// 用于 dhowden/tag 无法解析的容器（APE、WavPack、AIFF、DSD、WMA 等）
+type probeMetadata struct {
+	tags map[string]interface{} // 键统一为小写
+}
+
+// newProbeMetadata 合并音频流与容器的标签（Ogg 的标签在流上），没有标签时返回 nil
+func newProbeMetadata(data *ffprobe.ProbeData) tag.Metadata {
+	tags := make(map[string]interface{})
+	merge := func(list ffprobe.Tags) {
+		for k, v := range list {
+			if s, ok := v.(string); ok && strings.TrimSpace(s) != "" {
+				tags[strings.ToLower(k)] = s
+			}
+		}
+	}
+	for _, stream := range data.Streams {
+		if stream.CodecType == "audio" {
+			merge(stream.TagList)
+			break
+		}
+	}
+	if data.Format != nil {
+		merge(data.Format.TagList)
+	}
+	if len(tags) == 0 {
+		return nil
+	}
+	return &probeMetadata{tags: tags}
+}
+
+// get 返回第一个存在的标签值
+func (m *probeMetadata) get(keys ...string) string {
+	for _, key := range keys {
+		if v, ok := m.tags[key].(string); ok {
+			return v
+		}
+	}
+	return ""
+}
+
+// number 解析 "3/12" 形式的序号与总数
+func (m *probeMetadata) number(keys ...string) (int, int) {
+	parts := strings.SplitN(m.get(keys...), "/", 2)
+	n, _ := strconv.Atoi(strings.TrimSpace(parts[0]))
+	total := 0
+	if len(parts) == 2 {
+		total, _ = strconv.Atoi(strings.TrimSpace(parts[1]))
+	}
+	return n, total
+}
+
+func (m *probeMetadata) Format() tag.Format          { return tag.UnknownFormat }
+func (m *probeMetadata) FileType() tag.FileType      { return tag.UnknownFileType }
+func (m *probeMetadata) Title() string               { return m.get("title") }
+func (m *probeMetadata) Album() string               { return m.get("album") }
+func (m *probeMetadata) Artist() string              { return m.get("artist") }
+func (m *probeMetadata) AlbumArtist() string         { return m.get("album_artist", "albumartist") }
+func (m *probeMetadata) Composer() string            { return m.get("composer") }
+func (m *probeMetadata) Genre() string               { return m.get("genre") }
+func (m *probeMetadata) Track() (int, int)           { return m.number("track", "tracknumber") }
+func (m *probeMetadata) Disc() (int, int)            { return m.number("disc", "discnumber") }
+func (m *probeMetadata) Picture() *tag.Picture       { return nil }
+func (m *probeMetadata) Comment() string             { return m.get("comment") }
+func (m *probeMetadata) Raw() map[string]interface{} { return m.tags }
+
+// Year 取日期标签的前四位
+func (m *probeMetadata) Year() int {
+	date := m.get("date", "year", "originaldate")
+	if len(date) >= 4 {
+		if y, err := strconv.Atoi(date[:4]); err == nil {
+			return y
+		}
+	}
+	return 0
+}
+
+// Lyrics ffprobe 将 ID3 的 USLT 帧输出为 "lyrics-语言"
+func (m *probeMetadata) Lyrics() string {
+	if v := m.get("lyrics", "unsyncedlyrics"); v != "" {
+		return v
+	}
+	for k, v := range m.tags {
+		if strings.HasPrefix(k, "lyrics") {
+			return v.(string)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,90 @@
+package scanner
+
+import (
+	"path/filepath"
+	"testing"
+
+	"saboriman-music/config"
+	"saboriman-music/internal/entity"
+
+	"gopkg.in/vansante/go-ffprobe.v2"
+)
+
+func TestNewProbeMetadata(t *testing.T) {
+	if newProbeMetadata(&ffprobe.ProbeData{Format: &ffprobe.Format{}}) != nil {
+		t.Errorf("expected nil metadata without tags")
+	}
+
+	data := &ffprobe.ProbeData{
+		Streams: []*ffprobe.Stream{
+			{CodecType: "video", TagList: ffprobe.Tags{"title": "Cover"}},
+			{CodecType: "audio", TagList: ffprobe.Tags{"TITLE": "Song", "ARTIST": "Stream Artist", "genre": " "}},
+		},
+		Format: &ffprobe.Format{TagList: ffprobe.Tags{
+			"artist": "Artist", "album": "Album", "album_artist": "Band", "track": "3/12", "disc": "1",
+			"date": "2004-06-01", "lyrics-eng": "la la la",
+		}},
+	}
+	meta := newProbeMetadata(data)
+	if meta == nil {
+		t.Fatalf("expected metadata")
+	}
+	if meta.Title() != "Song" || meta.Album() != "Album" || meta.AlbumArtist() != "Band" {
+		t.Errorf("unexpected tags: %q %q %q", meta.Title(), meta.Album(), meta.AlbumArtist())
+	}
+	// 容器标签覆盖流标签，空白值被忽略
+	if meta.Artist() != "Artist" || meta.Genre() != "" {
+		t.Errorf("unexpected artist %q genre %q", meta.Artist(), meta.Genre())
+	}
+	if n, total := meta.Track(); n != 3 || total != 12 {
+		t.Errorf("track = %d/%d", n, total)
+	}
+	if n, total := meta.Disc(); n != 1 || total != 0 {
+		t.Errorf("disc = %d/%d", n, total)
+	}
+	if meta.Year() != 2004 || meta.Lyrics() != "la la la" {
+		t.Errorf("unexpected year %d lyrics %q", meta.Year(), meta.Lyrics())
+	}
+}
+
+func TestIsSupportedFileType(t *testing.T) {
+	for _, path := range []string{"a.mp3", "a.OPUS", "a.ape", "a.wv", "a.dsf", "a.m4a"} {
+		if !isSupportedFileType(path) {
+			t.Errorf("expected %s to be supported by default", path)
+		}
+	}
+	for _, path := range []string{"a.txt", "a.cue", "mp3"} {
+		if isSupportedFileType(path) {
+			t.Errorf("expected %s not to be supported", path)
+		}
+	}
+
+	prev := config.AppConfig
+	config.AppConfig = &config.Config{Scanner: config.ScannerConfig{Extensions: []string{"flac", ".MKA"}}}
+	defer func() { config.AppConfig = prev }()
+	for path, want := range map[string]bool{"a.flac": true, "a.mka": true, "a.mp3": false} {
+		if got := isSupportedFileType(path); got != want {
+			t.Errorf("isSupportedFileType(%s) = %v with configured extensions", path, got)
+		}
+	}
+}
+
+func TestScan_ProbeFallback(t *testing.T) {
+	db := openDB(t)
+	lib := newLibrary(t, db)
+	// dhowden/tag 无法解析 APE，标签与编码取自 ffprobe
+	path := filepath.Join(lib.Path, "song.ape")
+	writeFile(t, path, []byte("MAC \x96\x0f"))
+	writeFile(t, path+".probe", []byte(`{"format":{"duration":"200","bit_rate":"900000",`+
+		`"tags":{"TITLE":"Ape Song","ARTIST":"Artist","ALBUM":"Album","TRACK":"2"}},`+
+		`"streams":[{"codec_type":"audio","codec_name":"APE","sample_rate":"44100","channels":2}]}`))
+	scan(t, db, lib, Options{})
+
+	var m entity.Music
+	if err := db.Where("file_url = ?", path).First(&m).Error; err != nil {
+		t.Fatalf("expected ape file to be scanned: %v", err)
+	}
+	if m.Title != "Ape Song" || m.Suffix != "ape" || m.Codec != "ape" || m.TrackNumber != 2 {
+		t.Errorf("unexpected music %+v", m)
+	}
+}
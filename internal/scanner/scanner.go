@@ -0,0 +1,421 @@
+// Package scanner 扫描音乐库并同步到数据库。
+//
+// 扫描分为三个阶段：遍历协程收集文件，多个工作协程并发读取标签与 ffprobe 信息，
+// 调用方所在协程作为写入者按批次提交事务。
+package scanner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"saboriman-music/config"
+	"saboriman-music/internal/artist"
+	"saboriman-music/internal/entity"
+	"saboriman-music/internal/library"
+
+	"gorm.io/gorm"
+)
+
+// 扫描默认配置
+const (
+	defaultBatchSize = 200
+	progressInterval = 500 * time.Millisecond
+)
+
+// Options 扫描参数
+type Options struct {
+	FullScan    bool // 重新读取所有文件，不论是否变化
+	Concurrency int  // 并发读取元数据的工作协程数
+	BatchSize   int  // 每个事务写入的记录数
+
+	// Paths 只扫描音乐库中的这些目录，为空时扫描整个音乐库；
+	// 目录下消失的文件会被清理，目录之外的记录不受影响
+	Paths []string
+
+	// Progress 扫描期间定期以当前结果的快照调用，为 nil 时不报告进度
+	Progress func(Result)
+}
+
+// DefaultOptions 按配置生成扫描参数
+func DefaultOptions(fullScan bool) Options {
+	opts := Options{FullScan: fullScan}
+	if config.AppConfig != nil {
+		opts.Concurrency = config.AppConfig.Scanner.Concurrency
+		opts.BatchSize = config.AppConfig.Scanner.BatchSize
+	}
+	return opts
+}
+
+// Result 扫描结果
+type Result struct {
+	ScannedFiles int      `json:"scanned_files"`
+	Added        int      `json:"added"`
+	Updated      int      `json:"updated"`   // 文件有变化，重新读取元数据
+	Unchanged    int      `json:"unchanged"` // 文件未变化，跳过
+	Moved        int      `json:"moved"`     // 移动或重命名，沿用原记录
+	Moves        []Move   `json:"moves"`
+	Removed      int      `json:"removed"`
+	Errors       []string `json:"errors"`
+}
+
+// add 累加另一个音乐库的扫描结果，不修改两者原有的切片
+func (r *Result) add(o *Result) {
+	r.ScannedFiles += o.ScannedFiles
+	r.Added += o.Added
+	r.Updated += o.Updated
+	r.Unchanged += o.Unchanged
+	r.Moved += o.Moved
+	r.Removed += o.Removed
+	r.Moves = append(r.Moves[:len(r.Moves):len(r.Moves)], o.Moves...)
+	r.Errors = append(r.Errors[:len(r.Errors):len(r.Errors)], o.Errors...)
+}
+
+// Move 扫描时识别出的一次文件移动
+type Move struct {
+	ID   string `json:"id"`
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Log 打印扫描结果
+func (r *Result) Log() {
+	fmt.Printf("扫描完成: 新增 %d, 更新 %d, 未变化 %d, 移动 %d, 移除 %d, 扫描文件 %d, 错误 %d\n",
+		r.Added, r.Updated, r.Unchanged, r.Moved, r.Removed, r.ScannedFiles, len(r.Errors))
+	for _, m := range r.Moves {
+		fmt.Printf("- 移动 %s: %s -> %s\n", m.ID, m.From, m.To)
+	}
+	if len(r.Errors) > 0 {
+		fmt.Println("扫描期间发生错误:")
+		for _, e := range r.Errors {
+			fmt.Println("- ", e)
+		}
+	}
+}
+
+// file 遍历得到的一个待处理音频文件
+type file struct {
+	path   string
+	info   fs.FileInfo
+	prev   entity.Music // 数据库中已有的记录（exists 为 true 时有效）
+	exists bool
+
+	cue    *cueAudio // 整轨文件对应的 CUE，此时 tracks 为拆分出的各条音轨
+	tracks []file
+}
+
+// walkResult 遍历结束后的汇总
+type walkResult struct {
+	found map[string]bool // 找到的所有文件路径
+	errs  []string
+}
+
+// walkStats 遍历中的计数，写入协程汇报进度时读取
+type walkStats struct {
+	scanned   atomic.Int64
+	unchanged atomic.Int64
+}
+
+// Scan 扫描一个音乐库。ctx 取消时停止扫描并提交已处理的部分，此时不会清理消失的文件
+func Scan(ctx context.Context, db *gorm.DB, lib entity.Library, opts Options) (*Result, error) {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = runtime.NumCPU()
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultBatchSize
+	}
+	result := &Result{}
+
+	// 音乐库目录不可访问时直接失败，避免把所有记录当作已删除
+	if _, err := os.Stat(lib.Path); err != nil {
+		return result, fmt.Errorf("访问音乐库失败: %w", err)
+	}
+	if err := ensureSystemUser(db); err != nil {
+		return result, err
+	}
+
+	// 获取该音乐库中所有音乐的文件路径及修改时间、大小、指纹（没有指纹时用标题与时长识别移动），
+	// 以及更新时需要沿用的专辑与响度
+	var existingMusics []entity.Music
+	if err := db.Model(&entity.Music{}).
+		Select("id", "file_url", "cue_file", "size", "mod_time", "fingerprint", "title", "duration", "album_id",
+			"loudness", "track_gain", "track_peak", "album_gain", "album_peak").
+		Where("library_id = ?", lib.ID).
+		Find(&existingMusics).Error; err != nil {
+		return result, fmt.Errorf("查询已有音乐失败: %w", err)
+	}
+	roots := []string{lib.Path}
+	if len(opts.Paths) > 0 {
+		roots = scanRoots(lib.Path, opts.Paths)
+	}
+	existing := make(map[string]entity.Music, len(existingMusics))
+	for _, m := range existingMusics {
+		if len(opts.Paths) == 0 || underRoots(m.FileUrl, roots) {
+			existing[m.FileUrl] = m
+		}
+	}
+
+	files := make(chan file, opts.Concurrency*4)
+	tracks := make(chan *track, opts.Concurrency*4)
+	walkDone := make(chan walkResult, 1)
+
+	stats := &walkStats{}
+	go walk(ctx, roots, existing, opts.FullScan, stats, files, walkDone)
+
+	covers := newCoverCache(filepath.Join(lib.Path, ".covers"))
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for f := range files {
+				// 取消后仍需取完队列，让遍历协程退出
+				if ctx.Err() != nil {
+					continue
+				}
+				if f.cue != nil {
+					for _, t := range readCueTracks(ctx, f, covers) {
+						tracks <- t
+					}
+					continue
+				}
+				tracks <- readTrack(ctx, f, covers)
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(tracks)
+	}()
+
+	w := newWriter(db, lib.ID, opts.BatchSize, existing, existingMusics, result)
+	// snapshot 合并遍历计数，得到当前结果
+	snapshot := func() Result {
+		r := *result
+		r.ScannedFiles = int(stats.scanned.Load())
+		r.Unchanged += int(stats.unchanged.Load())
+		return r
+	}
+	report := func() {
+		if opts.Progress != nil {
+			opts.Progress(snapshot())
+		}
+	}
+
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+	var walked *walkResult
+	onWalked := func(wr walkResult) {
+		walked = &wr
+		result.Errors = append(result.Errors, wr.errs...)
+	}
+loop:
+	for {
+		select {
+		case wr := <-walkDone:
+			onWalked(wr)
+			walkDone = nil
+		case t, ok := <-tracks:
+			if !ok {
+				break loop
+			}
+			w.handle(t)
+		case <-ticker.C:
+			report()
+		}
+	}
+	if walked == nil {
+		onWalked(<-walkDone)
+	}
+
+	if err := ctx.Err(); err == nil {
+		// 清理（Pruning）：删除数据库中存在但文件已不存在的记录
+		w.prune(walked.found)
+	}
+	commitErr := w.commit()
+	if result.Added+result.Moved+result.Removed > 0 {
+		if err := library.Touch(db, lib.ID); err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("更新音乐库修改时间失败: %v", err))
+		}
+	}
+	if commitErr == nil && ctx.Err() == nil {
+		// 清理不再被任何歌曲或专辑引用的艺术家
+		if err := artist.Prune(db); err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("清理艺术家失败: %v", err))
+		}
+	}
+	*result = snapshot()
+	report()
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+	return result, commitErr
+}
+
+// scanRoots 整理要扫描的目录：只保留音乐库内的目录，并去掉被其他目录包含的子目录
+func scanRoots(libraryPath string, paths []string) []string {
+	library := []string{filepath.Clean(libraryPath)}
+	cleaned := make([]string, 0, len(paths))
+	for _, p := range paths {
+		if p = filepath.Clean(p); underRoots(p, library) {
+			cleaned = append(cleaned, p)
+		}
+	}
+	sort.Strings(cleaned)
+
+	var roots []string
+	for _, p := range cleaned {
+		if !underRoots(p, roots) {
+			roots = append(roots, p)
+		}
+	}
+	return roots
+}
+
+// underRoots 判断路径是否为某个目录本身或位于其中
+func underRoots(path string, roots []string) bool {
+	for _, root := range roots {
+		if path == root || strings.HasPrefix(path, root+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// walk 遍历要扫描的目录，将有变化或新增的音频文件发送给工作协程
+func walk(ctx context.Context, roots []string, existing map[string]entity.Music, fullScan bool,
+	stats *walkStats, files chan<- file, done chan<- walkResult) {
+	defer close(files)
+	wr := walkResult{found: make(map[string]bool)}
+	for _, root := range roots {
+		// 目录已被删除时其中的记录全部清理
+		if _, err := os.Stat(root); errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err := walkRoot(ctx, root, existing, fullScan, stats, files, &wr); err != nil {
+			break
+		}
+	}
+	done <- wr
+}
+
+// walkRoot 遍历一个目录，ctx 取消时返回错误
+func walkRoot(ctx context.Context, root string, existing map[string]entity.Music, fullScan bool,
+	stats *walkStats, files chan<- file, wr *walkResult) error {
+	// 已解析的目录下的 CUE，按引用的音频文件索引。进入目录时即解析，
+	// 保证 CUE 引用子目录中的音频文件时，先于这些文件解析
+	cues := make(map[string]*cueAudio)
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if err != nil {
+			wr.errs = append(wr.errs, fmt.Sprintf("访问路径失败 %s: %v", path, err))
+			return nil
+		}
+		if d.IsDir() {
+			audios, errs := loadCues(path)
+			for audio, cue := range audios {
+				cues[audio] = cue
+			}
+			wr.errs = append(wr.errs, errs...)
+			return nil
+		}
+
+		wr.found[path] = true
+		stats.scanned.Add(1)
+
+		if !isSupportedFileType(path) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			wr.errs = append(wr.errs, fmt.Sprintf("读取文件信息失败 %s: %v", path, err))
+			return nil
+		}
+		if cue := cues[path]; cue != nil {
+			return walkCue(ctx, path, info, cue, existing, fullScan, stats, files, wr)
+		}
+
+		f := file{path: path, info: info}
+		f.prev, f.exists = existing[path]
+		// 未变化的文件直接跳过；升级前入库、没有指纹的记录在文件变化或移动后重新读取时补充指纹，
+		// 避免升级后的第一次扫描读取所有文件
+		if f.exists && !fullScan && !fileChanged(f.prev, info) {
+			stats.unchanged.Add(1)
+			return nil
+		}
+
+		select {
+		case files <- f:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+}
+
+// walkCue 处理带 CUE 的整轨文件：每条音轨以 "文件#音轨号" 作为路径，文件与 CUE 都未变化时跳过
+func walkCue(ctx context.Context, path string, info fs.FileInfo, cue *cueAudio, existing map[string]entity.Music,
+	fullScan bool, stats *walkStats, files chan<- file, wr *walkResult) error {
+	// 整个文件不再作为一首歌入库，之前的记录随清理删除
+	delete(wr.found, path)
+
+	info = newCueFileInfo(info, cue.info)
+	f := file{path: path, info: info, cue: cue}
+	changed := fullScan
+	for _, t := range cue.tracks {
+		sub := file{path: entity.CueTrackPath(path, t.number), info: info}
+		sub.prev, sub.exists = existing[sub.path]
+		wr.found[sub.path] = true
+		if !sub.exists || fileChanged(sub.prev, info) {
+			changed = true
+		}
+		f.tracks = append(f.tracks, sub)
+	}
+	if !changed {
+		stats.unchanged.Add(int64(len(f.tracks)))
+		return nil
+	}
+
+	select {
+	case files <- f:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ensureSystemUser 确保扫描入库使用的 SYSTEM 用户存在
+func ensureSystemUser(db *gorm.DB) error {
+	var systemUser entity.User
+	err := db.First(&systemUser, "id = ?", "SYSTEM").Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		fmt.Println("SYSTEM 用户不存在，正在创建...")
+		newUser := entity.User{
+			ID:       "SYSTEM",
+			Username: "System",
+			Email:    "system@localhost",
+			Password: "123456",
+			Role:     entity.RoleAdmin,
+			Status:   1,
+		}
+		if createErr := db.Create(&newUser).Error; createErr != nil {
+			return fmt.Errorf("致命错误: 无法创建 SYSTEM 用户: %v", createErr)
+		}
+		fmt.Println("SYSTEM 用户创建成功。")
+	} else if err != nil {
+		return fmt.Errorf("查询 SYSTEM 用户失败: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,695 @@
+package scanner
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"saboriman-music/config"
+	database "saboriman-music/internal/db"
+	"saboriman-music/internal/entity"
+
+	"gopkg.in/vansante/go-ffprobe.v2"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// fakeProbe 代替 ffprobe 的脚本：文件旁有 .probe 时输出其内容，有 .fail 时失败，有 .slow 时先等待一秒，
+// 否则输出固定的音频信息
+const fakeProbe = `#!/bin/sh
+for f; do :; done
+if [ -f "$f.slow" ]; then sleep 1; fi
+if [ -f "$f.fail" ]; then echo "invalid data" >&2; exit 1; fi
+if [ -f "$f.probe" ]; then cat "$f.probe"; exit 0; fi
+echo '{"format":{"duration":"120.5","bit_rate":"320000"},"streams":[{"codec_type":"audio","codec_name":"mp3","sample_rate":"44100","channels":2}]}'
+`
+
+func TestMain(m *testing.M) {
+	if runtime.GOOS == "windows" {
+		os.Exit(0)
+	}
+	dir, err := os.MkdirTemp("", "scanner-test")
+	if err != nil {
+		panic(err)
+	}
+	probe := filepath.Join(dir, "ffprobe")
+	if err := os.WriteFile(probe, []byte(fakeProbe), 0o755); err != nil {
+		panic(err)
+	}
+	ffprobe.SetFFProbeBinPath(probe)
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// openDB 内存数据库，已迁移全部表
+func openDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	// 内存库每个连接独立，限制为单连接保证迁移与查询在同一个库
+	if sqlDB, err := db.DB(); err == nil {
+		sqlDB.SetMaxOpenConns(1)
+	}
+	if err := db.AutoMigrate(database.GetAllEntities()...); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return db
+}
+
+// newLibrary 在临时目录中创建音乐库
+func newLibrary(t *testing.T, db *gorm.DB) entity.Library {
+	t.Helper()
+	lib := entity.Library{Name: "test", Path: t.TempDir()}
+	if err := db.Create(&lib).Error; err != nil {
+		t.Fatalf("create library: %v", err)
+	}
+	return lib
+}
+
+// id3 生成带 ID3v2.3 标签的 MP3 内容。tags 依次为帧 ID 与文本，"TXXX:描述" 写入自定义帧，USLT 写入不同步歌词；
+// audio 决定音频数据，也就决定了内容指纹
+func id3(audio string, tags ...string) []byte {
+	var frames []byte
+	for i := 0; i+1 < len(tags); i += 2 {
+		id, body := tags[i], append([]byte{0}, tags[i+1]...)
+		if desc, ok := strings.CutPrefix(id, "TXXX:"); ok {
+			id, body = "TXXX", append(append([]byte{0}, desc...), append([]byte{0}, tags[i+1]...)...)
+		}
+		if id == "USLT" {
+			body = append([]byte{0, 'e', 'n', 'g', 0}, tags[i+1]...)
+		}
+		frames = append(frames, id...)
+		frames = binary.BigEndian.AppendUint32(frames, uint32(len(body)))
+		frames = append(frames, 0, 0)
+		frames = append(frames, body...)
+	}
+	size := len(frames)
+	data := []byte{'I', 'D', '3', 3, 0, 0, byte(size >> 21 & 0x7f), byte(size >> 14 & 0x7f), byte(size >> 7 & 0x7f), byte(size & 0x7f)}
+	data = append(data, frames...)
+	return append(data, strings.Repeat(audio, 512/len(audio)+1)...)
+}
+
+// writeFile 写入文件，必要时创建目录
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// song 写入一首带标题、艺术家与专辑的歌曲
+func song(t *testing.T, path, title, album string) {
+	t.Helper()
+	writeFile(t, path, id3(title, "TIT2", title, "TPE1", "Artist", "TALB", album, "TPE2", "Artist"))
+}
+
+func scan(t *testing.T, db *gorm.DB, lib entity.Library, opts Options) *Result {
+	t.Helper()
+	result, err := Scan(context.Background(), db, lib, opts)
+	if err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	return result
+}
+
+func count(t *testing.T, db *gorm.DB, model interface{}) int64 {
+	t.Helper()
+	var n int64
+	if err := db.Model(model).Count(&n).Error; err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestScan_AddsAndPrunes(t *testing.T) {
+	db := openDB(t)
+	lib := newLibrary(t, db)
+	for i := 1; i <= 5; i++ {
+		album := "First"
+		if i > 3 {
+			album = "Second"
+		}
+		song(t, filepath.Join(lib.Path, album, fmt.Sprintf("%02d.mp3", i)), fmt.Sprintf("Song %d", i), album)
+	}
+	writeFile(t, filepath.Join(lib.Path, "notes.txt"), []byte("not audio"))
+
+	r := scan(t, db, lib, Options{Concurrency: 3, BatchSize: 2})
+	if r.Added != 5 || r.ScannedFiles != 6 || len(r.Errors) != 0 {
+		t.Fatalf("unexpected first scan: %+v", r)
+	}
+	if n := count(t, db, &entity.Music{}); n != 5 {
+		t.Fatalf("expected 5 songs, got %d", n)
+	}
+	if n := count(t, db, &entity.Album{}); n != 2 {
+		t.Fatalf("expected 2 albums, got %d", n)
+	}
+	var music entity.Music
+	db.First(&music, "title = ?", "Song 4")
+	if music.LibraryID != lib.ID || music.AlbumID == "" || music.Fingerprint == "" || music.Duration != 120 {
+		t.Fatalf("unexpected record: %+v", music)
+	}
+
+	var scanned entity.Library
+	db.First(&scanned, lib.ID)
+	if scanned.ContentChangedAt == nil {
+		t.Fatalf("expected library content time to be set after adding songs")
+	}
+
+	// 没有变化的扫描不更新音乐库的修改时间，删除歌曲后更新
+	scan(t, db, lib, Options{})
+	var unchanged entity.Library
+	db.First(&unchanged, lib.ID)
+	if !unchanged.ContentChangedAt.Equal(*scanned.ContentChangedAt) {
+		t.Fatalf("expected unchanged scan to keep the library content time")
+	}
+	if err := os.Remove(filepath.Join(lib.Path, "Second", "04.mp3")); err != nil {
+		t.Fatal(err)
+	}
+	r = scan(t, db, lib, Options{Concurrency: 3, BatchSize: 2})
+	if r.Removed != 1 || r.Unchanged != 4 || r.Added != 0 {
+		t.Fatalf("unexpected rescan: %+v", r)
+	}
+	if n := count(t, db, &entity.Music{}); n != 4 {
+		t.Fatalf("expected 4 songs after pruning, got %d", n)
+	}
+	var pruned entity.Library
+	db.First(&pruned, lib.ID)
+	if !pruned.ContentChangedAt.After(*scanned.ContentChangedAt) {
+		t.Fatalf("expected pruning to bump the library content time")
+	}
+}
+
+func TestScan_ReadErrors(t *testing.T) {
+	db := openDB(t)
+	lib := newLibrary(t, db)
+	song(t, filepath.Join(lib.Path, "good.mp3"), "Good", "Album")
+	song(t, filepath.Join(lib.Path, "bad.mp3"), "Bad", "Album")
+	writeFile(t, filepath.Join(lib.Path, "bad.mp3.fail"), nil)
+
+	r := scan(t, db, lib, Options{})
+	if r.Added != 1 || len(r.Errors) != 1 || !strings.Contains(r.Errors[0], "bad.mp3") {
+		t.Fatalf("expected one failed file, got %+v", r)
+	}
+
+	// 音乐库目录不可访问时不清理任何记录
+	missing := entity.Library{ID: lib.ID, Path: filepath.Join(lib.Path, "missing")}
+	if _, err := Scan(context.Background(), db, missing, Options{}); err == nil {
+		t.Fatal("expected error for missing library path")
+	}
+	if n := count(t, db, &entity.Music{}); n != 1 {
+		t.Fatalf("expected records to be kept, got %d", n)
+	}
+}
+
+func TestScan_Cancelled(t *testing.T) {
+	db := openDB(t)
+	lib := newLibrary(t, db)
+	song(t, filepath.Join(lib.Path, "a.mp3"), "A", "Album")
+	song(t, filepath.Join(lib.Path, "b.mp3"), "B", "Album")
+	scan(t, db, lib, Options{})
+
+	// 取消的扫描不清理消失的文件
+	os.Remove(filepath.Join(lib.Path, "a.mp3"))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r, err := Scan(ctx, db, lib, Options{})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if r.Removed != 0 || count(t, db, &entity.Music{}) != 2 {
+		t.Fatalf("expected no pruning after cancel: %+v", r)
+	}
+}
+
+func TestWriter_Batches(t *testing.T) {
+	db := openDB(t)
+	lib := newLibrary(t, db)
+	result := &Result{}
+	w := newWriter(db, lib.ID, 2, map[string]entity.Music{}, nil, result)
+	for i := 0; i < 3; i++ {
+		path := filepath.Join(lib.Path, fmt.Sprintf("%d.mp3", i))
+		w.handle(&track{file: file{path: path}, music: &entity.Music{FileUrl: path, Title: path, UserID: "SYSTEM"}})
+		if i == 1 && (w.tx != nil || w.pending != 0) {
+			t.Fatalf("expected batch to be committed after %d writes", i+1)
+		}
+	}
+	if w.tx == nil || w.pending != 1 {
+		t.Fatalf("expected one pending write, got %d", w.pending)
+	}
+	if err := w.commit(); err != nil {
+		t.Fatal(err)
+	}
+	if result.Added != 3 || count(t, db, &entity.Music{}) != 3 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+}
+
+func TestWriter_BeginError(t *testing.T) {
+	db := openDB(t)
+	sqlDB, _ := db.DB()
+	sqlDB.Close()
+
+	result := &Result{}
+	w := newWriter(db, 1, 2, map[string]entity.Music{}, nil, result)
+	w.handle(&track{file: file{path: "/music/a.mp3"}, music: &entity.Music{FileUrl: "/music/a.mp3"}})
+	w.prune(map[string]bool{})
+	if w.tx != nil || result.Added != 0 || len(result.Errors) == 0 || !strings.Contains(result.Errors[0], "开启事务失败") {
+		t.Fatalf("expected begin error to be reported: %+v", result)
+	}
+}
+
+func TestCoverCache(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "a", "folder.jpg"), []byte("jpg"))
+	writeFile(t, filepath.Join(dir, "b", "x.png"), []byte("png"))
+	c := newCoverCache(filepath.Join(dir, ".covers"))
+
+	done := make(chan string, 4)
+	for _, p := range []string{"a/1.mp3", "a/2.mp3", "b/1.mp3", "c/1.mp3"} {
+		go func(p string) { done <- c.extract(filepath.Join(dir, p), nil) }(p)
+	}
+	got := map[string]int{}
+	for i := 0; i < 4; i++ {
+		got[<-done]++
+	}
+	if got[filepath.Join(dir, "a", "folder.jpg")] != 2 || got[filepath.Join(dir, "b", "x.png")] != 1 || got[""] != 1 {
+		t.Fatalf("unexpected covers: %v", got)
+	}
+}
+
+// fakeInfo 只提供大小与修改时间的 os.FileInfo
+type fakeInfo struct {
+	os.FileInfo
+	size    int64
+	modTime time.Time
+}
+
+func (f fakeInfo) Size() int64        { return f.size }
+func (f fakeInfo) ModTime() time.Time { return f.modTime }
+
+func TestFileChanged(t *testing.T) {
+	mod := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		prev    entity.Music
+		size    int64
+		modTime time.Time
+		want    bool
+	}{
+		{"unchanged", entity.Music{Size: 100, ModTime: &mod}, 100, mod, false},
+		{"sub-second precision", entity.Music{Size: 100, ModTime: &mod}, 100, mod.Add(300 * time.Millisecond), false},
+		{"different zone", entity.Music{Size: 100, ModTime: &mod}, 100, mod.In(time.FixedZone("CST", 8*3600)), false},
+		{"size changed", entity.Music{Size: 100, ModTime: &mod}, 101, mod, true},
+		{"mtime changed", entity.Music{Size: 100, ModTime: &mod}, 100, mod.Add(time.Second), true},
+		{"no mtime recorded", entity.Music{Size: 100}, 100, mod, true},
+	}
+	for _, tt := range tests {
+		if got := fileChanged(tt.prev, fakeInfo{size: tt.size, modTime: tt.modTime}); got != tt.want {
+			t.Errorf("%s: fileChanged = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestScan_Incremental(t *testing.T) {
+	db := openDB(t)
+	lib := newLibrary(t, db)
+	a, b, c := filepath.Join(lib.Path, "a.mp3"), filepath.Join(lib.Path, "b.mp3"), filepath.Join(lib.Path, "c.mp3")
+	song(t, a, "A", "Album")
+	song(t, b, "B", "Album")
+	song(t, c, "C", "Album")
+	scan(t, db, lib, Options{})
+
+	// 未变化的文件不再读取：让 ffprobe 对其失败，扫描不应报错
+	writeFile(t, c+".fail", nil)
+	// 升级前入库的记录没有指纹，未变化时同样跳过，不为补充指纹读取整个文件
+	db.Model(&entity.Music{}).Where("file_url = ?", c).Update("fingerprint", "")
+
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(a, later, later); err != nil {
+		t.Fatal(err)
+	}
+	song(t, b, "B (edited)", "Album")
+
+	r := scan(t, db, lib, Options{})
+	if r.Updated != 2 || r.Unchanged != 1 || r.Added != 0 || len(r.Errors) != 0 {
+		t.Fatalf("unexpected incremental scan: %+v", r)
+	}
+	var music entity.Music
+	db.First(&music, "file_url = ?", b)
+	if music.Title != "B (edited)" {
+		t.Fatalf("expected changed file to be re-read, got %q", music.Title)
+	}
+
+	// fullScan 重新读取所有文件
+	r = scan(t, db, lib, Options{FullScan: true})
+	if r.Updated != 2 || len(r.Errors) != 1 || !strings.Contains(r.Errors[0], "c.mp3") {
+		t.Fatalf("expected full scan to re-read every file: %+v", r)
+	}
+}
+
+func TestScan_MoveKeepsUserData(t *testing.T) {
+	for _, legacy := range []bool{false, true} {
+		t.Run(fmt.Sprintf("legacy=%v", legacy), func(t *testing.T) {
+			db := openDB(t)
+			lib := newLibrary(t, db)
+			from := filepath.Join(lib.Path, "Old", "a.mp3")
+			song(t, from, "A", "Album")
+			song(t, filepath.Join(lib.Path, "Old", "b.mp3"), "B", "Album")
+			scan(t, db, lib, Options{})
+
+			var before entity.Music
+			db.First(&before, "file_url = ?", from)
+			db.Model(&before).Update("play_count", 7)
+			if err := entity.SetStarred(db, "U1", entity.ItemTypeMusic, []string{before.ID}, true); err != nil {
+				t.Fatal(err)
+			}
+			db.Create(&entity.PlaylistMusic{PlaylistID: "P1", MusicID: before.ID})
+			if legacy {
+				// 升级前入库的记录没有指纹
+				db.Model(&entity.Music{}).Where("1 = 1").Update("fingerprint", "")
+			}
+
+			to := filepath.Join(lib.Path, "New", "renamed.mp3")
+			os.MkdirAll(filepath.Dir(to), 0o755)
+			if err := os.Rename(from, to); err != nil {
+				t.Fatal(err)
+			}
+			r := scan(t, db, lib, Options{})
+			if r.Moved != 1 || r.Added != 0 || r.Removed != 0 || len(r.Moves) != 1 || r.Moves[0].From != from {
+				t.Fatalf("expected a move: %+v", r)
+			}
+
+			var after entity.Music
+			if err := db.First(&after, "file_url = ?", to).Error; err != nil {
+				t.Fatal(err)
+			}
+			if after.ID != before.ID || after.PlayCount != 7 || after.Fingerprint == "" {
+				t.Fatalf("expected record to be kept: before=%s after=%+v", before.ID, after)
+			}
+			if n := count(t, db.Where("item_id = ? AND starred_at IS NOT NULL", before.ID), &entity.Annotation{}); n != 1 {
+				t.Fatal("expected star to be kept")
+			}
+			if n := count(t, db.Where("music_id = ?", before.ID), &entity.PlaylistMusic{}); n != 1 {
+				t.Fatalf("expected playlist entry to be kept, got %d", n)
+			}
+			if n := count(t, db, &entity.Music{}); n != 2 {
+				t.Fatalf("expected 2 songs, got %d", n)
+			}
+		})
+	}
+}
+
+func TestScan_MoveAcrossTargetedScans(t *testing.T) {
+	db := openDB(t)
+	lib := newLibrary(t, db)
+	from := filepath.Join(lib.Path, "Old", "a.mp3")
+	song(t, from, "A", "Album")
+	song(t, filepath.Join(lib.Path, "Old", "b.mp3"), "B", "Album")
+	scan(t, db, lib, Options{})
+	var before entity.Music
+	db.First(&before, "file_url = ?", from)
+
+	// 监听目录时移入与移出的目录可能在不同批次中扫描
+	to := filepath.Join(lib.Path, "New", "a.mp3")
+	os.MkdirAll(filepath.Dir(to), 0o755)
+	if err := os.Rename(from, to); err != nil {
+		t.Fatal(err)
+	}
+	r := scan(t, db, lib, Options{Paths: []string{filepath.Dir(to)}})
+	if r.Moved != 1 || r.Added != 0 || r.Removed != 0 {
+		t.Fatalf("expected a move from outside the scanned directory: %+v", r)
+	}
+	r = scan(t, db, lib, Options{Paths: []string{filepath.Dir(from)}})
+	if r.Removed != 0 {
+		t.Fatalf("expected the moved record not to be pruned: %+v", r)
+	}
+
+	var after entity.Music
+	if err := db.First(&after, "file_url = ?", to).Error; err != nil || after.ID != before.ID {
+		t.Fatalf("expected record to be kept: before=%s after=%+v (%v)", before.ID, after, err)
+	}
+	if n := count(t, db, &entity.Music{}); n != 2 {
+		t.Fatalf("expected 2 songs, got %d", n)
+	}
+}
+
+func TestScan_CopyIsNotMove(t *testing.T) {
+	db := openDB(t)
+	lib := newLibrary(t, db)
+	song(t, filepath.Join(lib.Path, "a.mp3"), "A", "Album")
+	scan(t, db, lib, Options{})
+
+	// 原文件仍在时相同内容的新文件是副本
+	song(t, filepath.Join(lib.Path, "copy", "a.mp3"), "A", "Album")
+	r := scan(t, db, lib, Options{})
+	if r.Added != 1 || r.Moved != 0 || count(t, db, &entity.Music{}) != 2 {
+		t.Fatalf("expected copy to be added: %+v", r)
+	}
+}
+
+// untagged 写入一首没有专辑艺术家标签、按目录归组的歌曲
+func untagged(t *testing.T, path, title, artistName, album string) {
+	t.Helper()
+	writeFile(t, path, id3(title, "TIT2", title, "TPE1", artistName, "TALB", album))
+}
+
+// albumsOf 返回各个文件所属专辑的 ID
+func albumsOf(t *testing.T, db *gorm.DB, paths ...string) []string {
+	t.Helper()
+	ids := make([]string, len(paths))
+	for i, p := range paths {
+		var m entity.Music
+		if err := db.First(&m, "file_url = ?", p).Error; err != nil {
+			t.Fatalf("find %s: %v", p, err)
+		}
+		ids[i] = m.AlbumID
+	}
+	return ids
+}
+
+func TestScan_AlbumGrouping(t *testing.T) {
+	db := openDB(t)
+	lib := newLibrary(t, db)
+	p := func(name string) string { return filepath.Join(lib.Path, filepath.FromSlash(name)) }
+
+	// 带专辑艺术家标签的专辑，与其他目录中同名同艺术家、没有标签的歌曲
+	song(t, p("Tagged/1.mp3"), "T1", "Greatest")
+	untagged(t, p("Elsewhere/1.mp3"), "E1", "Artist", "Greatest")
+	// 分碟存放在子目录中
+	untagged(t, p("Discs/CD1/1.mp3"), "D1", "Solo", "Double")
+	untagged(t, p("Discs/CD2/1.mp3"), "D2", "Solo", "Double")
+	// 同一目录中不同艺术家的歌曲组成合辑
+	untagged(t, p("Mix/1.mp3"), "M1", "One", "Mix")
+	untagged(t, p("Mix/2.mp3"), "M2", "Two", "Mix")
+	// 不同目录中同名的无关专辑
+	untagged(t, p("A/Hits/1.mp3"), "H1", "Someone", "Hits")
+	untagged(t, p("B/Hits/1.mp3"), "H2", "Someone", "Hits")
+	scan(t, db, lib, Options{})
+
+	ids := albumsOf(t, db, p("Tagged/1.mp3"), p("Elsewhere/1.mp3"), p("Discs/CD1/1.mp3"), p("Discs/CD2/1.mp3"),
+		p("Mix/1.mp3"), p("Mix/2.mp3"), p("A/Hits/1.mp3"), p("B/Hits/1.mp3"))
+	if ids[0] == ids[1] {
+		t.Errorf("expected untagged tracks in another directory not to join the tagged album")
+	}
+	if ids[2] != ids[3] {
+		t.Errorf("expected discs in sibling directories to share an album")
+	}
+	if ids[4] != ids[5] {
+		t.Errorf("expected tracks in the same directory to share an album")
+	}
+	if ids[6] == ids[7] {
+		t.Errorf("expected same-named albums in unrelated directories to stay separate")
+	}
+
+	var tagged, mix entity.Album
+	db.First(&tagged, "id = ?", ids[0])
+	db.First(&mix, "id = ?", ids[4])
+	if tagged.Dir != "" || tagged.IsCompilation {
+		t.Errorf("expected tagged album to be untouched: %+v", tagged)
+	}
+	if !mix.IsCompilation || mix.ArtistName != "Various Artists" || mix.Dir != p("Mix") {
+		t.Errorf("expected a compilation grouped by directory: %+v", mix)
+	}
+}
+
+func TestScan_AlbumGroupingLegacy(t *testing.T) {
+	db := openDB(t)
+	lib := newLibrary(t, db)
+	dir := filepath.Join(lib.Path, "Old")
+	untagged(t, filepath.Join(dir, "1.mp3"), "O1", "Artist", "Old")
+	scan(t, db, lib, Options{})
+
+	// 模拟升级前入库、没有记录目录的专辑
+	db.Model(&entity.Album{}).Where("1 = 1").Update("dir", "")
+	untagged(t, filepath.Join(dir, "2.mp3"), "O2", "Artist", "Old")
+	untagged(t, filepath.Join(lib.Path, "Other", "1.mp3"), "X1", "Artist", "Old")
+	scan(t, db, lib, Options{})
+
+	ids := albumsOf(t, db, filepath.Join(dir, "1.mp3"), filepath.Join(dir, "2.mp3"), filepath.Join(lib.Path, "Other", "1.mp3"))
+	if ids[0] != ids[1] || ids[0] == ids[2] {
+		t.Fatalf("expected only tracks in the album directory to join the legacy album: %v", ids)
+	}
+	var album entity.Album
+	db.First(&album, "id = ?", ids[0])
+	if album.Dir != dir {
+		t.Fatalf("expected legacy album to adopt its directory, got %q", album.Dir)
+	}
+}
+
+func TestRecoverJobs(t *testing.T) {
+	db := openDB(t)
+	running := entity.ScanJob{Status: entity.ScanRunning, StartedAt: time.Now()}
+	done := entity.ScanJob{Status: entity.ScanCompleted, StartedAt: time.Now()}
+	db.Create(&running)
+	db.Create(&done)
+
+	if err := RecoverJobs(db); err != nil {
+		t.Fatalf("recover: %v", err)
+	}
+	db.First(&running, "id = ?", running.ID)
+	db.First(&done, "id = ?", done.ID)
+	if running.Status != entity.ScanFailed || running.FinishedAt == nil || running.Message == "" {
+		t.Fatalf("expected stale job to be marked failed: %+v", running)
+	}
+	if done.Status != entity.ScanCompleted || done.FinishedAt != nil {
+		t.Fatalf("expected finished job to be untouched: %+v", done)
+	}
+}
+
+func TestManager_SavesProgress(t *testing.T) {
+	defer func(d time.Duration) { progressSaveInterval = d }(progressSaveInterval)
+	progressSaveInterval = 20 * time.Millisecond
+
+	db := openDB(t)
+	lib := newLibrary(t, db)
+	for _, name := range []string{"a", "b"} {
+		path := filepath.Join(lib.Path, name+".mp3")
+		song(t, path, name, "Album")
+		writeFile(t, path+".slow", nil)
+	}
+
+	m := NewManager()
+	job, err := m.Start(db, []entity.Library{lib}, Options{Concurrency: 1, BatchSize: 1}, "test")
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	defer m.Cancel()
+
+	// 扫描结束前数据库中的记录已有进度
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		var saved entity.ScanJob
+		db.First(&saved, "id = ?", job.ID)
+		if saved.Status != entity.ScanRunning {
+			t.Fatalf("expected progress to be saved while running, got %+v", saved)
+		}
+		if saved.ScannedFiles > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("progress was not saved")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if !m.Cancel() {
+		t.Fatalf("expected scan to be running")
+	}
+	var saved entity.ScanJob
+	db.First(&saved, "id = ?", job.ID)
+	if saved.Status != entity.ScanCancelled {
+		t.Fatalf("expected final status to replace the progress, got %+v", saved)
+	}
+}
+
+// fakeFFmpeg 代替 ffmpeg 的脚本：记录每次调用，路径包含 fail 时失败，否则输出 ebur128 的测量结果
+const fakeFFmpeg = `#!/bin/sh
+echo "$@" >> "$0.log"
+for a; do case "$a" in *fail*) echo "invalid data" >&2; exit 1;; esac; done
+printf '[Parsed_ebur128_0] Summary:\n  Integrated loudness:\n    I:         -14.0 LUFS\n  True peak:\n    Peak:       -1.0 dBFS\n' >&2
+`
+
+// analyzeWith 使用指定的 ffmpeg 测量响度并等待测量结束，返回 ffmpeg 被调用的次数
+func analyzeWith(t *testing.T, db *gorm.DB, ffmpeg string) int {
+	t.Helper()
+	prev := config.AppConfig
+	config.AppConfig = &config.Config{Transcoding: config.TranscodingConfig{FFmpegPath: ffmpeg}}
+	defer func() { config.AppConfig = prev }()
+
+	m := NewManager()
+	if !m.AnalyzeLoudness(db) {
+		t.Fatalf("expected analysis to start")
+	}
+	for {
+		m.mu.Lock()
+		analyzing := m.analyzing
+		m.mu.Unlock()
+		if !analyzing {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	data, _ := os.ReadFile(ffmpeg + ".log")
+	return strings.Count(string(data), "\n")
+}
+
+func TestAnalyzeLoudness_MarksFailures(t *testing.T) {
+	db := openDB(t)
+	lib := newLibrary(t, db)
+	song(t, filepath.Join(lib.Path, "ok.mp3"), "ok", "Album")
+	song(t, filepath.Join(lib.Path, "fail.mp3"), "fail", "Album")
+	scan(t, db, lib, Options{})
+
+	// 找不到 ffmpeg 时不记录失败
+	missing := filepath.Join(t.TempDir(), "ffmpeg")
+	analyzeWith(t, db, missing)
+	var marked int64
+	db.Model(&entity.Music{}).Where("loudness_err = ?", true).Count(&marked)
+	if marked != 0 {
+		t.Fatalf("expected a missing ffmpeg not to mark tracks as failed, got %d", marked)
+	}
+
+	ffmpeg := filepath.Join(t.TempDir(), "ffmpeg")
+	if err := os.WriteFile(ffmpeg, []byte(fakeFFmpeg), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if calls := analyzeWith(t, db, ffmpeg); calls != 2 {
+		t.Fatalf("expected both tracks to be measured, got %d calls", calls)
+	}
+	var ok, failed entity.Music
+	db.First(&ok, "title = ?", "ok")
+	db.First(&failed, "title = ?", "fail")
+	if ok.Loudness == nil || *ok.Loudness != -14 || ok.LoudnessErr {
+		t.Fatalf("unexpected measured track %+v", ok)
+	}
+	if failed.Loudness != nil || !failed.LoudnessErr {
+		t.Fatalf("expected failed track to be marked: %+v", failed)
+	}
+
+	// 失败的歌曲不在之后的测量中重试
+	if calls := analyzeWith(t, db, ffmpeg); calls != 2 {
+		t.Fatalf("expected failed track not to be retried, got %d calls", calls)
+	}
+	// 重新读取文件后再次测量
+	scan(t, db, lib, Options{FullScan: true})
+	db.First(&failed, "title = ?", "fail")
+	if failed.LoudnessErr {
+		t.Fatalf("expected rescanning to clear the failure marker")
+	}
+	if calls := analyzeWith(t, db, ffmpeg); calls != 3 {
+		t.Fatalf("expected failed track to be retried after rescanning, got %d calls", calls)
+	}
+}
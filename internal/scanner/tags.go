@@ -0,0 +1,57 @@
+package scanner
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"saboriman-music/internal/entity"
+)
+
+// rawTagField 从原始标签读取的一个字段。keys 为该字段在各格式中的键名，按顺序尝试、不区分大小写：
+// Vorbis 注释（ffprobe 读到的标签同样适用）、ID3v2 的帧 ID 或 TXXX 描述、
+// MP4 的原子名或 iTunes 自定义标签名，ID3v2 的 UFID 帧以 owner 标识
+type rawTagField struct {
+	keys []string
+	set  func(m *entity.Music, value string)
+}
+
+// rawTagFields 原始标签到歌曲字段的映射
+var rawTagFields = []rawTagField{
+	{[]string{"date", "TDRC", "TYER", "\xa9day"}, func(m *entity.Music, v string) { m.ReleaseDate = v }},
+	{[]string{"performer"}, func(m *entity.Music, v string) { m.Performer = v }},
+	{[]string{"label", "organization", "publisher", "TPUB"}, func(m *entity.Music, v string) { m.Label = v }},
+	{[]string{"copyright", "TCOP", "cprt"}, func(m *entity.Music, v string) { m.Copyright = v }},
+	{[]string{"isrc", "TSRC"}, func(m *entity.Music, v string) { m.ISRC = v }},
+	{[]string{"upc", "barcode"}, func(m *entity.Music, v string) { m.UPC = v }},
+
+	{[]string{"musicbrainz_trackid", "http://musicbrainz.org", "MusicBrainz Track Id"},
+		func(m *entity.Music, v string) { m.MbzTrackID = v }},
+	{[]string{"musicbrainz_albumid", "MusicBrainz Album Id"}, func(m *entity.Music, v string) { m.MbzAlbumID = v }},
+	{[]string{"musicbrainz_artistid", "MusicBrainz Artist Id"}, func(m *entity.Music, v string) { m.MbzArtistID = v }},
+	{[]string{"releasetype", "musicbrainz_albumtype", "MusicBrainz Album Type"},
+		func(m *entity.Music, v string) { m.ReleaseType = strings.ToLower(v) }},
+	{[]string{"originaldate", "TDOR", "originalyear", "TORY"}, func(m *entity.Music, v string) { m.OriginalDate = v }},
+	{[]string{"catalognumber"}, func(m *entity.Music, v string) { m.CatalogNumber = v }},
+	{[]string{"bpm", "TBPM", "tmpo"}, func(m *entity.Music, v string) { m.BPM = parseBPM(v) }},
+	{[]string{"mood", "TMOO"}, func(m *entity.Music, v string) { m.Mood = v }},
+}
+
+// applyRawTags 按 rawTagFields 从原始标签填充歌曲字段，没有的标签保持不变
+func applyRawTags(raw map[string]interface{}, music *entity.Music) {
+	for _, f := range rawTagFields {
+		if v := rawTag(raw, f.keys...); v != "" {
+			f.set(music, v)
+		}
+	}
+	replayGainTags(raw, music)
+}
+
+// parseBPM 解析节拍数，允许带小数
+func parseBPM(value string) int {
+	bpm, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || bpm <= 0 {
+		return 0
+	}
+	return int(math.Round(bpm))
+}
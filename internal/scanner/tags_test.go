@@ -0,0 +1,117 @@
+package scanner
+
+import (
+	"path/filepath"
+	"testing"
+
+	"saboriman-music/internal/entity"
+
+	"github.com/dhowden/tag"
+)
+
+func TestApplyRawTags(t *testing.T) {
+	const mbid = "0f9d5a4c-1c2b-4c8e-9d6a-1b2c3d4e5f60"
+	tests := []struct {
+		name string
+		raw  map[string]interface{}
+		want entity.Music
+	}{
+		{"vorbis", map[string]interface{}{
+			"date": "2001-05-01", "performer": "Orchestra", "label": "Label", "copyright": "(C) Label",
+			"isrc": "USABC0100001", "barcode": "0123456789012", "musicbrainz_trackid": "T1",
+			"musicbrainz_albumid": mbid, "musicbrainz_artistid": "A1", "releasetype": "Album",
+			"originaldate": "1999", "catalognumber": "CAT-1", "bpm": "127.6", "mood": "Happy",
+		}, entity.Music{
+			ReleaseDate: "2001-05-01", Performer: "Orchestra", Label: "Label", Copyright: "(C) Label",
+			ISRC: "USABC0100001", UPC: "0123456789012", MbzTrackID: "T1", MbzAlbumID: mbid, MbzArtistID: "A1",
+			ReleaseType: "album", OriginalDate: "1999", CatalogNumber: "CAT-1", BPM: 128, Mood: "Happy",
+		}},
+		{"vorbis uppercase keys and fallbacks", map[string]interface{}{
+			"DATE": "2001", "ORGANIZATION": "Org", "UPC": "111", "MUSICBRAINZ_ALBUMTYPE": "EP", "ORIGINALYEAR": "1998",
+		}, entity.Music{ReleaseDate: "2001", Label: "Org", UPC: "111", ReleaseType: "ep", OriginalDate: "1998"}},
+		{"id3v2.4", map[string]interface{}{
+			"TDRC": "2001-05", "TPUB": "Publisher", "TCOP": "(C) Pub", "TSRC": "ISRC1", "TDOR": "1999-01-01",
+			"TBPM": "90", "TMOO": "Calm",
+			"UFID":   &tag.UFID{Provider: "http://musicbrainz.org", Identifier: []byte("T2")},
+			"TXXX":   &tag.Comm{Description: "MusicBrainz Album Id", Text: mbid},
+			"TXXX_0": &tag.Comm{Description: "MusicBrainz Artist Id", Text: "A2"},
+			"TXXX_1": &tag.Comm{Description: "MusicBrainz Album Type", Text: "Single"},
+			"TXXX_2": &tag.Comm{Description: "CATALOGNUMBER", Text: "CAT-2"},
+			"TXXX_3": &tag.Comm{Description: "BARCODE", Text: "222"},
+		}, entity.Music{
+			ReleaseDate: "2001-05", Label: "Publisher", Copyright: "(C) Pub", ISRC: "ISRC1", OriginalDate: "1999-01-01",
+			BPM: 90, Mood: "Calm", MbzTrackID: "T2", MbzAlbumID: mbid, MbzArtistID: "A2", ReleaseType: "single",
+			CatalogNumber: "CAT-2", UPC: "222",
+		}},
+		{"id3v2.3", map[string]interface{}{"TYER": "1995", "TORY": "1990"},
+			entity.Music{ReleaseDate: "1995", OriginalDate: "1990"}},
+		{"mp4", map[string]interface{}{
+			"\xa9day": "2003-02-01T08:00:00Z", "cprt": "(C) MP4", "tmpo": 140,
+			"MusicBrainz Track Id": []string{"T3"}, "MusicBrainz Album Id": []string{mbid},
+			"MusicBrainz Artist Id": []string{"A3"}, "LABEL": []string{"MP4 Label"}, "ISRC": []string{"ISRC3"},
+		}, entity.Music{
+			ReleaseDate: "2003-02-01T08:00:00Z", Copyright: "(C) MP4", BPM: 140, MbzTrackID: "T3", MbzAlbumID: mbid,
+			MbzArtistID: "A3", Label: "MP4 Label", ISRC: "ISRC3",
+		}},
+		{"first key wins and blanks are ignored", map[string]interface{}{
+			"label": "  ", "publisher": "Publisher", "TPUB": "Frame", "bpm": "fast",
+		}, entity.Music{Label: "Publisher"}},
+	}
+	for _, tt := range tests {
+		var got entity.Music
+		applyRawTags(tt.raw, &got)
+		if got != tt.want {
+			t.Errorf("%s:\n got %+v\nwant %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestScan_MbzAlbumGrouping(t *testing.T) {
+	db := openDB(t)
+	lib := newLibrary(t, db)
+	p := func(name string) string { return filepath.Join(lib.Path, filepath.FromSlash(name)) }
+	release := func(path, title, album, mbid string) {
+		tags := []string{"TIT2", title, "TPE1", "Artist", "TPE2", "Artist", "TALB", album}
+		if mbid != "" {
+			tags = append(tags, "TXXX:MusicBrainz Album Id", mbid)
+		}
+		writeFile(t, path, id3(title, tags...))
+	}
+	// 同名专辑的两个发行版本，以及同一发行版本中专辑名写法不同的歌曲
+	release(p("Original/1.mp3"), "O1", "Album", "mbid-original")
+	release(p("Original/2.mp3"), "O2", "Album (Remaster)", "mbid-original")
+	release(p("Original/3.mp3"), "O3", "Album", "")
+	release(p("Deluxe/1.mp3"), "D1", "Album", "mbid-deluxe")
+	// 没有 MusicBrainz ID 的歌曲归入同一目录中的发行版本，其他目录中的按名称归入还没有 ID 的专辑
+	release(p("Plain/1.mp3"), "P1", "Album", "")
+	release(p("Another/1.mp3"), "A1", "Album", "")
+	scan(t, db, lib, Options{})
+
+	ids := albumsOf(t, db, p("Original/1.mp3"), p("Original/2.mp3"), p("Deluxe/1.mp3"), p("Plain/1.mp3"), p("Original/3.mp3"))
+	if ids[0] != ids[1] {
+		t.Errorf("expected tracks with the same release id to share an album")
+	}
+	if ids[4] != ids[0] {
+		t.Errorf("expected a track without a release id to join the release in its directory")
+	}
+	if ids[0] == ids[2] {
+		t.Errorf("expected different releases of the same album to stay separate")
+	}
+	if ids[3] == ids[0] || ids[3] == ids[2] {
+		t.Errorf("expected untagged track not to join a release with a MusicBrainz id")
+	}
+	if other := albumsOf(t, db, p("Another/1.mp3")); other[0] != ids[3] {
+		t.Errorf("expected tracks without a release id to share an album by name")
+	}
+	var albums []entity.Album
+	db.Order("mbz_album_id").Find(&albums)
+	if len(albums) != 3 || albums[0].MbzAlbumID != "" || albums[1].MbzAlbumID != "mbid-deluxe" || albums[2].MbzAlbumID != "mbid-original" {
+		t.Fatalf("unexpected albums %+v", albums)
+	}
+
+	// 重新扫描后专辑不变
+	scan(t, db, lib, Options{FullScan: true})
+	if again := albumsOf(t, db, p("Original/1.mp3"), p("Deluxe/1.mp3"), p("Plain/1.mp3")); again[0] != ids[0] || again[1] != ids[2] || again[2] != ids[3] {
+		t.Fatalf("expected albums to be stable across scans")
+	}
+}
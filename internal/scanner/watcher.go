@@ -0,0 +1,253 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"saboriman-music/config"
+	"saboriman-music/internal/entity"
+	"saboriman-music/internal/library"
+
+	"github.com/fsnotify/fsnotify"
+	"gorm.io/gorm"
+)
+
+// 目录监听默认配置
+const defaultWatchDelay = 5 * time.Second
+
+// WatchOptions 目录监听与定期扫描参数
+type WatchOptions struct {
+	Watch            bool          // 监听音乐库目录，变化后扫描受影响的目录
+	Delay            time.Duration // 最后一次变化后等待的时间，合并连续的变化
+	FullScanInterval time.Duration // 定期扫描整个音乐库的间隔，0 表示不定期扫描
+}
+
+// DefaultWatchOptions 按配置生成监听参数
+func DefaultWatchOptions() WatchOptions {
+	opts := WatchOptions{Delay: defaultWatchDelay}
+	if config.AppConfig != nil {
+		sc := config.AppConfig.Scanner
+		opts.Watch = sc.Watch
+		if sc.WatchDelay > 0 {
+			opts.Delay = time.Duration(sc.WatchDelay) * time.Second
+		}
+		opts.FullScanInterval = time.Duration(sc.FullScanInterval) * time.Hour
+	}
+	return opts
+}
+
+// Watcher 监听所有音乐库目录的变化，防抖后只扫描受影响的目录；
+// 同时按间隔定期扫描全部音乐库，补上监听遗漏的变化
+type Watcher struct {
+	db      *gorm.DB
+	manager *Manager
+	opts    WatchOptions
+	fs      *fsnotify.Watcher // 未开启监听时为 nil
+	pending map[string]bool   // 等待扫描的目录，只在 run 协程中使用
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+// Watch 开始监听 library.All() 中的音乐库，扫描通过 Manager 执行，与其他扫描互斥
+func (m *Manager) Watch(db *gorm.DB, opts WatchOptions) (*Watcher, error) {
+	if opts.Delay <= 0 {
+		opts.Delay = defaultWatchDelay
+	}
+	w := &Watcher{
+		db:      db,
+		manager: m,
+		opts:    opts,
+		pending: make(map[string]bool),
+		done:    make(chan struct{}),
+	}
+	if opts.Watch {
+		fsw, err := fsnotify.NewWatcher()
+		if err != nil {
+			return nil, err
+		}
+		w.fs = fsw
+		for _, lib := range library.All() {
+			// 单个音乐库不可访问时继续监听其他音乐库
+			if err := w.addTree(lib.Path); err != nil {
+				log.Printf("监听音乐库 %s 失败: %v", lib.Name, err)
+			}
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w.cancel = cancel
+	go w.run(ctx)
+
+	m.mu.Lock()
+	m.watcher = w
+	m.mu.Unlock()
+	log.Printf("开始监听音乐库 监听目录: %v 定期扫描间隔: %v", opts.Watch, opts.FullScanInterval)
+	return w, nil
+}
+
+// WatchLibrary 开始监听新增的音乐库，未开启监听时不做任何事
+func (m *Manager) WatchLibrary(lib entity.Library) error {
+	m.mu.Lock()
+	w := m.watcher
+	m.mu.Unlock()
+	if w == nil || w.fs == nil {
+		return nil
+	}
+	return w.addTree(lib.Path)
+}
+
+// Close 停止监听，不影响正在进行的扫描
+func (w *Watcher) Close() {
+	w.manager.mu.Lock()
+	if w.manager.watcher == w {
+		w.manager.watcher = nil
+	}
+	w.manager.mu.Unlock()
+	w.cancel()
+	<-w.done
+}
+
+func (w *Watcher) run(ctx context.Context) {
+	defer close(w.done)
+	var events <-chan fsnotify.Event
+	var errs <-chan error
+	if w.fs != nil {
+		defer w.fs.Close()
+		events, errs = w.fs.Events, w.fs.Errors
+	}
+	var schedule <-chan time.Time
+	if w.opts.FullScanInterval > 0 {
+		ticker := time.NewTicker(w.opts.FullScanInterval)
+		defer ticker.Stop()
+		schedule = ticker.C
+	}
+	debounce := time.NewTimer(w.opts.Delay)
+	debounce.Stop()
+	defer debounce.Stop()
+	// delay 重新开始计时
+	delay := func() {
+		if !debounce.Stop() {
+			select {
+			case <-debounce.C:
+			default:
+			}
+		}
+		debounce.Reset(w.opts.Delay)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			if w.handle(ev) {
+				delay()
+			}
+		case err, ok := <-errs:
+			if !ok {
+				return
+			}
+			log.Printf("监听音乐库出错: %v", err)
+		case <-debounce.C:
+			if !w.flush() {
+				// 已有扫描进行，稍后重试
+				debounce.Reset(w.opts.Delay)
+			}
+		case <-schedule:
+			if _, err := w.manager.Start(w.db, library.All(), DefaultOptions(false), "schedule"); err != nil {
+				log.Printf("跳过定期扫描: %v", err)
+			}
+		}
+	}
+}
+
+// handle 记录一次文件变化影响的目录，返回是否需要扫描
+func (w *Watcher) handle(ev fsnotify.Event) bool {
+	if w.hidden(ev.Name) || ev.Op == fsnotify.Chmod {
+		return false
+	}
+	// 新建的目录需要加入监听，并扫描其中已有的文件
+	if ev.Has(fsnotify.Create) {
+		if info, err := os.Stat(ev.Name); err == nil && info.IsDir() {
+			if err := w.addTree(ev.Name); err != nil {
+				log.Printf("监听目录失败 %s: %v", ev.Name, err)
+			}
+			w.pending[ev.Name] = true
+			return true
+		}
+	}
+	// 删除或移走的可能是目录，无法再判断类型，一律扫描其上级目录
+	if !isSupportedFileType(ev.Name) && !isCueFile(ev.Name) && !ev.Has(fsnotify.Remove) && !ev.Has(fsnotify.Rename) {
+		return false
+	}
+	w.pending[filepath.Dir(ev.Name)] = true
+	return true
+}
+
+// flush 扫描等待中的目录，已有扫描进行时返回 false 并保留这些目录
+func (w *Watcher) flush() bool {
+	if len(w.pending) == 0 {
+		return true
+	}
+	paths := make([]string, 0, len(w.pending))
+	for p := range w.pending {
+		paths = append(paths, p)
+	}
+	opts := DefaultOptions(false)
+	opts.Paths = paths
+	_, err := w.manager.Start(w.db, library.All(), opts, "watcher")
+	if errors.Is(err, ErrScanRunning) {
+		return false
+	}
+	if err != nil {
+		log.Printf("自动扫描失败: %v", err)
+	}
+	w.pending = make(map[string]bool)
+	return true
+}
+
+// addTree 监听目录及其所有子目录（fsnotify 不支持递归监听）
+func (w *Watcher) addTree(root string) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// 根目录不可访问时返回错误，子目录跳过
+			if path == root {
+				return err
+			}
+			return nil
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if path != root && w.hidden(path) {
+			return filepath.SkipDir
+		}
+		if err := w.fs.Add(path); err != nil {
+			log.Printf("监听目录失败 %s: %v", path, err)
+		}
+		return nil
+	})
+}
+
+// hidden 判断路径是否位于隐藏目录中（如 .covers）或不属于任何音乐库，这些变化不触发扫描
+func (w *Watcher) hidden(path string) bool {
+	_, rel, ok := library.Find(path)
+	if !ok {
+		return true
+	}
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		if strings.HasPrefix(part, ".") && part != "." && part != ".." {
+			return true
+		}
+	}
+	return false
+}
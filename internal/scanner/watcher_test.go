@@ -0,0 +1,142 @@
+package scanner
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"saboriman-music/internal/entity"
+	"saboriman-music/internal/library"
+
+	"github.com/fsnotify/fsnotify"
+	"gorm.io/gorm"
+)
+
+const testWatchDelay = 100 * time.Millisecond
+
+// watch 监听临时音乐库，防抖时间很短；测试结束时停止监听并取消扫描
+func watch(t *testing.T) (*gorm.DB, entity.Library, *Manager, *Watcher) {
+	t.Helper()
+	db := openDB(t)
+	lib := newLibrary(t, db)
+	if _, err := library.Load(db); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { library.Load(openDB(t)) })
+
+	m := NewManager()
+	w, err := m.Watch(db, WatchOptions{Watch: true, Delay: testWatchDelay})
+	if err != nil {
+		t.Fatalf("watch: %v", err)
+	}
+	t.Cleanup(func() {
+		w.Close()
+		m.Cancel()
+	})
+	return db, lib, m, w
+}
+
+// waitFor 等待条件成立
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// watcherJobs 返回已结束的自动扫描记录
+func watcherJobs(db *gorm.DB) []entity.ScanJob {
+	var jobs []entity.ScanJob
+	db.Where(&entity.ScanJob{Trigger: "watcher"}).Where("status <> ?", entity.ScanRunning).Order("started_at").Find(&jobs)
+	return jobs
+}
+
+func TestWatcher_CoalescesChanges(t *testing.T) {
+	db, lib, _, _ := watch(t)
+	for _, name := range []string{"a", "b", "c"} {
+		song(t, filepath.Join(lib.Path, name+".mp3"), name, "Album")
+	}
+	waitFor(t, "scan", func() bool { return len(watcherJobs(db)) > 0 })
+
+	// 防抖时间内的多次变化只触发一次扫描
+	time.Sleep(3 * testWatchDelay)
+	jobs := watcherJobs(db)
+	if len(jobs) != 1 || len(jobs[0].Paths) != 1 || jobs[0].Paths[0] != lib.Path || jobs[0].Added != 3 {
+		t.Fatalf("expected a single scan of the library root, got %+v", jobs)
+	}
+}
+
+func TestWatcher_RetriesWhileScanning(t *testing.T) {
+	db, lib, m, _ := watch(t)
+	slow := filepath.Join(lib.Path, "slow.mp3")
+	song(t, slow, "slow", "Album")
+	writeFile(t, slow+".slow", nil)
+	waitFor(t, "first scan", func() bool { return len(watcherJobs(db)) == 1 })
+
+	// 手动扫描读取较慢的文件时发生变化，自动扫描在手动扫描结束后重试
+	if _, err := m.Start(db, []entity.Library{lib}, Options{FullScan: true}, "test"); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	song(t, filepath.Join(lib.Path, "new.mp3"), "new", "Album")
+	waitFor(t, "retried scan", func() bool { return len(watcherJobs(db)) == 2 })
+
+	var manual entity.ScanJob
+	db.Where(&entity.ScanJob{Trigger: "test"}).First(&manual)
+	if manual.FinishedAt == nil || watcherJobs(db)[1].StartedAt.Before(*manual.FinishedAt) {
+		t.Fatalf("expected the watcher scan to start after the manual scan finished")
+	}
+	if count(t, db, &entity.Music{}) != 2 {
+		t.Fatalf("expected new file to be scanned")
+	}
+}
+
+func TestWatcher_IgnoresHiddenAndUnsupported(t *testing.T) {
+	db, lib, _, w := watch(t)
+	writeFile(t, filepath.Join(lib.Path, ".covers", "a.mp3"), id3("a"))
+	writeFile(t, filepath.Join(lib.Path, "notes.txt"), []byte("notes"))
+	time.Sleep(3 * testWatchDelay)
+	if jobs := watcherJobs(db); len(jobs) != 0 {
+		t.Fatalf("expected no scans, got %+v", jobs)
+	}
+
+	tests := []struct {
+		name string
+		ev   fsnotify.Event
+		want bool
+	}{
+		{"audio", fsnotify.Event{Name: filepath.Join(lib.Path, "a.mp3"), Op: fsnotify.Write}, true},
+		{"cue", fsnotify.Event{Name: filepath.Join(lib.Path, "a.cue"), Op: fsnotify.Create}, true},
+		{"chmod", fsnotify.Event{Name: filepath.Join(lib.Path, "a.mp3"), Op: fsnotify.Chmod}, false},
+		{"unsupported", fsnotify.Event{Name: filepath.Join(lib.Path, "a.txt"), Op: fsnotify.Write}, false},
+		{"removed directory", fsnotify.Event{Name: filepath.Join(lib.Path, "gone"), Op: fsnotify.Remove}, true},
+		{"hidden directory", fsnotify.Event{Name: filepath.Join(lib.Path, ".covers", "a.mp3"), Op: fsnotify.Write}, false},
+		{"outside library", fsnotify.Event{Name: filepath.Join(os.TempDir(), "a.mp3"), Op: fsnotify.Write}, false},
+	}
+	for _, tt := range tests {
+		if got := w.handle(tt.ev); got != tt.want {
+			t.Errorf("%s: handle = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWatcher_WatchesNewDirectories(t *testing.T) {
+	db, lib, _, _ := watch(t)
+	dir := filepath.Join(lib.Path, "New")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "directory scan", func() bool { return len(watcherJobs(db)) == 1 })
+
+	// 新目录加入监听后，其中的变化触发扫描
+	song(t, filepath.Join(dir, "a.mp3"), "a", "Album")
+	waitFor(t, "file scan", func() bool { return len(watcherJobs(db)) == 2 })
+	jobs := watcherJobs(db)
+	if len(jobs[1].Paths) != 1 || jobs[1].Paths[0] != dir || count(t, db, &entity.Music{}) != 1 {
+		t.Fatalf("expected the new directory to be scanned: %+v", jobs[1])
+	}
+}
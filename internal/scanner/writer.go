@@ -0,0 +1,521 @@
+package scanner
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"saboriman-music/internal/artist"
+	"saboriman-music/internal/entity"
+
+	"gorm.io/gorm"
+)
+
+// writer 负责扫描中的全部数据库写入，只在一个协程中使用；
+// 每写入 batchSize 条记录提交一次事务，避免长时间锁住数据库
+type writer struct {
+	db        *gorm.DB
+	tx        *gorm.DB
+	libraryID uint
+	batchSize int
+	pending   int
+	result    *Result
+
+	existing  map[string]entity.Music   // 扫描范围内扫描开始时数据库中的记录，按路径索引，只清理其中的记录
+	prints    map[string][]entity.Music // 整个音乐库中有指纹的记录按指纹索引，用于识别移动
+	legacy    map[string][]entity.Music // 没有指纹的记录按 legacyKey 索引
+	movedFrom map[string]bool           // 被识别为移动的原路径，不参与清理
+	albums    map[string]*entity.Album  // 本次扫描的专辑缓存
+	left      map[string]bool           // 有歌曲移出的专辑（如并入合辑），扫描结束时删除其中已为空的专辑
+	artists   *artist.Linker
+}
+
+// newWriter 创建写入器；all 为整个音乐库中的记录，只扫描部分目录时，
+// 从其他目录移入的文件也能按指纹识别为移动
+func newWriter(db *gorm.DB, libraryID uint, batchSize int, existing map[string]entity.Music, all []entity.Music, result *Result) *writer {
+	w := &writer{
+		db:        db,
+		libraryID: libraryID,
+		batchSize: batchSize,
+		result:    result,
+		existing:  existing,
+		prints:    make(map[string][]entity.Music),
+		legacy:    make(map[string][]entity.Music),
+		movedFrom: make(map[string]bool),
+		albums:    make(map[string]*entity.Album),
+		left:      make(map[string]bool),
+		artists:   artist.NewLinker(),
+	}
+	for _, m := range all {
+		if m.Fingerprint != "" {
+			w.prints[m.Fingerprint] = append(w.prints[m.Fingerprint], m)
+		} else {
+			w.legacy[legacyKey(&m)] = append(w.legacy[legacyKey(&m)], m)
+		}
+	}
+	return w
+}
+
+// begin 返回当前批次的事务，必要时开启新事务
+func (w *writer) begin() (*gorm.DB, error) {
+	if w.tx == nil {
+		tx := w.db.Begin()
+		if tx.Error != nil {
+			w.result.Errors = append(w.result.Errors, fmt.Sprintf("开启事务失败: %v", tx.Error))
+			return nil, tx.Error
+		}
+		w.tx = tx
+	}
+	return w.tx, nil
+}
+
+// written 记录一次写入，达到批次大小时提交
+func (w *writer) written() {
+	w.pending++
+	if w.pending >= w.batchSize {
+		w.commit()
+	}
+}
+
+// commit 提交当前批次
+func (w *writer) commit() error {
+	if w.tx == nil {
+		return nil
+	}
+	err := w.tx.Commit().Error
+	if err != nil {
+		w.result.Errors = append(w.result.Errors, fmt.Sprintf("提交扫描结果失败: %v", err))
+	}
+	w.tx = nil
+	w.pending = 0
+	return err
+}
+
+// handle 处理工作协程的解析结果
+func (w *writer) handle(t *track) {
+	w.result.Errors = append(w.result.Errors, t.errs...)
+	if t.music != nil {
+		w.write(t)
+	}
+}
+
+// legacyKey 升级前入库的记录没有指纹，按文件大小、时长与标题识别移动
+func legacyKey(m *entity.Music) string {
+	return fmt.Sprintf("%d|%d|%s", m.Size, m.Duration, m.Title)
+}
+
+// movedRecord 查找与新文件指纹相同（没有指纹的记录比较 legacyKey）、文件已不存在的记录，
+// 视为该文件移动前的记录
+func (w *writer) movedRecord(music *entity.Music) (entity.Music, bool) {
+	candidates := w.prints[music.Fingerprint]
+	candidates = append(candidates[:len(candidates):len(candidates)], w.legacy[legacyKey(music)]...)
+	for _, m := range candidates {
+		if w.movedFrom[m.FileUrl] {
+			continue
+		}
+		if _, err := os.Stat(m.FilePath()); errors.Is(err, fs.ErrNotExist) {
+			w.movedFrom[m.FileUrl] = true
+			return m, true
+		}
+	}
+	return entity.Music{}, false
+}
+
+// write 新增或原地更新一条音乐记录
+func (w *writer) write(t *track) {
+	tx, err := w.begin()
+	if err != nil {
+		return
+	}
+	music := t.music
+	music.LibraryID = w.libraryID
+	if album := w.album(tx, t); album != nil {
+		music.AlbumID = album.ID
+		compilationArtist(t, album)
+	}
+	music.Genre = getOrInferGenre(tx, music.Genre, music.AlbumID, t.albumName)
+
+	// 新文件与某个消失文件的指纹相同时视为移动，沿用原记录并补充指纹
+	prev, exists, moved := t.prev, t.exists, false
+	if !exists {
+		prev, moved = w.movedRecord(music)
+		exists = moved
+	}
+
+	musicID := ""
+	if exists && prev.AlbumID != "" && prev.AlbumID != music.AlbumID {
+		w.left[prev.AlbumID] = true
+	}
+	if exists {
+		keepLoudness(music, prev)
+		// 原地更新，保留 ID 与播放/喜爱次数，播放列表与收藏随之保留
+		if err := tx.Model(&entity.Music{ID: prev.ID}).
+			Select("*").
+			Omit("id", "play_count", "like_count", "user_id", "created_at").
+			Updates(music).Error; err != nil {
+			w.result.Errors = append(w.result.Errors, fmt.Sprintf("更新记录失败 %s: %v", t.path, err))
+		} else if moved {
+			musicID = prev.ID
+			w.result.Moved++
+			w.result.Moves = append(w.result.Moves, Move{ID: prev.ID, From: prev.FileUrl, To: t.path})
+		} else {
+			musicID = prev.ID
+			w.result.Updated++
+		}
+	} else if err := tx.Create(music).Error; err != nil {
+		w.result.Errors = append(w.result.Errors, fmt.Sprintf("创建记录失败 %s: %v", t.path, err))
+	} else {
+		musicID = music.ID
+		w.result.Added++
+	}
+
+	if musicID != "" {
+		if err := w.artists.LinkMusic(tx, musicID, t.credits); err != nil {
+			w.result.Errors = append(w.result.Errors, fmt.Sprintf("关联艺术家失败 %s: %v", t.path, err))
+		}
+	}
+	w.written()
+}
+
+// album 查找或创建专辑
+//
+// 带 MusicBrainz 发行 ID 的歌曲按该 ID 归组；有专辑艺术家标签的歌曲以 "专辑名::专辑艺术家" 区分专辑；
+// 没有该标签或带合辑标记的歌曲按 "目录::专辑名" 归组，同一目录下的同名专辑出现多位艺术家时改为合辑
+func (w *writer) album(tx *gorm.DB, t *track) *entity.Album {
+	if t.albumName == "" {
+		return nil
+	}
+	grouped := !t.albumTagged || t.compilation
+	dir := filepath.Dir(t.path)
+	mbzID := t.music.MbzAlbumID
+	albumKey := fmt.Sprintf("%s::%s", t.albumName, t.albumArtist)
+	switch {
+	case mbzID != "":
+		albumKey = "mbz:" + mbzID
+	case grouped:
+		albumKey = fmt.Sprintf("dir:%s::%s", dir, t.albumName)
+	}
+
+	// 没有 MusicBrainz ID 的带标签歌曲优先加入同一目录中已有歌曲的同名发行版本，
+	// 不会随机加入其他目录中的某个发行版本
+	if mbzID == "" && !grouped {
+		releaseKey := fmt.Sprintf("release:%s::%s", dir, albumKey)
+		if album, found := w.albums[releaseKey]; found {
+			return album
+		}
+		var release entity.Album
+		released := func(db *gorm.DB) *gorm.DB {
+			return db.Where("(dir IS NULL OR dir = '') AND mbz_album_id IS NOT NULL AND mbz_album_id <> ''")
+		}
+		err := w.dirAlbum(tx, released, t.albumName, t.albumArtist, dir, &release)
+		if err == nil {
+			album, found := w.albums["mbz:"+release.MbzAlbumID]
+			if !found {
+				album = &release
+				w.albums["mbz:"+release.MbzAlbumID] = album
+				w.linkAlbum(tx, album)
+			}
+			w.albums[releaseKey] = album
+			return album
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+	}
+
+	// 首先检查内存缓存
+	if album, found := w.albums[albumKey]; found {
+		if grouped {
+			w.group(tx, album, t)
+		}
+		return album
+	}
+
+	// 缓存未命中，查询数据库；按目录归组的歌曲找不到同目录专辑时，
+	// 沿用同名同艺术家、且在相关目录中的普通专辑，见 sameAlbumDir
+	var existingAlbum entity.Album
+	err := gorm.ErrRecordNotFound
+	byName := func(db *gorm.DB) *gorm.DB { return db }
+	if mbzID != "" {
+		err = tx.Where("mbz_album_id = ? AND library_id = ?", mbzID, w.libraryID).First(&existingAlbum).Error
+		// 按名称只能找到还没有 MusicBrainz ID 的专辑，同名的不同发行版本互相独立
+		byName = func(db *gorm.DB) *gorm.DB { return db.Where("(mbz_album_id IS NULL OR mbz_album_id = '')") }
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		if grouped {
+			err = tx.Scopes(byName).Where("name = ? AND dir = ? AND library_id = ?", t.albumName, dir, w.libraryID).First(&existingAlbum).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				err = w.dirAlbum(tx, byName, t.albumName, t.albumArtist, dir, &existingAlbum)
+			}
+		} else {
+			// 带标签的歌曲不加入按目录归组的专辑，只能找到还没有 MusicBrainz ID 的专辑；
+			// 带 ID 的歌曲只接管同一目录中已有歌曲的专辑，不会给其他目录中的同名专辑写上 ID
+			untagged := func(db *gorm.DB) *gorm.DB {
+				return db.Where("(dir IS NULL OR dir = '') AND (mbz_album_id IS NULL OR mbz_album_id = '')")
+			}
+			if mbzID != "" {
+				err = w.dirAlbum(tx, untagged, t.albumName, t.albumArtist, dir, &existingAlbum)
+			} else {
+				err = tx.Scopes(untagged).Where("name = ? AND artist_name = ? AND library_id = ?",
+					t.albumName, t.albumArtist, w.libraryID).First(&existingAlbum).Error
+			}
+		}
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// 数据库中也不存在，创建新专辑
+		newAlbum := entity.Album{
+			Name:          t.albumName,
+			ArtistName:    t.albumArtist,
+			Genre:         t.albumGenre,
+			CoverURL:      t.music.CoverUrl,
+			LibraryID:     w.libraryID,
+			IsCompilation: t.compilation,
+			MbzAlbumID:    mbzID,
+		}
+		if grouped {
+			newAlbum.Dir = dir
+		}
+		// 安全地设置发行日期
+		if t.year > 0 {
+			date := time.Date(t.year, 1, 1, 0, 0, 0, 0, time.UTC)
+			newAlbum.ReleaseDate = &date
+		}
+		if createErr := tx.Create(&newAlbum).Error; createErr != nil {
+			w.result.Errors = append(w.result.Errors, fmt.Sprintf("创建专辑失败 %s: %v", t.albumName, createErr))
+			return nil
+		}
+		w.albums[albumKey] = &newAlbum
+		w.linkAlbum(tx, &newAlbum)
+		return &newAlbum
+	}
+	if err != nil {
+		return nil
+	}
+
+	w.albums[albumKey] = &existingAlbum
+	if mbzID != "" && existingAlbum.MbzAlbumID == "" {
+		tx.Model(&existingAlbum).Update("mbz_album_id", mbzID)
+		existingAlbum.MbzAlbumID = mbzID
+	}
+	if grouped {
+		if existingAlbum.Dir == "" {
+			tx.Model(&existingAlbum).Update("dir", dir)
+			existingAlbum.Dir = dir
+		}
+		w.group(tx, &existingAlbum, t)
+	}
+	w.linkAlbum(tx, &existingAlbum)
+	// 如果专辑已存在但没有封面，尝试补充封面
+	if existingAlbum.CoverURL == "" && t.music.CoverUrl != "" {
+		tx.Model(&existingAlbum).Update("cover_url", t.music.CoverUrl)
+		existingAlbum.CoverURL = t.music.CoverUrl
+	}
+	return &existingAlbum
+}
+
+// dirAlbum 查找同名同艺术家的普通专辑中与 dir 相关的一个，没有时返回 gorm.ErrRecordNotFound
+func (w *writer) dirAlbum(tx *gorm.DB, scope func(*gorm.DB) *gorm.DB, name, artistName, dir string, album *entity.Album) error {
+	var candidates []entity.Album
+	if err := tx.Scopes(scope).Where("name = ? AND artist_name = ? AND library_id = ? AND is_compilation = ?",
+		name, artistName, w.libraryID, false).Find(&candidates).Error; err != nil {
+		return err
+	}
+	for _, c := range candidates {
+		ok, err := sameAlbumDir(tx, &c, dir)
+		if err != nil {
+			return err
+		}
+		if ok {
+			*album = c
+			return nil
+		}
+	}
+	return gorm.ErrRecordNotFound
+}
+
+// discDir 匹配分碟存放的子目录名，如 CD1、Disc 2、Disk_3
+var discDir = regexp.MustCompile(`(?i)^(cd|dis[ck])[\s_.-]*\d+$`)
+
+// sameAlbumDir 检查 dir 中的歌曲能否加入专辑：专辑按目录归组时，两个目录之一为分碟子目录，
+// 且另一个是它的上级或同级的分碟子目录；没有目录的专辑（带标签或升级前入库）需要已有歌曲在 dir 中，
+// 避免把其他目录中同名的歌曲合并到无关的专辑
+func sameAlbumDir(tx *gorm.DB, album *entity.Album, dir string) (bool, error) {
+	if album.Dir != "" {
+		isDisc := discDir.MatchString(filepath.Base(dir))
+		albumDisc := discDir.MatchString(filepath.Base(album.Dir))
+		parent, albumParent := filepath.Dir(dir), filepath.Dir(album.Dir)
+		return isDisc && albumDisc && parent == albumParent ||
+			isDisc && album.Dir == parent ||
+			albumDisc && albumParent == dir, nil
+	}
+	var paths []string
+	if err := tx.Model(&entity.Music{}).Where("album_id = ?", album.ID).Pluck("file_url", &paths).Error; err != nil {
+		return false, err
+	}
+	for _, p := range paths {
+		if filepath.Dir(p) == dir {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// group 按目录归组的歌曲加入专辑：歌曲带合辑标记，或与专辑的艺术家不同时，把专辑改为合辑，
+// 并更新专辑中已有歌曲的专辑艺术家
+func (w *writer) group(tx *gorm.DB, album *entity.Album, t *track) {
+	if album.IsCompilation || (!t.compilation && album.ArtistName == t.albumArtist) {
+		return
+	}
+	artistName := artist.VariousArtists()
+	if t.compilation {
+		artistName = t.albumArtist
+	}
+	if err := tx.Model(album).Updates(map[string]interface{}{
+		"is_compilation": true,
+		"artist_name":    artistName,
+	}).Error; err != nil {
+		w.result.Errors = append(w.result.Errors, fmt.Sprintf("更新合辑失败 %s: %v", album.Name, err))
+		return
+	}
+	album.IsCompilation = true
+	album.ArtistName = artistName
+	w.linkAlbum(tx, album)
+
+	var ids []string
+	if err := tx.Model(&entity.Music{}).Where("album_id = ?", album.ID).Pluck("id", &ids).Error; err != nil {
+		w.result.Errors = append(w.result.Errors, fmt.Sprintf("更新合辑失败 %s: %v", album.Name, err))
+		return
+	}
+	if len(ids) == 0 {
+		return
+	}
+	tx.Model(&entity.Music{}).Where("id IN ?", ids).Update("album_artist", artistName)
+	if err := w.artists.ReplaceRole(tx, ids, entity.ArtistRoleAlbumArtist, artist.AlbumCredits(artistName)); err != nil {
+		w.result.Errors = append(w.result.Errors, fmt.Sprintf("关联专辑艺术家失败 %s: %v", album.Name, err))
+	}
+}
+
+// compilationArtist 没有专辑艺术家标签的歌曲加入合辑时，以合辑的艺术家作为专辑艺术家
+func compilationArtist(t *track, album *entity.Album) {
+	if t.albumTagged || !album.IsCompilation || t.music.AlbumArtist == album.ArtistName {
+		return
+	}
+	t.music.AlbumArtist = album.ArtistName
+	credits := make([]artist.Credit, 0, len(t.credits))
+	for _, c := range t.credits {
+		if c.Role != entity.ArtistRoleAlbumArtist {
+			credits = append(credits, c)
+		}
+	}
+	t.credits = append(credits, artist.AlbumCredits(album.ArtistName)...)
+}
+
+// linkAlbum 按专辑艺术家更新专辑的艺术家关联，每次扫描中每张专辑只更新一次
+func (w *writer) linkAlbum(tx *gorm.DB, album *entity.Album) {
+	if err := w.artists.LinkAlbum(tx, album.ID, artist.AlbumCredits(album.ArtistName)); err != nil {
+		w.result.Errors = append(w.result.Errors, fmt.Sprintf("关联专辑艺术家失败 %s: %v", album.Name, err))
+	}
+}
+
+// prune 删除数据库中存在但文件已不存在（且未被识别为移动）的记录
+func (w *writer) prune(found map[string]bool) {
+	var ids []string
+	for path, m := range w.existing {
+		if !found[path] && !w.movedFrom[path] {
+			ids = append(ids, m.ID)
+		}
+	}
+	for start := 0; start < len(ids); start += w.batchSize {
+		end := start + w.batchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		tx, err := w.begin()
+		if err != nil {
+			return
+		}
+		if err := tx.Delete(&entity.MusicArtist{}, "music_id IN ?", ids[start:end]).Error; err != nil {
+			w.result.Errors = append(w.result.Errors, fmt.Sprintf("删除艺术家关联失败: %v", err))
+		}
+		res := tx.Delete(&entity.Music{}, "id IN ?", ids[start:end])
+		if res.Error != nil {
+			w.result.Errors = append(w.result.Errors, fmt.Sprintf("删除记录失败: %v", res.Error))
+		} else {
+			w.result.Removed += int(res.RowsAffected)
+		}
+		w.commit()
+	}
+
+	if len(w.left) > 0 {
+		ids := make([]string, 0, len(w.left))
+		for id := range w.left {
+			ids = append(ids, id)
+		}
+		tx, err := w.begin()
+		if err != nil {
+			return
+		}
+		if err := tx.Where("id IN ? AND id NOT IN (?)", ids, w.db.Model(&entity.Music{}).Select("album_id").Where("album_id IS NOT NULL")).
+			Delete(&entity.Album{}).Error; err != nil {
+			w.result.Errors = append(w.result.Errors, fmt.Sprintf("删除空专辑失败: %v", err))
+		}
+	}
+}
+
+// getOrInferGenre 获取或推断流派
+func getOrInferGenre(tx *gorm.DB, currentGenre, albumID, albumName string) string {
+	// 1. 如果当前音乐已有流派，直接返回
+	if currentGenre != "" {
+		return currentGenre
+	}
+
+	// 2. 尝试从专辑中其他音乐获取流派
+	if albumID != "" {
+		var genre string
+		err := tx.Model(&entity.Music{}).
+			Select("genre").
+			Where("album_id = ? AND genre != ''", albumID).
+			Group("genre").
+			Order("COUNT(*) DESC").
+			Limit(1).
+			Pluck("genre", &genre).Error
+
+		if err == nil && genre != "" {
+			return genre
+		}
+	}
+
+	// 3. 根据专辑名称或目录名称推断流派
+	albumLower := strings.ToLower(albumName)
+
+	genreKeywords := map[string][]string{
+		"Classical":  {"classical", "symphony", "concerto", "sonata", "古典"},
+		"Pop":        {"pop", "流行"},
+		"Rock":       {"rock", "摇滚"},
+		"Jazz":       {"jazz", "爵士"},
+		"Electronic": {"electronic", "edm", "techno", "house", "电子"},
+		"Hip Hop":    {"hip hop", "rap", "说唱"},
+		"Country":    {"country", "乡村"},
+		"R&B":        {"r&b", "soul"},
+		"Metal":      {"metal", "金属"},
+		"Folk":       {"folk", "民谣"},
+		"Soundtrack": {"soundtrack", "ost", "原声"},
+		"Anime":      {"anime", "动漫", "アニメ"},
+		"Game":       {"game", "游戏", "ゲーム"},
+	}
+
+	for genre, keywords := range genreKeywords {
+		for _, keyword := range keywords {
+			if strings.Contains(albumLower, keyword) {
+				return genre
+			}
+		}
+	}
+
+	return "Unknown"
+}
@@ -0,0 +1,417 @@
+package subsonic
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"saboriman-music/internal/artist"
+	"saboriman-music/internal/entity"
+	"saboriman-music/internal/library"
+	"saboriman-music/internal/nowplaying"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// write 为响应中的歌曲/专辑/艺术家附加当前用户的 starred/userRating/played 后输出
+func (h *SubsonicHandler) write(c *fiber.Ctx, resp Response) error {
+	if user := CurrentUser(c); user != nil {
+		if err := h.annotate(user.ID, &resp); err != nil {
+			return Write(c, NewError(ErrGeneric, fmt.Sprintf("db error: %v", err)))
+		}
+	}
+	return Write(c, resp)
+}
+
+// responseItems 响应中所有需要附加标注的条目
+type responseItems struct {
+	songs   []*Song
+	albums  []*Album
+	artists []*Artist
+}
+
+func (r *responseItems) addSongs(songs []Song) {
+	for i := range songs {
+		if !songs[i].IsDir {
+			r.songs = append(r.songs, &songs[i])
+		}
+	}
+}
+
+func (r *responseItems) addAlbums(albums []Album) {
+	for i := range albums {
+		r.albums = append(r.albums, &albums[i])
+	}
+}
+
+func (r *responseItems) addArtists(artists []Artist) {
+	for i := range artists {
+		r.artists = append(r.artists, &artists[i])
+	}
+}
+
+// collectItems 遍历响应，收集需要附加标注的条目
+func collectItems(resp *Response) *responseItems {
+	items := &responseItems{}
+	if resp.Artists != nil {
+		for i := range resp.Artists.Index {
+			items.addArtists(resp.Artists.Index[i].Artists)
+		}
+	}
+	if resp.Artist != nil {
+		items.artists = append(items.artists, &resp.Artist.Artist)
+		items.addAlbums(resp.Artist.Album)
+	}
+	if resp.Album != nil {
+		items.albums = append(items.albums, &resp.Album.Album)
+		items.addSongs(resp.Album.Song)
+	}
+	if resp.AlbumList2 != nil {
+		items.addAlbums(resp.AlbumList2.Album)
+	}
+	if resp.Song != nil {
+		items.songs = append(items.songs, resp.Song)
+	}
+	if resp.Directory != nil {
+		items.addSongs(resp.Directory.Child)
+	}
+	if resp.Indexes != nil {
+		items.addSongs(resp.Indexes.Child)
+	}
+	for _, list := range []*SongsResponse{resp.SongsByGenre, resp.RandomSongs} {
+		if list != nil {
+			items.addSongs(list.Song)
+		}
+	}
+	if resp.Playlist != nil {
+		items.addSongs(resp.Playlist.Entry)
+	}
+	if resp.NowPlaying != nil {
+		for i := range resp.NowPlaying.Entry {
+			items.songs = append(items.songs, &resp.NowPlaying.Entry[i].Song)
+		}
+	}
+	for _, result := range []*SearchResult2{resp.SearchResult2, resp.SearchResult3} {
+		if result != nil {
+			items.addArtists(result.Artists)
+			items.addAlbums(result.Albums)
+			items.addSongs(result.Songs)
+		}
+	}
+	for _, starred := range []*Starred{resp.Starred, resp.Starred2} {
+		if starred != nil {
+			items.addArtists(starred.Artists)
+			items.addAlbums(starred.Albums)
+			items.addSongs(starred.Songs)
+		}
+	}
+	return items
+}
+
+// annotate 批量读取当前用户的标注并写入响应
+func (h *SubsonicHandler) annotate(userID string, resp *Response) error {
+	items := collectItems(resp)
+
+	songIDs := make([]string, 0, len(items.songs))
+	for _, s := range items.songs {
+		songIDs = append(songIDs, s.ID)
+	}
+	songAnnotations, err := entity.LoadAnnotations(h.db, userID, entity.ItemTypeMusic, songIDs)
+	if err != nil {
+		return err
+	}
+	for _, s := range items.songs {
+		a := songAnnotations[s.ID]
+		s.Starred, s.UserRating = annotationAttrs(a)
+		s.PlayCount, s.Played = a.PlayCount, formatTimePtr(a.PlayedAt)
+	}
+
+	albumIDs := make([]string, 0, len(items.albums))
+	for _, a := range items.albums {
+		albumIDs = append(albumIDs, a.ID)
+	}
+	albumAnnotations, err := entity.LoadAnnotations(h.db, userID, entity.ItemTypeAlbum, albumIDs)
+	if err != nil {
+		return err
+	}
+	for _, al := range items.albums {
+		a := albumAnnotations[al.ID]
+		al.Starred, al.UserRating = annotationAttrs(a)
+		al.PlayCount, al.Played = a.PlayCount, formatTimePtr(a.PlayedAt)
+	}
+
+	artistIDs := make([]string, 0, len(items.artists))
+	for _, a := range items.artists {
+		artistIDs = append(artistIDs, a.ID)
+	}
+	artistAnnotations, err := entity.LoadAnnotations(h.db, userID, entity.ItemTypeArtist, artistIDs)
+	if err != nil {
+		return err
+	}
+	for _, a := range items.artists {
+		a.Starred, a.UserRating = annotationAttrs(artistAnnotations[a.ID])
+	}
+	return nil
+}
+
+// annotationAttrs 将标注转换为 starred/userRating 属性值
+func annotationAttrs(a entity.Annotation) (string, int) {
+	return formatTimePtr(a.StarredAt), a.Rating
+}
+
+// formatTimePtr 同 formatTime，nil 输出空字符串
+func formatTimePtr(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return formatTime(*t)
+}
+
+// inScope 判断条目是否存在且在 libraries 范围内
+func (h *SubsonicHandler) inScope(itemType entity.ItemType, id string, libraries []uint) (bool, error) {
+	var count int64
+	var err error
+	switch itemType {
+	case entity.ItemTypeMusic:
+		err = h.db.Model(&entity.Music{}).Scopes(library.Scope("music", libraries)).Where("id = ?", id).Count(&count).Error
+	case entity.ItemTypeAlbum:
+		err = h.db.Model(&entity.Album{}).Scopes(library.Scope("album", libraries)).Where("id = ?", id).Count(&count).Error
+	case entity.ItemTypeArtist:
+		err = h.db.Model(&entity.Artist{}).Scopes(artist.Scope(libraries)).Where("artists.id = ?", id).Count(&count).Error
+	}
+	return count > 0, err
+}
+
+// resolveItemType 判断 id 对应的条目类型：歌曲、专辑或艺术家，
+// 不在 libraries 范围内的条目视为不存在
+func (h *SubsonicHandler) resolveItemType(id string, libraries []uint) (entity.ItemType, error) {
+	for _, itemType := range []entity.ItemType{entity.ItemTypeMusic, entity.ItemTypeAlbum, entity.ItemTypeArtist} {
+		ok, err := h.inScope(itemType, id, libraries)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			return itemType, nil
+		}
+	}
+	return "", gorm.ErrRecordNotFound
+}
+
+// writeAnnotationError 将标注相关错误映射为 Subsonic 错误码
+func writeAnnotationError(c *fiber.Ctx, err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return Write(c, NewError(ErrNotFound, "item not found"))
+	}
+	return Write(c, NewError(ErrGeneric, fmt.Sprintf("db error: %v", err)))
+}
+
+// starred 处理 star/unstar：id 可以是歌曲、专辑或艺术家，albumId/artistId 显式指定类型
+func (h *SubsonicHandler) starred(c *fiber.Ctx, starred bool) error {
+	ids := queryValues(c, "id")
+	albumIDs := queryValues(c, "albumId")
+	artistIDs := queryValues(c, "artistId")
+	if len(ids) == 0 && len(albumIDs) == 0 && len(artistIDs) == 0 {
+		return Write(c, NewError(ErrRequiredParam, "missing id, albumId or artistId"))
+	}
+
+	libraries, err := h.accessible(c)
+	if err != nil {
+		return writeScopeError(c, err)
+	}
+	byType := map[entity.ItemType][]string{
+		entity.ItemTypeAlbum:  albumIDs,
+		entity.ItemTypeArtist: artistIDs,
+	}
+	for itemType, typed := range byType {
+		for _, id := range typed {
+			ok, err := h.inScope(itemType, id, libraries)
+			if err != nil {
+				return writeAnnotationError(c, err)
+			}
+			if !ok {
+				return writeAnnotationError(c, gorm.ErrRecordNotFound)
+			}
+		}
+	}
+	for _, id := range ids {
+		itemType, err := h.resolveItemType(id, libraries)
+		if err != nil {
+			return writeAnnotationError(c, err)
+		}
+		byType[itemType] = append(byType[itemType], id)
+	}
+
+	userID := CurrentUser(c).ID
+	for _, itemType := range []entity.ItemType{entity.ItemTypeMusic, entity.ItemTypeAlbum, entity.ItemTypeArtist} {
+		if err := entity.SetStarred(h.db, userID, itemType, byType[itemType], starred); err != nil {
+			return writeAnnotationError(c, err)
+		}
+	}
+	return Write(c, NewResponse())
+}
+
+// GET /rest/star.view?id=songId&albumId=albumId&artistId=artistId
+func (h *SubsonicHandler) HandleStar(c *fiber.Ctx) error {
+	return h.starred(c, true)
+}
+
+// GET /rest/unstar.view?id=songId&albumId=albumId&artistId=artistId
+func (h *SubsonicHandler) HandleUnstar(c *fiber.Ctx) error {
+	return h.starred(c, false)
+}
+
+// GET /rest/setRating.view?id=xxx&rating=0..5（0 表示清除评分）
+func (h *SubsonicHandler) HandleSetRating(c *fiber.Ctx) error {
+	id := c.Query("id")
+	if id == "" {
+		return Write(c, NewError(ErrRequiredParam, "missing id"))
+	}
+	rating, err := strconv.Atoi(c.Query("rating"))
+	if err != nil || rating < 0 || rating > 5 {
+		return Write(c, NewError(ErrRequiredParam, "rating must be between 0 and 5"))
+	}
+
+	libraries, err := h.accessible(c)
+	if err != nil {
+		return writeScopeError(c, err)
+	}
+	itemType, err := h.resolveItemType(id, libraries)
+	if err != nil {
+		return writeAnnotationError(c, err)
+	}
+	if err := entity.SetRating(h.db, CurrentUser(c).ID, itemType, id, rating); err != nil {
+		return writeAnnotationError(c, err)
+	}
+	return Write(c, NewResponse())
+}
+
+// loadStarred 读取当前用户收藏的艺术家、专辑和歌曲，按收藏时间倒序，只包含 libraries 中的条目
+func (h *SubsonicHandler) loadStarred(userID string, libraries []uint) (*Starred, error) {
+	result := &Starred{}
+
+	var artists []entity.Artist
+	if err := h.db.Select("artists.*").Joins("JOIN annotations ON annotations.item_id = artists.id").
+		Scopes(artist.Scope(libraries)).
+		Where("annotations.user_id = ? AND annotations.item_type = ? AND annotations.starred_at IS NOT NULL",
+			userID, entity.ItemTypeArtist).
+		Order("annotations.starred_at DESC").
+		Find(&artists).Error; err != nil {
+		return nil, err
+	}
+	artistList, err := h.toArtists(libraries, artists)
+	if err != nil {
+		return nil, err
+	}
+	result.Artists = artistList
+
+	var albums []entity.Album
+	if err := h.db.Select("album.*").Joins("JOIN annotations ON annotations.item_id = album.id").
+		Scopes(library.Scope("album", libraries)).
+		Where("annotations.user_id = ? AND annotations.item_type = ? AND annotations.starred_at IS NOT NULL",
+			userID, entity.ItemTypeAlbum).
+		Order("annotations.starred_at DESC").
+		Find(&albums).Error; err != nil {
+		return nil, err
+	}
+	list, err := h.toAlbums(albums)
+	if err != nil {
+		return nil, err
+	}
+	result.Albums = list
+
+	var musics []entity.Music
+	if err := h.db.Preload("Album").
+		Select("music.*").
+		Joins("JOIN annotations ON annotations.item_id = music.id").
+		Scopes(library.Scope("music", libraries)).
+		Where("annotations.user_id = ? AND annotations.item_type = ? AND annotations.starred_at IS NOT NULL",
+			userID, entity.ItemTypeMusic).
+		Order("annotations.starred_at DESC").
+		Find(&musics).Error; err != nil {
+		return nil, err
+	}
+	for _, m := range musics {
+		result.Songs = append(result.Songs, toSong(m))
+	}
+	return result, nil
+}
+
+// GET /rest/getStarred.view
+func (h *SubsonicHandler) HandleGetStarred(c *fiber.Ctx) error {
+	libraries, err := h.libraryScope(c)
+	if err != nil {
+		return writeScopeError(c, err)
+	}
+	starred, err := h.loadStarred(CurrentUser(c).ID, libraries)
+	if err != nil {
+		return writeAnnotationError(c, err)
+	}
+	resp := NewResponse()
+	resp.Starred = starred
+	return h.write(c, resp)
+}
+
+// GET /rest/getStarred2.view
+func (h *SubsonicHandler) HandleGetStarred2(c *fiber.Ctx) error {
+	libraries, err := h.libraryScope(c)
+	if err != nil {
+		return writeScopeError(c, err)
+	}
+	starred, err := h.loadStarred(CurrentUser(c).ID, libraries)
+	if err != nil {
+		return writeAnnotationError(c, err)
+	}
+	resp := NewResponse()
+	resp.Starred2 = starred
+	return h.write(c, resp)
+}
+
+// GET /rest/scrobble.view?id=songId&time=ms&submission=true
+// id/time 可重复以批量提交离线播放记录；submission=false 表示正在播放
+func (h *SubsonicHandler) HandleScrobble(c *fiber.Ctx) error {
+	ids := queryValues(c, "id")
+	if len(ids) == 0 {
+		return Write(c, NewError(ErrRequiredParam, "missing id"))
+	}
+	times := queryValues(c, "time")
+	submission := c.Query("submission") != "false"
+
+	libraries, err := h.accessible(c)
+	if err != nil {
+		return writeScopeError(c, err)
+	}
+	// 无权访问的歌曲视为不存在，不记录播放也不出现在正在播放中
+	var musics []entity.Music
+	if err := h.db.Scopes(library.Scope("music", libraries)).Where("id IN ?", ids).Find(&musics).Error; err != nil {
+		return writeAnnotationError(c, err)
+	}
+	byID := make(map[string]*entity.Music, len(musics))
+	for i := range musics {
+		byID[musics[i].ID] = &musics[i]
+	}
+
+	user := CurrentUser(c)
+	for i, id := range ids {
+		music, ok := byID[id]
+		if !ok {
+			return Write(c, NewError(ErrNotFound, "song not found: "+id))
+		}
+		playedAt := time.Now()
+		if i < len(times) {
+			if ms, err := strconv.ParseInt(times[i], 10, 64); err == nil && ms > 0 {
+				playedAt = time.UnixMilli(ms)
+			}
+		}
+		if err := entity.RecordPlay(h.db, user.ID, music, playedAt, c.Query("c"), submission); err != nil {
+			return writeAnnotationError(c, err)
+		}
+		if submission {
+			nowplaying.Default.Clear(user.ID, c.Query("c"), music.ID)
+		} else {
+			nowplaying.Default.Set(user.ID, user.Username, c.Query("c"), music.ID, music.Duration)
+		}
+	}
+	return Write(c, NewResponse())
+}
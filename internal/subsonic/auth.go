@@ -1,10 +1,13 @@
 package subsonic
 
 import (
+	"crypto/md5"
+	"crypto/subtle"
 	"encoding/hex"
 	"errors"
 	"net/url"
 	"strings"
+	"time"
 
 	"saboriman-music/internal/entity"
 
@@ -12,22 +15,53 @@ import (
 	"gorm.io/gorm"
 )
 
+// UserLocalKey 认证中间件在 Fiber Locals 中保存 *entity.User 的键
+const UserLocalKey = "subsonicUser"
+
 type Auth struct {
 	Username string
 	Password string // raw password (支持 enc:xxx 解码)
+	Token    string // t = md5(password + salt)
+	Salt     string // s
+	APIKey   string // OpenSubsonic apiKey
 	Client   string
 	Version  string
 }
 
+// AuthError 认证失败，Code 为 Subsonic 错误码
+type AuthError struct {
+	Code    int
+	Message string
+}
+
+func (e *AuthError) Error() string {
+	return e.Message
+}
+
+func authError(code int, message string) error {
+	return &AuthError{Code: code, Message: message}
+}
+
 // 解析通用查询参数，并解码 enc: 前缀的密码
 func ParseAuth(q url.Values) (*Auth, error) {
 	username := strings.TrimSpace(q.Get("u"))
 	client := strings.TrimSpace(q.Get("c"))
 	version := strings.TrimSpace(q.Get("v"))
 	password := q.Get("p")
+	token := strings.ToLower(strings.TrimSpace(q.Get("t")))
+	salt := q.Get("s")
+	apiKey := strings.TrimSpace(q.Get("apiKey"))
 
-	if username == "" || password == "" {
-		return nil, errors.New("missing required auth params (u,p)")
+	// OpenSubsonic：apiKey 不能与 u/p/t 同时出现
+	if apiKey != "" {
+		if username != "" || password != "" || token != "" {
+			return nil, authError(ErrConflictingAuth, "apiKey cannot be combined with u, p or t")
+		}
+		return &Auth{APIKey: apiKey, Client: client, Version: version}, nil
+	}
+
+	if username == "" || (password == "" && (token == "" || salt == "")) {
+		return nil, authError(ErrAuthFailed, "missing required auth params (u,p or u,t,s)")
 	}
 	// 支持 Subsonic 的 enc:HEX 编码密码
 	if strings.HasPrefix(password, "enc:") {
@@ -40,42 +74,108 @@ func ParseAuth(q url.Values) (*Auth, error) {
 	return &Auth{
 		Username: username,
 		Password: password,
+		Token:    token,
+		Salt:     salt,
 		Client:   client,
 		Version:  version,
 	}, nil
 }
 
-// 从 Fiber 构造 url.Values 并解析
+// 从 Fiber 构造 url.Values 并解析（同时支持 POST 表单参数）
 func ParseAuthFromFiber(c *fiber.Ctx) (*Auth, error) {
+	return ParseAuth(requestValues(c))
+}
+
+// requestValues 合并查询参数与表单参数
+func requestValues(c *fiber.Ctx) url.Values {
 	q := url.Values{}
+	c.Request().PostArgs().VisitAll(func(k, v []byte) {
+		q.Set(string(k), string(v))
+	})
 	for k, v := range c.Queries() {
 		q.Set(k, v)
 	}
-	return ParseAuth(q)
+	return q
 }
 
-// 校验用户名 + 密码，返回用户或错误
+// 校验用户名 + 密码 / token / apiKey，返回用户或错误
 func ValidateAuth(db *gorm.DB, a *Auth) (*entity.User, error) {
 	if a == nil {
-		return nil, errors.New("auth missing")
+		return nil, authError(ErrAuthFailed, "auth missing")
+	}
+	if a.APIKey != "" {
+		return validateAPIKey(db, a.APIKey)
 	}
 
 	var user entity.User
 	// 支持用户名或邮箱
 	if err := db.Where("username = ? OR email = ?", a.Username, a.Username).First(&user).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			return nil, errors.New("user not found")
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, authError(ErrAuthFailed, "wrong username or password")
 		}
 		return nil, err
 	}
 	if !user.IsActive() {
-		return nil, errors.New("user disabled")
+		return nil, authError(ErrUserNotAuthorized, "user disabled")
+	}
+
+	var creds []entity.SubsonicCredential
+	if err := db.Where("user_id = ? AND type = ?", user.ID, entity.CredentialAppPassword).Find(&creds).Error; err != nil {
+		return nil, err
+	}
+
+	if a.Password != "" {
+		// 明文密码：登录密码或任一应用密码均可
+		if user.CheckPassword(a.Password) {
+			return &user, nil
+		}
+		for _, cred := range creds {
+			if subtle.ConstantTimeCompare([]byte(cred.Secret), []byte(a.Password)) == 1 {
+				touchCredential(db, &cred)
+				return &user, nil
+			}
+		}
+		return nil, authError(ErrAuthFailed, "wrong username or password")
+	}
+
+	// token 认证：登录密码是 bcrypt 哈希无法参与计算，只能使用应用密码
+	if len(creds) == 0 {
+		return nil, authError(ErrAuthFailed, "token authentication requires a Subsonic app password")
 	}
-	// 校验明文密码（支持 enc:）
-	if !user.CheckPassword(a.Password) {
-		return nil, errors.New("invalid credentials")
+	for _, cred := range creds {
+		sum := md5.Sum([]byte(cred.Secret + a.Salt))
+		if subtle.ConstantTimeCompare([]byte(hex.EncodeToString(sum[:])), []byte(a.Token)) == 1 {
+			touchCredential(db, &cred)
+			return &user, nil
+		}
 	}
-	return &user, nil
+	return nil, authError(ErrAuthFailed, "wrong username or password")
+}
+
+// validateAPIKey 校验 OpenSubsonic apiKey
+func validateAPIKey(db *gorm.DB, key string) (*entity.User, error) {
+	var cred entity.SubsonicCredential
+	if err := db.Preload("User").
+		Where("type = ? AND secret = ?", entity.CredentialAPIKey, key).
+		First(&cred).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, authError(ErrInvalidAPIKey, "invalid api key")
+		}
+		return nil, err
+	}
+	if cred.User == nil {
+		return nil, authError(ErrInvalidAPIKey, "invalid api key")
+	}
+	if !cred.User.IsActive() {
+		return nil, authError(ErrUserNotAuthorized, "user disabled")
+	}
+	touchCredential(db, &cred)
+	return cred.User, nil
+}
+
+// touchCredential 记录凭据最后使用时间（失败不影响认证）
+func touchCredential(db *gorm.DB, cred *entity.SubsonicCredential) {
+	db.Model(&entity.SubsonicCredential{}).Where("id = ?", cred.ID).Update("last_used_at", time.Now())
 }
 
 // 便捷方法：直接从 Fiber 校验并返回用户
@@ -86,3 +186,47 @@ func ValidateAuthFromFiber(db *gorm.DB, c *fiber.Ctx) (*entity.User, error) {
 	}
 	return ValidateAuth(db, a)
 }
+
+// AuthMiddleware Subsonic 认证中间件，校验失败返回错误码 40（或 OpenSubsonic 的 43/44）
+func AuthMiddleware(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// 表单参数并入查询参数，处理器统一通过 c.Query 读取
+		mergeFormValues(c)
+
+		user, err := ValidateAuthFromFiber(db, c)
+		if err != nil {
+			code := ErrGeneric
+			var authErr *AuthError
+			if errors.As(err, &authErr) {
+				code = authErr.Code
+			}
+			return Write(c, NewError(code, err.Error()))
+		}
+
+		// 与 JSON API 的 AuthMiddleware 保持一致的 Locals
+		c.Locals(UserLocalKey, user)
+		c.Locals("userID", user.ID)
+		c.Locals("username", user.Username)
+		c.Locals("role", user.Role)
+
+		return c.Next()
+	}
+}
+
+// mergeFormValues 将 POST 表单参数追加到查询参数中（OpenSubsonic formPost），
+// 较长的参数（如 updatePlaylist、savePlayQueue 的大量 id）可以放在请求体中
+func mergeFormValues(c *fiber.Ctx) {
+	if c.Method() != fiber.MethodPost {
+		return
+	}
+	args := c.Request().URI().QueryArgs()
+	c.Request().PostArgs().VisitAll(func(k, v []byte) {
+		args.AddBytesKV(k, v)
+	})
+}
+
+// CurrentUser 获取认证中间件写入的当前用户
+func CurrentUser(c *fiber.Ctx) *entity.User {
+	user, _ := c.Locals(UserLocalKey).(*entity.User)
+	return user
+}
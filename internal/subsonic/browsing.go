@@ -0,0 +1,270 @@
+package subsonic
+
+import (
+	"errors"
+	"fmt"
+
+	"saboriman-music/internal/artist"
+	"saboriman-music/internal/entity"
+	"saboriman-music/internal/library"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// artistRoles 艺术家列表只包含歌曲艺术家与专辑艺术家，不包含仅作曲或表演的艺术家
+var artistRoles = []entity.ArtistRole{entity.ArtistRoleArtist, entity.ArtistRoleAlbumArtist}
+
+// findArtist 查询音乐库范围内的艺术家，libraries 为 nil 时包含全部音乐库
+func (h *SubsonicHandler) findArtist(id string, libraries []uint) (*entity.Artist, error) {
+	var a entity.Artist
+	err := h.db.Scopes(artist.Scope(libraries)).First(&a, "artists.id = ?", id).Error
+	return &a, err
+}
+
+// toArtists 批量映射艺术家并附带音乐库范围内的专辑数
+func (h *SubsonicHandler) toArtists(libraries []uint, artists []entity.Artist) ([]Artist, error) {
+	ids := make([]string, 0, len(artists))
+	for _, a := range artists {
+		ids = append(ids, a.ID)
+	}
+	stats, err := artist.LoadStats(h.db, libraries, ids)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]Artist, 0, len(artists))
+	for _, a := range artists {
+		result = append(result, Artist{ID: a.ID, Name: a.Name, AlbumCount: stats[a.ID].AlbumCount})
+	}
+	return result, nil
+}
+
+// toAlbums 批量映射专辑并附带歌曲数与时长
+func (h *SubsonicHandler) toAlbums(albums []entity.Album) ([]Album, error) {
+	stats, err := h.albumStats(albums)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]Album, 0, len(albums))
+	for _, a := range albums {
+		s := stats[a.ID]
+		result = append(result, toAlbum(a, s.SongCount, s.Duration))
+	}
+	return result, nil
+}
+
+// GET /rest/getArtist.view?id=artistId
+func (h *SubsonicHandler) HandleGetArtist(c *fiber.Ctx) error {
+	id := c.Query("id")
+	if id == "" {
+		return Write(c, NewError(ErrRequiredParam, "missing id"))
+	}
+
+	libraries, err := h.accessible(c)
+	if err != nil {
+		return writeScopeError(c, err)
+	}
+	found, err := h.findArtist(id, libraries)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return Write(c, NewError(ErrNotFound, "artist not found"))
+	} else if err != nil {
+		return Write(c, NewError(ErrGeneric, fmt.Sprintf("db error: %v", err)))
+	}
+
+	// 专辑艺术家中有该艺术家，或专辑中包含该艺术家的歌曲
+	var albums []entity.Album
+	if err := h.db.
+		Scopes(artist.AlbumScope(libraries, found.ID, artistRoles...)).
+		Order("release_date ASC").
+		Order("name ASC").
+		Find(&albums).Error; err != nil {
+		return Write(c, NewError(ErrGeneric, fmt.Sprintf("db error: %v", err)))
+	}
+	list, err := h.toAlbums(albums)
+	if err != nil {
+		return Write(c, NewError(ErrGeneric, fmt.Sprintf("db error: %v", err)))
+	}
+
+	resp := NewResponse()
+	resp.Artist = &ArtistResponse{
+		Artist: Artist{ID: found.ID, Name: found.Name, AlbumCount: len(list)},
+		Album:  list,
+	}
+	return h.write(c, resp)
+}
+
+// GET /rest/getAlbumList2.view?type=newest&size=10&offset=0
+func (h *SubsonicHandler) HandleGetAlbumList2(c *fiber.Ctx) error {
+	listType := c.Query("type")
+	if listType == "" {
+		return Write(c, NewError(ErrRequiredParam, "missing type"))
+	}
+	size := c.QueryInt("size", 10)
+	if size <= 0 {
+		size = 10
+	}
+	if size > 500 {
+		size = 500
+	}
+	offset := c.QueryInt("offset", 0)
+	libraries, err := h.libraryScope(c)
+	if err != nil {
+		return writeScopeError(c, err)
+	}
+
+	q := h.db.Model(&entity.Album{}).Scopes(library.Scope("album", libraries))
+	switch listType {
+	case "random":
+		q = q.Order(randomOrder(h.db))
+	case "newest":
+		q = q.Order("album.created_at DESC")
+	case "alphabeticalByName":
+		q = q.Order("album.name ASC")
+	case "alphabeticalByArtist":
+		q = q.Order("album.artist_name ASC").Order("album.name ASC")
+	case "frequent", "recent":
+		// 当前用户播放过的专辑：frequent 按播放次数，recent 按最后播放时间
+		q = q.Joins("JOIN annotations ON annotations.item_id = album.id").
+			Where("annotations.user_id = ? AND annotations.item_type = ? AND annotations.play_count > 0",
+				CurrentUser(c).ID, entity.ItemTypeAlbum)
+		if listType == "frequent" {
+			q = q.Order("annotations.play_count DESC")
+		}
+		q = q.Order("annotations.played_at DESC")
+	case "byYear":
+		fromYear := c.QueryInt("fromYear", 0)
+		toYear := c.QueryInt("toYear", 0)
+		if fromYear == 0 || toYear == 0 {
+			return Write(c, NewError(ErrRequiredParam, "missing fromYear or toYear"))
+		}
+		// fromYear > toYear 时按年份倒序
+		order := "album.release_date ASC"
+		if fromYear > toYear {
+			fromYear, toYear = toYear, fromYear
+			order = "album.release_date DESC"
+		}
+		q = q.Where("album.release_date >= ? AND album.release_date < ?",
+			fmt.Sprintf("%04d-01-01", fromYear), fmt.Sprintf("%04d-01-01", toYear+1)).
+			Order(order)
+	case "byGenre":
+		genre := c.Query("genre")
+		if genre == "" {
+			return Write(c, NewError(ErrRequiredParam, "missing genre"))
+		}
+		q = q.Where("album.genre = ?", genre).Order("album.name ASC")
+	case "starred":
+		// 当前用户收藏的专辑
+		q = q.Where("album.id IN (?)", entity.StarredIDs(h.db, CurrentUser(c).ID, entity.ItemTypeAlbum)).
+			Order("album.name ASC")
+	default:
+		return Write(c, NewError(ErrGeneric, "unsupported list type: "+listType))
+	}
+
+	var albums []entity.Album
+	if err := q.Select("album.*").Offset(offset).Limit(size).Find(&albums).Error; err != nil {
+		return Write(c, NewError(ErrGeneric, fmt.Sprintf("db error: %v", err)))
+	}
+	list, err := h.toAlbums(albums)
+	if err != nil {
+		return Write(c, NewError(ErrGeneric, fmt.Sprintf("db error: %v", err)))
+	}
+
+	resp := NewResponse()
+	resp.AlbumList2 = &AlbumList2{Album: list}
+	return h.write(c, resp)
+}
+
+// GET /rest/getSong.view?id=songId
+func (h *SubsonicHandler) HandleGetSong(c *fiber.Ctx) error {
+	id := c.Query("id")
+	if id == "" {
+		return Write(c, NewError(ErrRequiredParam, "missing id"))
+	}
+
+	var music entity.Music
+	if err := h.db.Preload("Album").First(&music, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return Write(c, NewError(ErrNotFound, "song not found"))
+		}
+		return Write(c, NewError(ErrGeneric, fmt.Sprintf("db error: %v", err)))
+	}
+	if !h.canAccess(c, music.LibraryID) {
+		return Write(c, NewError(ErrNotFound, "song not found"))
+	}
+
+	song := toSong(music)
+	resp := NewResponse()
+	resp.Song = &song
+	return h.write(c, resp)
+}
+
+// GET /rest/getGenres.view
+func (h *SubsonicHandler) HandleGetGenres(c *fiber.Ctx) error {
+	libraries, err := h.accessible(c)
+	if err != nil {
+		return writeScopeError(c, err)
+	}
+	var rows []struct {
+		Genre      string
+		SongCount  int
+		AlbumCount int
+	}
+	if err := h.db.Model(&entity.Music{}).
+		Scopes(library.Scope("music", libraries)).
+		Select("genre, COUNT(*) AS song_count, COUNT(DISTINCT album_id) AS album_count").
+		Where("genre IS NOT NULL AND genre <> ''").
+		Group("genre").
+		Order("genre ASC").
+		Scan(&rows).Error; err != nil {
+		return Write(c, NewError(ErrGeneric, fmt.Sprintf("db error: %v", err)))
+	}
+
+	genres := make([]Genre, 0, len(rows))
+	for _, r := range rows {
+		genres = append(genres, Genre{SongCount: r.SongCount, AlbumCount: r.AlbumCount, Value: r.Genre})
+	}
+
+	resp := NewResponse()
+	resp.Genres = &Genres{Genre: genres}
+	return h.write(c, resp)
+}
+
+// GET /rest/getSongsByGenre.view?genre=Rock&count=10&offset=0
+func (h *SubsonicHandler) HandleGetSongsByGenre(c *fiber.Ctx) error {
+	genre := c.Query("genre")
+	if genre == "" {
+		return Write(c, NewError(ErrRequiredParam, "missing genre"))
+	}
+	count := c.QueryInt("count", 10)
+	if count <= 0 {
+		count = 10
+	}
+	if count > 500 {
+		count = 500
+	}
+	libraries, err := h.libraryScope(c)
+	if err != nil {
+		return writeScopeError(c, err)
+	}
+
+	var musics []entity.Music
+	if err := h.db.Preload("Album").
+		Scopes(library.Scope("music", libraries)).
+		Where("genre = ?", genre).
+		Order("album_id ASC").
+		Order("COALESCE(disc_number, 0) ASC").
+		Order("COALESCE(track_number, 0) ASC").
+		Offset(c.QueryInt("offset", 0)).
+		Limit(count).
+		Find(&musics).Error; err != nil {
+		return Write(c, NewError(ErrGeneric, fmt.Sprintf("db error: %v", err)))
+	}
+
+	songs := make([]Song, 0, len(musics))
+	for _, m := range musics {
+		songs = append(songs, toSong(m))
+	}
+	resp := NewResponse()
+	resp.SongsByGenre = &SongsResponse{Song: songs}
+	return h.write(c, resp)
+}
@@ -0,0 +1,122 @@
+package subsonic
+
+import (
+	"path/filepath"
+	"strings"
+	"time"
+
+	"saboriman-music/config"
+	"saboriman-music/internal/artist"
+	"saboriman-music/internal/entity"
+	"saboriman-music/internal/library"
+	"saboriman-music/internal/utils"
+
+	"gorm.io/gorm"
+)
+
+// artistID 返回歌曲或专辑艺术家文本中主要艺术家的 ID，如 "A feat. B" 对应 A
+func artistID(name string) string {
+	return entity.ArtistID(artist.Primary(name))
+}
+
+// randomOrder 返回当前数据库方言的随机排序表达式
+func randomOrder(db *gorm.DB) string {
+	if db.Dialector.Name() == "mysql" {
+		return "RAND()"
+	}
+	return "RANDOM()"
+}
+
+// relativePath 返回相对于所属音乐库根目录的路径，客户端用它展示目录结构
+func relativePath(fullPath string) string {
+	if _, rel, ok := library.Find(fullPath); ok {
+		return filepath.ToSlash(rel)
+	}
+	if config.AppConfig == nil || config.AppConfig.MusicFolder == "" {
+		return fullPath
+	}
+	rel, err := filepath.Rel(config.AppConfig.MusicFolder, fullPath)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return fullPath
+	}
+	return filepath.ToSlash(rel)
+}
+
+// formatTime 按 Subsonic 约定输出 ISO8601 时间
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+// toSong 将音乐实体映射为 Subsonic <song>
+func toSong(m entity.Music) Song {
+	song := Song{
+		ID:           m.ID,
+		Parent:       m.AlbumID,
+		Title:        m.Title,
+		Artist:       m.Artist,
+		Track:        m.TrackNumber,
+		DiscNumber:   m.DiscNumber,
+		Year:         m.Year,
+		Genre:        m.Genre,
+		Duration:     m.Duration,
+		Size:         m.Size,
+		ContentType:  utils.MusicContentType(m.Suffix, m.Codec),
+		Suffix:       m.Suffix,
+		BitRate:      m.BitRate,
+		Path:         relativePath(m.FileUrl),
+		SamplingRate: m.SampleRate,
+		BitDepth:     m.BitDepth,
+		ChannelCount: m.Channels,
+		AlbumID:      m.AlbumID,
+		ArtistID:     artistID(m.Artist),
+		Created:      formatTime(m.CreatedAt),
+		Type:         "music",
+
+		MusicBrainzID: m.MbzTrackID,
+		BPM:           m.BPM,
+	}
+	if m.Album != nil {
+		song.Album = m.Album.Name
+	}
+	// 整轨文件中的音轨：大小按码率估算，播放时截取并转码为 FLAC
+	if m.CueFile != "" {
+		song.Size = int64(m.BitRate) * 1000 / 8 * int64(m.Duration)
+		if !strings.EqualFold(m.Suffix, "flac") {
+			song.TranscodedSuffix = "flac"
+			song.TranscodedContentType = utils.AudioContentType("flac")
+		}
+	}
+	// getCoverArt 以专辑 ID 作为封面 ID
+	if m.AlbumID != "" {
+		song.CoverArt = m.AlbumID
+	}
+	if m.TrackGain != nil || m.AlbumGain != nil {
+		song.ReplayGain = &ReplayGain{TrackGain: m.TrackGain, AlbumGain: m.AlbumGain, TrackPeak: m.TrackPeak, AlbumPeak: m.AlbumPeak}
+	}
+	return song
+}
+
+// toAlbum 将专辑实体映射为 Subsonic <album>，songCount/duration 由调用方统计后传入
+func toAlbum(a entity.Album, songCount, duration int) Album {
+	album := Album{
+		ID:        a.ID,
+		Name:      a.Name,
+		Artist:    a.ArtistName,
+		ArtistID:  artistID(a.ArtistName),
+		CoverArt:  a.ID,
+		SongCount: songCount,
+		Duration:  duration,
+		Created:   formatTime(a.CreatedAt),
+		Genre:     a.Genre,
+
+		IsCompilation: a.IsCompilation,
+		MusicBrainzID: a.MbzAlbumID,
+	}
+	if a.ReleaseDate != nil {
+		album.Year = a.ReleaseDate.Year()
+	}
+	return album
+}
@@ -4,5 +4,8 @@ const (
 	ErrGeneric           = 0
 	ErrRequiredParam     = 10
 	ErrAuthFailed        = 40
+	ErrConflictingAuth   = 43 // OpenSubsonic: 同时提供了多种认证方式
+	ErrInvalidAPIKey     = 44 // OpenSubsonic: apiKey 无效
 	ErrUserNotAuthorized = 50
+	ErrNotFound          = 70
 )
@@ -0,0 +1,325 @@
+package subsonic
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"saboriman-music/internal/entity"
+	"saboriman-music/internal/library"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// directoryID 根据音乐库与相对其根目录的路径生成稳定的目录 ID，
+// 重新扫描或重启后同一目录 ID 不变，客户端缓存的目录仍然可用
+func directoryID(libraryID uint, rel string) string {
+	sum := md5.Sum([]byte(fmt.Sprintf("dir:%d:%s", libraryID, filepath.ToSlash(rel))))
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// folderTree 由一个音乐库中已扫描的 Music.FileUrl 推导出的目录树
+type folderTree struct {
+	lib     entity.Library
+	ids     map[string]string   // 目录 ID -> 相对路径（根目录为 "."）
+	subdirs map[string][]string // 相对路径 -> 直接子目录相对路径
+	files   map[string][]string // 相对路径 -> 直接包含的音乐文件完整路径
+}
+
+// id 返回目录树中某个目录的 ID
+func (t *folderTree) id(rel string) string {
+	return directoryID(t.lib.ID, rel)
+}
+
+// folderCache 按音乐库缓存目录树；音乐库的名称、根目录或 ContentChangedAt 变化后重建
+type folderCache struct {
+	mu    sync.Mutex
+	trees map[uint]*folderTree
+}
+
+// get 返回仍然有效的缓存目录树
+func (c *folderCache) get(lib entity.Library) *folderTree {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	tree := c.trees[lib.ID]
+	if tree == nil || tree.lib.Name != lib.Name || tree.lib.Path != lib.Path ||
+		!sameTime(tree.lib.ContentChangedAt, lib.ContentChangedAt) {
+		return nil
+	}
+	return tree
+}
+
+// put 缓存音乐库的目录树
+func (c *folderCache) put(tree *folderTree) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.trees[tree.lib.ID] = tree
+}
+
+// sameTime 比较两个可能为空的时间
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+// loadFolderTrees 为每个音乐库构建或取出缓存的目录树
+func (h *SubsonicHandler) loadFolderTrees(libs []entity.Library) ([]*folderTree, error) {
+	trees := make([]*folderTree, 0, len(libs))
+	for _, lib := range libs {
+		tree := h.folders.get(lib)
+		if tree == nil {
+			var err error
+			if tree, err = h.loadFolderTree(lib); err != nil {
+				return nil, err
+			}
+			h.folders.put(tree)
+		}
+		trees = append(trees, tree)
+	}
+	return trees, nil
+}
+
+// loadFolderTree 读取音乐库中所有已扫描文件路径并构建目录树，根目录之外的文件会被忽略
+func (h *SubsonicHandler) loadFolderTree(lib entity.Library) (*folderTree, error) {
+	var paths []string
+	if err := h.db.Model(&entity.Music{}).Where("library_id = ?", lib.ID).Pluck("file_url", &paths).Error; err != nil {
+		return nil, err
+	}
+
+	tree := &folderTree{
+		lib:     lib,
+		ids:     map[string]string{directoryID(lib.ID, "."): "."},
+		subdirs: map[string][]string{},
+		files:   map[string][]string{},
+	}
+	for _, p := range paths {
+		rel, err := filepath.Rel(lib.Path, p)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		dir := filepath.Dir(rel)
+		tree.files[dir] = append(tree.files[dir], p)
+
+		// 逐级登记父目录，直到根目录或已登记过的目录
+		for dir != "." {
+			id := tree.id(dir)
+			if _, ok := tree.ids[id]; ok {
+				break
+			}
+			tree.ids[id] = dir
+			parent := filepath.Dir(dir)
+			tree.subdirs[parent] = append(tree.subdirs[parent], dir)
+			dir = parent
+		}
+	}
+	return tree, nil
+}
+
+// dirChild 将子目录映射为 isDir=true 的 <child>
+func dirChild(tree *folderTree, rel, parentID string) Song {
+	return Song{
+		ID:     tree.id(rel),
+		Parent: parentID,
+		IsDir:  true,
+		Title:  filepath.Base(rel),
+	}
+}
+
+// songsInDirBatch 查询目录中的歌曲时每条 IN 语句包含的路径数
+const songsInDirBatch = 500
+
+// songsInDir 查询目录下直接包含的歌曲，按碟号/轨号/标题排序
+func (h *SubsonicHandler) songsInDir(tree *folderTree, rel string) ([]Song, error) {
+	files := tree.files[rel]
+	if len(files) == 0 {
+		return []Song{}, nil
+	}
+	var musics []entity.Music
+	for start := 0; start < len(files); start += songsInDirBatch {
+		end := min(start+songsInDirBatch, len(files))
+		var batch []entity.Music
+		if err := h.db.Preload("Album").Where("file_url IN ?", files[start:end]).Find(&batch).Error; err != nil {
+			return nil, err
+		}
+		musics = append(musics, batch...)
+	}
+	sort.SliceStable(musics, func(i, j int) bool {
+		a, b := musics[i], musics[j]
+		if a.DiscNumber != b.DiscNumber {
+			return a.DiscNumber < b.DiscNumber
+		}
+		if a.TrackNumber != b.TrackNumber {
+			return a.TrackNumber < b.TrackNumber
+		}
+		return a.Title < b.Title
+	})
+	parentID := tree.id(rel)
+	songs := make([]Song, 0, len(musics))
+	for _, m := range musics {
+		song := toSong(m)
+		song.Parent = parentID
+		songs = append(songs, song)
+	}
+	return songs, nil
+}
+
+// GET /rest/getMusicFolders.view
+// 每个当前用户可以访问的音乐库对应一个音乐文件夹
+func (h *SubsonicHandler) HandleGetMusicFolders(c *fiber.Ctx) error {
+	ids, err := h.accessible(c)
+	if err != nil {
+		return writeScopeError(c, err)
+	}
+	libs, err := h.libraries(ids)
+	if err != nil {
+		return Write(c, NewError(ErrGeneric, fmt.Sprintf("db error: %v", err)))
+	}
+	folders := make([]MusicFolder, 0, len(libs))
+	for _, lib := range libs {
+		folders = append(folders, MusicFolder{ID: int(lib.ID), Name: lib.Name})
+	}
+	resp := NewResponse()
+	resp.MusicFolders = &MusicFolders{MusicFolder: folders}
+	return h.write(c, resp)
+}
+
+// GET /rest/getIndexes.view?musicFolderId=1&ifModifiedSince=ms
+func (h *SubsonicHandler) HandleGetIndexes(c *fiber.Ctx) error {
+	ids, err := h.libraryScope(c)
+	if err != nil {
+		return writeScopeError(c, err)
+	}
+	libs, err := h.libraries(ids)
+	if err != nil {
+		return Write(c, NewError(ErrGeneric, fmt.Sprintf("db error: %v", err)))
+	}
+
+	// 以歌曲最后更新时间与音乐库最后增删歌曲的时间中较晚的一个作为 lastModified（毫秒）
+	var lastUpdated struct{ UpdatedAt string }
+	if err := h.db.Model(&entity.Music{}).Scopes(library.Scope("music", ids)).
+		Select("MAX(updated_at) AS updated_at").Scan(&lastUpdated).Error; err != nil {
+		return Write(c, NewError(ErrGeneric, fmt.Sprintf("db error: %v", err)))
+	}
+	lastModified := parseDBTime(lastUpdated.UpdatedAt).UnixMilli()
+	for _, lib := range libs {
+		if lib.ContentChangedAt != nil && lib.ContentChangedAt.UnixMilli() > lastModified {
+			lastModified = lib.ContentChangedAt.UnixMilli()
+		}
+	}
+	if lastModified < 0 {
+		lastModified = 0
+	}
+
+	resp := NewResponse()
+	// 客户端缓存仍然有效时只返回 lastModified
+	if since := int64(c.QueryInt("ifModifiedSince", 0)); since > 0 && lastModified <= since {
+		resp.Indexes = &IndexesResponse{LastModified: lastModified}
+		return h.write(c, resp)
+	}
+
+	trees, err := h.loadFolderTrees(libs)
+	if err != nil {
+		return Write(c, NewError(ErrGeneric, fmt.Sprintf("db error: %v", err)))
+	}
+
+	// 各音乐库根目录下的一级目录作为索引条目
+	artists := []Artist{}
+	children := []Song{}
+	for _, tree := range trees {
+		for _, rel := range tree.subdirs["."] {
+			artists = append(artists, Artist{ID: tree.id(rel), Name: filepath.Base(rel)})
+		}
+		songs, err := h.songsInDir(tree, ".")
+		if err != nil {
+			return Write(c, NewError(ErrGeneric, fmt.Sprintf("db error: %v", err)))
+		}
+		children = append(children, songs...)
+	}
+
+	resp.Indexes = &IndexesResponse{
+		LastModified: lastModified,
+		Index:        buildIndexes(artists),
+		Child:        children,
+	}
+	return h.write(c, resp)
+}
+
+// GET /rest/getMusicDirectory.view?id=directoryId
+func (h *SubsonicHandler) HandleGetMusicDirectory(c *fiber.Ctx) error {
+	id := c.Query("id")
+	if id == "" {
+		return Write(c, NewError(ErrRequiredParam, "missing id"))
+	}
+
+	ids, err := h.accessible(c)
+	if err != nil {
+		return writeScopeError(c, err)
+	}
+	libs, err := h.libraries(ids)
+	if err != nil {
+		return Write(c, NewError(ErrGeneric, fmt.Sprintf("db error: %v", err)))
+	}
+	trees, err := h.loadFolderTrees(libs)
+	if err != nil {
+		return Write(c, NewError(ErrGeneric, fmt.Sprintf("db error: %v", err)))
+	}
+	var tree *folderTree
+	var rel string
+	for _, t := range trees {
+		if r, ok := t.ids[id]; ok {
+			tree, rel = t, r
+			break
+		}
+	}
+	if tree == nil {
+		return Write(c, NewError(ErrNotFound, "directory not found"))
+	}
+
+	dir := &Directory{ID: id, Name: filepath.Base(rel)}
+	if rel == "." {
+		dir.Name = tree.lib.Name
+	} else {
+		dir.Parent = tree.id(filepath.Dir(rel))
+	}
+
+	// 子目录在前（按名称排序），歌曲在后
+	subdirs := append([]string(nil), tree.subdirs[rel]...)
+	sort.Slice(subdirs, func(i, j int) bool {
+		return strings.ToLower(filepath.Base(subdirs[i])) < strings.ToLower(filepath.Base(subdirs[j]))
+	})
+	for _, sub := range subdirs {
+		dir.Child = append(dir.Child, dirChild(tree, sub, id))
+	}
+	songs, err := h.songsInDir(tree, rel)
+	if err != nil {
+		return Write(c, NewError(ErrGeneric, fmt.Sprintf("db error: %v", err)))
+	}
+	dir.Child = append(dir.Child, songs...)
+
+	resp := NewResponse()
+	resp.Directory = dir
+	return h.write(c, resp)
+}
+
+// parseDBTime 解析聚合查询返回的时间字符串（SQLite 与 MySQL 格式不同）
+func parseDBTime(s string) time.Time {
+	layouts := []string{
+		"2006-01-02 15:04:05.999999999-07:00",
+		"2006-01-02 15:04:05.999999999",
+		time.RFC3339Nano,
+		"2006-01-02 15:04:05",
+	}
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
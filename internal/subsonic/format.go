@@ -0,0 +1,55 @@
+package subsonic
+
+import (
+	"encoding/json"
+	"regexp"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// jsonpCallbackPattern 限制 JSONP 回调名为合法的 JS 标识符（可含 . 访问），防止脚本注入
+var jsonpCallbackPattern = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$.]*$`)
+
+// jsonEnvelope 参考实现的 JSON 外层结构：{"subsonic-response": {...}}
+type jsonEnvelope struct {
+	Response Response `json:"subsonic-response"`
+}
+
+// Write 根据请求参数 f=xml|json|jsonp 选择输出格式，默认 XML
+func Write(c *fiber.Ctx, resp Response) error {
+	switch c.Query("f") {
+	case "json":
+		return WriteJSONFiber(c, resp)
+	case "jsonp":
+		callback := c.Query("callback")
+		if !jsonpCallbackPattern.MatchString(callback) {
+			return WriteJSONFiber(c, NewError(ErrRequiredParam, "missing or invalid callback"))
+		}
+		return WriteJSONPFiber(c, resp, callback)
+	default:
+		return WriteXMLFiber(c, resp)
+	}
+}
+
+func WriteJSONFiber(c *fiber.Ctx, resp Response) error {
+	body, err := json.Marshal(jsonEnvelope{Response: resp})
+	if err != nil {
+		return err
+	}
+	c.Type("json", "utf-8")
+	return c.Send(body)
+}
+
+func WriteJSONPFiber(c *fiber.Ctx, resp Response, callback string) error {
+	body, err := json.Marshal(jsonEnvelope{Response: resp})
+	if err != nil {
+		return err
+	}
+	c.Type("js", "utf-8")
+	out := make([]byte, 0, len(callback)+len(body)+3)
+	out = append(out, callback...)
+	out = append(out, '(')
+	out = append(out, body...)
+	out = append(out, ");"...)
+	return c.Send(out)
+}
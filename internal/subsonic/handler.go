@@ -2,9 +2,16 @@ package subsonic
 
 import (
 	"fmt"
+	"io"
+	"os"
 	"path/filepath"
 	"saboriman-music/config"
+	"saboriman-music/internal/artist"
 	"saboriman-music/internal/entity"
+	"saboriman-music/internal/library"
+	"saboriman-music/internal/scanner"
+	"saboriman-music/internal/transcode"
+	"saboriman-music/internal/utils"
 	"sort"
 	"strconv"
 	"strings"
@@ -15,27 +22,25 @@ import (
 
 // MusicHandler 音乐处理器
 type SubsonicHandler struct {
-	db *gorm.DB
+	db      *gorm.DB
+	scans   *scanner.Manager
+	folders *folderCache
 }
 
-// NewMusicHandler 创建音乐处理器
-func NewMusicHandler(db *gorm.DB) *SubsonicHandler {
-	return &SubsonicHandler{db: db}
+// NewMusicHandler 创建音乐处理器，扫描由 scans 管理
+func NewMusicHandler(db *gorm.DB, scans *scanner.Manager) *SubsonicHandler {
+	return &SubsonicHandler{db: db, scans: scans, folders: &folderCache{trees: map[uint]*folderTree{}}}
 }
 
 // GET /rest/ping.view
+// 认证由 AuthMiddleware 完成，能走到这里即认证成功
 func (h *SubsonicHandler) HandlePing(c *fiber.Ctx) error {
-	_, err := ParseAuthFromFiber(c)
 	resp := Response{
 		Status:  "ok",
 		Version: "1.16.1",
 		Ping:    &Ping{},
 	}
-	if err != nil {
-		resp.Status = "failed"
-		resp.Error = &Error{Code: ErrRequiredParam, Message: err.Error()}
-	}
-	return WriteXMLFiber(c, resp)
+	return h.write(c, resp)
 }
 
 // GET /rest/getLicense.view
@@ -45,180 +50,133 @@ func (h *SubsonicHandler) HandleGetLicense(c *fiber.Ctx) error {
 		Version: "1.16.1",
 		License: &License{Valid: true},
 	}
-	return WriteXMLFiber(c, resp)
+	return h.write(c, resp)
 }
 
 // GET /rest/getArtists.view
 func (h *SubsonicHandler) HandleGetArtists(c *fiber.Ctx) error {
-	// 1) 从音乐表中查询所有非空艺术家并去重
-	var artistNames []string
-	if err := h.db.
-		Model(&entity.Music{}).
-		Where("artist IS NOT NULL AND artist <> ''").
-		Pluck("DISTINCT artist", &artistNames).Error; err != nil {
-		return WriteXMLFiber(c, Response{
+	libraries, err := h.libraryScope(c)
+	if err != nil {
+		return writeScopeError(c, err)
+	}
+
+	// 1) 查询音乐库范围内的歌曲艺术家与专辑艺术家
+	var artists []entity.Artist
+	if err := h.db.Scopes(artist.Scope(libraries, artistRoles...)).Find(&artists).Error; err != nil {
+		return Write(c, Response{
 			Status: "failed", Version: "1.16.1",
 			Error: &Error{Code: ErrGeneric, Message: fmt.Sprintf("db error: %v", err)},
 		})
 	}
 
-	// 2) 映射为 Subsonic Artist（ID 用规范化名称或哈希）
-	// 如果你有 Artist 表和真实 ID，可改为用真实 ID
-	makeID := func(name string) string {
-		// 简单规范化 ID（避免空格和大小写差异）
-		return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(name), " ", "-"))
-	}
-	all := make([]Artist, 0, len(artistNames))
-	seen := map[string]struct{}{}
-	for _, name := range artistNames {
-		n := strings.TrimSpace(name)
-		if n == "" {
-			continue
-		}
-		id := makeID(n)
-		if _, ok := seen[id]; ok {
-			continue
-		}
-		seen[id] = struct{}{}
-		all = append(all, Artist{ID: id, Name: n})
-	}
-
-	// 3) 分组：A-Z、0-9、其他(#)
-	groups := map[string][]Artist{}
-	for _, a := range all {
-		initial := "#"
-		runes := []rune(strings.TrimSpace(a.Name))
-		if len(runes) > 0 {
-			up := strings.ToUpper(string(runes[0]))
-			if up[0] >= 'A' && up[0] <= 'Z' {
-				initial = up
-			} else if up[0] >= '0' && up[0] <= '9' {
-				initial = "0-9"
-			} else {
-				initial = "#"
-			}
-		}
-		groups[initial] = append(groups[initial], a)
+	// 2) 映射为 Subsonic Artist 并统计专辑数
+	all, err := h.toArtists(libraries, artists)
+	if err != nil {
+		return Write(c, NewError(ErrGeneric, fmt.Sprintf("db error: %v", err)))
 	}
 
-	// 4) 分组名排序，组内按名称排序
-	keys := make([]string, 0, len(groups))
-	for k := range groups {
-		keys = append(keys, k)
-	}
-	sort.Strings(keys)
+	// 3) 按首字母分组并排序
+	indexes := buildIndexes(all)
 
-	indexes := make([]ArtistIndex, 0, len(keys))
-	for _, k := range keys {
-		artists := groups[k]
-		sort.Slice(artists, func(i, j int) bool {
-			return strings.ToLower(artists[i].Name) < strings.ToLower(artists[j].Name)
-		})
-		indexes = append(indexes, ArtistIndex{
-			Name:    k,
-			Artists: artists,
-		})
-	}
-
-	// 5) 返回 XML
+	// 4) 返回 XML
 	resp := Response{
 		Status:  "ok",
 		Version: "1.16.1",
 		Artists: &ArtistsResponse{Index: indexes},
 	}
-	return WriteXMLFiber(c, resp)
+	return h.write(c, resp)
 }
 
 // GET /rest/getAlbum.view?id=albumId
 func (h *SubsonicHandler) HandleGetAlbum(c *fiber.Ctx) error {
 	id := c.Query("id")
 	if id == "" {
-		return WriteXMLFiber(c, Response{
+		return Write(c, Response{
 			Status:  "failed",
 			Version: "1.16.1",
 			Error:   &Error{Code: ErrRequiredParam, Message: "missing id"},
 		})
 	}
 
-	// 查询专辑，预加载 Musics
+	// 查询专辑，预加载 Musics（按碟号/轨号排序）
 	var album entity.Album
-	if err := h.db.Preload("Musics").First(&album, "id = ?", id).Error; err != nil {
+	if err := h.db.Preload("Musics", func(db *gorm.DB) *gorm.DB {
+		return db.Order("COALESCE(disc_number, 0) ASC").Order("COALESCE(track_number, 0) ASC").Order("title ASC")
+	}).First(&album, "id = ?", id).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
-			return WriteXMLFiber(c, Response{
-				Status:  "failed",
-				Version: "1.16.1",
-				Error:   &Error{Code: ErrGeneric, Message: "album not found"},
-			})
+			return Write(c, NewError(ErrNotFound, "album not found"))
 		}
-		return WriteXMLFiber(c, Response{
+		return Write(c, Response{
 			Status:  "failed",
 			Version: "1.16.1",
 			Error:   &Error{Code: ErrGeneric, Message: fmt.Sprintf("db error: %v", err)},
 		})
 	}
+	if !h.canAccess(c, album.LibraryID) {
+		return Write(c, NewError(ErrNotFound, "album not found"))
+	}
 
-	// 汇总专辑总时长
+	// 汇总专辑总时长，<song> 作为 <album> 的子节点返回
 	totalDuration := 0
 	songs := make([]Song, 0, len(album.Musics))
 	for _, m := range album.Musics {
-		// 兼容你的实体字段命名
-		// entity.Music 典型字段: ID, Title, Artist, AlbumName/AlbumTitle, Duration, CoverURL
-		title := m.Title
-		artist := m.Artist
-		track := m.TrackNumber // 如无 Track 字段，可置 0
-		duration := m.Duration
-		cover := m.CoverUrl
-		parent := id
-
-		totalDuration += duration
-
-		songs = append(songs, Song{
-			ID:       fmt.Sprintf("%v", m.ID),
-			Parent:   fmt.Sprintf("%v", parent),
-			Title:    title,
-			Artist:   artist,
-			Album:    album.Name,
-			Track:    track,
-			Duration: duration,
-			CoverArt: h.coverIDFromURLOrAlbum(cover, album.ID), // 生成 coverArt id（可用 URL 或专辑 ID）
-			Type:     "music",
-		})
+		totalDuration += m.Duration
+		m.Album = &album
+		songs = append(songs, toSong(m))
 	}
 
 	resp := Response{
 		Status:  "ok",
 		Version: "1.16.1",
 		Album: &AlbumResponse{
-			Album: Album{
-				ID:        fmt.Sprintf("%v", album.ID),
-				Name:      album.Name,
-				Artist:    album.ArtistName,
-				CoverArt:  h.coverIDFromURLOrAlbum(album.CoverURL, album.ID),
-				SongCount: len(songs),
-				Duration:  totalDuration,
-			},
+			Album: toAlbum(album, len(songs), totalDuration),
+			Song:  songs,
 		},
 	}
-	// Subsonic 规范中 album 下通常还需要包含 <song> 子节点；
-	// 如果你在 Response/AlbumResponse 结构中未内嵌 song 列表，可考虑扩展：
-	// 例如在 Response 加一个 AlbumSongs 字段，或修改 AlbumResponse 以携带 Songs。
-	// 这里将随机/占位接口之外的真实歌曲列表作为 RandomSongs 返回，便于客户端使用：
-	resp.RandomSongs = &SongsResponse{Song: songs}
+	return h.write(c, resp)
+}
 
-	return WriteXMLFiber(c, resp)
+// indexName 返回名称所属的索引分组：A-Z、0-9、其他(#)
+func indexName(name string) string {
+	runes := []rune(strings.TrimSpace(name))
+	if len(runes) == 0 {
+		return "#"
+	}
+	up := strings.ToUpper(string(runes[0]))
+	if up[0] >= 'A' && up[0] <= 'Z' {
+		return up
+	} else if up[0] >= '0' && up[0] <= '9' {
+		return "0-9"
+	}
+	return "#"
 }
 
-// 辅助：从封面 URL 或专辑 ID 生成 coverArt 引用
-func (h *SubsonicHandler) coverIDFromURLOrAlbum(url string, albumID any) string {
-	if strings.TrimSpace(url) != "" {
-		// 有 URL 时可直接用 URL 或 hash；这里直接返回 URL 以兼容 getCoverArt?id=<url>
-		return url
+// buildIndexes 按首字母分组，分组名排序，组内按名称排序
+func buildIndexes(all []Artist) []ArtistIndex {
+	groups := map[string][]Artist{}
+	for _, a := range all {
+		initial := indexName(a.Name)
+		groups[initial] = append(groups[initial], a)
 	}
-	var album entity.Album
-	if err := h.db.First(&album, "id = ?", albumID).Error; err != nil {
-		return fmt.Sprintf("cover-%v", album.CoverURL)
+
+	keys := make([]string, 0, len(groups))
+	for k := range groups {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	indexes := make([]ArtistIndex, 0, len(keys))
+	for _, k := range keys {
+		artists := groups[k]
+		sort.Slice(artists, func(i, j int) bool {
+			return strings.ToLower(artists[i].Name) < strings.ToLower(artists[j].Name)
+		})
+		indexes = append(indexes, ArtistIndex{
+			Name:    k,
+			Artists: artists,
+		})
 	}
-	return fmt.Sprintf("cover-%v", album.CoverURL)
+	return indexes
 }
 
 // GET /rest/getRandomSongs.view?size=10
@@ -227,22 +185,31 @@ func (h *SubsonicHandler) HandleGetRandomSongs(c *fiber.Ctx) error {
 	if size <= 0 {
 		size = 10
 	}
-	songs := make([]Song, 0, size)
-	if err := h.db.Model(&entity.Music{}).
-		Order("RANDOM()").
+	libraries, err := h.libraryScope(c)
+	if err != nil {
+		return writeScopeError(c, err)
+	}
+	var musics []entity.Music
+	if err := h.db.Preload("Album").
+		Scopes(library.Scope("music", libraries)).
+		Order(randomOrder(h.db)).
 		Limit(size).
-		Find(&songs).Error; err != nil {
-		return WriteXMLFiber(c, Response{
+		Find(&musics).Error; err != nil {
+		return Write(c, Response{
 			Status: "failed", Version: "1.16.1",
 			Error: &Error{Code: ErrGeneric, Message: fmt.Sprintf("db error: %v", err)},
 		})
 	}
+	songs := make([]Song, 0, len(musics))
+	for _, m := range musics {
+		songs = append(songs, toSong(m))
+	}
 	resp := Response{
 		Status:      "ok",
 		Version:     "1.16.1",
 		RandomSongs: &SongsResponse{Song: songs},
 	}
-	return WriteXMLFiber(c, resp)
+	return h.write(c, resp)
 }
 
 // GET /rest/getCoverArt.view?id=coverId
@@ -252,12 +219,12 @@ func (h *SubsonicHandler) HandleGetCoverArt(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).SendString("missing id")
 	}
 	// 1) 查询 album 的封面 URL（字符串）
-	var coverURL string
-	if err := h.db.Model(&entity.Album{}).
-		Where("id = ?", id).
-		Pluck("cover_url", &coverURL).Error; err != nil {
+	var album entity.Album
+	if err := h.db.Select("id", "cover_url", "library_id").
+		First(&album, "id = ?", id).Error; err != nil || !h.canAccess(c, album.LibraryID) {
 		return c.Status(fiber.StatusNotFound).SendString("cover art not found")
 	}
+	coverURL := album.CoverURL
 	if strings.TrimSpace(coverURL) == "" {
 		return c.Status(fiber.StatusNotFound).SendString("cover art not set")
 	}
@@ -288,45 +255,133 @@ func (h *SubsonicHandler) HandleGetCoverArt(c *fiber.Ctx) error {
 	return c.SendFile(fullPath)
 }
 
-// GET /rest/stream.view?id=songId
+// limitBitRate 请求的码率与用户默认最大码率取较小的非零值
+func limitBitRate(c *fiber.Ctx, bitRate int) int {
+	if user := CurrentUser(c); user != nil && user.MaxBitRate > 0 &&
+		(bitRate <= 0 || user.MaxBitRate < bitRate) {
+		return user.MaxBitRate
+	}
+	return bitRate
+}
+
+// GET /rest/stream.view?id=songId&format=mp3&maxBitRate=128&timeOffset=30&estimateContentLength=true
+// timeOffset 仅在转码时生效
 func (h *SubsonicHandler) HandleStream(c *fiber.Ctx) error {
 	id := c.Query("id")
 	if id == "" {
 		return c.Status(fiber.StatusBadRequest).SendString("missing id")
 	}
-	// 1) 查询音乐文件路径
-	var filePath string
-	if err := h.db.Model(&entity.Music{}).
-		Where("id = ?", id).
-		Pluck("cover_url", &filePath).Error; err != nil {
+	// 1) 查询音乐文件
+	var music entity.Music
+	if err := h.db.First(&music, "id = ?", id).Error; err != nil || !h.canAccess(c, music.LibraryID) {
 		return c.Status(fiber.StatusNotFound).SendString("music not found")
 	}
-	if strings.TrimSpace(filePath) == "" {
+	if strings.TrimSpace(music.FileUrl) == "" {
 		return c.Status(fiber.StatusNotFound).SendString("music file path not set")
 	}
+	fullPath := utils.MusicFilePath(music.FilePath())
+	if _, err := os.Stat(fullPath); err != nil {
+		return c.Status(fiber.StatusNotFound).SendString("music file not found")
+	}
 
-	// 2) 否则当作本地文件路径（相对或绝对）
-	// 例如你保存为 /app/music/<filename>.mp3
-	// 注意：请确保路径安全且存在
-	// 推断 Content-Type
-	ext := strings.ToLower(filepath.Ext(filePath))
-	switch ext {
-	case ".flac":
-		c.Type("audio/flac")
-	case ".wav":
-		c.Type("audio/wav")
-	default:
-		c.Type("audio/mpeg") // 默认 mp3
+	// 2) 按请求的格式与码率决定是否转码；整轨文件中的音轨总是需要截取
+	maxBitRate := limitBitRate(c, c.QueryInt("maxBitRate", 0))
+	decision, err := transcode.Decide(music.Suffix, music.BitRate, c.Query("format"), maxBitRate)
+	if err != nil {
+		return Write(c, NewError(ErrGeneric, err.Error()))
 	}
+	decision = decision.ForClip(transcode.NewClip(music.CueStart, music.CueEnd)).
+		WithGain(transcode.Gain(music.TrackGain, music.TrackPeak, music.AlbumGain, music.AlbumPeak))
 
-	// 如果 filePath 是相对路径，拼上你的基准目录
-	// baseDir 可来自配置，如 /app/music
-	// DB保存路径是/music/xxx.mp3
-	AppBasePath := config.AppConfig.AppBasePath
-	baseDir := filepath.Join(AppBasePath)
-	fullPath := filePath
-	if !strings.HasPrefix(filePath, "/") {
-		fullPath = filepath.Join(baseDir, filePath)
+	return h.serveMusic(c, &music, fullPath, decision, c.QueryInt("timeOffset", 0),
+		c.QueryBool("estimateContentLength", false))
+}
+
+// GET /rest/download.view?id=songId
+// 默认下载原文件；指定 format 时下载转码后的文件
+func (h *SubsonicHandler) HandleDownload(c *fiber.Ctx) error {
+	id := c.Query("id")
+	if id == "" {
+		return Write(c, NewError(ErrRequiredParam, "missing id"))
 	}
-	return c.SendFile(fullPath)
+	var music entity.Music
+	if err := h.db.First(&music, "id = ?", id).Error; err != nil || !h.canAccess(c, music.LibraryID) {
+		return Write(c, NewError(ErrNotFound, "song not found"))
+	}
+	fullPath := utils.MusicFilePath(music.FilePath())
+	if _, err := os.Stat(fullPath); err != nil {
+		return Write(c, NewError(ErrNotFound, "music file not found"))
+	}
+
+	decision := transcode.Decision{Raw: true}
+	if format := c.Query("format"); format != "" {
+		d, err := transcode.Decide(music.Suffix, music.BitRate, format, c.QueryInt("maxBitRate", 0))
+		if err != nil {
+			return Write(c, NewError(ErrGeneric, err.Error()))
+		}
+		decision = d
+	}
+	clip := transcode.NewClip(music.CueStart, music.CueEnd)
+	decision = decision.ForClip(clip)
+
+	name := filepath.Base(fullPath)
+	if !clip.IsZero() {
+		// 整轨文件中的音轨以标题命名
+		name = strings.ReplaceAll(music.Title, "/", "_") + filepath.Ext(fullPath)
+	}
+	if !decision.Raw {
+		name = strings.TrimSuffix(name, filepath.Ext(name)) + "." + decision.Profile.Format
+	}
+	c.Attachment(name)
+	return h.serveMusic(c, &music, fullPath, decision, 0, false)
+}
+
+// serveMusic 输出音乐：无需转码时直接发送原文件（支持 Range），
+// 否则优先使用转码缓存；timeOffset 非 0 的转码结果不缓存
+func (h *SubsonicHandler) serveMusic(c *fiber.Ctx, music *entity.Music, fullPath string,
+	decision transcode.Decision, offset int, estimate bool) error {
+	if decision.Raw {
+		return sendFile(c, fullPath, utils.MusicContentType(filepath.Ext(fullPath), music.Codec))
+	}
+
+	start := func() (io.ReadCloser, error) {
+		return transcode.Start(fullPath, decision, transcode.NewClip(music.CueStart, music.CueEnd), offset)
+	}
+	var stream io.ReadCloser
+	var err error
+	info, statErr := os.Stat(fullPath)
+	if cache := transcode.DefaultCache(); cache != nil && offset == 0 && statErr == nil {
+		key := transcode.Key(music.ID, info, decision)
+		if path, ok := cache.Lookup(key); ok {
+			return sendFile(c, path, decision.Profile.ContentType)
+		}
+		stream, err = cache.Stream(key, decision.Profile.Format, start)
+	} else {
+		stream, err = start()
+	}
+	if err != nil {
+		return Write(c, NewError(ErrGeneric, err.Error()))
+	}
+
+	size := -1
+	if estimate {
+		if n := decision.EstimateSize(music.Duration, offset); n > 0 {
+			stream = transcode.FixedSize(stream, n)
+			size = int(n)
+		}
+	}
+	c.Set(fiber.HeaderContentType, decision.Profile.ContentType)
+	c.Context().SetBodyStream(stream, size)
+	return nil
+}
+
+// sendFile 发送文件并覆盖按扩展名推断的 Content-Type
+func sendFile(c *fiber.Ctx, path, contentType string) error {
+	if err := c.SendFile(path); err != nil {
+		return err
+	}
+	if status := c.Response().StatusCode(); status == fiber.StatusOK || status == fiber.StatusPartialContent {
+		c.Set(fiber.HeaderContentType, contentType)
+	}
+	return nil
 }
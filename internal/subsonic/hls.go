@@ -0,0 +1,97 @@
+package subsonic
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"saboriman-music/internal/entity"
+	"saboriman-music/internal/transcode"
+	"saboriman-music/internal/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// hlsURL 生成播放列表中的相对地址：沿用当前请求的查询参数（包括认证参数），
+// 并以 overrides 替换其中的键
+func hlsURL(c *fiber.Ctx, path string, overrides map[string]string) string {
+	values, _ := url.ParseQuery(string(c.Request().URI().QueryString()))
+	values.Del("bitRate")
+	values.Del("segment")
+	for k, v := range overrides {
+		values.Set(k, v)
+	}
+	return path + "?" + values.Encode()
+}
+
+// GET /rest/hls.m3u8?id=songId[&bitRate=128&bitRate=320]
+// 未指定或指定多个 bitRate 时返回主播放列表，只指定一个时返回该码率的分片列表
+func (h *SubsonicHandler) HandleHLS(c *fiber.Ctx) error {
+	id := c.Query("id")
+	if id == "" {
+		return Write(c, NewError(ErrRequiredParam, "missing id"))
+	}
+	var music entity.Music
+	if err := h.db.First(&music, "id = ?", id).Error; err != nil || !h.canAccess(c, music.LibraryID) {
+		return Write(c, NewError(ErrNotFound, "song not found"))
+	}
+	if music.Duration <= 0 {
+		return Write(c, NewError(ErrGeneric, "song duration unknown"))
+	}
+
+	var bitRates []int
+	for _, v := range queryValues(c, "bitRate") {
+		// 视频客户端可能传入 1000@480x360 的形式，只取码率部分
+		br, err := strconv.Atoi(strings.SplitN(v, "@", 2)[0])
+		if err != nil || br <= 0 {
+			return Write(c, NewError(ErrGeneric, "invalid bitRate: "+v))
+		}
+		bitRates = append(bitRates, transcode.HLSBitRate(limitBitRate(c, br)))
+	}
+	if len(bitRates) == 0 {
+		bitRates = transcode.HLSBitRates(music.BitRate, limitBitRate(c, 0))
+	}
+
+	c.Set(fiber.HeaderContentType, transcode.PlaylistContentType)
+	if len(bitRates) == 1 {
+		bitRate := strconv.Itoa(bitRates[0])
+		return c.SendString(transcode.MediaPlaylist(music.Duration, func(i int) string {
+			return hlsURL(c, "hlsSegment.ts", map[string]string{"bitRate": bitRate, "segment": strconv.Itoa(i)})
+		}))
+	}
+	return c.SendString(transcode.MasterPlaylist(bitRates, func(bitRate int) string {
+		return hlsURL(c, "hls.m3u8", map[string]string{"bitRate": strconv.Itoa(bitRate)})
+	}))
+}
+
+// GET /rest/hlsSegment.ts?id=songId&bitRate=128&segment=0
+// hls.m3u8 分片列表中引用的分片，按需转码并写入转码缓存
+func (h *SubsonicHandler) HandleHLSSegment(c *fiber.Ctx) error {
+	id := c.Query("id")
+	if id == "" {
+		return Write(c, NewError(ErrRequiredParam, "missing id"))
+	}
+	var music entity.Music
+	if err := h.db.First(&music, "id = ?", id).Error; err != nil || !h.canAccess(c, music.LibraryID) {
+		return Write(c, NewError(ErrNotFound, "song not found"))
+	}
+	fullPath := utils.MusicFilePath(music.FilePath())
+	if _, err := os.Stat(fullPath); err != nil {
+		return Write(c, NewError(ErrNotFound, "music file not found"))
+	}
+
+	bitRate := transcode.HLSBitRate(limitBitRate(c, c.QueryInt("bitRate", 0)))
+	stream, err := transcode.OpenSegment(music.ID, fullPath, transcode.NewClip(music.CueStart, music.CueEnd), music.Duration, bitRate, c.QueryInt("segment", -1),
+		transcode.Gain(music.TrackGain, music.TrackPeak, music.AlbumGain, music.AlbumPeak))
+	if errors.Is(err, transcode.ErrSegmentOutOfRange) {
+		return Write(c, NewError(ErrNotFound, err.Error()))
+	}
+	if err != nil {
+		return Write(c, NewError(ErrGeneric, err.Error()))
+	}
+	c.Set(fiber.HeaderContentType, transcode.SegmentContentType)
+	c.Context().SetBodyStream(stream, -1)
+	return nil
+}
@@ -0,0 +1,54 @@
+package subsonic
+
+import (
+	"errors"
+	"fmt"
+
+	"saboriman-music/internal/entity"
+	"saboriman-music/internal/library"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// accessible 当前用户可以访问的音乐库，nil 表示全部
+func (h *SubsonicHandler) accessible(c *fiber.Ctx) ([]uint, error) {
+	user := CurrentUser(c)
+	if user == nil {
+		return nil, library.ErrForbidden
+	}
+	return library.Accessible(h.db, user.ID, user.Role)
+}
+
+// libraryScope 列表接口的音乐库范围，带 musicFolderId 时只返回该音乐库
+func (h *SubsonicHandler) libraryScope(c *fiber.Ctx) ([]uint, error) {
+	ids, err := h.accessible(c)
+	if err != nil {
+		return nil, err
+	}
+	return library.Resolve(ids, uint(c.QueryInt("musicFolderId", 0)))
+}
+
+// canAccess 判断当前用户能否访问某个音乐库中的条目
+func (h *SubsonicHandler) canAccess(c *fiber.Ctx, libraryID uint) bool {
+	ids, err := h.accessible(c)
+	return err == nil && library.Allowed(ids, libraryID)
+}
+
+// writeScopeError 返回音乐库范围的错误
+func writeScopeError(c *fiber.Ctx, err error) error {
+	if errors.Is(err, library.ErrForbidden) {
+		return Write(c, NewError(ErrUserNotAuthorized, "music folder not accessible"))
+	}
+	return Write(c, NewError(ErrGeneric, fmt.Sprintf("db error: %v", err)))
+}
+
+// libraries 查询范围内的音乐库，ids 为 nil 时返回全部
+func (h *SubsonicHandler) libraries(ids []uint) ([]entity.Library, error) {
+	q := h.db.Order("id ASC")
+	if ids != nil {
+		q = q.Where("id IN ?", ids)
+	}
+	var libs []entity.Library
+	err := q.Find(&libs).Error
+	return libs, err
+}
@@ -0,0 +1,64 @@
+package subsonic
+
+import (
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	"saboriman-music/internal/entity"
+	"saboriman-music/internal/library"
+	"saboriman-music/internal/nowplaying"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// playerID 根据用户和客户端生成稳定的播放器 ID
+func playerID(e nowplaying.Entry) int {
+	h := fnv.New32a()
+	h.Write([]byte(e.UserID + "\x00" + e.Client))
+	return int(h.Sum32() & 0x7fffffff)
+}
+
+// GET /rest/getNowPlaying.view
+func (h *SubsonicHandler) HandleGetNowPlaying(c *fiber.Ctx) error {
+	libraries, err := h.accessible(c)
+	if err != nil {
+		return writeScopeError(c, err)
+	}
+	entries := nowplaying.Default.List()
+	ids := make([]string, 0, len(entries))
+	for _, e := range entries {
+		ids = append(ids, e.MusicID)
+	}
+
+	var musics []entity.Music
+	if len(ids) > 0 {
+		if err := h.db.Preload("Album").Scopes(library.Scope("music", libraries)).
+			Where("id IN ?", ids).Find(&musics).Error; err != nil {
+			return Write(c, NewError(ErrGeneric, fmt.Sprintf("db error: %v", err)))
+		}
+	}
+	byID := make(map[string]entity.Music, len(musics))
+	for _, m := range musics {
+		byID[m.ID] = m
+	}
+
+	result := &NowPlaying{}
+	for _, e := range entries {
+		m, ok := byID[e.MusicID]
+		if !ok {
+			continue // 歌曲已被删除或不在可访问的音乐库中
+		}
+		result.Entry = append(result.Entry, NowPlayingEntry{
+			Song:       toSong(m),
+			Username:   e.Username,
+			MinutesAgo: int(time.Since(e.StartedAt).Minutes()),
+			PlayerID:   playerID(e),
+			PlayerName: e.Client,
+		})
+	}
+
+	resp := NewResponse()
+	resp.NowPlaying = result
+	return h.write(c, resp)
+}
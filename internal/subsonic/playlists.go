@@ -0,0 +1,390 @@
+package subsonic
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"saboriman-music/internal/entity"
+	"saboriman-music/internal/library"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// errForbidden 当前用户无权操作该播放列表
+var errForbidden = errors.New("user is not the owner of the playlist")
+
+// queryValues 读取可重复的参数（如 songId=1&songId=2），POST 表单参数已由认证中间件并入查询参数
+func queryValues(c *fiber.Ctx, key string) []string {
+	var values []string
+	for _, v := range c.Context().QueryArgs().PeekMulti(key) {
+		values = append(values, string(v))
+	}
+	return values
+}
+
+// playlistStat 播放列表的歌曲数与总时长
+type playlistStat struct {
+	PlaylistID string
+	SongCount  int
+	Duration   int
+}
+
+// playlistStats 批量统计播放列表的歌曲数与总时长
+func (h *SubsonicHandler) playlistStats(playlists []entity.Playlist) (map[string]playlistStat, error) {
+	stats := make(map[string]playlistStat, len(playlists))
+	if len(playlists) == 0 {
+		return stats, nil
+	}
+	ids := make([]string, 0, len(playlists))
+	for _, p := range playlists {
+		ids = append(ids, p.ID)
+	}
+	var rows []playlistStat
+	if err := h.db.Table("playlist_musics").
+		Select("playlist_musics.playlist_id, COUNT(*) AS song_count, COALESCE(SUM(music.duration), 0) AS duration").
+		Joins("JOIN music ON music.id = playlist_musics.music_id").
+		Where("playlist_musics.playlist_id IN ?", ids).
+		Group("playlist_musics.playlist_id").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, r := range rows {
+		stats[r.PlaylistID] = r
+	}
+	return stats, nil
+}
+
+// toPlaylist 将播放列表实体映射为 Subsonic <playlist>
+func toPlaylist(p entity.Playlist, songCount, duration int) Playlist {
+	return Playlist{
+		ID:        p.ID,
+		Name:      p.Name,
+		Comment:   p.Description,
+		Owner:     p.User.Username,
+		Public:    p.IsPublic,
+		SongCount: songCount,
+		Duration:  duration,
+		Created:   formatTime(p.CreatedAt),
+		Changed:   formatTime(p.UpdatedAt),
+	}
+}
+
+// canReadPlaylist 自己的或公开的播放列表可读
+func canReadPlaylist(p *entity.Playlist, user *entity.User) bool {
+	return p.UserID == user.ID || p.IsPublic
+}
+
+// loadPlaylistMusics 按位置顺序加载播放列表中的歌曲
+func loadPlaylistMusics(db *gorm.DB, playlistID string) ([]entity.Music, error) {
+	return entity.LoadPlaylistMusics(db, playlistID, func(db *gorm.DB) *gorm.DB { return db.Preload("Album") })
+}
+
+// replacePlaylistMusics 用给定顺序的歌曲替换播放列表内容，忽略不存在的歌曲，重复的歌曲保留每一次出现
+func replacePlaylistMusics(tx *gorm.DB, playlistID string, musicIDs []string) error {
+	if err := tx.Where("playlist_id = ?", playlistID).Delete(&entity.PlaylistMusic{}).Error; err != nil {
+		return err
+	}
+	if len(musicIDs) == 0 {
+		return nil
+	}
+
+	var existing []string
+	if err := tx.Model(&entity.Music{}).Where("id IN ?", musicIDs).Pluck("id", &existing).Error; err != nil {
+		return err
+	}
+	valid := make(map[string]bool, len(existing))
+	for _, id := range existing {
+		valid[id] = true
+	}
+
+	rows := make([]entity.PlaylistMusic, 0, len(musicIDs))
+	for _, id := range musicIDs {
+		if valid[id] {
+			rows = append(rows, entity.PlaylistMusic{PlaylistID: playlistID, Position: len(rows), MusicID: id})
+		}
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	return tx.Create(&rows).Error
+}
+
+// accessibleMusicIDs 只保留 libraries 范围内存在的歌曲，顺序与重复不变
+func accessibleMusicIDs(db *gorm.DB, musicIDs []string, libraries []uint) ([]string, error) {
+	if len(musicIDs) == 0 {
+		return nil, nil
+	}
+	var existing []string
+	if err := db.Model(&entity.Music{}).Scopes(library.Scope("music", libraries)).
+		Where("id IN ?", musicIDs).Pluck("id", &existing).Error; err != nil {
+		return nil, err
+	}
+	valid := make(map[string]bool, len(existing))
+	for _, id := range existing {
+		valid[id] = true
+	}
+	ids := make([]string, 0, len(musicIDs))
+	for _, id := range musicIDs {
+		if valid[id] {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// findPlaylist 查询播放列表并校验权限
+func (h *SubsonicHandler) findPlaylist(id string, user *entity.User, write bool) (*entity.Playlist, error) {
+	var playlist entity.Playlist
+	if err := h.db.Preload("User").First(&playlist, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	if write && playlist.UserID != user.ID {
+		return nil, errForbidden
+	}
+	if !write && !canReadPlaylist(&playlist, user) {
+		return nil, errForbidden
+	}
+	return &playlist, nil
+}
+
+// writePlaylistError 将播放列表相关错误映射为 Subsonic 错误码
+func writePlaylistError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return Write(c, NewError(ErrNotFound, "playlist not found"))
+	case errors.Is(err, errForbidden):
+		return Write(c, NewError(ErrUserNotAuthorized, err.Error()))
+	default:
+		return Write(c, NewError(ErrGeneric, fmt.Sprintf("db error: %v", err)))
+	}
+}
+
+// writePlaylist 返回带 <entry> 的完整播放列表，跳过当前用户无权访问的音乐库中的歌曲
+func (h *SubsonicHandler) writePlaylist(c *fiber.Ctx, playlist *entity.Playlist) error {
+	musics, err := loadPlaylistMusics(h.db, playlist.ID)
+	if err != nil {
+		return writePlaylistError(c, err)
+	}
+	libraries, err := h.accessible(c)
+	if err != nil {
+		return writeScopeError(c, err)
+	}
+	duration := 0
+	entries := make([]Song, 0, len(musics))
+	for _, m := range musics {
+		if !library.Allowed(libraries, m.LibraryID) {
+			continue
+		}
+		duration += m.Duration
+		entries = append(entries, toSong(m))
+	}
+
+	result := toPlaylist(*playlist, len(entries), duration)
+	result.Entry = entries
+	if len(entries) > 0 {
+		result.CoverArt = entries[0].CoverArt
+	}
+
+	resp := NewResponse()
+	resp.Playlist = &result
+	return h.write(c, resp)
+}
+
+// GET /rest/getPlaylists.view
+func (h *SubsonicHandler) HandleGetPlaylists(c *fiber.Ctx) error {
+	user := CurrentUser(c)
+
+	// 自己的播放列表 + 他人公开的播放列表；管理员可通过 username 查看指定用户的播放列表
+	q := h.db.Preload("User").Where("user_id = ? OR is_public = ?", user.ID, true)
+	if username := c.Query("username"); username != "" && username != user.Username {
+		if !user.IsAdmin() {
+			return Write(c, NewError(ErrUserNotAuthorized, "only admins may list other users' playlists"))
+		}
+		q = h.db.Preload("User").
+			Where("user_id IN (?)", h.db.Model(&entity.User{}).Select("id").Where("username = ?", username))
+	}
+
+	var playlists []entity.Playlist
+	if err := q.Order("name ASC").Find(&playlists).Error; err != nil {
+		return writePlaylistError(c, err)
+	}
+	stats, err := h.playlistStats(playlists)
+	if err != nil {
+		return writePlaylistError(c, err)
+	}
+
+	list := make([]Playlist, 0, len(playlists))
+	for _, p := range playlists {
+		s := stats[p.ID]
+		list = append(list, toPlaylist(p, s.SongCount, s.Duration))
+	}
+	resp := NewResponse()
+	resp.Playlists = &Playlists{Playlist: list}
+	return h.write(c, resp)
+}
+
+// GET /rest/getPlaylist.view?id=playlistId
+func (h *SubsonicHandler) HandleGetPlaylist(c *fiber.Ctx) error {
+	id := c.Query("id")
+	if id == "" {
+		return Write(c, NewError(ErrRequiredParam, "missing id"))
+	}
+	playlist, err := h.findPlaylist(id, CurrentUser(c), false)
+	if err != nil {
+		return writePlaylistError(c, err)
+	}
+	return h.writePlaylist(c, playlist)
+}
+
+// GET /rest/createPlaylist.view?name=xxx&songId=1&songId=2
+// 传 playlistId 时替换已有播放列表的全部歌曲，忽略无权访问的歌曲
+func (h *SubsonicHandler) HandleCreatePlaylist(c *fiber.Ctx) error {
+	user := CurrentUser(c)
+	playlistID := c.Query("playlistId")
+	name := c.Query("name")
+	songIDs := queryValues(c, "songId")
+
+	var playlist *entity.Playlist
+	if playlistID != "" {
+		p, err := h.findPlaylist(playlistID, user, true)
+		if err != nil {
+			return writePlaylistError(c, err)
+		}
+		playlist = p
+	} else if name == "" {
+		return Write(c, NewError(ErrRequiredParam, "missing name or playlistId"))
+	}
+
+	libraries, err := h.accessible(c)
+	if err != nil {
+		return writeScopeError(c, err)
+	}
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		songIDs, err := accessibleMusicIDs(tx, songIDs, libraries)
+		if err != nil {
+			return err
+		}
+		if playlist == nil {
+			// 新建的播放列表默认私有；is_public 列默认为 true，零值在插入时会被忽略，因此单独更新
+			playlist = &entity.Playlist{Name: name, UserID: user.ID}
+			if err := tx.Create(playlist).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&entity.Playlist{}).Where("id = ?", playlist.ID).Update("is_public", false).Error; err != nil {
+				return err
+			}
+			playlist.IsPublic = false
+			playlist.User = *user
+		} else if name != "" {
+			if err := tx.Model(&entity.Playlist{}).Where("id = ?", playlist.ID).Update("name", name).Error; err != nil {
+				return err
+			}
+			playlist.Name = name
+		}
+		return replacePlaylistMusics(tx, playlist.ID, songIDs)
+	})
+	if err != nil {
+		return writePlaylistError(c, err)
+	}
+	return h.writePlaylist(c, playlist)
+}
+
+// GET /rest/updatePlaylist.view?playlistId=xxx&songIdToAdd=1&songIndexToRemove=0
+func (h *SubsonicHandler) HandleUpdatePlaylist(c *fiber.Ctx) error {
+	playlistID := c.Query("playlistId")
+	if playlistID == "" {
+		return Write(c, NewError(ErrRequiredParam, "missing playlistId"))
+	}
+	playlist, err := h.findPlaylist(playlistID, CurrentUser(c), true)
+	if err != nil {
+		return writePlaylistError(c, err)
+	}
+	libraries, err := h.accessible(c)
+	if err != nil {
+		return writeScopeError(c, err)
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		// 1) 基本信息，使用 map 以便更新 public=false；
+		//    不能用已预加载 User 的 playlist 作为 Model，否则会连带 upsert 用户
+		updates := map[string]interface{}{}
+		if name := c.Query("name"); name != "" {
+			updates["name"] = name
+		}
+		if comment, ok := c.Queries()["comment"]; ok {
+			updates["description"] = comment
+		}
+		if public := c.Query("public"); public != "" {
+			updates["is_public"] = public == "true"
+		}
+		if len(updates) > 0 {
+			if err := tx.Model(&entity.Playlist{}).Where("id = ?", playlist.ID).Updates(updates).Error; err != nil {
+				return err
+			}
+		}
+
+		// 2) 歌曲增删：songIndexToRemove 基于更新前客户端看到的列表，
+		//    即 getPlaylist 跳过无权访问的歌曲后的顺序；这些歌曲原样保留
+		toAdd := queryValues(c, "songIdToAdd")
+		toRemove := queryValues(c, "songIndexToRemove")
+		if len(toAdd) == 0 && len(toRemove) == 0 {
+			return nil
+		}
+		toAdd, err := accessibleMusicIDs(tx, toAdd, libraries)
+		if err != nil {
+			return err
+		}
+		musics, err := loadPlaylistMusics(tx, playlist.ID)
+		if err != nil {
+			return err
+		}
+		removed := make(map[int]bool, len(toRemove))
+		for _, v := range toRemove {
+			if idx, err := strconv.Atoi(v); err == nil {
+				removed[idx] = true
+			}
+		}
+		ids := make([]string, 0, len(musics)+len(toAdd))
+		visible := 0
+		for _, m := range musics {
+			if library.Allowed(libraries, m.LibraryID) {
+				visible++
+				if removed[visible-1] {
+					continue
+				}
+			}
+			ids = append(ids, m.ID)
+		}
+		ids = append(ids, toAdd...)
+		return replacePlaylistMusics(tx, playlist.ID, ids)
+	})
+	if err != nil {
+		return writePlaylistError(c, err)
+	}
+	return Write(c, NewResponse())
+}
+
+// GET /rest/deletePlaylist.view?id=playlistId
+func (h *SubsonicHandler) HandleDeletePlaylist(c *fiber.Ctx) error {
+	id := c.Query("id")
+	if id == "" {
+		return Write(c, NewError(ErrRequiredParam, "missing id"))
+	}
+	playlist, err := h.findPlaylist(id, CurrentUser(c), true)
+	if err != nil {
+		return writePlaylistError(c, err)
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("playlist_id = ?", playlist.ID).Delete(&entity.PlaylistMusic{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&entity.Playlist{}, "id = ?", playlist.ID).Error
+	})
+	if err != nil {
+		return writePlaylistError(c, err)
+	}
+	return Write(c, NewResponse())
+}
@@ -0,0 +1,70 @@
+package subsonic
+
+import (
+	"errors"
+	"fmt"
+
+	"saboriman-music/internal/entity"
+	"saboriman-music/internal/library"
+	"saboriman-music/internal/scanner"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// scanStatus 当前扫描状态；未在扫描时 count 为当前用户可以访问的歌曲总数
+func (h *SubsonicHandler) scanStatus(c *fiber.Ctx) (*ScanStatus, error) {
+	status := &ScanStatus{}
+	job, running, ok := h.scans.Status()
+	if !ok {
+		if last, err := scanner.LastJob(h.db); err == nil {
+			job, ok = *last, true
+		}
+	}
+	if ok && job.FinishedAt != nil {
+		status.LastScan = formatTime(*job.FinishedAt)
+	}
+	if running {
+		status.Scanning = true
+		status.Count = int64(job.ScannedFiles)
+		return status, nil
+	}
+	ids, err := h.accessible(c)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.db.Model(&entity.Music{}).Scopes(library.Scope("music", ids)).Count(&status.Count).Error; err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// GET /rest/getScanStatus.view
+func (h *SubsonicHandler) HandleGetScanStatus(c *fiber.Ctx) error {
+	status, err := h.scanStatus(c)
+	if err != nil {
+		return Write(c, NewError(ErrGeneric, fmt.Sprintf("db error: %v", err)))
+	}
+	resp := NewResponse()
+	resp.ScanStatus = status
+	return h.write(c, resp)
+}
+
+// GET /rest/startScan.view
+// 仅管理员可用，依次扫描所有音乐库；已有扫描进行时直接返回当前状态。fullScan=true 时重新读取所有文件
+func (h *SubsonicHandler) HandleStartScan(c *fiber.Ctx) error {
+	if !CurrentUser(c).IsAdmin() {
+		return Write(c, NewError(ErrUserNotAuthorized, "only admins may start a scan"))
+	}
+	libs, err := h.libraries(nil)
+	if err != nil {
+		return Write(c, NewError(ErrGeneric, fmt.Sprintf("db error: %v", err)))
+	}
+
+	opts := scanner.DefaultOptions(c.QueryBool("fullScan", false))
+	if _, err := h.scans.Start(h.db, libs, opts, "subsonic"); errors.Is(err, scanner.ErrNoLibrary) {
+		return Write(c, NewError(ErrGeneric, "no music folder configured"))
+	} else if err != nil && !errors.Is(err, scanner.ErrScanRunning) {
+		return Write(c, NewError(ErrGeneric, err.Error()))
+	}
+	return h.HandleGetScanStatus(c)
+}
@@ -0,0 +1,146 @@
+package subsonic
+
+import (
+	"strings"
+
+	"saboriman-music/internal/artist"
+	"saboriman-music/internal/entity"
+	"saboriman-music/internal/library"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// GET /rest/search2.view?query=xxx
+func (h *SubsonicHandler) HandleSearch2(c *fiber.Ctx) error {
+	result, err := h.search(c)
+	if err != nil {
+		return writeScopeError(c, err)
+	}
+	resp := NewResponse()
+	resp.SearchResult2 = result
+	return h.write(c, resp)
+}
+
+// GET /rest/search3.view?query=xxx
+func (h *SubsonicHandler) HandleSearch3(c *fiber.Ctx) error {
+	result, err := h.search(c)
+	if err != nil {
+		return writeScopeError(c, err)
+	}
+	resp := NewResponse()
+	resp.SearchResult3 = result
+	return h.write(c, resp)
+}
+
+// searchQuery 规范化客户端传入的关键字：
+// 部分客户端（如 Symfonium 同步时）会发送 query="" 或带 * 通配符，统一去掉后视为全量匹配
+func searchQuery(raw string) string {
+	q := strings.TrimSpace(raw)
+	q = strings.Trim(q, `"`)
+	q = strings.TrimSuffix(q, "*")
+	return strings.TrimSpace(q)
+}
+
+// search 按 artistCount/albumCount/songCount 及对应 offset 分别查询三类结果，
+// 带 musicFolderId 时只搜索该音乐库
+func (h *SubsonicHandler) search(c *fiber.Ctx) (*SearchResult2, error) {
+	libraries, err := h.libraryScope(c)
+	if err != nil {
+		return nil, err
+	}
+	like := "%" + searchQuery(c.Query("query")) + "%"
+
+	result := &SearchResult2{
+		Artists: []Artist{},
+		Albums:  []Album{},
+		Songs:   []Song{},
+	}
+
+	// 1) 艺术家：与 getArtists 一致，包含歌曲艺术家与专辑艺术家
+	if count := c.QueryInt("artistCount", 20); count > 0 {
+		var artists []entity.Artist
+		if err := h.db.
+			Scopes(artist.Scope(libraries, artistRoles...)).
+			Where("artists.name LIKE ?", like).
+			Order("artists.name ASC").
+			Offset(c.QueryInt("artistOffset", 0)).
+			Limit(count).
+			Find(&artists).Error; err != nil {
+			return nil, err
+		}
+		list, err := h.toArtists(libraries, artists)
+		if err != nil {
+			return nil, err
+		}
+		result.Artists = list
+	}
+
+	// 2) 专辑：按专辑名或专辑艺术家匹配
+	if count := c.QueryInt("albumCount", 20); count > 0 {
+		var albums []entity.Album
+		if err := h.db.
+			Scopes(library.Scope("album", libraries)).
+			Where("(name LIKE ? OR artist_name LIKE ?)", like, like).
+			Order("name ASC").
+			Offset(c.QueryInt("albumOffset", 0)).
+			Limit(count).
+			Find(&albums).Error; err != nil {
+			return nil, err
+		}
+		list, err := h.toAlbums(albums)
+		if err != nil {
+			return nil, err
+		}
+		result.Albums = list
+	}
+
+	// 3) 歌曲：与 JSON ListMusics 的关键字筛选保持一致
+	if count := c.QueryInt("songCount", 20); count > 0 {
+		var musics []entity.Music
+		if err := h.db.Preload("Album").
+			Scopes(library.Scope("music", libraries)).
+			Where("(title LIKE ? OR artist LIKE ? OR album_artist LIKE ?)", like, like, like).
+			Order("title ASC").
+			Offset(c.QueryInt("songOffset", 0)).
+			Limit(count).
+			Find(&musics).Error; err != nil {
+			return nil, err
+		}
+		for _, m := range musics {
+			result.Songs = append(result.Songs, toSong(m))
+		}
+	}
+
+	return result, nil
+}
+
+// albumStat 专辑下的歌曲数与总时长
+type albumStat struct {
+	AlbumID   string
+	SongCount int
+	Duration  int
+}
+
+// albumStats 批量统计专辑的歌曲数与总时长
+func (h *SubsonicHandler) albumStats(albums []entity.Album) (map[string]albumStat, error) {
+	stats := make(map[string]albumStat, len(albums))
+	if len(albums) == 0 {
+		return stats, nil
+	}
+	ids := make([]string, 0, len(albums))
+	for _, a := range albums {
+		ids = append(ids, a.ID)
+	}
+	var rows []albumStat
+	if err := h.db.Model(&entity.Music{}).
+		Select("album_id, COUNT(*) AS song_count, COALESCE(SUM(duration), 0) AS duration").
+		Where("album_id IN ?", ids).
+		Group("album_id").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, r := range rows {
+		stats[r.AlbumID] = r
+	}
+	return stats, nil
+}
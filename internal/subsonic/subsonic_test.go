@@ -1,67 +1,92 @@
 package subsonic_test
 
 import (
+	"crypto/md5"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
+	"saboriman-music/config"
+	"saboriman-music/internal/artist"
+	database "saboriman-music/internal/db"
+	"saboriman-music/internal/entity"
+	"saboriman-music/internal/library"
 	"saboriman-music/internal/router"
+	"saboriman-music/internal/scanner"
+	"saboriman-music/internal/transcode"
 
 	"github.com/gofiber/fiber/v2"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
 
-// 定义用于测试的最小实体（与你项目的 entity 对应字段保持一致）
-type Music struct {
-	ID          uint `gorm:"primaryKey"`
-	Title       string
-	Artist      string
-	AlbumName   string
-	Duration    int
-	CoverUrl    string
-	FilePath    string
-	TrackNumber int
-}
-type Album struct {
-	ID         uint `gorm:"primaryKey"`
-	Name       string
-	ArtistName string
-	CoverURL   string
-	Musics     []Music `gorm:"foreignKey:AlbumName;references:Name"`
+func setup(t *testing.T) (*fiber.App, *gorm.DB) {
+	app, db, _ := setupWithUser(t)
+	return app, db
 }
 
-func setup(t *testing.T) (*fiber.App, *gorm.DB) {
+func setupWithUser(t *testing.T) (*fiber.App, *gorm.DB, entity.User) {
 	t.Helper()
+	prev := config.AppConfig
+	t.Cleanup(func() { config.AppConfig = prev })
+	config.AppConfig = &config.Config{
+		MusicFolder: "/music",
+		// 转码缓存为进程内单例，测试统一放在临时目录
+		Transcoding: config.TranscodingConfig{CacheDir: filepath.Join(os.TempDir(), "saboriman-test-transcode")},
+	}
+
 	// 内存 DB
 	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
 	if err != nil {
 		t.Fatalf("open sqlite: %v", err)
 	}
+	// 内存库每个连接独立，限制为单连接保证迁移与查询在同一个库
+	if sqlDB, err := db.DB(); err == nil {
+		sqlDB.SetMaxOpenConns(1)
+	}
 	// 迁移与准备数据
-	if err := db.AutoMigrate(&Album{}, &Music{}); err != nil {
+	if err := db.AutoMigrate(&entity.User{}, &entity.Album{}, &entity.Music{}, &entity.Playlist{}, &entity.PlaylistMusic{}, &entity.SubsonicCredential{}, &entity.Annotation{}, &entity.PlayEvent{}, &entity.ScanJob{}, &entity.Library{}, &entity.UserLibrary{}, &entity.Artist{}, &entity.MusicArtist{}, &entity.AlbumArtist{}); err != nil {
 		t.Fatalf("migrate: %v", err)
 	}
-	al := Album{ID: 1, Name: "Test Album", ArtistName: "Artist A", CoverURL: "/uploads/covers/test.jpg"}
+	user := entity.User{Username: "test", Email: "test@localhost", Password: "test", Role: entity.RoleUser, Status: 1}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("seed user: %v", err)
+	}
+	released := time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+	al := entity.Album{ID: "1", Name: "Test Album", ArtistName: "Artist A", CoverURL: "/uploads/covers/test.jpg", Genre: "Rock", ReleaseDate: &released}
 	if err := db.Create(&al).Error; err != nil {
 		t.Fatalf("seed album: %v", err)
 	}
-	musics := []Music{
-		{ID: 1, Title: "Song 1", Artist: "Artist A", AlbumName: "Test Album", Duration: 240, CoverUrl: "/uploads/covers/test.jpg", FilePath: "/music/song1.mp3", TrackNumber: 1},
-		{ID: 2, Title: "Song 2", Artist: "Band B", AlbumName: "Test Album", Duration: 200, CoverUrl: "/uploads/covers/test.jpg", FilePath: "/music/song2.mp3", TrackNumber: 2},
-		{ID: 3, Title: "Song 3", Artist: "3 Doors Down", AlbumName: "Test Album", Duration: 180, CoverUrl: "/uploads/covers/test.jpg", FilePath: "/music/song3.mp3", TrackNumber: 3},
+	musics := []entity.Music{
+		{Title: "Song 1", Artist: "Artist A", AlbumID: "1", Duration: 240, CoverUrl: "/uploads/covers/test.jpg", FileUrl: "/music/Artist A/Test Album/song1.mp3", Suffix: "mp3", TrackNumber: 1, Genre: "Rock"},
+		{Title: "Song 2", Artist: "Band B", AlbumID: "1", Duration: 200, CoverUrl: "/uploads/covers/test.jpg", FileUrl: "/music/Artist A/Test Album/song2.mp3", Suffix: "mp3", TrackNumber: 2, Genre: "Rock"},
+		{Title: "Song 3", Artist: "3 Doors Down", AlbumID: "1", Duration: 180, CoverUrl: "/uploads/covers/test.jpg", FileUrl: "/music/Artist A/Test Album/song3.mp3", Suffix: "mp3", TrackNumber: 3, Genre: "Pop"},
 	}
 	if err := db.Create(&musics).Error; err != nil {
 		t.Fatalf("seed musics: %v", err)
 	}
+	// 默认音乐库 ID 为 1，上面的专辑和歌曲归入其中
+	if err := library.EnsureDefault(db, "/music"); err != nil {
+		t.Fatalf("seed library: %v", err)
+	}
+	if err := artist.Backfill(db); err != nil {
+		t.Fatalf("seed artists: %v", err)
+	}
 
 	// Fiber app + 注册子声波路由
+	// 每个测试使用独立的扫描任务管理器，结束时取消未完成的扫描
+	scans := scanner.NewManager()
+	t.Cleanup(func() { scans.Cancel() })
 	app := fiber.New()
-	router.RegisterSubsonic(app, db) // 你已有此函数
+	router.RegisterSubsonic(app, db, scans)
 
-	return app, db
+	return app, db, user
 }
 
 func get(app *fiber.App, path string) (int, string) {
@@ -71,6 +96,15 @@ func get(app *fiber.App, path string) (int, string) {
 	return res.StatusCode, string(body)
 }
 
+// post 以表单方式提交参数（OpenSubsonic formPost）
+func post(app *fiber.App, path, form string) (int, string) {
+	req := httptest.NewRequest("POST", path, strings.NewReader(form))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res, _ := app.Test(req, -1)
+	body, _ := io.ReadAll(res.Body)
+	return res.StatusCode, string(body)
+}
+
 func TestPing(t *testing.T) {
 	app, _ := setup(t)
 	code, body := get(app, "/rest/ping.view?u=test&p=enc:74657374&v=1.16.1&c=test")
@@ -84,7 +118,7 @@ func TestPing(t *testing.T) {
 
 func TestGetLicense(t *testing.T) {
 	app, _ := setup(t)
-	code, body := get(app, "/rest/getLicense.view")
+	code, body := get(app, "/rest/getLicense.view?u=test&p=enc:74657374&v=1.16.1&c=test")
 	if code != 200 || !strings.Contains(body, `<license valid="true"`) {
 		t.Fatalf("unexpected: %d %s", code, body)
 	}
@@ -109,15 +143,15 @@ func TestGetAlbum(t *testing.T) {
 	if code != 200 {
 		t.Fatalf("status=%d body=%s", code, body)
 	}
-	// 验证 album 节点与 RandomSongs（我们把 songs 返回在 RandomSongs）
-	if !(strings.Contains(body, `<album>`) && strings.Contains(body, `<song`)) {
+	// 验证 album 节点下直接包含 song 子节点
+	if !(strings.Contains(body, `<album id="1"`) && strings.Count(body, `<song `) == 3 && !strings.Contains(body, `<randomSongs>`)) {
 		t.Fatalf("unexpected getAlbum body: %s", body)
 	}
 }
 
 func TestGetCoverArt_NotFound(t *testing.T) {
 	app, _ := setup(t)
-	code, body := get(app, "/rest/getCoverArt.view?id=999")
+	code, body := get(app, "/rest/getCoverArt.view?id=999&u=test&p=enc:74657374&v=1.16.1&c=test")
 	if code != 404 {
 		t.Fatalf("expected 404, got %d body=%s", code, body)
 	}
@@ -125,7 +159,7 @@ func TestGetCoverArt_NotFound(t *testing.T) {
 
 func TestStream_NotFound(t *testing.T) {
 	app, _ := setup(t)
-	code, body := get(app, "/rest/stream.view?id=999")
+	code, body := get(app, "/rest/stream.view?id=999&u=test&p=enc:74657374&v=1.16.1&c=test")
 	if code != 404 {
 		t.Fatalf("expected 404, got %d body=%s", code, body)
 	}
@@ -144,9 +178,1115 @@ func TestGetRandomSongs(t *testing.T) {
 }
 
 // 便于在容器里本地查看 XML
-func ExampleBrowse() {
+func Example_browse() {
 	app, _ := setup(&testing.T{})
 	_, _ = get(app, "/rest/ping.view?u=test&p=enc:74657374&v=1.16.1&c=test")
 	fmt.Println("ok")
 	// Output: ok
 }
+
+func TestSearch3(t *testing.T) {
+	app, _ := setup(t)
+	code, body := get(app, "/rest/search3.view?query=song&u=test&p=enc:74657374&v=1.16.1&c=test")
+	if code != 200 {
+		t.Fatalf("status=%d body=%s", code, body)
+	}
+	if !strings.Contains(body, `<searchResult3>`) || strings.Count(body, `<song `) != 3 {
+		t.Fatalf("unexpected search3 body: %s", body)
+	}
+	if !strings.Contains(body, `path="Artist A/Test Album/song1.mp3"`) || !strings.Contains(body, `contentType="audio/mpeg"`) {
+		t.Fatalf("song element missing attributes: %s", body)
+	}
+}
+
+func TestSearch3_CountsAndOffsets(t *testing.T) {
+	app, _ := setup(t)
+	code, body := get(app, `/rest/search3.view?query=""&artistCount=1&artistOffset=1&albumCount=0&songCount=1&songOffset=2&u=test&p=enc:74657374&v=1.16.1&c=test`)
+	if code != 200 {
+		t.Fatalf("status=%d body=%s", code, body)
+	}
+	if strings.Count(body, `<artist `) != 1 || strings.Count(body, `<song `) != 1 || strings.Contains(body, `<album `) {
+		t.Fatalf("unexpected paging result: %s", body)
+	}
+	// 按名称排序后 offset=1 的艺术家为 "Artist A"，offset=2 的歌曲为 "Song 3"
+	if !strings.Contains(body, `name="Artist A"`) || !strings.Contains(body, `title="Song 3"`) {
+		t.Fatalf("unexpected paging result: %s", body)
+	}
+}
+
+func TestSearch2_Albums(t *testing.T) {
+	app, _ := setup(t)
+	code, body := get(app, "/rest/search2.view?query=Test&u=test&p=enc:74657374&v=1.16.1&c=test")
+	if code != 200 {
+		t.Fatalf("status=%d body=%s", code, body)
+	}
+	if !strings.Contains(body, `<searchResult2>`) || !strings.Contains(body, `name="Test Album"`) || !strings.Contains(body, `songCount="3"`) {
+		t.Fatalf("unexpected search2 body: %s", body)
+	}
+}
+
+func TestAuth_MissingCredentials(t *testing.T) {
+	app, _ := setup(t)
+	code, body := get(app, "/rest/ping.view?v=1.16.1&c=test")
+	if code != 200 || !strings.Contains(body, `status="failed"`) || !strings.Contains(body, `code="40"`) {
+		t.Fatalf("expected auth failure 40, got %d %s", code, body)
+	}
+}
+
+func TestAuth_WrongPassword(t *testing.T) {
+	app, _ := setup(t)
+	_, body := get(app, "/rest/getArtists.view?u=test&p=wrong&v=1.16.1&c=test")
+	if !strings.Contains(body, `code="40"`) || strings.Contains(body, `<artists>`) {
+		t.Fatalf("expected auth failure 40, got %s", body)
+	}
+}
+
+func TestAuth_TokenWithAppPassword(t *testing.T) {
+	app, db, user := setupWithUser(t)
+
+	// 登录密码只有 bcrypt 哈希，token 认证必须失败
+	salt := "c19b2d"
+	sum := md5.Sum([]byte("test" + salt))
+	_, body := get(app, fmt.Sprintf("/rest/ping.view?u=test&t=%x&s=%s&v=1.16.1&c=test", sum, salt))
+	if !strings.Contains(body, `code="40"`) {
+		t.Fatalf("expected token auth to fail without app password: %s", body)
+	}
+
+	cred := entity.SubsonicCredential{UserID: user.ID, Type: entity.CredentialAppPassword, Secret: "sesame"}
+	if err := db.Create(&cred).Error; err != nil {
+		t.Fatalf("seed credential: %v", err)
+	}
+	sum = md5.Sum([]byte("sesame" + salt))
+	_, body = get(app, fmt.Sprintf("/rest/ping.view?u=test&t=%x&s=%s&v=1.16.1&c=test", sum, salt))
+	if !strings.Contains(body, `status="ok"`) {
+		t.Fatalf("expected token auth to succeed: %s", body)
+	}
+
+	// 应用密码也可以直接作为 p= 使用
+	_, body = get(app, "/rest/ping.view?u=test&p=sesame&v=1.16.1&c=test")
+	if !strings.Contains(body, `status="ok"`) {
+		t.Fatalf("expected app password auth to succeed: %s", body)
+	}
+}
+
+func TestAuth_APIKey(t *testing.T) {
+	app, db, user := setupWithUser(t)
+	cred := entity.SubsonicCredential{UserID: user.ID, Type: entity.CredentialAPIKey}
+	if err := db.Create(&cred).Error; err != nil {
+		t.Fatalf("seed credential: %v", err)
+	}
+	if len(cred.Secret) != 64 {
+		t.Fatalf("expected generated api key, got %q", cred.Secret)
+	}
+
+	_, body := get(app, "/rest/ping.view?apiKey="+cred.Secret+"&v=1.16.1&c=test")
+	if !strings.Contains(body, `status="ok"`) {
+		t.Fatalf("expected api key auth to succeed: %s", body)
+	}
+	_, body = get(app, "/rest/ping.view?apiKey=nope&v=1.16.1&c=test")
+	if !strings.Contains(body, `code="44"`) {
+		t.Fatalf("expected invalid api key 44: %s", body)
+	}
+	_, body = get(app, "/rest/ping.view?apiKey="+cred.Secret+"&u=test&v=1.16.1&c=test")
+	if !strings.Contains(body, `code="43"`) {
+		t.Fatalf("expected conflicting auth 43: %s", body)
+	}
+}
+
+func TestFormat_JSON(t *testing.T) {
+	app, _ := setup(t)
+	code, body := get(app, "/rest/search3.view?query=Song%201&f=json&u=test&p=enc:74657374&v=1.16.1&c=test")
+	if code != 200 {
+		t.Fatalf("status=%d body=%s", code, body)
+	}
+	var env struct {
+		Response struct {
+			Status        string `json:"status"`
+			Version       string `json:"version"`
+			SearchResult3 struct {
+				Song []struct {
+					ID    string `json:"id"`
+					Title string `json:"title"`
+					IsDir bool   `json:"isDir"`
+				} `json:"song"`
+			} `json:"searchResult3"`
+		} `json:"subsonic-response"`
+	}
+	if err := json.Unmarshal([]byte(body), &env); err != nil {
+		t.Fatalf("invalid json: %v body=%s", err, body)
+	}
+	r := env.Response
+	if r.Status != "ok" || r.Version == "" || len(r.SearchResult3.Song) != 1 || r.SearchResult3.Song[0].Title != "Song 1" {
+		t.Fatalf("unexpected json body: %s", body)
+	}
+}
+
+func TestFormat_JSONError(t *testing.T) {
+	app, _ := setup(t)
+	_, body := get(app, "/rest/ping.view?f=json&u=test&p=wrong")
+	if !strings.HasPrefix(body, `{"subsonic-response":{"status":"failed"`) || !strings.Contains(body, `"code":40`) {
+		t.Fatalf("unexpected json error body: %s", body)
+	}
+}
+
+func TestFormat_JSONP(t *testing.T) {
+	app, _ := setup(t)
+	_, body := get(app, "/rest/ping.view?f=jsonp&callback=cb&u=test&p=enc:74657374&v=1.16.1&c=test")
+	if !strings.HasPrefix(body, `cb({"subsonic-response":{"status":"ok"`) || !strings.HasSuffix(body, ");") {
+		t.Fatalf("unexpected jsonp body: %s", body)
+	}
+	_, body = get(app, "/rest/ping.view?f=jsonp&callback=alert(1)&u=test&p=enc:74657374&v=1.16.1&c=test")
+	if strings.Contains(body, "alert") || !strings.Contains(body, `"code":10`) {
+		t.Fatalf("expected invalid callback to be rejected: %s", body)
+	}
+}
+
+func TestGetMusicFolders(t *testing.T) {
+	app, _ := setup(t)
+	_, body := get(app, "/rest/getMusicFolders.view?u=test&p=enc:74657374&v=1.16.1&c=test")
+	if !strings.Contains(body, `<musicFolder id="1" name="music">`) {
+		t.Fatalf("unexpected getMusicFolders body: %s", body)
+	}
+}
+
+func TestLibraryAccess(t *testing.T) {
+	app, db, user := setupWithUser(t)
+	const auth = "&u=test&p=enc:74657374&v=1.16.1&c=test"
+
+	// 第二个音乐库，只授权给当前用户
+	other := entity.Library{Name: "other", Path: "/other"}
+	db.Create(&other)
+	db.Create(&entity.Album{ID: "2", Name: "Other Album", ArtistName: "Artist C", LibraryID: other.ID})
+	db.Create(&entity.Music{Title: "Other Song", Artist: "Artist C", AlbumID: "2", FileUrl: "/other/a.mp3", Suffix: "mp3", LibraryID: other.ID})
+	library.Load(db)
+
+	_, body := get(app, "/rest/getMusicFolders.view?"+auth)
+	if !strings.Contains(body, `<musicFolder id="1" name="music">`) || !strings.Contains(body, `<musicFolder id="2" name="other">`) {
+		t.Fatalf("expected both folders without restriction: %s", body)
+	}
+	_, body = get(app, "/rest/getAlbumList2.view?type=alphabeticalByName&musicFolderId=2"+auth)
+	if strings.Count(body, "<album ") != 1 || !strings.Contains(body, `name="Other Album"`) {
+		t.Fatalf("expected musicFolderId filter: %s", body)
+	}
+
+	if err := library.SetUserLibraries(db, user.ID, []uint{other.ID}); err != nil {
+		t.Fatalf("grant: %v", err)
+	}
+	_, body = get(app, "/rest/getMusicFolders.view?"+auth)
+	if strings.Contains(body, `name="music"`) || !strings.Contains(body, `name="other"`) {
+		t.Fatalf("expected only granted folder: %s", body)
+	}
+	_, body = get(app, "/rest/getAlbum.view?id=1"+auth)
+	if !strings.Contains(body, `code="70"`) {
+		t.Fatalf("expected album in other library to be hidden: %s", body)
+	}
+	_, body = get(app, "/rest/search3.view?query="+auth)
+	if strings.Contains(body, "Song 1") || !strings.Contains(body, "Other Song") {
+		t.Fatalf("expected search scoped to granted library: %s", body)
+	}
+	_, body = get(app, "/rest/getArtists.view?musicFolderId=1"+auth)
+	if !strings.Contains(body, `code="50"`) {
+		t.Fatalf("expected forbidden musicFolderId: %s", body)
+	}
+}
+
+func TestHiddenItemAnnotations(t *testing.T) {
+	app, db, user := setupWithUser(t)
+	const auth = "&u=test&p=enc:74657374&v=1.16.1&c=test"
+
+	other := entity.Library{Name: "other", Path: "/other"}
+	db.Create(&other)
+	db.Create(&entity.Album{ID: "2", Name: "Other Album", ArtistName: "Artist C", LibraryID: other.ID})
+	hidden := entity.Music{Title: "Other Song", Artist: "Artist C", AlbumID: "2", FileUrl: "/other/a.mp3", Suffix: "mp3", LibraryID: other.ID}
+	db.Create(&hidden)
+	library.Load(db)
+	if err := library.SetUserLibraries(db, user.ID, []uint{1}); err != nil {
+		t.Fatalf("grant: %v", err)
+	}
+
+	for _, path := range []string{
+		"/rest/star.view?id=" + hidden.ID,
+		"/rest/star.view?albumId=2",
+		"/rest/setRating.view?rating=5&id=" + hidden.ID,
+		"/rest/setRating.view?rating=5&id=2",
+		"/rest/scrobble.view?submission=false&id=" + hidden.ID,
+	} {
+		if _, body := get(app, path+auth); !strings.Contains(body, `code="70"`) {
+			t.Errorf("%s: expected hidden item to be not found: %s", path, body)
+		}
+	}
+	var count int64
+	db.Model(&entity.Annotation{}).Count(&count)
+	if count != 0 {
+		t.Fatalf("expected no annotations on hidden items, got %d", count)
+	}
+	db.Model(&entity.PlayEvent{}).Count(&count)
+	if count != 0 {
+		t.Fatalf("expected no play events for hidden songs, got %d", count)
+	}
+
+	// 不受限制的用户看不到隐藏歌曲出现在正在播放中
+	if err := library.SetUserLibraries(db, user.ID, nil); err != nil {
+		t.Fatalf("unrestrict: %v", err)
+	}
+	if _, body := get(app, "/rest/getNowPlaying.view?"+auth); strings.Contains(body, "Other Song") {
+		t.Fatalf("expected hidden song not to be reported as playing: %s", body)
+	}
+}
+
+func TestGetIndexesAndMusicDirectory(t *testing.T) {
+	app, db := setup(t)
+	code, body := get(app, "/rest/getIndexes.view?f=json&u=test&p=enc:74657374&v=1.16.1&c=test")
+	if code != 200 {
+		t.Fatalf("status=%d body=%s", code, body)
+	}
+	var env struct {
+		Response struct {
+			Indexes struct {
+				LastModified int64 `json:"lastModified"`
+				Index        []struct {
+					Name   string `json:"name"`
+					Artist []struct {
+						ID   string `json:"id"`
+						Name string `json:"name"`
+					} `json:"artist"`
+				} `json:"index"`
+			} `json:"indexes"`
+		} `json:"subsonic-response"`
+	}
+	if err := json.Unmarshal([]byte(body), &env); err != nil {
+		t.Fatalf("invalid json: %v", err)
+	}
+	idx := env.Response.Indexes
+	if idx.LastModified == 0 || len(idx.Index) != 1 || idx.Index[0].Name != "A" || idx.Index[0].Artist[0].Name != "Artist A" {
+		t.Fatalf("unexpected indexes: %s", body)
+	}
+
+	// 缓存未过期时不返回索引
+	_, body = get(app, fmt.Sprintf("/rest/getIndexes.view?ifModifiedSince=%d&u=test&p=enc:74657374&v=1.16.1&c=test", idx.LastModified))
+	if strings.Contains(body, `<index `) {
+		t.Fatalf("expected no indexes when not modified: %s", body)
+	}
+
+	// Artist A -> Test Album -> 3 首歌曲
+	artistDir := idx.Index[0].Artist[0].ID
+	_, body = get(app, "/rest/getMusicDirectory.view?id="+artistDir+"&u=test&p=enc:74657374&v=1.16.1&c=test")
+	if !strings.Contains(body, `name="Artist A"`) || !strings.Contains(body, `isDir="true" title="Test Album"`) {
+		t.Fatalf("unexpected artist directory: %s", body)
+	}
+	start := strings.Index(body, `<child id="`) + len(`<child id="`)
+	albumDir := body[start : start+16]
+	_, body = get(app, "/rest/getMusicDirectory.view?id="+albumDir+"&u=test&p=enc:74657374&v=1.16.1&c=test")
+	if strings.Count(body, `isDir="false"`) != 3 || !strings.Contains(body, `parent="`+artistDir+`"`) {
+		t.Fatalf("unexpected album directory: %s", body)
+	}
+
+	_, body = get(app, "/rest/getMusicDirectory.view?id=nope&u=test&p=enc:74657374&v=1.16.1&c=test")
+	if !strings.Contains(body, `code="70"`) {
+		t.Fatalf("expected not found: %s", body)
+	}
+
+	// 目录树按音乐库缓存，音乐库记录增删歌曲后重建
+	db.Create(&entity.Music{Title: "Song 4", Artist: "Zed", FileUrl: "/music/Zed/Other/song4.mp3", Suffix: "mp3", LibraryID: 1})
+	_, body = get(app, "/rest/getIndexes.view?u=test&p=enc:74657374&v=1.16.1&c=test")
+	if strings.Contains(body, `name="Zed"`) {
+		t.Fatalf("expected cached folder tree: %s", body)
+	}
+	if err := library.Touch(db, 1); err != nil {
+		t.Fatal(err)
+	}
+	_, body = get(app, "/rest/getIndexes.view?u=test&p=enc:74657374&v=1.16.1&c=test")
+	if !strings.Contains(body, `name="Zed"`) {
+		t.Fatalf("expected folder tree to be rebuilt: %s", body)
+	}
+
+	// 删除歌曲不会改变其余歌曲的 updated_at，由音乐库的修改时间让客户端缓存失效
+	_, body = get(app, "/rest/getIndexes.view?f=json&u=test&p=enc:74657374&v=1.16.1&c=test")
+	if err := json.Unmarshal([]byte(body), &env); err != nil {
+		t.Fatalf("invalid json: %v", err)
+	}
+	since := env.Response.Indexes.LastModified
+	db.Where("title = ?", "Song 4").Delete(&entity.Music{})
+	time.Sleep(2 * time.Millisecond)
+	if err := library.Touch(db, 1); err != nil {
+		t.Fatal(err)
+	}
+	_, body = get(app, fmt.Sprintf("/rest/getIndexes.view?ifModifiedSince=%d&u=test&p=enc:74657374&v=1.16.1&c=test", since))
+	if !strings.Contains(body, `<index `) || strings.Contains(body, `name="Zed"`) {
+		t.Fatalf("expected indexes after a song was removed: %s", body)
+	}
+}
+
+func TestGetArtist(t *testing.T) {
+	app, _ := setup(t)
+	_, body := get(app, "/rest/getArtist.view?id="+entity.ArtistID("Band B")+"&u=test&p=enc:74657374&v=1.16.1&c=test")
+	if !strings.Contains(body, `<artist id="`+entity.ArtistID("Band B")+`" name="Band B" albumCount="1">`) || !strings.Contains(body, `<album id="1" name="Test Album"`) {
+		t.Fatalf("unexpected getArtist body: %s", body)
+	}
+	_, body = get(app, "/rest/getArtist.view?id=nobody&u=test&p=enc:74657374&v=1.16.1&c=test")
+	if !strings.Contains(body, `code="70"`) {
+		t.Fatalf("expected not found: %s", body)
+	}
+}
+
+func TestMultiArtistCredits(t *testing.T) {
+	app, db := setup(t)
+	const auth = "&u=test&p=enc:74657374&v=1.16.1&c=test"
+
+	duet := entity.Music{Title: "Duet", Artist: "Artist A feat. Guest Star", Composer: "Writer; Artist A", AlbumID: "1", FileUrl: "/music/Artist A/Test Album/duet.mp3", Suffix: "mp3", LibraryID: 1}
+	db.Create(&duet)
+	if err := artist.Backfill(db); err != nil {
+		t.Fatalf("backfill: %v", err)
+	}
+
+	_, body := get(app, "/rest/getArtist.view?id="+entity.ArtistID("Guest Star")+auth)
+	if !strings.Contains(body, `<artist id="`+entity.ArtistID("Guest Star")+`" name="Guest Star" albumCount="1">`) || !strings.Contains(body, `<album id="1"`) {
+		t.Fatalf("expected featured artist to be split out: %s", body)
+	}
+	// 歌曲的 artistId 指向主要艺术家
+	_, body = get(app, "/rest/search3.view?query=Duet&artistCount=0&albumCount=0"+auth)
+	if !strings.Contains(body, `artist="Artist A feat. Guest Star"`) || !strings.Contains(body, `artistId="`+entity.ArtistID("Artist A")+`"`) {
+		t.Fatalf("unexpected song artist: %s", body)
+	}
+	// 仅作曲的艺术家不出现在艺术家列表中
+	_, body = get(app, "/rest/getArtists.view?"+auth)
+	if !strings.Contains(body, `name="Guest Star"`) || strings.Contains(body, `name="Writer"`) {
+		t.Fatalf("unexpected artists: %s", body)
+	}
+	var roles []entity.ArtistRole
+	db.Model(&entity.MusicArtist{}).Where("music_id = ? AND artist_id = ?", duet.ID, entity.ArtistID("Artist A")).Order("role ASC").Pluck("role", &roles)
+	if len(roles) != 2 || roles[0] != entity.ArtistRoleArtist || roles[1] != entity.ArtistRoleComposer {
+		t.Fatalf("expected Artist A credited as artist and composer: %v", roles)
+	}
+}
+
+func TestCompilationAlbum(t *testing.T) {
+	app, db := setup(t)
+	const auth = "&u=test&p=enc:74657374&v=1.16.1&c=test"
+
+	_, body := get(app, "/rest/getAlbum.view?id=1"+auth)
+	if strings.Contains(body, `isCompilation`) {
+		t.Fatalf("regular album should not be a compilation: %s", body)
+	}
+	db.Model(&entity.Album{}).Where("id = ?", "1").Updates(map[string]interface{}{"is_compilation": true, "artist_name": "Various Artists"})
+	_, body = get(app, "/rest/getAlbum.view?id=1"+auth)
+	if !strings.Contains(body, `artist="Various Artists"`) || !strings.Contains(body, `isCompilation="true"`) {
+		t.Fatalf("expected compilation album: %s", body)
+	}
+}
+
+func TestGetAlbumList2(t *testing.T) {
+	app, _ := setup(t)
+	for _, q := range []string{"type=newest", "type=alphabeticalByName", "type=random", "type=byGenre&genre=Rock", "type=byYear&fromYear=2005&toYear=2000"} {
+		_, body := get(app, "/rest/getAlbumList2.view?"+q+"&u=test&p=enc:74657374&v=1.16.1&c=test")
+		if !strings.Contains(body, `<albumList2>`) || !strings.Contains(body, `year="2001" genre="Rock"`) {
+			t.Fatalf("%s: unexpected body: %s", q, body)
+		}
+	}
+	for _, q := range []string{"type=frequent", "type=recent", "type=starred", "type=byGenre&genre=Jazz", "type=byYear&fromYear=1990&toYear=1999"} {
+		_, body := get(app, "/rest/getAlbumList2.view?"+q+"&u=test&p=enc:74657374&v=1.16.1&c=test")
+		if !strings.Contains(body, `status="ok"`) || strings.Contains(body, `<album `) {
+			t.Fatalf("%s: expected empty list: %s", q, body)
+		}
+	}
+}
+
+func TestGetSongAndGenres(t *testing.T) {
+	app, db := setup(t)
+	var m entity.Music
+	db.First(&m, "title = ?", "Song 2")
+	_, body := get(app, "/rest/getSong.view?id="+m.ID+"&u=test&p=enc:74657374&v=1.16.1&c=test")
+	if !strings.Contains(body, `title="Song 2"`) || !strings.Contains(body, `album="Test Album"`) || !strings.Contains(body, `suffix="mp3"`) {
+		t.Fatalf("unexpected getSong body: %s", body)
+	}
+
+	_, body = get(app, "/rest/getGenres.view?u=test&p=enc:74657374&v=1.16.1&c=test")
+	if !strings.Contains(body, `<genre songCount="2" albumCount="1">Rock</genre>`) || !strings.Contains(body, `>Pop</genre>`) {
+		t.Fatalf("unexpected getGenres body: %s", body)
+	}
+
+	_, body = get(app, "/rest/getSongsByGenre.view?genre=Rock&u=test&p=enc:74657374&v=1.16.1&c=test")
+	if strings.Count(body, `<song `) != 2 || strings.Contains(body, `title="Song 3"`) {
+		t.Fatalf("unexpected getSongsByGenre body: %s", body)
+	}
+}
+
+func TestMusicBrainzIDs(t *testing.T) {
+	app, db := setup(t)
+	const auth = "&u=test&p=enc:74657374&v=1.16.1&c=test"
+	db.Model(&entity.Album{}).Where("id = ?", "1").Update("mbz_album_id", "f5093c06-23e3-404f-aeaa-40f72885ee3a")
+	db.Model(&entity.Music{}).Where("title = ?", "Song 1").Updates(map[string]interface{}{
+		"mbz_track_id": "b1a9c0e9-d987-4042-ae91-78d6a3267d69", "bpm": 128})
+
+	_, body := get(app, "/rest/getAlbum.view?id=1"+auth)
+	if !strings.Contains(body, `<album id="1"`) || !strings.Contains(body, `musicBrainzId="f5093c06-23e3-404f-aeaa-40f72885ee3a"`) {
+		t.Fatalf("album musicBrainzId missing: %s", body)
+	}
+	if !strings.Contains(body, `musicBrainzId="b1a9c0e9-d987-4042-ae91-78d6a3267d69" bpm="128"`) {
+		t.Fatalf("song musicBrainzId/bpm missing: %s", body)
+	}
+}
+
+func TestSongContentType_Codec(t *testing.T) {
+	app, db := setup(t)
+	musics := []entity.Music{
+		{Title: "AAC", AlbumID: "1", FileUrl: "/music/Artist A/Test Album/aac.m4a", Suffix: "m4a", Codec: "aac"},
+		{Title: "ALAC", AlbumID: "1", FileUrl: "/music/Artist A/Test Album/alac.m4a", Suffix: "m4a", Codec: "alac"},
+		{Title: "APE", AlbumID: "1", FileUrl: "/music/Artist A/Test Album/song.ape", Suffix: "ape", Codec: "ape"},
+	}
+	db.Create(&musics)
+	want := map[string]string{"AAC": "audio/mp4", "ALAC": "audio/x-alac", "APE": "audio/x-ape"}
+	for _, m := range musics {
+		_, body := get(app, "/rest/getSong.view?id="+m.ID+"&u=test&p=enc:74657374&v=1.16.1&c=test")
+		if !strings.Contains(body, `contentType="`+want[m.Title]+`"`) {
+			t.Fatalf("%s: unexpected contentType: %s", m.Title, body)
+		}
+	}
+}
+
+func TestPlaylists(t *testing.T) {
+	app, db := setup(t)
+	const auth = "&u=test&p=enc:74657374&v=1.16.1&c=test"
+	var ids []string
+	db.Model(&entity.Music{}).Order("title ASC").Pluck("id", &ids)
+
+	// 创建：顺序为 Song 3, Song 1，默认私有
+	_, body := get(app, "/rest/createPlaylist.view?name=Road&songId="+ids[2]+"&songId="+ids[0]+auth)
+	if !strings.Contains(body, `name="Road"`) || !strings.Contains(body, `songCount="2"`) || !strings.Contains(body, `owner="test"`) ||
+		!strings.Contains(body, `public="false"`) {
+		t.Fatalf("unexpected createPlaylist body: %s", body)
+	}
+	if strings.Index(body, `title="Song 3"`) > strings.Index(body, `title="Song 1"`) {
+		t.Fatalf("entries out of order: %s", body)
+	}
+	var playlist entity.Playlist
+	db.First(&playlist, "name = ?", "Road")
+	if playlist.IsPublic {
+		t.Fatalf("expected new playlist to be private")
+	}
+
+	// 同一首歌可以重复出现
+	_, body = get(app, "/rest/updatePlaylist.view?playlistId="+playlist.ID+"&songIdToAdd="+ids[2]+auth)
+	if !strings.Contains(body, `status="ok"`) {
+		t.Fatalf("unexpected updatePlaylist body: %s", body)
+	}
+	_, body = get(app, "/rest/getPlaylist.view?id="+playlist.ID+auth)
+	if !strings.Contains(body, `songCount="3"`) || strings.Count(body, `title="Song 3"`) != 2 {
+		t.Fatalf("expected duplicate entry: %s", body)
+	}
+	_, body = get(app, "/rest/updatePlaylist.view?playlistId="+playlist.ID+"&songIndexToRemove=2&public=true"+auth)
+	if !strings.Contains(body, `status="ok"`) {
+		t.Fatalf("unexpected updatePlaylist body: %s", body)
+	}
+
+	// 更新：移除第 0 首，追加 Song 2，设为私有并修改备注
+	_, body = get(app, "/rest/updatePlaylist.view?playlistId="+playlist.ID+"&songIndexToRemove=0&songIdToAdd="+ids[1]+"&public=false&comment=night"+auth)
+	if !strings.Contains(body, `status="ok"`) {
+		t.Fatalf("unexpected updatePlaylist body: %s", body)
+	}
+	_, body = get(app, "/rest/getPlaylist.view?id="+playlist.ID+auth)
+	if !strings.Contains(body, `comment="night"`) || !strings.Contains(body, `public="false"`) || strings.Contains(body, `title="Song 3"`) {
+		t.Fatalf("unexpected getPlaylist body: %s", body)
+	}
+	if strings.Index(body, `title="Song 1"`) > strings.Index(body, `title="Song 2"`) {
+		t.Fatalf("entries out of order: %s", body)
+	}
+
+	// playlistId + songId 替换全部歌曲
+	_, body = get(app, "/rest/createPlaylist.view?playlistId="+playlist.ID+"&songId="+ids[2]+auth)
+	if !strings.Contains(body, `songCount="1"`) || !strings.Contains(body, `title="Song 3"`) {
+		t.Fatalf("unexpected replace body: %s", body)
+	}
+
+	// 其他用户既看不到私有列表，也不能修改/删除
+	other := entity.User{Username: "other", Email: "other@localhost", Password: "other", Status: 1}
+	db.Create(&other)
+	const otherAuth = "&u=other&p=other&v=1.16.1&c=test"
+	_, body = get(app, "/rest/getPlaylists.view?"+otherAuth)
+	if strings.Contains(body, `name="Road"`) {
+		t.Fatalf("private playlist leaked: %s", body)
+	}
+	_, body = get(app, "/rest/deletePlaylist.view?id="+playlist.ID+otherAuth)
+	if !strings.Contains(body, `code="50"`) {
+		t.Fatalf("expected not authorized: %s", body)
+	}
+
+	_, body = get(app, "/rest/getPlaylists.view?"+auth)
+	if !strings.Contains(body, `name="Road"`) {
+		t.Fatalf("unexpected getPlaylists body: %s", body)
+	}
+	_, body = get(app, "/rest/deletePlaylist.view?id="+playlist.ID+auth)
+	if !strings.Contains(body, `status="ok"`) {
+		t.Fatalf("unexpected deletePlaylist body: %s", body)
+	}
+	_, body = get(app, "/rest/getPlaylist.view?id="+playlist.ID+auth)
+	if !strings.Contains(body, `code="70"`) {
+		t.Fatalf("expected deleted playlist to be gone: %s", body)
+	}
+}
+
+func TestFormPost(t *testing.T) {
+	app, db := setup(t)
+	const auth = "u=test&p=enc:74657374&v=1.16.1&c=test"
+	var ids []string
+	db.Model(&entity.Music{}).Order("title ASC").Pluck("id", &ids)
+
+	code, body := post(app, "/rest/ping.view", auth)
+	if code != 200 || !strings.Contains(body, `status="ok"`) {
+		t.Fatalf("status=%d body=%s", code, body)
+	}
+
+	// 参数全部放在表单里，重复参数不能因为合并查询参数而被读两次
+	_, body = post(app, "/rest/createPlaylist.view", "name=Form&songId="+ids[0]+"&songId="+ids[1]+"&"+auth)
+	if !strings.Contains(body, `name="Form"`) || !strings.Contains(body, `songCount="2"`) {
+		t.Fatalf("unexpected createPlaylist body: %s", body)
+	}
+	var playlist entity.Playlist
+	db.First(&playlist, "name = ?", "Form")
+
+	// 查询参数和表单参数可以混用
+	_, body = post(app, "/rest/updatePlaylist.view?playlistId="+playlist.ID, "songIdToAdd="+ids[2]+"&"+auth)
+	if !strings.Contains(body, `status="ok"`) {
+		t.Fatalf("unexpected updatePlaylist body: %s", body)
+	}
+	_, body = post(app, "/rest/getPlaylist.view", "id="+playlist.ID+"&"+auth)
+	if !strings.Contains(body, `songCount="3"`) || strings.Count(body, `title="Song 3"`) != 1 {
+		t.Fatalf("unexpected getPlaylist body: %s", body)
+	}
+}
+
+func TestUpdatePlaylistHiddenSongs(t *testing.T) {
+	app, db, user := setupWithUser(t)
+	const auth = "&u=test&p=enc:74657374&v=1.16.1&c=test"
+	var ids []string
+	db.Model(&entity.Music{}).Order("title ASC").Pluck("id", &ids)
+
+	other := entity.Library{Name: "other", Path: "/other"}
+	db.Create(&other)
+	hidden := entity.Music{Title: "Other Song", FileUrl: "/other/a.mp3", Suffix: "mp3", LibraryID: other.ID}
+	db.Create(&hidden)
+	library.Load(db)
+
+	// 列表为 Song 1, Other Song, Song 2, Song 3；用户只能看到默认音乐库
+	playlist := entity.Playlist{Name: "Mixed", UserID: user.ID}
+	db.Create(&playlist)
+	for i, id := range []string{ids[0], hidden.ID, ids[1], ids[2]} {
+		db.Create(&entity.PlaylistMusic{PlaylistID: playlist.ID, Position: i, MusicID: id})
+	}
+	if err := library.SetUserLibraries(db, user.ID, []uint{1}); err != nil {
+		t.Fatalf("grant: %v", err)
+	}
+
+	// 客户端看到的第 1 首是 Song 2；无权访问的歌曲不能添加
+	_, body := get(app, "/rest/updatePlaylist.view?playlistId="+playlist.ID+"&songIndexToRemove=1&songIdToAdd="+hidden.ID+auth)
+	if !strings.Contains(body, `status="ok"`) {
+		t.Fatalf("unexpected updatePlaylist body: %s", body)
+	}
+	musics, err := entity.LoadPlaylistMusics(db, playlist.ID)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	var titles []string
+	for _, m := range musics {
+		titles = append(titles, m.Title)
+	}
+	if strings.Join(titles, ",") != "Song 1,Other Song,Song 3" {
+		t.Fatalf("unexpected playlist %v", titles)
+	}
+
+	_, body = get(app, "/rest/createPlaylist.view?name=New&songId="+hidden.ID+"&songId="+ids[0]+auth)
+	if !strings.Contains(body, `songCount="1"`) {
+		t.Fatalf("expected hidden song to be skipped: %s", body)
+	}
+	var count int64
+	db.Model(&entity.PlaylistMusic{}).Where("playlist_id <> ? AND music_id = ?", playlist.ID, hidden.ID).Count(&count)
+	if count != 0 {
+		t.Fatalf("expected hidden song not to be added to the new playlist")
+	}
+}
+
+func TestMigratePlaylistPositions(t *testing.T) {
+	app, db := setup(t)
+	const auth = "&u=test&p=enc:74657374&v=1.16.1&c=test"
+	var ids []string
+	db.Model(&entity.Music{}).Order("title ASC").Pluck("id", &ids)
+	var user entity.User
+	db.First(&user, "username = ?", "test")
+	playlist := entity.Playlist{Name: "Old", UserID: user.ID}
+	db.Create(&playlist)
+
+	// 旧版表以 (playlist_id, music_id) 为主键，按 order 排序
+	if err := db.Migrator().DropTable(&entity.PlaylistMusic{}); err != nil {
+		t.Fatal(err)
+	}
+	db.Exec("CREATE TABLE playlist_musics (playlist_id varchar(8), music_id varchar(8), `order` int DEFAULT 0, PRIMARY KEY (playlist_id, music_id))")
+	for i, id := range []string{ids[1], ids[2], ids[0]} {
+		db.Exec("INSERT INTO playlist_musics (playlist_id, music_id, `order`) VALUES (?, ?, ?)", playlist.ID, id, i)
+	}
+	if err := database.MigratePlaylistPositions(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	if err := database.MigratePlaylistPositions(db); err != nil {
+		t.Fatalf("migrate again: %v", err)
+	}
+
+	var rows []entity.PlaylistMusic
+	db.Order("position").Find(&rows)
+	if len(rows) != 3 || rows[0].MusicID != ids[1] || rows[1].MusicID != ids[2] || rows[2].MusicID != ids[0] || rows[2].Position != 2 {
+		t.Fatalf("unexpected rows %+v", rows)
+	}
+	// 迁移后可以重复添加同一首歌
+	_, body := get(app, "/rest/updatePlaylist.view?playlistId="+playlist.ID+"&songIdToAdd="+ids[1]+auth)
+	if !strings.Contains(body, `status="ok"`) {
+		t.Fatalf("unexpected updatePlaylist body: %s", body)
+	}
+	_, body = get(app, "/rest/getPlaylist.view?id="+playlist.ID+auth)
+	if !strings.Contains(body, `songCount="4"`) || strings.Index(body, `title="Song 2"`) > strings.Index(body, `title="Song 3"`) {
+		t.Fatalf("unexpected playlist after migration: %s", body)
+	}
+}
+
+func TestStarAndRating(t *testing.T) {
+	app, db, user := setupWithUser(t)
+	const auth = "&u=test&p=enc:74657374&v=1.16.1&c=test"
+	var ids []string
+	db.Model(&entity.Music{}).Order("title ASC").Pluck("id", &ids)
+
+	// id 自动识别为歌曲，albumId/artistId 显式指定
+	_, body := get(app, "/rest/star.view?id="+ids[0]+"&albumId=1&artistId="+entity.ArtistID("Artist A")+auth)
+	if !strings.Contains(body, `status="ok"`) {
+		t.Fatalf("unexpected star body: %s", body)
+	}
+	_, body = get(app, "/rest/setRating.view?id="+ids[0]+"&rating=4"+auth)
+	if !strings.Contains(body, `status="ok"`) {
+		t.Fatalf("unexpected setRating body: %s", body)
+	}
+
+	_, body = get(app, "/rest/getStarred2.view?"+auth)
+	if !strings.Contains(body, "<starred2>") || !strings.Contains(body, `name="Artist A"`) ||
+		!strings.Contains(body, `name="Test Album"`) || !strings.Contains(body, `title="Song 1"`) ||
+		strings.Contains(body, `title="Song 2"`) {
+		t.Fatalf("unexpected getStarred2 body: %s", body)
+	}
+
+	// 其他接口返回的歌曲/专辑同样带 starred/userRating
+	_, body = get(app, "/rest/getAlbum.view?id=1"+auth)
+	if strings.Count(body, `starred="`) != 2 || !strings.Contains(body, `userRating="4"`) {
+		t.Fatalf("unexpected getAlbum body: %s", body)
+	}
+	_, body = get(app, "/rest/getAlbumList2.view?type=starred"+auth)
+	if !strings.Contains(body, `name="Test Album"`) {
+		t.Fatalf("unexpected starred album list: %s", body)
+	}
+
+	_, body = get(app, "/rest/unstar.view?id="+ids[0]+auth)
+	if !strings.Contains(body, `status="ok"`) {
+		t.Fatalf("unexpected unstar body: %s", body)
+	}
+	_, body = get(app, "/rest/getSong.view?id="+ids[0]+auth)
+	if strings.Contains(body, `starred="`) || !strings.Contains(body, `userRating="4"`) {
+		t.Fatalf("unexpected getSong body: %s", body)
+	}
+	_, body = get(app, "/rest/setRating.view?id=nope&rating=3"+auth)
+	if !strings.Contains(body, `code="70"`) {
+		t.Fatalf("expected not found: %s", body)
+	}
+
+	// 旧版"我的喜爱"播放列表迁移为收藏
+	favorites := entity.Playlist{Name: "我的喜爱", UserID: user.ID}
+	db.Create(&favorites)
+	db.Create(&entity.PlaylistMusic{PlaylistID: favorites.ID, MusicID: ids[1]})
+	if err := database.MigrateFavoritePlaylists(db); err != nil {
+		t.Fatalf("migrate favorites: %v", err)
+	}
+	_, body = get(app, "/rest/getStarred.view?"+auth)
+	if !strings.Contains(body, "<starred>") || !strings.Contains(body, `title="Song 2"`) {
+		t.Fatalf("unexpected getStarred body after migration: %s", body)
+	}
+	var count int64
+	db.Model(&entity.Playlist{}).Where("name = ?", "我的喜爱").Count(&count)
+	if count != 0 {
+		t.Fatalf("favorite playlist should be removed, got %d", count)
+	}
+	var music entity.Music
+	db.First(&music, "id = ?", ids[1])
+	if music.LikeCount != 1 {
+		t.Fatalf("like_count = %d, want 1", music.LikeCount)
+	}
+}
+
+func TestScrobble(t *testing.T) {
+	app, db := setup(t)
+	const auth = "&u=test&p=enc:74657374&v=1.16.1&c=test"
+	var ids []string
+	db.Model(&entity.Music{}).Order("title ASC").Pluck("id", &ids)
+
+	// 正在播放不计入播放次数
+	_, body := get(app, "/rest/scrobble.view?id="+ids[0]+"&submission=false"+auth)
+	if !strings.Contains(body, `status="ok"`) {
+		t.Fatalf("unexpected scrobble body: %s", body)
+	}
+	_, body = get(app, "/rest/getAlbumList2.view?type=recent"+auth)
+	if strings.Contains(body, `name="Test Album"`) {
+		t.Fatalf("now-playing should not count as a play: %s", body)
+	}
+
+	// 批量提交离线播放记录
+	_, body = get(app, "/rest/scrobble.view?id="+ids[0]+"&time=1700000000000&id="+ids[0]+"&time=1600000000000"+auth)
+	if !strings.Contains(body, `status="ok"`) {
+		t.Fatalf("unexpected scrobble body: %s", body)
+	}
+	_, body = get(app, "/rest/getSong.view?id="+ids[0]+auth)
+	if !strings.Contains(body, `playCount="2"`) || !strings.Contains(body, `played="2023-11-14T22:13:20.000Z"`) {
+		t.Fatalf("unexpected getSong body: %s", body)
+	}
+	for _, q := range []string{"type=recent", "type=frequent"} {
+		_, body = get(app, "/rest/getAlbumList2.view?"+q+auth)
+		if !strings.Contains(body, `name="Test Album"`) || !strings.Contains(body, `playCount="2"`) {
+			t.Fatalf("unexpected %s body: %s", q, body)
+		}
+	}
+
+	var events int64
+	db.Model(&entity.PlayEvent{}).Count(&events)
+	if events != 3 {
+		t.Fatalf("play events = %d, want 3", events)
+	}
+
+	// 播放记录按用户区分
+	db.Create(&entity.User{Username: "other", Email: "other@localhost", Password: "other", Status: 1})
+	_, body = get(app, "/rest/getAlbumList2.view?type=recent&u=other&p=other&v=1.16.1&c=test")
+	if strings.Contains(body, `name="Test Album"`) {
+		t.Fatalf("recent list leaked across users: %s", body)
+	}
+	_, body = get(app, "/rest/scrobble.view?id=nope"+auth)
+	if !strings.Contains(body, `code="70"`) {
+		t.Fatalf("expected not found: %s", body)
+	}
+}
+
+func TestGetNowPlaying(t *testing.T) {
+	app, db := setup(t)
+	const auth = "&u=test&p=enc:74657374&v=1.16.1&c=jukebox"
+	var ids []string
+	db.Model(&entity.Music{}).Order("title ASC").Pluck("id", &ids)
+
+	get(app, "/rest/scrobble.view?id="+ids[0]+"&submission=false"+auth)
+	get(app, "/rest/scrobble.view?id="+ids[1]+"&submission=false"+auth)
+
+	// 同一客户端只保留最新的一首
+	_, body := get(app, "/rest/getNowPlaying.view?f=json"+auth)
+	var parsed struct {
+		Response struct {
+			NowPlaying struct {
+				Entry []struct {
+					ID         string `json:"id"`
+					Username   string `json:"username"`
+					PlayerName string `json:"playerName"`
+				} `json:"entry"`
+			} `json:"nowPlaying"`
+		} `json:"subsonic-response"`
+	}
+	if err := json.Unmarshal([]byte(body), &parsed); err != nil {
+		t.Fatalf("invalid json: %v\n%s", err, body)
+	}
+	var found int
+	for _, e := range parsed.Response.NowPlaying.Entry {
+		if e.PlayerName == "jukebox" {
+			found++
+			if e.ID != ids[1] || e.Username != "test" {
+				t.Fatalf("unexpected now playing entry: %+v", e)
+			}
+		}
+	}
+	if found != 1 {
+		t.Fatalf("expected one jukebox entry: %s", body)
+	}
+
+	// 提交播放记录表示歌曲已播完，不再显示为正在播放
+	get(app, "/rest/scrobble.view?id="+ids[1]+"&submission=true"+auth)
+	_, body = get(app, "/rest/getNowPlaying.view?f=json"+auth)
+	if strings.Contains(body, `"playerName":"jukebox"`) {
+		t.Fatalf("expected submission to clear now playing: %s", body)
+	}
+}
+
+func TestStream_Raw(t *testing.T) {
+	app, db := setup(t)
+	const auth = "&u=test&p=enc:74657374&v=1.16.1&c=test"
+	path := filepath.Join(t.TempDir(), "hi-res.flac")
+	if err := os.WriteFile(path, []byte("fLaC-data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	music := entity.Music{Title: "Hi-Res", AlbumID: "1", FileUrl: path, Suffix: "flac", BitRate: 1411, Duration: 60}
+	db.Create(&music)
+
+	// 未限制码率时直接输出原文件
+	req := httptest.NewRequest("GET", "/rest/stream.view?id="+music.ID+auth, nil)
+	res, _ := app.Test(req, -1)
+	body, _ := io.ReadAll(res.Body)
+	if res.StatusCode != 200 || string(body) != "fLaC-data" || res.Header.Get("Content-Type") != "audio/flac" {
+		t.Fatalf("unexpected raw stream: %d %q %s", res.StatusCode, body, res.Header.Get("Content-Type"))
+	}
+
+	// 用户默认码率限制下 format=raw 仍输出原文件
+	db.Model(&entity.User{}).Where("username = ?", "test").Update("max_bit_rate", 128)
+	_, out := get(app, "/rest/stream.view?id="+music.ID+"&format=raw"+auth)
+	if out != "fLaC-data" {
+		t.Fatalf("format=raw should bypass transcoding, got %q", out)
+	}
+
+	_, out = get(app, "/rest/stream.view?id="+music.ID+"&format=wma"+auth)
+	if !strings.Contains(out, "unsupported transcoding format") {
+		t.Fatalf("expected unsupported format error, got %q", out)
+	}
+}
+
+func TestStream_CueTrack(t *testing.T) {
+	app, db := setup(t)
+	const auth = "&u=test&p=enc:74657374&v=1.16.1&c=test"
+	dir := t.TempDir()
+	// 用输出参数的脚本代替 ffmpeg
+	ffmpeg := filepath.Join(dir, "ffmpeg")
+	if err := os.WriteFile(ffmpeg, []byte("#!/bin/sh\necho \"$@\"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	config.AppConfig.Transcoding.FFmpegPath = ffmpeg
+	path := filepath.Join(dir, "album.wav")
+	if err := os.WriteFile(path, []byte("RIFF-data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	music := entity.Music{Title: "Second", AlbumID: "1", FileUrl: entity.CueTrackPath(path, 2), CueFile: filepath.Join(dir, "album.cue"),
+		CueStart: 42493, CueEnd: 90000, Suffix: "wav", BitRate: 1411, Duration: 47}
+	db.Create(&music)
+
+	// 只截取音轨所在的片段并转码为 FLAC
+	req := httptest.NewRequest("GET", "/rest/download.view?id="+music.ID+auth, nil)
+	res, _ := app.Test(req, -1)
+	body, _ := io.ReadAll(res.Body)
+	if !strings.Contains(string(body), "-ss 42.493 -t 47.507 -i "+path) || !strings.Contains(string(body), "-c:a flac") {
+		t.Fatalf("unexpected ffmpeg args: %q", body)
+	}
+	if cd := res.Header.Get("Content-Disposition"); !strings.Contains(cd, "Second.flac") {
+		t.Fatalf("expected track title as file name, got %q", cd)
+	}
+
+	_, out := get(app, "/rest/getSong.view?id="+music.ID+auth)
+	if !strings.Contains(out, `transcodedSuffix="flac"`) || !strings.Contains(out, `size="8289625"`) {
+		t.Fatalf("unexpected cue track: %s", out)
+	}
+}
+
+func TestReplayGain(t *testing.T) {
+	app, db := setup(t)
+	const auth = "&u=test&p=enc:74657374&v=1.16.1&c=test"
+	dir := t.TempDir()
+	ffmpeg := filepath.Join(dir, "ffmpeg")
+	if err := os.WriteFile(ffmpeg, []byte("#!/bin/sh\necho \"$@\"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	config.AppConfig.Transcoding.FFmpegPath = ffmpeg
+	for _, name := range []string{"quiet.flac", "loud.flac"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("fLaC-data"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	gain := func(v float64) *float64 { return &v }
+	quiet := entity.Music{Title: "Quiet", AlbumID: "1", FileUrl: filepath.Join(dir, "quiet.flac"), Suffix: "flac", BitRate: 900, Duration: 60,
+		TrackGain: gain(6), TrackPeak: gain(0.9)}
+	loud := entity.Music{Title: "Loud", AlbumID: "1", FileUrl: filepath.Join(dir, "loud.flac"), Suffix: "flac", BitRate: 900, Duration: 60,
+		TrackGain: gain(-6.5), TrackPeak: gain(1), AlbumGain: gain(-7), AlbumPeak: gain(1)}
+	db.Create(&quiet)
+	db.Create(&loud)
+
+	_, out := get(app, "/rest/getSong.view?id="+loud.ID+auth)
+	if !strings.Contains(out, `<replayGain trackGain="-6.5" albumGain="-7" trackPeak="1" albumPeak="1"></replayGain>`) {
+		t.Fatalf("unexpected replayGain: %s", out)
+	}
+	_, out = get(app, "/rest/getSong.view?id="+loud.ID+auth+"&f=json")
+	if !strings.Contains(out, `"replayGain":{"trackGain":-6.5,"albumGain":-7,"trackPeak":1,"albumPeak":1}`) {
+		t.Fatalf("unexpected replayGain json: %s", out)
+	}
+
+	// 默认不调整音量
+	_, out = get(app, "/rest/stream.view?id="+loud.ID+"&format=mp3"+auth)
+	if strings.Contains(out, "volume=") {
+		t.Fatalf("gain should be off by default: %q", out)
+	}
+	// 专辑模式使用专辑增益，没有专辑增益时使用音轨增益并按峰值限制
+	config.AppConfig.Transcoding.ReplayGain = "album"
+	defer func() { config.AppConfig.Transcoding.ReplayGain = "" }()
+	_, out = get(app, "/rest/stream.view?id="+loud.ID+"&format=opus"+auth)
+	if !strings.Contains(out, "-af volume=-7.00dB") {
+		t.Fatalf("expected album gain, got %q", out)
+	}
+	_, out = get(app, "/rest/stream.view?id="+quiet.ID+"&format=opus"+auth)
+	if !strings.Contains(out, "-af volume=0.92dB") {
+		t.Fatalf("expected peak-limited track gain, got %q", out)
+	}
+	// 直接输出原文件时不调整
+	_, out = get(app, "/rest/stream.view?id="+quiet.ID+auth)
+	if out != "fLaC-data" {
+		t.Fatalf("raw stream should be untouched, got %q", out)
+	}
+}
+
+func TestDownload_Raw(t *testing.T) {
+	app, db := setup(t)
+	const auth = "&u=test&p=enc:74657374&v=1.16.1&c=test"
+	path := filepath.Join(t.TempDir(), "track.flac")
+	if err := os.WriteFile(path, []byte("fLaC-data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	music := entity.Music{Title: "Track", AlbumID: "1", FileUrl: path, Suffix: "flac", BitRate: 1411}
+	db.Create(&music)
+
+	req := httptest.NewRequest("GET", "/rest/download.view?id="+music.ID+auth, nil)
+	res, _ := app.Test(req, -1)
+	body, _ := io.ReadAll(res.Body)
+	if res.StatusCode != 200 || string(body) != "fLaC-data" {
+		t.Fatalf("unexpected download: %d %q", res.StatusCode, body)
+	}
+	if cd := res.Header.Get("Content-Disposition"); !strings.Contains(cd, "attachment") || !strings.Contains(cd, "track.flac") {
+		t.Fatalf("expected attachment header, got %q", cd)
+	}
+
+	if _, out := get(app, "/rest/download.view?id=missing"+auth); !strings.Contains(out, `code="70"`) {
+		t.Fatalf("expected not found error, got %q", out)
+	}
+}
+
+func TestTranscodeCache_CoalesceAndEvict(t *testing.T) {
+	cache, err := transcode.NewCache(t.TempDir(), 12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pr, pw := io.Pipe()
+	starts := 0
+	start := func() (io.ReadCloser, error) {
+		starts++
+		return pr, nil
+	}
+
+	// 第二个请求跟随正在进行的转码读取
+	first, err := cache.Stream("a_mp3_128", "mp3", start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := cache.Stream("a_mp3_128", "mp3", start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		pw.Write([]byte("hello "))
+		pw.Write([]byte("world"))
+		pw.Close()
+	}()
+	for _, r := range []io.ReadCloser{first, second} {
+		data, err := io.ReadAll(r)
+		r.Close()
+		if err != nil || string(data) != "hello world" {
+			t.Fatalf("unexpected stream: %q %v", data, err)
+		}
+	}
+	if starts != 1 {
+		t.Fatalf("expected a single transcode, got %d", starts)
+	}
+
+	// 完成后可直接命中缓存文件；超出容量时淘汰最久未使用的
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, ok := cache.Lookup("a_mp3_128"); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("cache entry not committed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	r, _ := cache.Stream("b_mp3_128", "mp3", func() (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader("abc")), nil
+	})
+	io.ReadAll(r)
+	r.Close()
+	for cache.Stats().Entries != 1 || cache.Stats().Evictions != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected eviction, got %+v", cache.Stats())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, ok := cache.Lookup("a_mp3_128"); ok {
+		t.Fatal("least recently used entry should be evicted")
+	}
+	if stats := cache.Stats(); stats.Coalesced != 1 || stats.Misses != 2 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestHLSPlaylists(t *testing.T) {
+	app, db := setup(t)
+	const auth = "&u=test&p=enc:74657374&v=1.16.1&c=test"
+	music := entity.Music{Title: "Long", AlbumID: "1", FileUrl: "/music/long.flac", Suffix: "flac", BitRate: 200, Duration: 25}
+	db.Create(&music)
+
+	// 未指定码率：主播放列表省略高于源文件码率的档位，子地址保留认证参数
+	_, body := get(app, "/rest/hls.m3u8?id="+music.ID+auth)
+	if !strings.Contains(body, "BANDWIDTH=192000") || strings.Contains(body, "BANDWIDTH=320000") ||
+		!strings.Contains(body, "hls.m3u8?bitRate=128") || !strings.Contains(body, "u=test") {
+		t.Fatalf("unexpected master playlist:\n%s", body)
+	}
+
+	// 单一码率：按 10 秒分片，最后一片为剩余时长
+	_, body = get(app, "/rest/hls.m3u8?id="+music.ID+"&bitRate=128"+auth)
+	if strings.Count(body, "#EXTINF:") != 3 || !strings.Contains(body, "#EXTINF:5.000,") ||
+		!strings.Contains(body, "segment=2") || !strings.Contains(body, "#EXT-X-ENDLIST") {
+		t.Fatalf("unexpected media playlist:\n%s", body)
+	}
+
+	if _, out := get(app, "/rest/hls.m3u8?id="+music.ID+"&bitRate=abc"+auth); !strings.Contains(out, "invalid bitRate") {
+		t.Fatalf("expected invalid bitRate error, got %q", out)
+	}
+}
+
+func TestScanStatusAndStartScan(t *testing.T) {
+	app, db, user := setupWithUser(t)
+	const auth = "&u=test&p=enc:74657374&v=1.16.1&c=test"
+
+	_, body := get(app, "/rest/getScanStatus.view?"+auth)
+	if !strings.Contains(body, `<scanStatus scanning="false" count="3"`) {
+		t.Fatalf("unexpected idle scanStatus: %s", body)
+	}
+
+	// 普通用户不能开始扫描
+	_, body = get(app, "/rest/startScan.view?"+auth)
+	if !strings.Contains(body, `code="50"`) {
+		t.Fatalf("expected not authorized: %s", body)
+	}
+
+	// 管理员扫描空目录，结束后库中歌曲被清理并留下扫描记录
+	db.Model(&entity.Library{}).Where("id = 1").Update("path", t.TempDir())
+	library.Load(db)
+	db.Model(&user).Update("role", entity.RoleAdmin)
+	_, body = get(app, "/rest/startScan.view?"+auth)
+	if !strings.Contains(body, `<scanStatus`) {
+		t.Fatalf("unexpected startScan body: %s", body)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, body = get(app, "/rest/getScanStatus.view?"+auth)
+		if strings.Contains(body, `scanning="false"`) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("scan did not finish: %s", body)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if !strings.Contains(body, `count="0"`) || !strings.Contains(body, `lastScan="`) {
+		t.Fatalf("unexpected scanStatus after scan: %s", body)
+	}
+	var job entity.ScanJob
+	if err := db.First(&job).Error; err != nil || job.Status != entity.ScanCompleted || job.Removed != 3 || job.Trigger != "subsonic" {
+		t.Fatalf("unexpected scan job: %+v err=%v", job, err)
+	}
+}
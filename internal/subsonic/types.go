@@ -2,27 +2,81 @@ package subsonic
 
 // 参考 Subsonic 1.16 响应结构的最小子集
 type Artist struct {
-	ID   string `xml:"id,attr"`
-	Name string `xml:"name,attr"`
+	ID         string `xml:"id,attr" json:"id"`
+	Name       string `xml:"name,attr" json:"name"`
+	CoverArt   string `xml:"coverArt,attr,omitempty" json:"coverArt,omitempty"`
+	AlbumCount int    `xml:"albumCount,attr,omitempty" json:"albumCount,omitempty"`
+	Starred    string `xml:"starred,attr,omitempty" json:"starred,omitempty"`
+	UserRating int    `xml:"userRating,attr,omitempty" json:"userRating,omitempty"`
 }
 
 type Album struct {
-	ID        string `xml:"id,attr"`
-	Name      string `xml:"name,attr"`
-	Artist    string `xml:"artist,attr,omitempty"`
-	CoverArt  string `xml:"coverArt,attr,omitempty"`
-	SongCount int    `xml:"songCount,attr,omitempty"`
-	Duration  int    `xml:"duration,attr,omitempty"`
+	ID        string `xml:"id,attr" json:"id"`
+	Name      string `xml:"name,attr" json:"name"`
+	Artist    string `xml:"artist,attr,omitempty" json:"artist,omitempty"`
+	ArtistID  string `xml:"artistId,attr,omitempty" json:"artistId,omitempty"`
+	CoverArt  string `xml:"coverArt,attr,omitempty" json:"coverArt,omitempty"`
+	SongCount int    `xml:"songCount,attr,omitempty" json:"songCount,omitempty"`
+	Duration  int    `xml:"duration,attr,omitempty" json:"duration,omitempty"`
+	Created   string `xml:"created,attr,omitempty" json:"created,omitempty"`
+	Year      int    `xml:"year,attr,omitempty" json:"year,omitempty"`
+	Genre     string `xml:"genre,attr,omitempty" json:"genre,omitempty"`
+	// OpenSubsonic 扩展：是否为合辑
+	IsCompilation bool `xml:"isCompilation,attr,omitempty" json:"isCompilation,omitempty"`
+	// OpenSubsonic 扩展：MusicBrainz 发行 ID
+	MusicBrainzID string `xml:"musicBrainzId,attr,omitempty" json:"musicBrainzId,omitempty"`
+	// 当前用户的收藏、评分与播放记录
+	Starred    string `xml:"starred,attr,omitempty" json:"starred,omitempty"`
+	UserRating int    `xml:"userRating,attr,omitempty" json:"userRating,omitempty"`
+	PlayCount  int    `xml:"playCount,attr,omitempty" json:"playCount,omitempty"`
+	Played     string `xml:"played,attr,omitempty" json:"played,omitempty"`
 }
 
 type Song struct {
-	ID       string `xml:"id,attr"`
-	Parent   string `xml:"parent,attr,omitempty"`
-	Title    string `xml:"title,attr"`
-	Artist   string `xml:"artist,attr,omitempty"`
-	Album    string `xml:"album,attr,omitempty"`
-	Track    int    `xml:"track,attr,omitempty"`
-	Duration int    `xml:"duration,attr,omitempty"`
-	CoverArt string `xml:"coverArt,attr,omitempty"`
-	Type     string `xml:"type,attr,omitempty"` // "music"
+	ID          string `xml:"id,attr" json:"id"`
+	Parent      string `xml:"parent,attr,omitempty" json:"parent,omitempty"`
+	IsDir       bool   `xml:"isDir,attr" json:"isDir"`
+	Title       string `xml:"title,attr" json:"title"`
+	Artist      string `xml:"artist,attr,omitempty" json:"artist,omitempty"`
+	Album       string `xml:"album,attr,omitempty" json:"album,omitempty"`
+	Track       int    `xml:"track,attr,omitempty" json:"track,omitempty"`
+	DiscNumber  int    `xml:"discNumber,attr,omitempty" json:"discNumber,omitempty"`
+	Year        int    `xml:"year,attr,omitempty" json:"year,omitempty"`
+	Genre       string `xml:"genre,attr,omitempty" json:"genre,omitempty"`
+	Duration    int    `xml:"duration,attr,omitempty" json:"duration,omitempty"`
+	CoverArt    string `xml:"coverArt,attr,omitempty" json:"coverArt,omitempty"`
+	Size        int64  `xml:"size,attr,omitempty" json:"size,omitempty"`
+	ContentType string `xml:"contentType,attr,omitempty" json:"contentType,omitempty"`
+	Suffix      string `xml:"suffix,attr,omitempty" json:"suffix,omitempty"`
+	BitRate     int    `xml:"bitRate,attr,omitempty" json:"bitRate,omitempty"`
+	Path        string `xml:"path,attr,omitempty" json:"path,omitempty"`
+	Starred     string `xml:"starred,attr,omitempty" json:"starred,omitempty"`
+	UserRating  int    `xml:"userRating,attr,omitempty" json:"userRating,omitempty"`
+	PlayCount   int    `xml:"playCount,attr,omitempty" json:"playCount,omitempty"`
+	Played      string `xml:"played,attr,omitempty" json:"played,omitempty"`
+	// 整轨文件中的音轨播放时转码为 FLAC
+	TranscodedContentType string `xml:"transcodedContentType,attr,omitempty" json:"transcodedContentType,omitempty"`
+	TranscodedSuffix      string `xml:"transcodedSuffix,attr,omitempty" json:"transcodedSuffix,omitempty"`
+	// OpenSubsonic 扩展字段
+	SamplingRate int    `xml:"samplingRate,attr,omitempty" json:"samplingRate,omitempty"`
+	BitDepth     int    `xml:"bitDepth,attr,omitempty" json:"bitDepth,omitempty"`
+	ChannelCount int    `xml:"channelCount,attr,omitempty" json:"channelCount,omitempty"`
+	AlbumID      string `xml:"albumId,attr,omitempty" json:"albumId,omitempty"`
+	ArtistID     string `xml:"artistId,attr,omitempty" json:"artistId,omitempty"`
+	Created      string `xml:"created,attr,omitempty" json:"created,omitempty"`
+	Type         string `xml:"type,attr,omitempty" json:"type,omitempty"` // "music"
+
+	// OpenSubsonic 扩展：MusicBrainz 录音 ID 与节拍数
+	MusicBrainzID string `xml:"musicBrainzId,attr,omitempty" json:"musicBrainzId,omitempty"`
+	BPM           int    `xml:"bpm,attr,omitempty" json:"bpm,omitempty"`
+
+	ReplayGain *ReplayGain `xml:"replayGain,omitempty" json:"replayGain,omitempty"`
+}
+
+// ReplayGain OpenSubsonic 扩展：歌曲的增益 (dB) 与线性峰值，没有的值省略
+type ReplayGain struct {
+	TrackGain *float64 `xml:"trackGain,attr,omitempty" json:"trackGain,omitempty"`
+	AlbumGain *float64 `xml:"albumGain,attr,omitempty" json:"albumGain,omitempty"`
+	TrackPeak *float64 `xml:"trackPeak,attr,omitempty" json:"trackPeak,omitempty"`
+	AlbumPeak *float64 `xml:"albumPeak,attr,omitempty" json:"albumPeak,omitempty"`
 }
@@ -7,66 +7,185 @@ import (
 	"github.com/gofiber/fiber/v2"
 )
 
+// APIVersion 当前实现的 Subsonic API 版本
+const APIVersion = "1.16.1"
+
 type Response struct {
-	XMLName xml.Name `xml:"subsonic-response"`
-	Status  string   `xml:"status,attr"`  // "ok" or "failed"
-	Version string   `xml:"version,attr"` // e.g. "1.16.1"
-	Type    string   `xml:"type,attr,omitempty"`
-	Server  string   `xml:"serverVersion,attr,omitempty"`
+	XMLName xml.Name `xml:"subsonic-response" json:"-"`
+	Status  string   `xml:"status,attr" json:"status"`   // "ok" or "failed"
+	Version string   `xml:"version,attr" json:"version"` // e.g. "1.16.1"
+	Type    string   `xml:"type,attr,omitempty" json:"type,omitempty"`
+	Server  string   `xml:"serverVersion,attr,omitempty" json:"serverVersion,omitempty"`
 
-	Error   *Error   `xml:"error,omitempty"`
-	Ping    *Ping    `xml:"ping,omitempty"`
-	License *License `xml:"license,omitempty"`
+	Error   *Error   `xml:"error,omitempty" json:"error,omitempty"`
+	Ping    *Ping    `xml:"ping,omitempty" json:"-"` // JSON 中参考实现不输出 ping 节点
+	License *License `xml:"license,omitempty" json:"license,omitempty"`
 
 	// Browsing
-	Indexes       *IndexesResponse `xml:"indexes,omitempty"`
-	Artists       *ArtistsResponse `xml:"artists,omitempty"`
-	Album         *AlbumResponse   `xml:"album,omitempty"`
-	Playlists     *Playlists       `xml:"playlists,omitempty"`
-	Playlist      *Playlist        `xml:"playlist,omitempty"`
-	NowPlaying    *NowPlaying      `xml:"nowPlaying,omitempty"`
-	RandomSongs   *SongsResponse   `xml:"randomSongs,omitempty"`
-	SearchResult2 *SearchResult2   `xml:"searchResult2,omitempty"`
+	MusicFolders  *MusicFolders    `xml:"musicFolders,omitempty" json:"musicFolders,omitempty"`
+	Directory     *Directory       `xml:"directory,omitempty" json:"directory,omitempty"`
+	Indexes       *IndexesResponse `xml:"indexes,omitempty" json:"indexes,omitempty"`
+	Artists       *ArtistsResponse `xml:"artists,omitempty" json:"artists,omitempty"`
+	Artist        *ArtistResponse  `xml:"artist,omitempty" json:"artist,omitempty"`
+	Album         *AlbumResponse   `xml:"album,omitempty" json:"album,omitempty"`
+	AlbumList2    *AlbumList2      `xml:"albumList2,omitempty" json:"albumList2,omitempty"`
+	Song          *Song            `xml:"song,omitempty" json:"song,omitempty"`
+	Genres        *Genres          `xml:"genres,omitempty" json:"genres,omitempty"`
+	SongsByGenre  *SongsResponse   `xml:"songsByGenre,omitempty" json:"songsByGenre,omitempty"`
+	Playlists     *Playlists       `xml:"playlists,omitempty" json:"playlists,omitempty"`
+	Playlist      *Playlist        `xml:"playlist,omitempty" json:"playlist,omitempty"`
+	NowPlaying    *NowPlaying      `xml:"nowPlaying,omitempty" json:"nowPlaying,omitempty"`
+	RandomSongs   *SongsResponse   `xml:"randomSongs,omitempty" json:"randomSongs,omitempty"`
+	SearchResult2 *SearchResult2   `xml:"searchResult2,omitempty" json:"searchResult2,omitempty"`
+	SearchResult3 *SearchResult3   `xml:"searchResult3,omitempty" json:"searchResult3,omitempty"`
+	Starred       *Starred         `xml:"starred,omitempty" json:"starred,omitempty"`
+	Starred2      *Starred         `xml:"starred2,omitempty" json:"starred2,omitempty"`
+	ScanStatus    *ScanStatus      `xml:"scanStatus,omitempty" json:"scanStatus,omitempty"`
 }
 
 // Standard error format
 type Error struct {
-	Code    int    `xml:"code,attr"`
-	Message string `xml:"message,attr"`
+	Code    int    `xml:"code,attr" json:"code"`
+	Message string `xml:"message,attr" json:"message"`
 }
 
 type License struct {
-	Valid bool `xml:"valid,attr"`
+	Valid bool `xml:"valid,attr" json:"valid"`
 }
 
 type Ping struct{}
 
+// ScanStatus 媒体库扫描状态，count 为扫描中已处理的文件数或扫描结束后的歌曲总数
+type ScanStatus struct {
+	Scanning bool   `xml:"scanning,attr" json:"scanning"`
+	Count    int64  `xml:"count,attr" json:"count"`
+	LastScan string `xml:"lastScan,attr,omitempty" json:"lastScan,omitempty"`
+}
+
 type IndexesResponse struct {
-	LastModified int64    `xml:"lastModified,attr,omitempty"`
-	Children     []Artist `xml:"artist"`
+	LastModified    int64         `xml:"lastModified,attr" json:"lastModified"`
+	IgnoredArticles string        `xml:"ignoredArticles,attr" json:"ignoredArticles"`
+	Index           []ArtistIndex `xml:"index" json:"index,omitempty"`
+	Child           []Song        `xml:"child" json:"child,omitempty"` // 根目录下直接存放的文件
+}
+
+type MusicFolders struct {
+	MusicFolder []MusicFolder `xml:"musicFolder" json:"musicFolder,omitempty"`
+}
+
+type MusicFolder struct {
+	ID   int    `xml:"id,attr" json:"id"`
+	Name string `xml:"name,attr,omitempty" json:"name,omitempty"`
+}
+
+// Directory getMusicDirectory 返回的目录，子目录以 isDir=true 的 <child> 表示
+type Directory struct {
+	ID     string `xml:"id,attr" json:"id"`
+	Parent string `xml:"parent,attr,omitempty" json:"parent,omitempty"`
+	Name   string `xml:"name,attr" json:"name"`
+	Child  []Song `xml:"child" json:"child,omitempty"`
 }
 
 type ArtistsResponse struct {
-	Index []ArtistIndex `xml:"index"`
+	Index []ArtistIndex `xml:"index" json:"index,omitempty"`
 }
 
 type ArtistIndex struct {
-	Name    string   `xml:"name,attr"`
-	Artists []Artist `xml:"artist"`
+	Name    string   `xml:"name,attr" json:"name"`
+	Artists []Artist `xml:"artist" json:"artist,omitempty"`
 }
 
+// AlbumResponse getAlbum 返回的专辑，歌曲作为 <song> 子节点
 type AlbumResponse struct {
-	Album Album `xml:"album"`
+	Album
+	Song []Song `xml:"song" json:"song,omitempty"`
+}
+
+// ArtistResponse getArtist 返回的艺术家，专辑作为 <album> 子节点
+type ArtistResponse struct {
+	Artist
+	Album []Album `xml:"album" json:"album,omitempty"`
+}
+
+type AlbumList2 struct {
+	Album []Album `xml:"album" json:"album,omitempty"`
+}
+
+type Genres struct {
+	Genre []Genre `xml:"genre" json:"genre,omitempty"`
+}
+
+type Genre struct {
+	SongCount  int    `xml:"songCount,attr" json:"songCount"`
+	AlbumCount int    `xml:"albumCount,attr" json:"albumCount"`
+	Value      string `xml:",chardata" json:"value"`
 }
 
 type SongsResponse struct {
-	Song []Song `xml:"song"`
+	Song []Song `xml:"song" json:"song,omitempty"`
 }
 
-type Playlists struct{}
-type Playlist struct{}
-type NowPlaying struct{}
-type SearchResult2 struct{}
+type Playlists struct {
+	Playlist []Playlist `xml:"playlist" json:"playlist,omitempty"`
+}
+
+// Playlist getPlaylists 中只含属性，getPlaylist/createPlaylist 额外返回 <entry>
+type Playlist struct {
+	ID        string `xml:"id,attr" json:"id"`
+	Name      string `xml:"name,attr" json:"name"`
+	Comment   string `xml:"comment,attr,omitempty" json:"comment,omitempty"`
+	Owner     string `xml:"owner,attr,omitempty" json:"owner,omitempty"`
+	Public    bool   `xml:"public,attr" json:"public"`
+	SongCount int    `xml:"songCount,attr" json:"songCount"`
+	Duration  int    `xml:"duration,attr" json:"duration"`
+	Created   string `xml:"created,attr" json:"created"`
+	Changed   string `xml:"changed,attr" json:"changed"`
+	CoverArt  string `xml:"coverArt,attr,omitempty" json:"coverArt,omitempty"`
+	Entry     []Song `xml:"entry" json:"entry,omitempty"`
+}
+type NowPlaying struct {
+	Entry []NowPlayingEntry `xml:"entry" json:"entry,omitempty"`
+}
+
+// NowPlayingEntry 正在播放的歌曲，附带播放者信息
+type NowPlayingEntry struct {
+	Song
+	Username   string `xml:"username,attr" json:"username"`
+	MinutesAgo int    `xml:"minutesAgo,attr" json:"minutesAgo"`
+	PlayerID   int    `xml:"playerId,attr" json:"playerId"`
+	PlayerName string `xml:"playerName,attr,omitempty" json:"playerName,omitempty"`
+}
+
+// SearchResult2 search2/search3 共用的结果集
+type SearchResult2 struct {
+	Artists []Artist `xml:"artist" json:"artist,omitempty"`
+	Albums  []Album  `xml:"album" json:"album,omitempty"`
+	Songs   []Song   `xml:"song" json:"song,omitempty"`
+}
+
+// SearchResult3 与 SearchResult2 结构一致，仅 XML 节点名不同
+type SearchResult3 = SearchResult2
+
+// Starred getStarred / getStarred2 返回的收藏列表，两者结构一致
+type Starred struct {
+	Artists []Artist `xml:"artist" json:"artist,omitempty"`
+	Albums  []Album  `xml:"album" json:"album,omitempty"`
+	Songs   []Song   `xml:"song" json:"song,omitempty"`
+}
+
+// NewResponse 创建状态为 ok 的响应
+func NewResponse() Response {
+	return Response{Status: "ok", Version: APIVersion}
+}
+
+// NewError 创建状态为 failed 的错误响应
+func NewError(code int, message string) Response {
+	return Response{
+		Status:  "failed",
+		Version: APIVersion,
+		Error:   &Error{Code: code, Message: message},
+	}
+}
 
 func WriteXML(w http.ResponseWriter, v any) {
 	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
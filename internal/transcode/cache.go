@@ -0,0 +1,410 @@
+package transcode
+
+import (
+	"container/list"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"saboriman-music/config"
+)
+
+// 缓存默认配置
+const (
+	defaultCacheDir    = "cache/transcode"
+	defaultCacheSizeMB = 1024
+)
+
+// Key 转码缓存键：同一首歌、同一方案、同一码率、同一增益的转码结果可以复用；
+// 键中包含源文件的修改时间与大小，替换源文件后不会再读到旧的转码结果
+func Key(musicID string, src os.FileInfo, d Decision) string {
+	key := fmt.Sprintf("%s_%s_%s_%d", musicID, sourceVersion(src), strings.ToLower(d.Profile.Format), d.BitRate)
+	if d.Gain != 0 {
+		key += fmt.Sprintf("_g%.2f", d.Gain)
+	}
+	return key
+}
+
+// sourceVersion 由源文件的修改时间与大小生成版本标识
+func sourceVersion(src os.FileInfo) string {
+	return fmt.Sprintf("%x-%x", src.ModTime().UnixNano(), src.Size())
+}
+
+// CacheStats 缓存统计
+type CacheStats struct {
+	Hits      int64 `json:"hits"`      // 命中已完成的缓存文件
+	Misses    int64 `json:"misses"`    // 未命中，启动新的转码
+	Coalesced int64 `json:"coalesced"` // 同一转码正在进行，直接跟随读取
+	Evictions int64 `json:"evictions"` // 因超出容量被淘汰的文件数
+	Entries   int   `json:"entries"`
+	Size      int64 `json:"size"`    // 当前占用字节数
+	MaxSize   int64 `json:"maxSize"` // 容量上限（字节）
+}
+
+// cacheEntry 已完成的缓存文件
+type cacheEntry struct {
+	key  string
+	path string
+	size int64
+}
+
+// Cache 转码结果的磁盘缓存，按最近使用时间淘汰
+type Cache struct {
+	dir     string
+	maxSize int64
+
+	mu       sync.Mutex
+	lru      *list.List // 最近使用的在前
+	entries  map[string]*list.Element
+	inflight map[string]*job
+	starting map[string]*keyLock
+	size     int64
+	stats    CacheStats
+}
+
+// keyLock 启动同一个键的转码时使用的锁，refs 为持有或等待该锁的请求数
+type keyLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// NewCache 创建缓存并载入目录中已有的缓存文件，maxSize 为字节数
+func NewCache(dir string, maxSize int64) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("create cache dir: %w", err)
+	}
+	c := &Cache{
+		dir:      dir,
+		maxSize:  maxSize,
+		lru:      list.New(),
+		entries:  make(map[string]*list.Element),
+		inflight: make(map[string]*job),
+		starting: make(map[string]*keyLock),
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	type existing struct {
+		cacheEntry
+		modTime int64
+	}
+	var found []existing
+	for _, f := range files {
+		path := filepath.Join(dir, f.Name())
+		// 上次退出时未完成的转码直接删除
+		if strings.HasSuffix(f.Name(), ".part") {
+			os.Remove(path)
+			continue
+		}
+		info, err := f.Info()
+		if err != nil || info.IsDir() {
+			continue
+		}
+		key := strings.TrimSuffix(f.Name(), filepath.Ext(f.Name()))
+		found = append(found, existing{cacheEntry{key: key, path: path, size: info.Size()}, info.ModTime().UnixNano()})
+	}
+	// 按修改时间从旧到新加入，最新的位于 LRU 前端
+	sort.Slice(found, func(i, j int) bool { return found[i].modTime < found[j].modTime })
+	for _, e := range found {
+		entry := e.cacheEntry
+		c.entries[entry.key] = c.lru.PushFront(&entry)
+		c.size += entry.size
+	}
+	c.mu.Lock()
+	c.evict()
+	c.mu.Unlock()
+	return c, nil
+}
+
+var (
+	defaultCache     *Cache
+	defaultCacheOnce sync.Once
+)
+
+// DefaultCache 按配置创建的全局缓存，CacheSizeMB 为负数时禁用缓存并返回 nil
+func DefaultCache() *Cache {
+	defaultCacheOnce.Do(func() {
+		dir, sizeMB := defaultCacheDir, defaultCacheSizeMB
+		if config.AppConfig != nil {
+			if config.AppConfig.Transcoding.CacheDir != "" {
+				dir = config.AppConfig.Transcoding.CacheDir
+			}
+			if config.AppConfig.Transcoding.CacheSizeMB != 0 {
+				sizeMB = config.AppConfig.Transcoding.CacheSizeMB
+			}
+		}
+		if sizeMB < 0 {
+			return
+		}
+		cache, err := NewCache(dir, int64(sizeMB)<<20)
+		if err != nil {
+			log.Printf("⚠️  转码缓存不可用: %v", err)
+			return
+		}
+		defaultCache = cache
+	})
+	return defaultCache
+}
+
+// Lookup 查找已完成的缓存文件，命中时返回文件路径
+func (c *Cache) Lookup(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return "", false
+	}
+	c.lru.MoveToFront(el)
+	c.stats.Hits++
+	return el.Value.(*cacheEntry).path, true
+}
+
+// Stream 返回 key 对应的转码输出流：
+// 已有缓存文件时直接读取（调用方在 Lookup 之后、转码刚好完成的情况），
+// 已有相同的转码在进行时跟随其输出读取，否则调用 start 启动转码并边写缓存边输出。
+// 转码在后台完成，即使最先发起请求的客户端中途断开，缓存仍然会被写完整
+func (c *Cache) Stream(key, suffix string, start func() (io.ReadCloser, error)) (io.ReadCloser, error) {
+	// 启动 ffmpeg 期间只持有该键的锁，同一个键的请求等待后跟随读取，不阻塞其他键
+	unlock := c.lockKey(key)
+	defer unlock()
+
+	// 打开 .part 文件与完成后的重命名都在 c.mu 内进行，保证跟随者打开时文件仍然存在；
+	// 缓存文件同样在 c.mu 内打开，避免打开前被淘汰删除
+	c.mu.Lock()
+	if el, ok := c.entries[key]; ok {
+		f, err := os.Open(el.Value.(*cacheEntry).path)
+		if err == nil {
+			c.lru.MoveToFront(el)
+			c.stats.Hits++
+			c.mu.Unlock()
+			return f, nil
+		}
+		// 缓存文件已被外部删除，移除记录后重新转码
+		c.remove(el)
+	}
+	if j, ok := c.inflight[key]; ok {
+		c.stats.Coalesced++
+		r, err := j.reader()
+		c.mu.Unlock()
+		return r, err
+	}
+	c.stats.Misses++
+	c.mu.Unlock()
+
+	src, err := start()
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(c.dir, key+"."+suffix)
+	f, err := os.Create(path + ".part")
+	if err != nil {
+		src.Close()
+		return nil, err
+	}
+	j := newJob(path + ".part")
+	r, err := j.reader()
+	if err != nil {
+		f.Close()
+		src.Close()
+		os.Remove(j.path)
+		return nil, err
+	}
+	c.mu.Lock()
+	c.inflight[key] = j
+	c.mu.Unlock()
+
+	go c.fill(key, path, j, src, f)
+	return r, nil
+}
+
+// lockKey 获取 key 的启动锁，返回释放函数
+func (c *Cache) lockKey(key string) func() {
+	c.mu.Lock()
+	l := c.starting[key]
+	if l == nil {
+		l = &keyLock{}
+		c.starting[key] = l
+	}
+	l.refs++
+	c.mu.Unlock()
+
+	l.mu.Lock()
+	return func() {
+		l.mu.Unlock()
+		c.mu.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(c.starting, key)
+		}
+		c.mu.Unlock()
+	}
+}
+
+// fill 将转码输出写入缓存文件，完成后加入 LRU
+func (c *Cache) fill(key, path string, j *job, src io.ReadCloser, f *os.File) {
+	buf := make([]byte, 64*1024)
+	var err error
+	for {
+		n, rerr := src.Read(buf)
+		if n > 0 {
+			if _, werr := f.Write(buf[:n]); werr != nil {
+				err = werr
+				break
+			}
+			j.advance(int64(n))
+		}
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil {
+			err = rerr
+			break
+		}
+	}
+	if cerr := src.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	c.mu.Lock()
+	delete(c.inflight, key)
+	if err == nil {
+		err = os.Rename(j.path, path)
+	}
+	if err != nil {
+		os.Remove(j.path)
+		log.Printf("⚠️  转码失败 %s: %v", key, err)
+	} else {
+		// 同一个键已有记录时先移除，避免重复计入容量；文件已被重命名覆盖，不能删除
+		if el, ok := c.entries[key]; ok {
+			c.remove(el)
+		}
+		entry := &cacheEntry{key: key, path: path, size: j.size()}
+		c.entries[key] = c.lru.PushFront(entry)
+		c.size += entry.size
+		c.evict()
+	}
+	c.mu.Unlock()
+	j.finish(err)
+}
+
+// remove 从 LRU 中移除记录（不删除文件），调用方需持有锁
+func (c *Cache) remove(el *list.Element) {
+	entry := el.Value.(*cacheEntry)
+	c.lru.Remove(el)
+	delete(c.entries, entry.key)
+	c.size -= entry.size
+}
+
+// evict 淘汰最久未使用的文件直到不超过容量上限，调用方需持有锁
+func (c *Cache) evict() {
+	for c.size > c.maxSize && c.lru.Len() > 0 {
+		el := c.lru.Back()
+		entry := el.Value.(*cacheEntry)
+		c.remove(el)
+		c.stats.Evictions++
+		// 正在读取该文件的请求持有打开的文件句柄，删除不影响其读取
+		os.Remove(entry.path)
+	}
+}
+
+// Stats 返回缓存统计
+func (c *Cache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Entries = c.lru.Len()
+	stats.Size = c.size
+	stats.MaxSize = c.maxSize
+	return stats
+}
+
+// job 一次正在进行的转码，多个请求可以同时跟随读取
+type job struct {
+	path    string
+	mu      sync.Mutex
+	cond    *sync.Cond
+	written int64
+	done    bool
+	err     error
+}
+
+func newJob(path string) *job {
+	j := &job{path: path}
+	j.cond = sync.NewCond(&j.mu)
+	return j
+}
+
+func (j *job) advance(n int64) {
+	j.mu.Lock()
+	j.written += n
+	j.mu.Unlock()
+	j.cond.Broadcast()
+}
+
+func (j *job) finish(err error) {
+	j.mu.Lock()
+	j.done = true
+	j.err = err
+	j.mu.Unlock()
+	j.cond.Broadcast()
+}
+
+func (j *job) size() int64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.written
+}
+
+// reader 从头读取正在写入的缓存文件，读到已写入的末尾时等待后续输出
+func (j *job) reader() (io.ReadCloser, error) {
+	f, err := os.Open(j.path)
+	if err != nil {
+		return nil, err
+	}
+	return &tailReader{job: j, f: f}, nil
+}
+
+type tailReader struct {
+	job *job
+	f   *os.File
+	off int64
+}
+
+func (r *tailReader) Read(p []byte) (int, error) {
+	j := r.job
+	j.mu.Lock()
+	for r.off >= j.written && !j.done {
+		j.cond.Wait()
+	}
+	written, err := j.written, j.err
+	j.mu.Unlock()
+
+	if r.off >= written {
+		if err != nil {
+			return 0, err
+		}
+		return 0, io.EOF
+	}
+	if remain := written - r.off; int64(len(p)) > remain {
+		p = p[:remain]
+	}
+	n, rerr := r.f.ReadAt(p, r.off)
+	r.off += int64(n)
+	if rerr == io.EOF {
+		rerr = nil
+	}
+	return n, rerr
+}
+
+func (r *tailReader) Close() error {
+	return r.f.Close()
+}
@@ -0,0 +1,153 @@
+package transcode
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"saboriman-music/config"
+)
+
+func TestKeySourceVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.flac")
+	stat := func() os.FileInfo {
+		t.Helper()
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info
+	}
+	if err := os.WriteFile(path, []byte("fLaC-old"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	d := Decision{Profile: config.TranscodeProfile{Format: "mp3"}, BitRate: 192}
+	before := Key("M1", stat(), d)
+	if again := Key("M1", stat(), d); again != before {
+		t.Fatalf("expected stable key, got %s and %s", before, again)
+	}
+	beforeSegment := SegmentKey("M1", stat(), 128, 0, 0)
+
+	// 替换源文件后缓存键改变
+	if err := os.WriteFile(path, []byte("fLaC-replaced"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if after := Key("M1", stat(), d); after == before {
+		t.Fatalf("expected key to change after replacing the source, got %s", after)
+	}
+	if after := SegmentKey("M1", stat(), 128, 0, 0); after == beforeSegment {
+		t.Fatalf("expected segment key to change after replacing the source, got %s", after)
+	}
+}
+
+func TestCacheStreamLocksPerKey(t *testing.T) {
+	cache, err := NewCache(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release := make(chan struct{})
+	var starts atomic.Int32
+	slow := func() (io.ReadCloser, error) {
+		starts.Add(1)
+		<-release
+		return io.NopCloser(strings.NewReader("slow-data")), nil
+	}
+	read := func(key string, start func() (io.ReadCloser, error)) string {
+		r, err := cache.Stream(key, "mp3", start)
+		if err != nil {
+			t.Error(err)
+			return ""
+		}
+		defer r.Close()
+		data, _ := io.ReadAll(r)
+		return string(data)
+	}
+
+	var wg sync.WaitGroup
+	results := make([]string, 2)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = read("slow", slow)
+		}(i)
+	}
+	for starts.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// 启动其他键的转码不等待正在启动的转码
+	done := make(chan string)
+	go func() {
+		done <- read("fast", func() (io.ReadCloser, error) { return io.NopCloser(strings.NewReader("fast-data")), nil })
+	}()
+	select {
+	case out := <-done:
+		if out != "fast-data" {
+			t.Fatalf("unexpected output %q", out)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected another key not to wait for a starting transcode")
+	}
+
+	close(release)
+	wg.Wait()
+	if results[0] != "slow-data" || results[1] != "slow-data" {
+		t.Fatalf("unexpected outputs %q", results)
+	}
+	if n := starts.Load(); n != 1 {
+		t.Fatalf("expected concurrent requests for the same key to share one transcode, got %d", n)
+	}
+}
+
+func TestCacheStreamAfterFill(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := NewCache(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := func(start func() (io.ReadCloser, error)) string {
+		t.Helper()
+		r, err := cache.Stream("k", "mp3", start)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		data, _ := io.ReadAll(r)
+		return string(data)
+	}
+	if out := read(func() (io.ReadCloser, error) { return io.NopCloser(strings.NewReader("first")), nil }); out != "first" {
+		t.Fatalf("unexpected output %q", out)
+	}
+	for {
+		if _, ok := cache.Lookup("k"); ok {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Lookup 未命中后转码刚好完成：直接读取缓存文件，不再启动转码
+	out := read(func() (io.ReadCloser, error) {
+		t.Errorf("expected the cached file to be served")
+		return io.NopCloser(strings.NewReader("second")), nil
+	})
+	if out != "first" {
+		t.Fatalf("expected cached output, got %q", out)
+	}
+
+	// 同一个键再次写入时替换原记录，容量只计一次
+	path := filepath.Join(dir, "k.mp3")
+	f, err := os.Create(path + ".part")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.fill("k", path, newJob(path+".part"), io.NopCloser(strings.NewReader("replaced")), f)
+	if stats := cache.Stats(); stats.Entries != 1 || stats.Size != int64(len("replaced")) {
+		t.Fatalf("expected one entry of the new size, got %+v", stats)
+	}
+}
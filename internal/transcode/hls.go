@@ -0,0 +1,157 @@
+package transcode
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"saboriman-music/config"
+)
+
+// HLS 分片统一编码为 AAC 并封装为 MPEG-TS，每个分片由 ffmpeg 从对应时间点单独生成，
+// 拖动进度时客户端只需请求目标位置的分片
+
+// ErrSegmentOutOfRange 请求的分片序号超出歌曲时长
+var ErrSegmentOutOfRange = errors.New("hls segment out of range")
+
+// HLS 默认配置
+var defaultHLSBitRates = []int{64, 128, 192, 320}
+
+const defaultHLSSegment = 10
+
+// 播放列表与分片的 MIME 类型
+const (
+	PlaylistContentType = "application/vnd.apple.mpegurl"
+	SegmentContentType  = "video/mp2t"
+)
+
+// hlsSegment 返回分片时长（秒）
+func hlsSegment() int {
+	if config.AppConfig != nil && config.AppConfig.Transcoding.HLSSegment > 0 {
+		return config.AppConfig.Transcoding.HLSSegment
+	}
+	return defaultHLSSegment
+}
+
+// HLSBitRates 返回主播放列表提供的码率档位（从低到高）：
+// 省略高于源文件码率与 maxBitRate 的档位，但至少保留最低一档
+func HLSBitRates(srcBitRate, maxBitRate int) []int {
+	all := defaultHLSBitRates
+	if config.AppConfig != nil && len(config.AppConfig.Transcoding.HLSBitRates) > 0 {
+		all = append([]int(nil), config.AppConfig.Transcoding.HLSBitRates...)
+		sort.Ints(all)
+	}
+	var rates []int
+	for _, br := range all {
+		if br <= 0 || (srcBitRate > 0 && br > srcBitRate) || (maxBitRate > 0 && br > maxBitRate) {
+			continue
+		}
+		rates = append(rates, br)
+	}
+	if len(rates) == 0 && len(all) > 0 {
+		rates = all[:1]
+	}
+	return rates
+}
+
+// HLSBitRate 将客户端请求的码率限制在可用档位的上限内
+func HLSBitRate(bitRate int) int {
+	rates := HLSBitRates(0, 0)
+	if top := rates[len(rates)-1]; bitRate <= 0 || bitRate > top {
+		return top
+	}
+	return bitRate
+}
+
+// SegmentCount 按时长计算分片数量
+func SegmentCount(duration int) int {
+	if duration <= 0 {
+		return 0
+	}
+	seg := hlsSegment()
+	return (duration + seg - 1) / seg
+}
+
+// MasterPlaylist 生成主播放列表，variantURL 返回各码率子播放列表的地址
+func MasterPlaylist(bitRates []int, variantURL func(bitRate int) string) string {
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+	for _, br := range bitRates {
+		fmt.Fprintf(&b, "#EXT-X-STREAM-INF:BANDWIDTH=%d,CODECS=\"mp4a.40.2\"\n%s\n", br*1000, variantURL(br))
+	}
+	return b.String()
+}
+
+// MediaPlaylist 生成某个码率的分片列表，segmentURL 返回第 i 个分片的地址
+func MediaPlaylist(duration int, segmentURL func(index int) string) string {
+	seg := hlsSegment()
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-PLAYLIST-TYPE:VOD\n")
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n#EXT-X-MEDIA-SEQUENCE:0\n", seg)
+	for i := 0; i < SegmentCount(duration); i++ {
+		length := seg
+		if rest := duration - i*seg; rest < seg {
+			length = rest
+		}
+		fmt.Fprintf(&b, "#EXTINF:%d.000,\n%s\n", length, segmentURL(i))
+	}
+	b.WriteString("#EXT-X-ENDLIST\n")
+	return b.String()
+}
+
+// SegmentKey 分片的缓存键，与 Key 一样包含源文件的修改时间与大小
+func SegmentKey(musicID string, src os.FileInfo, bitRate, index int, gain float64) string {
+	key := fmt.Sprintf("%s_%s_hls%d_%d", musicID, sourceVersion(src), bitRate, index)
+	if gain != 0 {
+		key += fmt.Sprintf("_g%.2f", gain)
+	}
+	return key
+}
+
+// segmentArgs 生成第 index 个分片的 ffmpeg 参数，保留原始时间戳以便分片无缝衔接；
+// 片段的最后一个分片截止到片段结尾
+func segmentArgs(path string, clip Clip, bitRate, index int, gain float64) []string {
+	seg := time.Duration(hlsSegment()) * time.Second
+	offset := time.Duration(index) * seg
+	start := clip.Start + offset
+	length := seg
+	if clip.End > 0 && start+length > clip.End {
+		length = clip.End - start
+	}
+	args := []string{"-v", "error", "-nostdin", "-ss", seconds(start), "-t", seconds(length), "-i", path, "-vn"}
+	args = append(args, gainArgs(gain)...)
+	return append(args,
+		"-map", "0:a:0", "-c:a", "aac", "-b:a", strconv.Itoa(bitRate)+"k", "-ac", "2",
+		"-output_ts_offset", seconds(offset), "-f", "mpegts", "-")
+}
+
+// OpenSegment 返回分片内容，优先读取转码缓存，调用方读取完毕后需 Close；gain 为调整的音量 (dB)
+func OpenSegment(musicID, path string, clip Clip, duration, bitRate, index int, gain float64) (io.ReadCloser, error) {
+	if index < 0 || index >= SegmentCount(duration) {
+		return nil, ErrSegmentOutOfRange
+	}
+	start := func() (io.ReadCloser, error) {
+		return run(segmentArgs(path, clip, bitRate, index, gain))
+	}
+	cache := DefaultCache()
+	if cache == nil {
+		return start()
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	key := SegmentKey(musicID, info, bitRate, index, gain)
+	if cached, ok := cache.Lookup(key); ok {
+		// 查找与打开之间文件可能被淘汰，此时重新转码
+		if f, err := os.Open(cached); err == nil {
+			return f, nil
+		}
+	}
+	return cache.Stream(key, "ts", start)
+}
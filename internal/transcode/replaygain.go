@@ -0,0 +1,150 @@
+package transcode
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"saboriman-music/config"
+)
+
+// ReferenceLoudness ReplayGain 2.0 的参考响度 (LUFS)，增益 = 参考响度 - 综合响度
+const ReferenceLoudness = -18.0
+
+// ReplayGain 转码时应用的增益模式
+const (
+	ReplayGainTrack = "track"
+	ReplayGainAlbum = "album"
+)
+
+// replayGainMode 返回配置的增益模式，为空时不调整音量
+func replayGainMode() string {
+	if config.AppConfig == nil {
+		return ""
+	}
+	return strings.ToLower(strings.TrimSpace(config.AppConfig.Transcoding.ReplayGain))
+}
+
+// Gain 按配置的模式选择转码时应用的增益 (dB)，专辑增益缺失时使用音轨增益；
+// 增益按峰值限制，放大后不超过满幅
+func Gain(trackGain, trackPeak, albumGain, albumPeak *float64) float64 {
+	var gain, peak *float64
+	switch replayGainMode() {
+	case ReplayGainAlbum:
+		gain, peak = albumGain, albumPeak
+		if gain == nil {
+			gain, peak = trackGain, trackPeak
+		}
+	case ReplayGainTrack:
+		gain, peak = trackGain, trackPeak
+	}
+	if gain == nil {
+		return 0
+	}
+	g := *gain
+	if peak != nil && *peak > 0 {
+		g = math.Min(g, -20*math.Log10(*peak))
+	}
+	return math.Round(g*100) / 100
+}
+
+// WithGain 转码时调整音量，直接输出原文件时不生效
+func (d Decision) WithGain(gain float64) Decision {
+	if !d.Raw {
+		d.Gain = gain
+	}
+	return d
+}
+
+// gainArgs 调整音量的 ffmpeg 滤镜参数
+func gainArgs(gain float64) []string {
+	if gain == 0 {
+		return nil
+	}
+	return []string{"-af", "volume=" + strconv.FormatFloat(gain, 'f', 2, 64) + "dB"}
+}
+
+// Loudness ffmpeg ebur128 滤镜测得的响度
+type Loudness struct {
+	Integrated float64 // 综合响度 (LUFS)
+	TruePeak   float64 // 真峰值 (dBTP)
+}
+
+// Gain 按参考响度换算的 ReplayGain 增益 (dB)
+func (l Loudness) Gain() float64 {
+	return math.Round((ReferenceLoudness-l.Integrated)*100) / 100
+}
+
+// Peak 线性峰值，与 REPLAYGAIN_*_PEAK 标签一致
+func (l Loudness) Peak() float64 {
+	return math.Round(math.Pow(10, l.TruePeak/20)*1e6) / 1e6
+}
+
+// MeasureLoudness 调用 ffmpeg 测量文件（或其中的片段）的 EBU R128 响度
+func MeasureLoudness(ctx context.Context, path string, clip Clip) (Loudness, error) {
+	args := []string{"-hide_banner", "-nostats", "-nostdin"}
+	if clip.Start > 0 {
+		args = append(args, "-ss", seconds(clip.Start))
+	}
+	if clip.End > clip.Start {
+		args = append(args, "-t", seconds(clip.End-clip.Start))
+	}
+	args = append(args, "-i", path, "-vn", "-map", "0:a:0", "-af", "ebur128=peak=true", "-f", "null", "-")
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, ffmpegPath(), args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return Loudness{}, fmt.Errorf("ffmpeg: %w: %s", err, lastLine(stderr.String()))
+	}
+	return parseLoudness(stderr.String())
+}
+
+// parseLoudness 解析 ebur128 滤镜在结束时输出的 Summary：
+//
+//	Integrated loudness:
+//	  I:         -14.2 LUFS
+//	...
+//	True peak:
+//	  Peak:        0.5 dBFS
+func parseLoudness(output string) (Loudness, error) {
+	var l Loudness
+	var summary, hasI, hasPeak bool
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasSuffix(line, "Summary:") {
+			summary = true
+			continue
+		}
+		fields := strings.Fields(line)
+		if !summary || len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "I:":
+			if v, err := strconv.ParseFloat(fields[1], 64); err == nil {
+				l.Integrated, hasI = v, true
+			}
+		case "Peak:":
+			if v, err := strconv.ParseFloat(fields[1], 64); err == nil {
+				l.TruePeak, hasPeak = v, true
+			}
+		}
+	}
+	if !hasI || !hasPeak {
+		return Loudness{}, fmt.Errorf("ffmpeg: no loudness summary")
+	}
+	return l, nil
+}
+
+// lastLine 返回输出的最后一个非空行，用于错误信息
+func lastLine(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
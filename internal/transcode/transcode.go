@@ -0,0 +1,244 @@
+// Package transcode 调用 ffmpeg 将音频实时转码为客户端请求的格式与码率
+package transcode
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"saboriman-music/config"
+)
+
+// ErrUnknownFormat 请求的 format 没有对应的转码方案
+var ErrUnknownFormat = errors.New("unsupported transcoding format")
+
+// ErrOffsetOutOfRange 请求的起始位置超出片段结尾
+var ErrOffsetOutOfRange = errors.New("time offset out of range")
+
+// defaultProfiles 未配置 Transcoding.Profiles 时使用的内置方案
+var defaultProfiles = []config.TranscodeProfile{
+	{Format: "mp3", ContentType: "audio/mpeg", BitRate: 192,
+		Args: []string{"-map", "0:a:0", "-c:a", "libmp3lame", "-b:a", "{bitrate}k", "-f", "mp3"}},
+	{Format: "opus", ContentType: "audio/ogg", BitRate: 128,
+		Args: []string{"-map", "0:a:0", "-c:a", "libopus", "-b:a", "{bitrate}k", "-f", "ogg"}},
+	{Format: "aac", ContentType: "audio/aac", BitRate: 256,
+		Args: []string{"-map", "0:a:0", "-c:a", "aac", "-b:a", "{bitrate}k", "-f", "adts"}},
+}
+
+// Profiles 返回当前生效的转码方案
+func Profiles() []config.TranscodeProfile {
+	if config.AppConfig != nil && len(config.AppConfig.Transcoding.Profiles) > 0 {
+		return config.AppConfig.Transcoding.Profiles
+	}
+	return defaultProfiles
+}
+
+// findProfile 按 format 查找转码方案
+func findProfile(format string) (config.TranscodeProfile, bool) {
+	for _, p := range Profiles() {
+		if strings.EqualFold(p.Format, format) {
+			return p, true
+		}
+	}
+	return config.TranscodeProfile{}, false
+}
+
+// defaultFormat 需要降码率但客户端未指定 format 时使用的格式
+func defaultFormat() string {
+	if config.AppConfig != nil && config.AppConfig.Transcoding.DefaultFormat != "" {
+		return config.AppConfig.Transcoding.DefaultFormat
+	}
+	return "mp3"
+}
+
+// ffmpegPath 返回 ffmpeg 可执行文件路径
+func ffmpegPath() string {
+	if config.AppConfig != nil && config.AppConfig.Transcoding.FFmpegPath != "" {
+		return config.AppConfig.Transcoding.FFmpegPath
+	}
+	return "ffmpeg"
+}
+
+// Clip 要播放的文件片段，用于整轨文件中的一条音轨；零值表示整个文件
+type Clip struct {
+	Start time.Duration
+	End   time.Duration // 0 表示到文件末尾
+}
+
+// NewClip 由毫秒表示的起止位置创建 Clip
+func NewClip(startMs, endMs int) Clip {
+	return Clip{Start: time.Duration(startMs) * time.Millisecond, End: time.Duration(endMs) * time.Millisecond}
+}
+
+// IsZero 是否为整个文件
+func (c Clip) IsZero() bool {
+	return c.Start == 0 && c.End == 0
+}
+
+// clipProfile 片段无法直接发送原文件，原本直接输出的请求改为无损转码为 FLAC
+var clipProfile = config.TranscodeProfile{Format: "flac", ContentType: "audio/flac",
+	Args: []string{"-map", "0:a:0", "-c:a", "flac", "-f", "flac"}}
+
+// Decision 一次播放请求的转码决定
+type Decision struct {
+	Raw     bool                    // 直接输出原始文件
+	Profile config.TranscodeProfile // Raw 为 false 时使用的转码方案
+	BitRate int                     // 输出码率 (kbps)
+	Gain    float64                 // 转码时调整的音量 (dB)，见 WithGain
+}
+
+// Decide 根据源文件扩展名/码率、请求的 format 与最大码率决定是否转码
+// format 为 raw 时总是输出原始文件；maxBitRate 为 0 表示不限制
+func Decide(suffix string, srcBitRate int, format string, maxBitRate int) (Decision, error) {
+	format = strings.ToLower(strings.TrimSpace(format))
+	if format == "raw" {
+		return Decision{Raw: true}, nil
+	}
+
+	// 未指定格式或与源文件相同时，码率满足要求就不转码
+	sameFormat := format == "" || strings.EqualFold(format, suffix)
+	if sameFormat {
+		if maxBitRate <= 0 || (srcBitRate > 0 && srcBitRate <= maxBitRate) {
+			return Decision{Raw: true}, nil
+		}
+	}
+
+	profile, ok := findProfile(format)
+	if !ok {
+		if !sameFormat {
+			return Decision{}, fmt.Errorf("%w: %s", ErrUnknownFormat, format)
+		}
+		// 源格式没有转码方案（如 flac），降码率时改用默认格式
+		if profile, ok = findProfile(defaultFormat()); !ok {
+			return Decision{}, fmt.Errorf("%w: %s", ErrUnknownFormat, defaultFormat())
+		}
+	}
+
+	bitRate := profile.BitRate
+	if maxBitRate > 0 && (bitRate <= 0 || maxBitRate < bitRate) {
+		bitRate = maxBitRate
+	}
+	return Decision{Profile: profile, BitRate: bitRate}, nil
+}
+
+// ForClip 播放片段时把直接输出原文件的决定改为转码为 FLAC
+func (d Decision) ForClip(clip Clip) Decision {
+	if !d.Raw || clip.IsZero() {
+		return d
+	}
+	return Decision{Profile: clipProfile}
+}
+
+// EstimateSize 按输出码率估算转码后的字节数，用于 estimateContentLength
+func (d Decision) EstimateSize(duration, offset int) int64 {
+	seconds := duration - offset
+	if seconds <= 0 || d.BitRate <= 0 {
+		return 0
+	}
+	return int64(seconds) * int64(d.BitRate) * 1000 / 8
+}
+
+// Args 生成完整的 ffmpeg 参数，offset 为相对片段开头的起始秒数；
+// 起始位置到达或超过片段结尾时输出长度为 0，不会输出后面的音轨
+func (d Decision) Args(path string, clip Clip, offset int) []string {
+	args := []string{"-v", "error", "-nostdin"}
+	start := clip.Start + time.Duration(offset)*time.Second
+	if start > 0 {
+		args = append(args, "-ss", seconds(start))
+	}
+	if clip.End > 0 {
+		args = append(args, "-t", seconds(max(clip.End-start, 0)))
+	}
+	args = append(args, "-i", path, "-vn")
+	args = append(args, gainArgs(d.Gain)...)
+	for _, a := range d.Profile.Args {
+		args = append(args, strings.ReplaceAll(a, "{bitrate}", strconv.Itoa(d.BitRate)))
+	}
+	return append(args, "-")
+}
+
+// process 正在运行的 ffmpeg 进程，读取其标准输出；Close 时结束进程
+type process struct {
+	io.ReadCloser
+	cmd    *exec.Cmd
+	stderr *bytes.Buffer
+}
+
+func (p *process) Close() error {
+	p.ReadCloser.Close()
+	p.cmd.Process.Kill()
+	if err := p.cmd.Wait(); err != nil && p.stderr.Len() > 0 {
+		return fmt.Errorf("ffmpeg: %v: %s", err, strings.TrimSpace(p.stderr.String()))
+	}
+	return nil
+}
+
+// seconds 将时长格式化为 ffmpeg 接受的秒数
+func seconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', -1, 64)
+}
+
+// Start 启动 ffmpeg 转码，返回转码后的输出流，调用方读取完毕后需 Close；
+// 起始位置超出片段结尾时返回 ErrOffsetOutOfRange
+func Start(path string, d Decision, clip Clip, offset int) (io.ReadCloser, error) {
+	if clip.End > 0 && clip.Start+time.Duration(offset)*time.Second >= clip.End {
+		return nil, ErrOffsetOutOfRange
+	}
+	return run(d.Args(path, clip, offset))
+}
+
+// run 以给定参数启动 ffmpeg，返回其标准输出
+func run(args []string) (io.ReadCloser, error) {
+	cmd := exec.Command(ffmpegPath(), args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("start ffmpeg: %w", err)
+	}
+	return &process{ReadCloser: stdout, cmd: cmd, stderr: stderr}, nil
+}
+
+// fixedSize 输出恰好 size 字节：多余的截断，不足的以 0 补齐，
+// 保证与 estimateContentLength 时声明的 Content-Length 一致
+type fixedSize struct {
+	io.ReadCloser
+	remaining int64
+	eof       bool
+}
+
+// FixedSize 将转码输出包装为固定长度
+func FixedSize(r io.ReadCloser, size int64) io.ReadCloser {
+	return &fixedSize{ReadCloser: r, remaining: size}
+}
+
+func (f *fixedSize) Read(p []byte) (int, error) {
+	if f.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > f.remaining {
+		p = p[:f.remaining]
+	}
+	if !f.eof {
+		n, err := f.ReadCloser.Read(p)
+		f.remaining -= int64(n)
+		if err == io.EOF {
+			f.eof = true
+			err = nil
+		}
+		return n, err
+	}
+	for i := range p {
+		p[i] = 0
+	}
+	f.remaining -= int64(len(p))
+	return len(p), nil
+}
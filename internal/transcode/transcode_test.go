@@ -0,0 +1,41 @@
+package transcode
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecisionArgs(t *testing.T) {
+	d := Decision{Profile: clipProfile}
+	tests := []struct {
+		name   string
+		clip   Clip
+		offset int
+		want   string // -i 之前的参数
+	}{
+		{"whole file", Clip{}, 0, "-v error -nostdin"},
+		{"whole file with offset", Clip{}, 30, "-v error -nostdin -ss 30"},
+		{"clip", Clip{Start: time.Minute, End: 90 * time.Second}, 0, "-v error -nostdin -ss 60 -t 30"},
+		{"clip with offset", Clip{Start: time.Minute, End: 90 * time.Second}, 10, "-v error -nostdin -ss 70 -t 20"},
+		{"last track", Clip{Start: time.Minute}, 10, "-v error -nostdin -ss 70"},
+		{"offset at clip end", Clip{Start: time.Minute, End: 90 * time.Second}, 30, "-v error -nostdin -ss 90 -t 0"},
+		{"offset past clip end", Clip{Start: time.Minute, End: 90 * time.Second}, 45, "-v error -nostdin -ss 105 -t 0"},
+	}
+	for _, tt := range tests {
+		args := strings.Join(d.Args("in.flac", tt.clip, tt.offset), " ")
+		if got, _, _ := strings.Cut(args, " -i "); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestStartOffsetOutOfRange(t *testing.T) {
+	clip := Clip{Start: time.Minute, End: 90 * time.Second}
+	for _, offset := range []int{30, 45} {
+		if _, err := Start("in.flac", Decision{Profile: clipProfile}, clip, offset); !errors.Is(err, ErrOffsetOutOfRange) {
+			t.Errorf("offset %d: expected ErrOffsetOutOfRange, got %v", offset, err)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package utils
+
+import (
+	"path/filepath"
+	"strings"
+
+	"saboriman-music/config"
+)
+
+// audioContentTypes 音频扩展名与 MIME 类型的对应关系
+var audioContentTypes = map[string]string{
+	"mp3":  "audio/mpeg",
+	"m4a":  "audio/mp4",
+	"m4b":  "audio/mp4",
+	"flac": "audio/flac",
+	"ogg":  "audio/ogg",
+	"oga":  "audio/ogg",
+	"opus": "audio/ogg",
+	"wav":  "audio/wav",
+	"aac":  "audio/aac",
+	"aiff": "audio/aiff",
+	"aif":  "audio/aiff",
+	"ape":  "audio/x-ape",
+	"wv":   "audio/x-wavpack",
+	"dsf":  "audio/x-dsf",
+	"dff":  "audio/x-dff",
+	"wma":  "audio/x-ms-wma",
+}
+
+// codecContentTypes 同一容器中编码不同、MIME 类型也不同的编码，如 .m4a 中的 ALAC
+var codecContentTypes = map[string]string{
+	"alac": "audio/x-alac",
+}
+
+// AudioContentType 根据扩展名（可带 "."）返回音频的 MIME 类型，未知类型返回 application/octet-stream
+func AudioContentType(suffix string) string {
+	if ct, ok := audioContentTypes[strings.ToLower(strings.TrimPrefix(suffix, "."))]; ok {
+		return ct
+	}
+	return "application/octet-stream"
+}
+
+// MusicContentType 根据扩展名与编码返回音频的 MIME 类型，编码未知时按扩展名判断
+func MusicContentType(suffix, codec string) string {
+	if ct, ok := codecContentTypes[strings.ToLower(codec)]; ok {
+		return ct
+	}
+	return AudioContentType(suffix)
+}
+
+// MusicFilePath 将 Music.FileUrl 解析为本地文件路径，相对路径基于 AppBasePath
+func MusicFilePath(fileURL string) string {
+	if filepath.IsAbs(fileURL) || config.AppConfig == nil {
+		return fileURL
+	}
+	return filepath.Join(config.AppConfig.AppBasePath, fileURL)
+}
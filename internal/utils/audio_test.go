@@ -0,0 +1,22 @@
+package utils
+
+import "testing"
+
+func TestMusicContentType(t *testing.T) {
+	tests := []struct {
+		suffix, codec, want string
+	}{
+		{"m4a", "aac", "audio/mp4"},
+		{"m4a", "ALAC", "audio/x-alac"},
+		{".FLAC", "", "audio/flac"},
+		{"opus", "opus", "audio/ogg"},
+		{"ape", "ape", "audio/x-ape"},
+		{"dsf", "dsd_lsbf_planar", "audio/x-dsf"},
+		{"xyz", "", "application/octet-stream"},
+	}
+	for _, tt := range tests {
+		if got := MusicContentType(tt.suffix, tt.codec); got != tt.want {
+			t.Errorf("MusicContentType(%q, %q) = %q, want %q", tt.suffix, tt.codec, got, tt.want)
+		}
+	}
+}